@@ -27,37 +27,248 @@
 package agent
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
+	"fmt"
+	"github.com/jasonish/evebox/core"
 	"github.com/jasonish/evebox/httpclient"
+	"github.com/jasonish/evebox/log"
 	"github.com/jasonish/evebox/util"
+	"net/http"
+	"sync"
 )
 
+// Compression modes for event submission. In auto mode batches are
+// compressed only if the server advertises support for it in its version
+// response, so older servers keep working.
+const (
+	COMPRESSION_AUTO = "auto"
+	COMPRESSION_GZIP = "gzip"
+	COMPRESSION_NONE = "none"
+)
+
+// SubmitError is returned when the server rejects a batch of events or a
+// heartbeat.
+type SubmitError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *SubmitError) Error() string {
+	return fmt.Sprintf("unexpected status: %s", e.Status)
+}
+
 type Client struct {
 	httpClient *httpclient.HttpClient
+	baseUrl    string
+
+	lock        sync.Mutex
+	compression string
+
+	// The content encoding to submit with, and if it has been negotiated
+	// with the server yet. Only used in auto mode.
+	encoding   string
+	negotiated bool
 }
 
 func NewClient() *Client {
 	client := Client{
-		httpClient: httpclient.NewHttpClient(),
+		httpClient:  httpclient.NewHttpClient(),
+		compression: COMPRESSION_AUTO,
 	}
 	return &client
 }
 
+// SetCompression sets the compression mode used when submitting events.
+func (c *Client) SetCompression(compression string) error {
+	switch compression {
+	case COMPRESSION_AUTO, COMPRESSION_GZIP, COMPRESSION_NONE:
+	default:
+		return fmt.Errorf("unsupported compression: %s", compression)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.compression = compression
+	c.encoding = ""
+	c.negotiated = false
+	return nil
+}
+
 func (c *Client) SetBaseUrl(url string) {
+	c.baseUrl = url
 	c.httpClient.SetBaseUrl(url)
 }
 
+func (c *Client) BaseUrl() string {
+	return c.baseUrl
+}
+
 func (c *Client) SetUsernamePassword(username string, password string) {
 	c.httpClient.SetUsernamePassword(username, password)
 }
 
+func (c *Client) SetToken(token string) {
+	c.httpClient.SetBearerToken(token)
+}
+
+func (c *Client) DisableCertCheck(disableCertCheck bool) {
+	c.httpClient.DisableCertCheck(disableCertCheck)
+}
+
+// SetCA pins the CA the server certificate must be signed by.
+func (c *Client) SetCA(filename string) error {
+	return c.httpClient.SetCA(filename)
+}
+
+// SetClientCertificate sets the certificate presented to the server, for
+// servers that authenticate agents with client certificates.
+func (c *Client) SetClientCertificate(certFile string, keyFile string) error {
+	return c.httpClient.SetClientCertificate(certFile, keyFile)
+}
+
 func (c *Client) GetVersion() (*util.JsonMap, error) {
 	response, err := c.httpClient.Get("api/1/version")
 	if err != nil {
 		return nil, err
 	}
+	defer response.Body.Close()
 	var version util.JsonMap
 	decoder := json.NewDecoder(response.Body)
 	err = decoder.Decode(&version)
+	if err == nil {
+		c.negotiate(version)
+	}
 	return &version, err
 }
+
+// negotiate picks the content encoding for submissions from the encodings
+// the server advertises in its version response.
+func (c *Client) negotiate(version util.JsonMap) {
+	encoding := ""
+	for _, value := range version.GetAsStrings("submit_encodings") {
+		if value == COMPRESSION_GZIP {
+			encoding = value
+		}
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.compression == COMPRESSION_AUTO && !c.negotiated {
+		log.Debug("Negotiated submission encoding: %q", encoding)
+	}
+	c.encoding = encoding
+	c.negotiated = true
+}
+
+// submitEncoding returns the content encoding to submit events with,
+// querying the server for its version if it hasn't been negotiated yet.
+func (c *Client) submitEncoding() string {
+	c.lock.Lock()
+	compression := c.compression
+	negotiated := c.negotiated
+	encoding := c.encoding
+	c.lock.Unlock()
+
+	switch compression {
+	case COMPRESSION_GZIP:
+		return COMPRESSION_GZIP
+	case COMPRESSION_NONE:
+		return ""
+	}
+
+	if !negotiated {
+		if _, err := c.GetVersion(); err != nil {
+			return ""
+		}
+		c.lock.Lock()
+		encoding = c.encoding
+		c.lock.Unlock()
+	}
+
+	return encoding
+}
+
+// resetNegotiation forces the encoding to be negotiated again on the next
+// submission, for example after the server rejected the encoding.
+func (c *Client) resetNegotiation() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.encoding = ""
+	c.negotiated = false
+}
+
+func gzipBytes(buf []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(buf); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return compressed.Bytes(), nil
+}
+
+// Submit sends a batch of newline separated events to the server.
+func (c *Client) Submit(batch []byte) (*util.JsonMap, error) {
+	headers := http.Header{}
+	headers.Set("Content-Type", "application/json")
+
+	encoding := c.submitEncoding()
+	if encoding == COMPRESSION_GZIP {
+		compressed, err := gzipBytes(batch)
+		if err != nil {
+			return nil, err
+		}
+		batch = compressed
+		headers.Set("Content-Encoding", encoding)
+	}
+
+	response, err := c.httpClient.RequestWithHeaders("POST", "api/1/submit",
+		headers, bytes.NewReader(batch))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode > 200 {
+		if response.StatusCode == http.StatusUnsupportedMediaType &&
+			encoding != "" {
+			c.resetNegotiation()
+		}
+		return nil, &SubmitError{
+			StatusCode: response.StatusCode,
+			Status:     response.Status,
+		}
+	}
+
+	var jsonMap util.JsonMap
+	decoder := json.NewDecoder(response.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&jsonMap); err != nil {
+		return nil, err
+	}
+	return &jsonMap, nil
+}
+
+// SendHeartbeat reports the agent's status to the server, returning the
+// sensor the agent is registered as.
+func (c *Client) SendHeartbeat(heartbeat core.Heartbeat) (*core.Sensor, error) {
+	response, err := c.httpClient.PostJson("api/1/sensors/heartbeat",
+		heartbeat)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode > 200 {
+		return nil, &SubmitError{
+			StatusCode: response.StatusCode,
+			Status:     response.Status,
+		}
+	}
+	var sensor core.Sensor
+	if err := json.NewDecoder(response.Body).Decode(&sensor); err != nil {
+		return nil, err
+	}
+	return &sensor, nil
+}
@@ -0,0 +1,217 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package agent
+
+import (
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// compressionServer records the encoding and decoded body of submissions,
+// advertising the given encodings in its version response.
+type compressionServer struct {
+	lock      sync.Mutex
+	encodings string
+	encoding  string
+	body      string
+}
+
+func (s *compressionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if r.URL.Path == "/api/1/version" {
+		w.Write([]byte(`{"version": "test", "submit_encodings": ` + s.encodings + `}`))
+		return
+	}
+	s.encoding = r.Header.Get("Content-Encoding")
+	var reader io.Reader = r.Body
+	switch s.encoding {
+	case "":
+	case "gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		reader = gz
+	default:
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+	body, _ := ioutil.ReadAll(reader)
+	s.body = string(body)
+	w.Write([]byte(`{"count": 1}`))
+}
+
+func TestClientCompression(t *testing.T) {
+	submit := func(encodings string, compression string) *compressionServer {
+		server := &compressionServer{encodings: encodings}
+		httpServer := httptest.NewServer(server)
+		defer httpServer.Close()
+
+		client := NewClient()
+		client.SetBaseUrl(httpServer.URL)
+		require.Nil(t, client.SetCompression(compression))
+		_, err := client.Submit([]byte("{\"value\": 1}\n"))
+		require.Nil(t, err)
+		assert.Equal(t, "{\"value\": 1}\n", server.body)
+		return server
+	}
+
+	// Negotiated with a server that supports gzip.
+	assert.Equal(t, "gzip", submit(`["gzip"]`, COMPRESSION_AUTO).encoding)
+
+	// Older servers don't advertise any encodings.
+	assert.Equal(t, "", submit(`null`, COMPRESSION_AUTO).encoding)
+
+	// Compression can be forced on or off.
+	assert.Equal(t, "gzip", submit(`null`, COMPRESSION_GZIP).encoding)
+	assert.Equal(t, "", submit(`["gzip"]`, COMPRESSION_NONE).encoding)
+
+	assert.NotNil(t, NewClient().SetCompression("zip"))
+}
+
+// testCertificate creates a certificate signed by parent, or self signed if
+// parent is nil.
+func testCertificate(t *testing.T, template *x509.Certificate,
+	parent *x509.Certificate, parentKey *rsa.PrivateKey) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Minute)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.BasicConstraintsValid = true
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent,
+		&key.PublicKey, parentKey)
+	require.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+	return cert, key
+}
+
+func writeTestPem(t *testing.T, filename string, cert *x509.Certificate, key *rsa.PrivateKey) {
+	file, err := os.Create(filename)
+	require.Nil(t, err)
+	defer file.Close()
+	pem.Encode(file, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	if key != nil {
+		pem.Encode(file, &pem.Block{Type: "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	}
+}
+
+func TestClientCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evebox-client")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	ca, caKey := testCertificate(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "Test CA"},
+		IsCA:     true,
+		KeyUsage: x509.KeyUsageCertSign,
+	}, nil, nil)
+	serverCert, serverKey := testCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	}, ca, caKey)
+	agentCert, agentKey := testCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "sensor-1"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	caFilename := filepath.Join(dir, "ca.pem")
+	writeTestPem(t, caFilename, ca, nil)
+	agentFilename := filepath.Join(dir, "sensor-1.pem")
+	writeTestPem(t, agentFilename, agentCert, agentKey)
+
+	var sensor string
+	httpServer := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			sensor = r.TLS.VerifiedChains[0][0].Subject.CommonName
+			w.Write([]byte(`{"count": 1}`))
+		}))
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	httpServer.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{serverCert.Raw},
+			PrivateKey:  serverKey,
+		}},
+		ClientCAs:  pool,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	}
+	httpServer.StartTLS()
+	defer httpServer.Close()
+
+	newClient := func() *Client {
+		client := NewClient()
+		client.SetBaseUrl(httpServer.URL)
+		require.Nil(t, client.SetCompression(COMPRESSION_NONE))
+		return client
+	}
+
+	// Without the pinned CA the server certificate isn't trusted.
+	client := newClient()
+	require.Nil(t, client.SetClientCertificate(agentFilename, ""))
+	_, err = client.Submit([]byte("{}\n"))
+	assert.NotNil(t, err)
+
+	// Without a client certificate the server rejects the connection.
+	client = newClient()
+	require.Nil(t, client.SetCA(caFilename))
+	_, err = client.Submit([]byte("{}\n"))
+	assert.NotNil(t, err)
+
+	client = newClient()
+	require.Nil(t, client.SetCA(caFilename))
+	require.Nil(t, client.SetClientCertificate(agentFilename, ""))
+	_, err = client.Submit([]byte("{}\n"))
+	require.Nil(t, err)
+	assert.Equal(t, "sensor-1", sensor)
+}
@@ -0,0 +1,308 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/eve"
+	"github.com/pkg/errors"
+	"hash/fnv"
+	"net"
+	"strings"
+	"sync/atomic"
+)
+
+// Filter rule actions.
+const (
+	FILTER_ACTION_DROP = "drop"
+	FILTER_ACTION_KEEP = "keep"
+)
+
+// The field sampling is hashed on by default, so all the events of a flow
+// are kept or dropped together.
+const DEFAULT_SAMPLE_BY = "flow_id"
+
+// FilterRuleConfig is a rule as configured in agent.yaml under
+// input.filters. A rule matches an event if every criteria set matches,
+// where a criteria with a list of values matches if any value does. A rule
+// without any criteria matches every event.
+type FilterRuleConfig struct {
+	Name string
+
+	// FILTER_ACTION_DROP (the default) or FILTER_ACTION_KEEP.
+	Action string
+
+	EventType []string `mapstructure:"event-type"`
+	AppProto  []string `mapstructure:"app-proto"`
+
+	// Addresses or networks matched against either address, or only
+	// the source or destination address.
+	CIDR     []string `mapstructure:"cidr"`
+	SrcCIDR  []string `mapstructure:"src-cidr"`
+	DestCIDR []string `mapstructure:"dest-cidr"`
+
+	// Values to match keyed by field name, nested fields are separated
+	// by dots, for example "dns.rrname".
+	Fields map[string]interface{}
+
+	// For keep rules, the fraction of matching events to keep, the rest
+	// are dropped. Sampling is deterministic on the value of SampleBy.
+	// Events without the field are kept.
+	Sample   float64
+	SampleBy string `mapstructure:"sample-by"`
+}
+
+type filterRule struct {
+	// Number of events dropped, updated atomically.
+	dropped uint64
+
+	FilterRuleConfig
+	cidr     []*net.IPNet
+	srcCIDR  []*net.IPNet
+	destCIDR []*net.IPNet
+	fields   map[string][]string
+}
+
+// DroppedCount is the number of events dropped by a rule.
+type DroppedCount struct {
+	Name    string `json:"name"`
+	Dropped uint64 `json:"dropped"`
+}
+
+// EventFilter is an eve.EveFilter applying drop and keep rules to events
+// before they are sent to the server. The first rule matching an event
+// decides if it is dropped or kept, events matching no rule are kept.
+type EventFilter struct {
+	rules []*filterRule
+
+	// Set if dropped events are not counted.
+	uncounted bool
+}
+
+func NewEventFilter(configs []FilterRuleConfig) (*EventFilter, error) {
+	filter := &EventFilter{}
+	for i, config := range configs {
+		rule, err := newFilterRule(config)
+		if err != nil {
+			name := config.Name
+			if name == "" {
+				name = fmt.Sprintf("%d", i+1)
+			}
+			return nil, errors.Wrapf(err, "filter rule %s", name)
+		}
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		filter.rules = append(filter.rules, rule)
+	}
+	return filter, nil
+}
+
+func newFilterRule(config FilterRuleConfig) (*filterRule, error) {
+	rule := &filterRule{FilterRuleConfig: config}
+
+	switch rule.Action {
+	case "":
+		rule.Action = FILTER_ACTION_DROP
+	case FILTER_ACTION_DROP, FILTER_ACTION_KEEP:
+	default:
+		return nil, errors.Errorf("invalid action: %s", rule.Action)
+	}
+
+	if rule.Sample < 0 || rule.Sample > 1 {
+		return nil, errors.Errorf("sample must be between 0 and 1: %v",
+			rule.Sample)
+	}
+	if rule.Sample > 0 && rule.Action != FILTER_ACTION_KEEP {
+		return nil, errors.New("sample is only valid on keep rules")
+	}
+	if rule.SampleBy == "" {
+		rule.SampleBy = DEFAULT_SAMPLE_BY
+	}
+
+	var err error
+	if rule.cidr, err = parseNetworks(config.CIDR); err != nil {
+		return nil, err
+	}
+	if rule.srcCIDR, err = parseNetworks(config.SrcCIDR); err != nil {
+		return nil, err
+	}
+	if rule.destCIDR, err = parseNetworks(config.DestCIDR); err != nil {
+		return nil, err
+	}
+
+	rule.fields = map[string][]string{}
+	for field, value := range config.Fields {
+		if values, ok := value.([]interface{}); ok {
+			for _, value := range values {
+				rule.fields[field] = append(rule.fields[field],
+					fmt.Sprint(value))
+			}
+		} else {
+			rule.fields[field] = []string{fmt.Sprint(value)}
+		}
+	}
+
+	return rule, nil
+}
+
+func parseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		network, err := core.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func (r *filterRule) matches(event eve.EveEvent) bool {
+	if len(r.EventType) > 0 && !containsString(r.EventType, event.EventType()) {
+		return false
+	}
+	if len(r.AppProto) > 0 &&
+		!containsString(r.AppProto, event.GetString("app_proto")) {
+		return false
+	}
+	if len(r.cidr) > 0 && !containsIp(r.cidr, event.SrcIp()) &&
+		!containsIp(r.cidr, event.DestIp()) {
+		return false
+	}
+	if len(r.srcCIDR) > 0 && !containsIp(r.srcCIDR, event.SrcIp()) {
+		return false
+	}
+	if len(r.destCIDR) > 0 && !containsIp(r.destCIDR, event.DestIp()) {
+		return false
+	}
+	for field, values := range r.fields {
+		value, ok := fieldString(event, field)
+		if !ok || !containsString(values, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// sampled returns true if the event is in the sample to keep.
+func (r *filterRule) sampled(event eve.EveEvent) bool {
+	value, ok := fieldString(event, r.SampleBy)
+	if !ok {
+		return true
+	}
+	hash := fnv.New64a()
+	hash.Write([]byte(value))
+	return float64(hash.Sum64()%10000) < r.Sample*10000
+}
+
+// Uncounted returns a filter applying the same rules that doesn't count
+// the events it drops, for filtering events that are also filtered by f,
+// such as when files are read once for each output.
+func (f *EventFilter) Uncounted() *EventFilter {
+	return &EventFilter{
+		rules:     f.rules,
+		uncounted: true,
+	}
+}
+
+func (f *EventFilter) Filter(event eve.EveEvent) {
+	for _, rule := range f.rules {
+		if !rule.matches(event) {
+			continue
+		}
+		if rule.Action == FILTER_ACTION_DROP ||
+			(rule.Sample > 0 && !rule.sampled(event)) {
+			if !f.uncounted {
+				atomic.AddUint64(&rule.dropped, 1)
+			}
+			event.Drop()
+		}
+		return
+	}
+}
+
+// Dropped returns the number of events dropped by each rule, in rule
+// order.
+func (f *EventFilter) Dropped() []DroppedCount {
+	counts := []DroppedCount{}
+	for _, rule := range f.rules {
+		counts = append(counts, DroppedCount{
+			Name:    rule.Name,
+			Dropped: atomic.LoadUint64(&rule.dropped),
+		})
+	}
+	return counts
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsIp(networks []*net.IPNet, addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldString returns the value of a possibly nested field as a string,
+// and false if the event doesn't have the field or it isn't a simple value.
+func fieldString(event eve.EveEvent, field string) (string, bool) {
+	var value interface{} = map[string]interface{}(event)
+	for _, name := range strings.Split(field, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if value, ok = object[name]; !ok {
+			return "", false
+		}
+	}
+	switch value := value.(type) {
+	case string:
+		return value, true
+	case json.Number:
+		return value.String(), true
+	case bool, float64, int64, int:
+		return fmt.Sprint(value), true
+	}
+	return "", false
+}
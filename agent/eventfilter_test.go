@@ -0,0 +1,150 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package agent
+
+import (
+	"fmt"
+	"github.com/jasonish/evebox/eve"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+const testFilterConfig = `
+input:
+  filters:
+    - name: keep-alerts
+      action: keep
+      event-type: alert
+    - name: drop-stats
+      event-type: stats
+    - name: sample-flows
+      action: keep
+      event-type: [flow, netflow]
+      sample: 0.1
+    - name: internal-dns
+      event-type: dns
+      app-proto: dns
+      src-cidr: 10.0.0.0/8
+      fields:
+        dns.type: [query, answer]
+`
+
+func loadTestFilter(t *testing.T) *EventFilter {
+	config := viper.New()
+	config.SetConfigType("yaml")
+	require.Nil(t, config.ReadConfig(strings.NewReader(testFilterConfig)))
+	var rules []FilterRuleConfig
+	require.Nil(t, config.UnmarshalKey("input.filters", &rules))
+	filter, err := NewEventFilter(rules)
+	require.Nil(t, err)
+	return filter
+}
+
+func filtered(filter *EventFilter, event eve.EveEvent) bool {
+	filter.Filter(event)
+	return event.IsDropped()
+}
+
+func TestEventFilter(t *testing.T) {
+	filter := loadTestFilter(t)
+
+	assert.False(t, filtered(filter, eve.EveEvent{"event_type": "alert"}))
+	assert.True(t, filtered(filter, eve.EveEvent{"event_type": "stats"}))
+
+	dns := func(srcIp string, dnsType string) eve.EveEvent {
+		return eve.EveEvent{
+			"event_type": "dns",
+			"app_proto":  "dns",
+			"src_ip":     srcIp,
+			"dns":        map[string]interface{}{"type": dnsType},
+		}
+	}
+	assert.True(t, filtered(filter, dns("10.1.1.1", "query")))
+	assert.True(t, filtered(filter, dns("10.1.1.1", "answer")))
+	assert.False(t, filtered(filter, dns("192.168.1.1", "query")))
+	assert.False(t, filtered(filter, dns("10.1.1.1", "other")))
+	assert.False(t, filtered(filter, eve.EveEvent{"event_type": "dns",
+		"src_ip": "10.1.1.1"}))
+
+	// Events matching no rule are kept.
+	assert.False(t, filtered(filter, eve.EveEvent{"event_type": "http"}))
+
+	dropped := filter.Dropped()
+	require.Len(t, dropped, 4)
+	assert.Equal(t, DroppedCount{"keep-alerts", 0}, dropped[0])
+	assert.Equal(t, DroppedCount{"drop-stats", 1}, dropped[1])
+	assert.Equal(t, DroppedCount{"internal-dns", 2}, dropped[3])
+}
+
+func TestEventFilterSampling(t *testing.T) {
+	filter := loadTestFilter(t)
+
+	kept := 0
+	for i := 0; i < 10000; i++ {
+		flowId := fmt.Sprintf("%d", 1000000+i*7919)
+		event := eve.EveEvent{"event_type": "flow", "flow_id": flowId}
+		dropped := filtered(filter, event)
+		if !dropped {
+			kept++
+		}
+
+		// Sampling is deterministic on the flow ID.
+		event = eve.EveEvent{"event_type": "netflow", "flow_id": flowId}
+		assert.Equal(t, dropped, filtered(filter, event))
+	}
+	assert.InDelta(t, 1000, kept, 150)
+	assert.Equal(t, uint64(2*(10000-kept)), filter.Dropped()[2].Dropped)
+
+	// Events without a flow ID are kept.
+	assert.False(t, filtered(filter, eve.EveEvent{"event_type": "flow"}))
+}
+
+func TestEventFilterUncounted(t *testing.T) {
+	filter := loadTestFilter(t)
+	uncounted := filter.Uncounted()
+
+	// The same event filtered for two outputs is only counted once.
+	assert.True(t, filtered(filter, eve.EveEvent{"event_type": "stats"}))
+	assert.True(t, filtered(uncounted, eve.EveEvent{"event_type": "stats"}))
+	assert.False(t, filtered(uncounted, eve.EveEvent{"event_type": "alert"}))
+	assert.Equal(t, uint64(1), filter.Dropped()[1].Dropped)
+}
+
+func TestEventFilterBadRules(t *testing.T) {
+	for _, rule := range []FilterRuleConfig{
+		{Action: "ignore"},
+		{Action: FILTER_ACTION_DROP, Sample: 0.5},
+		{Action: FILTER_ACTION_KEEP, Sample: 2},
+		{CIDR: []string{"10.0.0.0/33"}},
+	} {
+		_, err := NewEventFilter([]FilterRuleConfig{rule})
+		assert.NotNil(t, err, "%+v", rule)
+	}
+}
@@ -29,8 +29,7 @@ package agent
 import (
 	"encoding/json"
 	"github.com/jasonish/evebox/eve"
-	"github.com/jasonish/evebox/util"
-	"github.com/pkg/errors"
+	"github.com/jasonish/evebox/log"
 )
 
 // AgentEventSink is the event sink for the agent. It consumes events and sends
@@ -40,6 +39,10 @@ import (
 type AgentEventSink struct {
 	client *Client
 
+	// If set, batches that can't be sent are spooled to disk to be sent
+	// later.
+	spool *Spool
+
 	// Raw buffer that is sent to server on commit.
 	buf []byte
 }
@@ -50,26 +53,47 @@ func NewEventChannel(client *Client) *AgentEventSink {
 	return &eventChannel
 }
 
+// NewSpoolingEventSink creates a sink that adds batches to the spool if
+// the server can't be reached, instead of returning an error.
+func NewSpoolingEventSink(client *Client, spool *Spool) *AgentEventSink {
+	sink := NewEventChannel(client)
+	sink.spool = spool
+	return sink
+}
+
 func (ec *AgentEventSink) Commit() (interface{}, error) {
-	response, err := ec.client.httpClient.PostBytes("api/1/submit",
-		"application/json", ec.buf)
-	if err != nil {
-		return nil, err
+	if ec.spool == nil {
+		response, err := ec.client.Submit(ec.buf)
+		if err != nil {
+			return nil, err
+		}
+		ec.buf = ec.buf[:0]
+		return response, nil
 	}
 
-	if response.StatusCode > 200 {
-		return nil, errors.Errorf("unexpected status: %s", response.Status)
+	// While there are spooled batches new batches are added after them,
+	// so events are sent in order.
+	if ec.spool.Len() == 0 {
+		response, err := ec.client.Submit(ec.buf)
+		if err == nil {
+			ec.buf = ec.buf[:0]
+			return response, nil
+		}
+		log.Warning("Failed to submit events, spooling: %v", err)
+	}
+	if err := ec.spool.Add(ec.buf); err != nil {
+		return nil, err
 	}
-
 	ec.buf = ec.buf[:0]
+	return nil, nil
+}
 
-	var jsonMap util.JsonMap
-	decoder := json.NewDecoder(response.Body)
-	decoder.UseNumber()
-	if err := decoder.Decode(&jsonMap); err != nil {
-		return nil, err
+// QueueDepth returns the number of batches waiting in the spool.
+func (ec *AgentEventSink) QueueDepth() int {
+	if ec.spool == nil {
+		return 0
 	}
-	return &jsonMap, nil
+	return ec.spool.Len()
 }
 
 func (ec *AgentEventSink) Submit(event eve.EveEvent) error {
@@ -0,0 +1,127 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package agent
+
+import (
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/eve"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// FanoutSink submits events to several sinks. If some of the sinks fail to
+// commit, only those are committed again on the next commit, so the others
+// don't receive the batch twice.
+//
+// Implements core.EveEventSink.
+type FanoutSink struct {
+	sinks []core.EveEventSink
+
+	// Sinks that have committed the current batch, while a commit is
+	// being retried.
+	committed []bool
+	retrying  bool
+}
+
+func NewFanoutSink(sinks ...core.EveEventSink) *FanoutSink {
+	return &FanoutSink{
+		sinks:     sinks,
+		committed: make([]bool, len(sinks)),
+	}
+}
+
+func (s *FanoutSink) Submit(event eve.EveEvent) error {
+	// A new batch was started instead of retrying the commit, everything
+	// buffered by each sink is committed with it.
+	if s.retrying {
+		s.reset()
+	}
+	failed := []string{}
+	for i, sink := range s.sinks {
+		// Sinks may add fields to the event, give each its own copy.
+		submitted := event
+		if i < len(s.sinks)-1 {
+			submitted = copyEvent(event)
+		}
+		if err := sink.Submit(submitted); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func (s *FanoutSink) Commit() (interface{}, error) {
+	failed := []string{}
+	for i, sink := range s.sinks {
+		if s.committed[i] {
+			continue
+		}
+		if _, err := sink.Commit(); err != nil {
+			failed = append(failed, err.Error())
+			continue
+		}
+		s.committed[i] = true
+	}
+	if len(failed) > 0 {
+		s.retrying = true
+		return nil, errors.Errorf("%d of %d sinks failed to commit: %s",
+			len(failed), len(s.sinks), strings.Join(failed, "; "))
+	}
+	s.reset()
+	return nil, nil
+}
+
+func copyEvent(event eve.EveEvent) eve.EveEvent {
+	copy := eve.EveEvent{}
+	for key, value := range event {
+		copy[key] = value
+	}
+	return copy
+}
+
+func (s *FanoutSink) reset() {
+	for i := range s.committed {
+		s.committed[i] = false
+	}
+	s.retrying = false
+}
+
+// QueueDepth returns the most batches queued by any of the sinks.
+func (s *FanoutSink) QueueDepth() int {
+	depth := 0
+	for _, sink := range s.sinks {
+		if queued, ok := sink.(interface{ QueueDepth() int }); ok {
+			if queued.QueueDepth() > depth {
+				depth = queued.QueueDepth()
+			}
+		}
+	}
+	return depth
+}
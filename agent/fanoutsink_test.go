@@ -0,0 +1,92 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package agent
+
+import (
+	"github.com/jasonish/evebox/eve"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type testSink struct {
+	fail      bool
+	submitted []eve.EveEvent
+	committed []eve.EveEvent
+	commits   int
+}
+
+func (s *testSink) Submit(event eve.EveEvent) error {
+	s.submitted = append(s.submitted, event)
+	return nil
+}
+
+func (s *testSink) Commit() (interface{}, error) {
+	s.commits++
+	if s.fail {
+		return nil, errors.New("failed")
+	}
+	s.committed = append(s.committed, s.submitted...)
+	s.submitted = nil
+	return nil, nil
+}
+
+func TestFanoutSink(t *testing.T) {
+	good := &testSink{}
+	bad := &testSink{fail: true}
+	sink := NewFanoutSink(good, bad)
+
+	event := eve.EveEvent{"event_type": "dns"}
+	require.Nil(t, sink.Submit(event))
+
+	// Each sink gets its own copy of the event.
+	good.submitted[0]["@timestamp"] = "now"
+	assert.NotContains(t, bad.submitted[0], "@timestamp")
+
+	_, err := sink.Commit()
+	assert.NotNil(t, err)
+	assert.Len(t, good.committed, 1)
+
+	// Only the failed sink is committed again.
+	_, err = sink.Commit()
+	assert.NotNil(t, err)
+	bad.fail = false
+	_, err = sink.Commit()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, good.commits)
+	assert.Equal(t, 3, bad.commits)
+	assert.Len(t, bad.committed, 1)
+
+	// The next batch is committed to both.
+	require.Nil(t, sink.Submit(event))
+	_, err = sink.Commit()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, good.commits)
+	assert.Len(t, good.committed, 2)
+	assert.Len(t, bad.committed, 2)
+}
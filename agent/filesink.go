@@ -0,0 +1,171 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jasonish/evebox/eve"
+	"github.com/jasonish/evebox/log"
+	"os"
+	"sync"
+)
+
+// The number of rotated files kept by default.
+const DEFAULT_MAX_FILES = 5
+
+// RotatingFile is a file events are appended to as newline delimited JSON,
+// rotated once it reaches a maximum size. Rotated files are renamed with a
+// numeric suffix, .1 being the most recent. It is shared by all the sinks
+// writing to the file.
+type RotatingFile struct {
+	filename string
+
+	// Size to rotate at in bytes, 0 to never rotate.
+	maxSize int64
+
+	// Number of rotated files to keep.
+	maxFiles int
+
+	lock sync.Mutex
+	file *os.File
+	size int64
+}
+
+func NewRotatingFile(filename string, maxSize int64, maxFiles int) *RotatingFile {
+	if maxFiles <= 0 {
+		maxFiles = DEFAULT_MAX_FILES
+	}
+	return &RotatingFile{
+		filename: filename,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.filename,
+		os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		log.Warning("Failed to close %s: %v", f.filename, err)
+	}
+	f.file = nil
+	os.Remove(fmt.Sprintf("%s.%d", f.filename, f.maxFiles))
+	for i := f.maxFiles - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", f.filename, i),
+			fmt.Sprintf("%s.%d", f.filename, i+1))
+	}
+	if err := os.Rename(f.filename, f.filename+".1"); err != nil {
+		return err
+	}
+	log.Info("Rotated %s", f.filename)
+	return f.open()
+}
+
+// Write appends a batch of events and syncs the file to disk, rotating the
+// file first if the batch would take it over the maximum size.
+func (f *RotatingFile) Write(buf []byte) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(buf)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := f.file.Write(buf)
+	f.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return f.file.Sync()
+}
+
+func (f *RotatingFile) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// FileSink is an event sink writing events to a RotatingFile.
+//
+// Implements core.EveEventSink.
+type FileSink struct {
+	file *RotatingFile
+	buf  []byte
+}
+
+func NewFileSink(file *RotatingFile) *FileSink {
+	return &FileSink{file: file}
+}
+
+func (s *FileSink) Submit(event eve.EveEvent) error {
+	rawEvent, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	s.buf = append(s.buf, rawEvent...)
+	s.buf = append(s.buf, '\n')
+	return nil
+}
+
+func (s *FileSink) Commit() (interface{}, error) {
+	if len(s.buf) == 0 {
+		return nil, nil
+	}
+	if err := s.file.Write(s.buf); err != nil {
+		return nil, err
+	}
+	s.buf = s.buf[:0]
+	return nil, nil
+}
@@ -0,0 +1,85 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package agent
+
+import (
+	"github.com/jasonish/evebox/eve"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evebox-filesink")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "eve.json")
+	file := NewRotatingFile(filename, 30, 2)
+	defer file.Close()
+	sink := NewFileSink(file)
+
+	commit := func(n int) {
+		for i := 0; i < n; i++ {
+			require.Nil(t, sink.Submit(eve.EveEvent{"event_type": "dns"}))
+		}
+		_, err := sink.Commit()
+		require.Nil(t, err)
+	}
+
+	read := func(filename string) string {
+		buf, err := ioutil.ReadFile(filename)
+		require.Nil(t, err)
+		return string(buf)
+	}
+
+	event := "{\"event_type\":\"dns\"}\n"
+
+	// Nothing is written until committed.
+	require.Nil(t, sink.Submit(eve.EveEvent{"event_type": "dns"}))
+	_, err = os.Stat(filename)
+	assert.True(t, os.IsNotExist(err))
+	_, err = sink.Commit()
+	require.Nil(t, err)
+	assert.Equal(t, event, read(filename))
+
+	// A batch that doesn't fit rotates the file first.
+	commit(1)
+	assert.Equal(t, event, read(filename))
+	assert.Equal(t, event, read(filename+".1"))
+
+	// Only the configured number of rotated files are kept.
+	commit(1)
+	commit(1)
+	commit(1)
+	assert.Equal(t, event, read(filename+".2"))
+	_, err = os.Stat(filename + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
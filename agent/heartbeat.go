@@ -0,0 +1,185 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package agent
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/log"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// LoadSensorID returns the sensor ID stored in filename, generating and
+// storing a new one if the file doesn't exist, so the agent keeps its
+// identity across restarts.
+func LoadSensorID(filename string) (string, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err == nil {
+		if id := strings.TrimSpace(string(buf)); id != "" {
+			return id, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	id, err := NewSensorID()
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(filename, []byte(id+"\n"), 0644); err != nil {
+		return "", err
+	}
+	log.Info("Generated sensor ID %s", id)
+	return id, nil
+}
+
+// NewSensorID returns a random sensor ID.
+func NewSensorID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Heartbeater periodically sends heartbeats reporting the agent's status,
+// taken from its metrics, to EveBox servers.
+type Heartbeater struct {
+	SensorID string
+
+	// Name to register as if not authenticated, defaults to the hostname
+	// on the server.
+	Name string
+
+	Hostname string
+	Interval time.Duration
+	Metrics  *Metrics
+
+	clients []*Client
+
+	// Events committed by each input at the last heartbeat, to calculate
+	// the rate from.
+	lastEvents map[string]uint64
+	lastTime   time.Time
+}
+
+func (h *Heartbeater) AddClient(client *Client) {
+	h.clients = append(h.clients, client)
+}
+
+// Heartbeat returns the heartbeat for the current metrics. Event rates are
+// since the previous call.
+func (h *Heartbeater) Heartbeat() core.Heartbeat {
+	now := time.Now()
+	elapsed := now.Sub(h.lastTime).Seconds()
+	if h.lastTime.IsZero() {
+		elapsed = now.Sub(h.Metrics.started).Seconds()
+	}
+
+	heartbeat := core.Heartbeat{
+		SensorID: h.SensorID,
+		Name:     h.Name,
+		Hostname: h.Hostname,
+		Version:  core.BuildVersion,
+		Interval: int64(h.Interval.Seconds()),
+		SensorStatus: core.SensorStatus{
+			Inputs: []core.SensorInput{},
+		},
+	}
+
+	events := map[string]uint64{}
+	for _, metrics := range h.Metrics.Snapshot().Inputs {
+		key := metrics.Input + "\x00" + metrics.Output
+		events[key] = metrics.Committed
+		input := core.SensorInput{
+			Input:  metrics.Input,
+			Output: metrics.Output,
+			Events: metrics.Committed,
+			Lag:    metrics.Lag,
+		}
+		if elapsed > 0 && metrics.Committed >= h.lastEvents[key] {
+			input.EventsPerSecond =
+				float64(metrics.Committed-h.lastEvents[key]) / elapsed
+		}
+		heartbeat.EventsPerSecond += input.EventsPerSecond
+		heartbeat.Inputs = append(heartbeat.Inputs, input)
+	}
+	h.lastEvents = events
+	h.lastTime = now
+
+	return heartbeat
+}
+
+// Run sends a heartbeat to each server every interval until stop is
+// closed.
+func (h *Heartbeater) Run(stop <-chan bool) {
+	if h.Interval <= 0 {
+		h.Interval = core.DEFAULT_HEARTBEAT_INTERVAL
+	}
+	failing := make([]bool, len(h.clients))
+	registered := make([]bool, len(h.clients))
+	ticker := time.NewTicker(h.Interval)
+	defer ticker.Stop()
+	for {
+		heartbeat := h.Heartbeat()
+		for i, client := range h.clients {
+			sensor, err := client.SendHeartbeat(heartbeat)
+			if err != nil {
+				// Only log when heartbeats start failing, as they will
+				// keep failing while the server is down, or forever
+				// with a server too old to support them.
+				if !failing[i] {
+					if err, ok := err.(*SubmitError); ok &&
+						err.StatusCode == http.StatusNotFound {
+						log.Warning("Server %s does not support heartbeats",
+							client.BaseUrl())
+					} else {
+						log.Warning("Failed to send heartbeat to %s: %v",
+							client.BaseUrl(), err)
+					}
+				}
+				failing[i] = true
+				continue
+			}
+			if !registered[i] {
+				log.Info("Registered with %s as sensor %s",
+					client.BaseUrl(), sensor.Name)
+				registered[i] = true
+			}
+			failing[i] = false
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,127 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package agent
+
+import (
+	"encoding/json"
+	"github.com/jasonish/evebox/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadSensorID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evebox-sensorid")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "sensor-id")
+	id, err := LoadSensorID(filename)
+	require.Nil(t, err)
+	assert.Len(t, id, 32)
+
+	// The same ID is returned once stored.
+	again, err := LoadSensorID(filename)
+	require.Nil(t, err)
+	assert.Equal(t, id, again)
+}
+
+func TestHeartbeater(t *testing.T) {
+	heartbeats := make(chan core.Heartbeat, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/1/sensors/heartbeat", r.URL.Path)
+		var heartbeat core.Heartbeat
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&heartbeat))
+		heartbeats <- heartbeat
+		json.NewEncoder(w).Encode(core.Sensor{Name: "sensor-one"})
+	}))
+	defer server.Close()
+
+	client := NewClient()
+	client.SetBaseUrl(server.URL)
+
+	source := testMetricsSource{{
+		Input:     "/var/log/suricata/eve.json",
+		Committed: 100,
+		Lag:       10,
+	}}
+	metrics := NewMetrics()
+	metrics.AddSource(source)
+
+	heartbeater := &Heartbeater{
+		SensorID: "0123456789abcdef",
+		Hostname: "sensor-host",
+		Interval: 50 * time.Millisecond,
+		Metrics:  metrics,
+	}
+	heartbeater.AddClient(client)
+
+	stop := make(chan bool)
+	go heartbeater.Run(stop)
+	defer close(stop)
+
+	select {
+	case heartbeat := <-heartbeats:
+		assert.Equal(t, "0123456789abcdef", heartbeat.SensorID)
+		assert.Equal(t, "sensor-host", heartbeat.Hostname)
+		require.Len(t, heartbeat.Inputs, 1)
+		assert.Equal(t, uint64(100), heartbeat.Inputs[0].Events)
+		assert.Equal(t, int64(10), heartbeat.Inputs[0].Lag)
+		assert.True(t, heartbeat.EventsPerSecond > 0)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "timed out waiting for heartbeat")
+	}
+
+	// With no new events committed the rate drops to 0.
+	select {
+	case heartbeat := <-heartbeats:
+		assert.Equal(t, float64(0), heartbeat.EventsPerSecond)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "timed out waiting for heartbeat")
+	}
+}
+
+func TestHeartbeatRate(t *testing.T) {
+	source := testMetricsSource{{Input: "eve.json", Committed: 100}}
+	metrics := NewMetrics()
+	metrics.AddSource(source)
+	heartbeater := &Heartbeater{Metrics: metrics}
+
+	heartbeater.Heartbeat()
+	heartbeater.lastTime = time.Now().Add(-10 * time.Second)
+	source[0].Committed = 200
+	heartbeat := heartbeater.Heartbeat()
+	assert.InDelta(t, 10, heartbeat.EventsPerSecond, 0.1)
+	assert.InDelta(t, 10, heartbeat.Inputs[0].EventsPerSecond, 0.1)
+
+}
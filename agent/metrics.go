@@ -0,0 +1,281 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jasonish/evebox/evereader"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsSource is an input reporting metrics, such as an
+// evereader.MultiFileProcessor.
+type MetricsSource interface {
+	Metrics() []evereader.ProcessorMetrics
+}
+
+// SpoolMetrics is the state of the spool of an output.
+type SpoolMetrics struct {
+	Output   string `json:"output"`
+	Batches  int    `json:"batches"`
+	Bytes    int64  `json:"bytes"`
+	MaxBytes int64  `json:"max_bytes"`
+}
+
+// MetricsSnapshot is the state of the agent returned by /metrics.json.
+type MetricsSnapshot struct {
+	Uptime  float64                      `json:"uptime_seconds"`
+	Ready   bool                         `json:"ready"`
+	Reasons []string                     `json:"reasons,omitempty"`
+	Inputs  []evereader.ProcessorMetrics `json:"inputs"`
+	Spools  []SpoolMetrics               `json:"spools"`
+	Filters []DroppedCount               `json:"filters"`
+}
+
+// Metrics collects the metrics of the agent's inputs, spools and filters,
+// and serves them over HTTP as JSON and in the Prometheus text format.
+type Metrics struct {
+	started time.Time
+
+	lock    sync.Mutex
+	sources []MetricsSource
+	spools  map[string]*Spool
+	filter  *EventFilter
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		started: time.Now(),
+		spools:  map[string]*Spool{},
+	}
+}
+
+func (m *Metrics) AddSource(source MetricsSource) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.sources = append(m.sources, source)
+}
+
+// AddSpool adds the spool of the named output.
+func (m *Metrics) AddSpool(output string, spool *Spool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.spools[output] = spool
+}
+
+func (m *Metrics) SetEventFilter(filter *EventFilter) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.filter = filter
+}
+
+// Snapshot returns the current metrics. The agent is ready once it has an
+// input and none of the inputs are failing to commit events, which
+// includes a full spool.
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	snapshot := MetricsSnapshot{
+		Uptime:  time.Now().Sub(m.started).Seconds(),
+		Inputs:  []evereader.ProcessorMetrics{},
+		Spools:  []SpoolMetrics{},
+		Filters: []DroppedCount{},
+	}
+
+	for _, source := range m.sources {
+		snapshot.Inputs = append(snapshot.Inputs, source.Metrics()...)
+	}
+
+	for output, spool := range m.spools {
+		snapshot.Spools = append(snapshot.Spools, SpoolMetrics{
+			Output:   output,
+			Batches:  spool.Len(),
+			Bytes:    spool.Size(),
+			MaxBytes: spool.MaxSize(),
+		})
+	}
+	sort.Slice(snapshot.Spools, func(i, j int) bool {
+		return snapshot.Spools[i].Output < snapshot.Spools[j].Output
+	})
+
+	if m.filter != nil {
+		snapshot.Filters = m.filter.Dropped()
+	}
+
+	if len(m.sources) == 0 {
+		snapshot.Reasons = append(snapshot.Reasons, "no inputs started")
+	}
+	for _, input := range snapshot.Inputs {
+		if input.Failing {
+			reason := fmt.Sprintf("%s: failing to commit events",
+				input.Input)
+			if input.Output != "" {
+				reason = fmt.Sprintf("%s (%s): failing to commit events",
+					input.Input, input.Output)
+			}
+			snapshot.Reasons = append(snapshot.Reasons, reason)
+		}
+	}
+	snapshot.Ready = len(snapshot.Reasons) == 0
+
+	return snapshot
+}
+
+// Handler returns the HTTP handler serving /metrics in the Prometheus text
+// format, /metrics.json and the /ready readiness check.
+func (m *Metrics) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		m.Snapshot().WritePrometheus(w)
+	})
+	mux.HandleFunc("/metrics.json", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, m.Snapshot())
+	})
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		snapshot := m.Snapshot()
+		status := http.StatusOK
+		if !snapshot.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, struct {
+			Ready   bool     `json:"ready"`
+			Reasons []string `json:"reasons,omitempty"`
+		}{snapshot.Ready, snapshot.Reasons})
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+// WritePrometheus writes the metrics in the Prometheus text format.
+func (s MetricsSnapshot) WritePrometheus(w io.Writer) {
+	writeMetric := func(name string, kind string, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	writeMetric("evebox_agent_uptime_seconds", "gauge",
+		"Seconds since the agent started.")
+	fmt.Fprintf(w, "evebox_agent_uptime_seconds %g\n", s.Uptime)
+
+	ready := 0
+	if s.Ready {
+		ready = 1
+	}
+	writeMetric("evebox_agent_ready", "gauge",
+		"1 if the agent is ready, 0 if not.")
+	fmt.Fprintf(w, "evebox_agent_ready %d\n", ready)
+
+	inputMetrics := []struct {
+		name  string
+		kind  string
+		help  string
+		value func(evereader.ProcessorMetrics) interface{}
+	}{
+		{"evebox_agent_events_read_total", "counter",
+			"Events read, including those dropped by filters.",
+			func(m evereader.ProcessorMetrics) interface{} { return m.Read }},
+		{"evebox_agent_events_dropped_total", "counter",
+			"Events dropped by filters.",
+			func(m evereader.ProcessorMetrics) interface{} { return m.Dropped }},
+		{"evebox_agent_events_submitted_total", "counter",
+			"Events submitted to the output.",
+			func(m evereader.ProcessorMetrics) interface{} { return m.Submitted }},
+		{"evebox_agent_events_committed_total", "counter",
+			"Events committed to the output.",
+			func(m evereader.ProcessorMetrics) interface{} { return m.Committed }},
+		{"evebox_agent_commit_retries_total", "counter",
+			"Failed commits that were retried.",
+			func(m evereader.ProcessorMetrics) interface{} { return m.Retries }},
+		{"evebox_agent_last_commit_seconds", "gauge",
+			"Time taken by the last commit, including retries.",
+			func(m evereader.ProcessorMetrics) interface{} { return m.LastCommitSeconds }},
+		{"evebox_agent_lag_bytes", "gauge",
+			"Bytes left to read in the input file.",
+			func(m evereader.ProcessorMetrics) interface{} { return m.Lag }},
+	}
+	for _, metric := range inputMetrics {
+		writeMetric(metric.name, metric.kind, metric.help)
+		for _, input := range s.Inputs {
+			fmt.Fprintf(w, "%s{%s} %v\n", metric.name, inputLabels(input),
+				metric.value(input))
+		}
+	}
+
+	writeMetric("evebox_agent_commit_duration_seconds", "summary",
+		"Time taken by successful commits, including retries.")
+	for _, input := range s.Inputs {
+		fmt.Fprintf(w, "evebox_agent_commit_duration_seconds_sum{%s} %g\n",
+			inputLabels(input), input.CommitSeconds)
+		fmt.Fprintf(w, "evebox_agent_commit_duration_seconds_count{%s} %d\n",
+			inputLabels(input), input.Commits)
+	}
+
+	writeMetric("evebox_agent_spool_batches", "gauge",
+		"Batches queued in the spool of an output.")
+	for _, spool := range s.Spools {
+		fmt.Fprintf(w, "evebox_agent_spool_batches{output=%s} %d\n",
+			quoteLabel(spool.Output), spool.Batches)
+	}
+	writeMetric("evebox_agent_spool_bytes", "gauge",
+		"Bytes queued in the spool of an output.")
+	for _, spool := range s.Spools {
+		fmt.Fprintf(w, "evebox_agent_spool_bytes{output=%s} %d\n",
+			quoteLabel(spool.Output), spool.Bytes)
+	}
+
+	writeMetric("evebox_agent_filter_dropped_total", "counter",
+		"Events dropped by each filter rule.")
+	for _, filter := range s.Filters {
+		fmt.Fprintf(w, "evebox_agent_filter_dropped_total{rule=%s} %d\n",
+			quoteLabel(filter.Name), filter.Dropped)
+	}
+}
+
+func inputLabels(input evereader.ProcessorMetrics) string {
+	return fmt.Sprintf("input=%s,output=%s", quoteLabel(input.Input),
+		quoteLabel(input.Output))
+}
+
+var labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabel(value string) string {
+	return `"` + labelReplacer.Replace(value) + `"`
+}
@@ -0,0 +1,129 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package agent
+
+import (
+	"encoding/json"
+	"github.com/jasonish/evebox/evereader"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+type testMetricsSource []evereader.ProcessorMetrics
+
+func (s testMetricsSource) Metrics() []evereader.ProcessorMetrics {
+	return s
+}
+
+func get(t *testing.T, url string) (int, string) {
+	response, err := http.Get(url)
+	require.Nil(t, err)
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	require.Nil(t, err)
+	return response.StatusCode, string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evebox-metrics")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	metrics := NewMetrics()
+	server := httptest.NewServer(metrics.Handler())
+	defer server.Close()
+
+	// Not ready until there is an input.
+	status, _ := get(t, server.URL+"/ready")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+
+	source := testMetricsSource{{
+		Input:         "/var/log/suricata/eve.json",
+		Output:        "backup",
+		Read:          10,
+		Dropped:       2,
+		Submitted:     8,
+		Committed:     8,
+		Commits:       2,
+		CommitSeconds: 0.5,
+		Lag:           1024,
+	}}
+	metrics.AddSource(source)
+
+	spool, err := NewSpool(dir, 100)
+	require.Nil(t, err)
+	require.Nil(t, spool.Add([]byte("batch\n")))
+	metrics.AddSpool("backup", spool)
+
+	filter, err := NewEventFilter([]FilterRuleConfig{{Name: "drop-stats"}})
+	require.Nil(t, err)
+	metrics.SetEventFilter(filter)
+
+	status, body := get(t, server.URL+"/ready")
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"ready": true}`, body)
+
+	status, body = get(t, server.URL+"/metrics.json")
+	assert.Equal(t, http.StatusOK, status)
+	var snapshot MetricsSnapshot
+	require.Nil(t, json.Unmarshal([]byte(body), &snapshot))
+	assert.Equal(t, []evereader.ProcessorMetrics(source), snapshot.Inputs)
+	assert.Equal(t, []SpoolMetrics{{"backup", 1, 6, 100}}, snapshot.Spools)
+	assert.Equal(t, []DroppedCount{{"drop-stats", 0}}, snapshot.Filters)
+
+	status, body = get(t, server.URL+"/metrics")
+	assert.Equal(t, http.StatusOK, status)
+	labels := `{input="/var/log/suricata/eve.json",output="backup"}`
+	for _, line := range []string{
+		"evebox_agent_ready 1",
+		"evebox_agent_events_read_total" + labels + " 10",
+		"evebox_agent_events_dropped_total" + labels + " 2",
+		"evebox_agent_events_committed_total" + labels + " 8",
+		"evebox_agent_lag_bytes" + labels + " 1024",
+		"evebox_agent_commit_duration_seconds_sum" + labels + " 0.5",
+		"evebox_agent_commit_duration_seconds_count" + labels + " 2",
+		`evebox_agent_spool_batches{output="backup"} 1`,
+		`evebox_agent_filter_dropped_total{rule="drop-stats"} 0`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+
+	// An input failing to commit makes the agent not ready.
+	source[0].Failing = true
+	status, body = get(t, server.URL+"/ready")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Contains(t, body, "failing to commit")
+}
+
+func TestQuoteLabel(t *testing.T) {
+	assert.Equal(t, `"a\\b\"c\nd"`, quoteLabel("a\\b\"c\nd"))
+}
@@ -0,0 +1,259 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package agent
+
+import (
+	"fmt"
+	"github.com/jasonish/evebox/log"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrSpoolFull = errors.New("spool is full")
+
+// How long to wait before trying to send spooled batches again after a
+// failure. The wait doubles after each failure up to the maximum.
+const SPOOL_MIN_BACKOFF = time.Second
+const SPOOL_MAX_BACKOFF = time.Minute
+
+const spoolSuffix = ".batch"
+
+// Spool is a bounded on-disk queue of event batches waiting to be sent
+// to the server. Each batch is a file named by its sequence number so
+// batches are sent in the order they were added.
+type Spool struct {
+	directory string
+
+	// The most bytes of batches to hold, 0 for no limit.
+	maxSize int64
+
+	lock  sync.Mutex
+	seq   uint64
+	files []string
+	sizes map[string]int64
+	size  int64
+
+	// Signaled when a batch is added.
+	added chan bool
+}
+
+// NewSpool opens the spool in directory, creating the directory if needed
+// and picking up any batches left from a previous run.
+func NewSpool(directory string, maxSize int64) (*Spool, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create spool directory")
+	}
+	spool := &Spool{
+		directory: directory,
+		maxSize:   maxSize,
+		sizes:     map[string]int64{},
+		added:     make(chan bool, 1),
+	}
+
+	entries, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read spool directory")
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			// An incomplete write.
+			os.Remove(filepath.Join(directory, name))
+			continue
+		}
+		if !strings.HasSuffix(name, spoolSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, spoolSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		if seq > spool.seq {
+			spool.seq = seq
+		}
+		spool.files = append(spool.files, name)
+		spool.sizes[name] = entry.Size()
+		spool.size += entry.Size()
+	}
+	sort.Strings(spool.files)
+	return spool, nil
+}
+
+// Add writes a batch to the end of the spool.
+func (s *Spool) Add(batch []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	size := int64(len(batch))
+	if s.maxSize > 0 && s.size+size > s.maxSize {
+		return ErrSpoolFull
+	}
+
+	s.seq++
+	name := fmt.Sprintf("%020d%s", s.seq, spoolSuffix)
+	filename := filepath.Join(s.directory, name)
+	if err := ioutil.WriteFile(filename+".tmp", batch, 0600); err != nil {
+		os.Remove(filename + ".tmp")
+		return errors.Wrap(err, "failed to write spool file")
+	}
+	if err := os.Rename(filename+".tmp", filename); err != nil {
+		return errors.Wrap(err, "failed to write spool file")
+	}
+	s.files = append(s.files, name)
+	s.sizes[name] = size
+	s.size += size
+
+	select {
+	case s.added <- true:
+	default:
+	}
+	return nil
+}
+
+// Peek returns the name and contents of the oldest batch, or an empty
+// name if the spool is empty.
+func (s *Spool) Peek() (string, []byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.files) == 0 {
+		return "", nil, nil
+	}
+	name := s.files[0]
+	batch, err := ioutil.ReadFile(filepath.Join(s.directory, name))
+	if err != nil {
+		return name, nil, errors.Wrap(err, "failed to read spool file")
+	}
+	return name, batch, nil
+}
+
+// Remove removes a batch returned by Peek once it has been sent.
+func (s *Spool) Remove(name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, file := range s.files {
+		if file == name {
+			s.files = append(s.files[:i], s.files[i+1:]...)
+			break
+		}
+	}
+	s.size -= s.sizes[name]
+	delete(s.sizes, name)
+	err := os.Remove(filepath.Join(s.directory, name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Len returns the number of batches in the spool.
+func (s *Spool) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.files)
+}
+
+// Size returns the number of bytes in the spool.
+func (s *Spool) Size() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.size
+}
+
+// MaxSize returns the most bytes the spool will hold, 0 for no limit.
+func (s *Spool) MaxSize() int64 {
+	return s.maxSize
+}
+
+// Drain sends spooled batches to the server in order until stop is
+// closed, backing off exponentially while the server can't be reached.
+func (s *Spool) Drain(client *Client, stop <-chan bool) {
+	backoff := SPOOL_MIN_BACKOFF
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		name, batch, err := s.Peek()
+		if name == "" {
+			select {
+			case <-stop:
+				return
+			case <-s.added:
+			}
+			continue
+		}
+		if err != nil {
+			log.Error("Dropping unreadable spooled batch %s: %v", name, err)
+			s.Remove(name)
+			continue
+		}
+
+		_, err = client.Submit(batch)
+		if err == nil {
+			if err := s.Remove(name); err != nil {
+				log.Error("Failed to remove spooled batch %s: %v", name, err)
+			}
+			if backoff > SPOOL_MIN_BACKOFF && s.Len() == 0 {
+				log.Info("Sent all spooled events")
+			}
+			backoff = SPOOL_MIN_BACKOFF
+			continue
+		}
+
+		// The server will never accept a bad batch, don't let it block
+		// the ones after it.
+		if submitErr, ok := err.(*SubmitError); ok &&
+			submitErr.StatusCode == http.StatusBadRequest {
+			log.Error("Server rejected spooled batch %s, dropping: %v",
+				name, err)
+			s.Remove(name)
+			continue
+		}
+
+		log.Warning("Failed to send %d spooled batches, will try again in %v: %v",
+			s.Len(), backoff, err)
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > SPOOL_MAX_BACKOFF {
+			backoff = SPOOL_MAX_BACKOFF
+		}
+	}
+}
@@ -0,0 +1,173 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package agent
+
+import (
+	"github.com/jasonish/evebox/eve"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evebox-spool")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	spool, err := NewSpool(dir, 10)
+	require.Nil(t, err)
+
+	name, _, err := spool.Peek()
+	require.Nil(t, err)
+	assert.Equal(t, "", name)
+
+	require.Nil(t, spool.Add([]byte("one\n")))
+	require.Nil(t, spool.Add([]byte("two\n")))
+	assert.Equal(t, ErrSpoolFull, spool.Add([]byte("three\n")))
+	assert.Equal(t, 2, spool.Len())
+	assert.Equal(t, int64(8), spool.Size())
+
+	// Reopening picks up the batches in order, ignoring incomplete
+	// writes.
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "x.batch.tmp"),
+		[]byte("partial"), 0600))
+	spool, err = NewSpool(dir, 10)
+	require.Nil(t, err)
+	assert.Equal(t, 2, spool.Len())
+
+	name, batch, err := spool.Peek()
+	require.Nil(t, err)
+	assert.Equal(t, "one\n", string(batch))
+	require.Nil(t, spool.Remove(name))
+
+	require.Nil(t, spool.Add([]byte("three\n")))
+	for _, expected := range []string{"two\n", "three\n"} {
+		name, batch, err = spool.Peek()
+		require.Nil(t, err)
+		assert.Equal(t, expected, string(batch))
+		require.Nil(t, spool.Remove(name))
+	}
+	assert.Equal(t, 0, spool.Len())
+	assert.Equal(t, int64(0), spool.Size())
+
+	_, err = os.Stat(filepath.Join(dir, "x.batch.tmp"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+// A server that accepts submissions only while up.
+type testServer struct {
+	lock     sync.Mutex
+	up       bool
+	received []string
+}
+
+func (s *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.up {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if r.URL.Path == "/api/1/version" {
+		w.Write([]byte(`{"version": "test"}`))
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	s.received = append(s.received, strings.Split(strings.TrimSpace(string(body)), "\n")...)
+	w.Write([]byte(`{"count": 1}`))
+}
+
+func (s *testServer) setUp(up bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.up = up
+}
+
+func (s *testServer) Received() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string{}, s.received...)
+}
+
+func TestSpoolingEventSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evebox-spool")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	server := &testServer{}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	client := NewClient()
+	client.SetBaseUrl(httpServer.URL)
+	spool, err := NewSpool(dir, 0)
+	require.Nil(t, err)
+	sink := NewSpoolingEventSink(client, spool)
+
+	commit := func(value string) {
+		require.Nil(t, sink.Submit(eve.EveEvent{"value": value}))
+		_, err := sink.Commit()
+		require.Nil(t, err)
+	}
+
+	// While the server is down batches are spooled.
+	commit("one")
+	commit("two")
+	assert.Equal(t, 2, sink.QueueDepth())
+	assert.Len(t, server.Received(), 0)
+
+	// New batches are spooled while there are spooled batches, even if
+	// the server is back, so they are sent in order.
+	server.setUp(true)
+	commit("three")
+	assert.Equal(t, 3, sink.QueueDepth())
+
+	stop := make(chan bool)
+	defer close(stop)
+	go spool.Drain(client, stop)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for spool.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, []string{
+		`{"value":"one"}`, `{"value":"two"}`, `{"value":"three"}`,
+	}, server.Received())
+
+	// With an empty spool batches go straight to the server.
+	commit("four")
+	assert.Equal(t, 0, sink.QueueDepth())
+	assert.Len(t, server.Received(), 4)
+}
@@ -30,7 +30,11 @@ import (
 	"github.com/jasonish/evebox/core"
 	"github.com/jasonish/evebox/elasticsearch"
 	"github.com/jasonish/evebox/geoip"
+	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/rules"
 	"github.com/jasonish/evebox/sqlite/configdb"
+	"github.com/jasonish/evebox/suppression"
+	"time"
 )
 
 type GithubAuthConfig struct {
@@ -40,11 +44,111 @@ type GithubAuthConfig struct {
 	Callback     string
 }
 
+type OidcAuthConfig struct {
+	Enabled bool
+
+	// Issuer URL, used for discovery of the provider endpoints.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Callback     string
+
+	// Scopes to request in addition to "openid".
+	Scopes []string
+
+	// Claim to use as the username, defaults to preferred_username.
+	UsernameClaim string
+
+	// Claim to map roles from, such as "groups". Roles maps each role to
+	// the claim values that grant it.
+	RoleClaim string
+	Roles     map[string][]string
+
+	// Role for users with no mapped role. If empty, and a role claim is
+	// configured, users without a mapped role are denied.
+	DefaultRole string
+
+	// Add users that do not exist in the user store on first login.
+	AutoProvision bool
+}
+
+type LdapAuthConfig struct {
+	Enabled bool
+
+	// Server URL, ldap://host:389 or ldaps://host:636.
+	Url string
+
+	// Upgrade an ldap:// connection with StartTLS.
+	StartTLS bool
+
+	// CA certificate file to verify the server certificate with, and
+	// an option to not verify it at all.
+	TlsCA              string
+	InsecureSkipVerify bool
+
+	// DN and password to bind as to search for users. If not set the
+	// search is done anonymously.
+	BindDN       string
+	BindPassword string
+
+	// Where to search for users, and the filter to find a user with.
+	// "{username}" in the filter is replaced by the escaped username.
+	BaseDN     string
+	UserFilter string
+
+	// Attribute listing the groups of a user, defaults to memberOf.
+	GroupAttribute string
+
+	// Roles maps each role to the group DNs that grant it.
+	Roles map[string][]string
+
+	// Role for users that are not in a mapped group. If empty, and roles
+	// are configured, users not in a mapped group are denied.
+	DefaultRole string
+
+	// Also allow local users with a password in the configuration
+	// database to login if they are not found in the directory, or the
+	// directory can not be reached.
+	Fallback bool
+}
+
+// LockoutConfig configures how failed logins lock out a username or
+// remote address.
+type LockoutConfig struct {
+	Enabled bool
+
+	// Failed logins before a username or address is locked out. 0
+	// disables lockout by that kind.
+	UsernameThreshold int
+	AddressThreshold  int
+
+	// How long the first lockout lasts. It doubles with each further
+	// failure, up to MaxDelay.
+	Delay    time.Duration
+	MaxDelay time.Duration
+
+	// Forget failures after this long without another failure.
+	ResetAfter time.Duration
+}
+
+// PasswordPolicyConfig configures the policy local passwords must meet.
+type PasswordPolicyConfig struct {
+	MinLength  int
+	MinClasses int
+
+	// File of known breached passwords or their SHA-1 hashes.
+	BreachedList string
+}
+
 type Config struct {
 	Http struct {
 		TlsEnabled     bool
 		TlsCertificate string
 		TlsKey         string
+
+		// CA used to verify client certificates presented by agents.
+		TlsClientCA string
+
 		ReverseProxy   bool
 		RequestLogging bool
 	}
@@ -61,6 +165,39 @@ type Config struct {
 
 		// GitHub Oauth2.
 		Github GithubAuthConfig
+
+		// Generic OpenID Connect.
+		Oidc OidcAuthConfig
+
+		// LDAP for username and password authentication.
+		Ldap LdapAuthConfig
+
+		Lockout LockoutConfig
+
+		PasswordPolicy PasswordPolicyConfig
+
+		Session struct {
+			// Idle timeout of login sessions.
+			Timeout time.Duration
+
+			// Persist sessions in the configuration database so they
+			// survive a restart.
+			Persistent bool
+		}
+
+		Agents struct {
+			// Require agents to authenticate with a sensor token or
+			// client certificate to submit events.
+			Required bool
+
+			// Require agents to present a client certificate verified
+			// with Http.TlsClientCA, a sensor token is not enough.
+			RequireCertificate bool
+
+			// The maximum number of sensors unauthenticated agents can
+			// register, 0 for no limit.
+			MaxUnauthenticated int
+		}
 	}
 }
 
@@ -71,6 +208,28 @@ type AppContext struct {
 	ConfigDB  *configdb.ConfigDB
 	Userstore core.UserStore
 
+	ApiTokenStore core.ApiTokenStore
+
+	TotpStore core.TotpStore
+
+	LoginFailureStore core.LoginFailureStore
+
+	AuditStore core.AuditStore
+
+	// Only set if persistent sessions are enabled.
+	PersistentSessionStore core.PersistentSessionStore
+
+	SensorStore core.SensorStore
+
+	SuppressionStore core.SuppressionStore
+
+	SavedSearchStore core.SavedSearchStore
+
+	PreferencesStore core.PreferencesStore
+
+	// Applies the suppressions to incoming alerts.
+	SuppressionFilter *suppression.Filter
+
 	// The interface to the underlying datastore.
 	DataStore core.Datastore
 
@@ -80,6 +239,9 @@ type AppContext struct {
 
 	GeoIpService *geoip.GeoIpService
 
+	// Suricata rules, if configured.
+	RuleMap *rules.RuleMap
+
 	Features map[core.Feature]bool
 
 	// A default time range to send to a client. Mainly useful for oneshot
@@ -91,6 +253,25 @@ type AppContext struct {
 	ForceDefaultTimeRange bool
 }
 
+// GetDefaultTimeRange returns the default time range for a user. This is the
+// user's preferred time range unless the default time range is forced, or
+// the user has no preference.
+func (c *AppContext) GetDefaultTimeRange(user core.User) string {
+	if c.ForceDefaultTimeRange || c.PreferencesStore == nil {
+		return c.DefaultTimeRange
+	}
+	preferences, err := c.PreferencesStore.GetPreferences(user)
+	if err != nil {
+		log.Warning("Failed to get preferences for user %s: %v",
+			user.Username, err)
+		return c.DefaultTimeRange
+	}
+	if preferences.TimeRange != "" {
+		return preferences.TimeRange
+	}
+	return c.DefaultTimeRange
+}
+
 func (c *AppContext) SetFeature(feature core.Feature) {
 	if c.Features == nil {
 		c.Features = map[core.Feature]bool{}
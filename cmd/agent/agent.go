@@ -27,29 +27,49 @@
 package agent
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"github.com/jasonish/evebox/agent"
+	"github.com/jasonish/evebox/core"
 	"github.com/jasonish/evebox/eve"
 	"github.com/jasonish/evebox/evereader"
 	"github.com/jasonish/evebox/log"
 	"github.com/jasonish/evebox/rules"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"syscall"
+	"time"
 )
 
 var flagset *pflag.FlagSet
 
+// How often counts of events dropped by filters are logged.
+const FILTER_STATS_INTERVAL = 60 * time.Second
+
 func initViper() {
 	viper.SetDefault("disable-certificate-check", false)
 
+	viper.SetDefault("heartbeat.enabled", true)
+	viper.SetDefault("heartbeat.interval", core.DEFAULT_HEARTBEAT_INTERVAL)
+
+	viper.SetDefault("spool.enabled", true)
+	viper.SetDefault("spool.max-size-mb", 100)
+
 	viper.BindEnv("bookmark-directory", "BOOKMARK_DIRECTORY")
 
 	viper.BindEnv("server.url", "EVEBOX_AGENT_SERVER")
 	viper.BindEnv("server.username", "EVEBOX_AGENT_USERNAME")
 	viper.BindEnv("server.password", "EVEBOX_AGENT_PASSWORD")
+	viper.BindEnv("server.token", "EVEBOX_AGENT_TOKEN")
+
+	viper.BindEnv("metrics.address", "EVEBOX_AGENT_METRICS_ADDRESS")
 }
 
 func configure(args []string) {
@@ -100,73 +120,259 @@ func configure(args []string) {
 	}
 }
 
-func Main(args []string) {
+// inputPaths returns the paths and glob patterns to read from
+// input.filename and input.filenames.
+func inputPaths() []string {
+	paths := viper.GetStringSlice("input.filenames")
+	if filename := viper.GetString("input.filename"); filename != "" {
+		paths = append([]string{filename}, paths...)
+	}
+	return paths
+}
 
-	initViper()
-	configure(args)
+// An input the filters and custom fields from the configuration are added to.
+type input interface {
+	AddFilter(filter eve.EveFilter)
+	AddCustomField(field string, value interface{})
+}
 
-	var serverUrl string
-	var serverUsername string
-	var serverPassword string
+// newRedisProcessor returns a Redis input configured from input.redis.
+func newRedisProcessor() *evereader.RedisProcessor {
+	return &evereader.RedisProcessor{
+		Address:       viper.GetString("input.redis.address"),
+		Password:      viper.GetString("input.redis.password"),
+		Database:      viper.GetInt("input.redis.database"),
+		Mode:          viper.GetString("input.redis.mode"),
+		Key:           viper.GetString("input.redis.key"),
+		ProcessingKey: viper.GetString("input.redis.processing-key"),
+	}
+}
 
-	serverNode := viper.Get("server")
-	switch serverNode.(type) {
-	case string:
-		serverUrl = viper.GetString("server")
-	case map[string]interface{}:
-		serverUrl = viper.GetString("server.url")
-		serverUsername = viper.GetString("server.username")
-		serverPassword = viper.GetString("server.password")
+// newEventFilter returns the drop and keep rules configured in
+// input.filters, or nil if there are none. Counts of dropped events are
+// logged every minute.
+func newEventFilter() *agent.EventFilter {
+	var configs []agent.FilterRuleConfig
+	if err := viper.UnmarshalKey("input.filters", &configs); err != nil {
+		log.Fatalf("Bad input.filters: %v", err)
+	}
+	if len(configs) == 0 {
+		return nil
 	}
+	filter, err := agent.NewEventFilter(configs)
+	if err != nil {
+		log.Fatalf("Bad input.filters: %v", err)
+	}
+	log.Info("Loaded %d event filter rules", len(configs))
+
+	go func() {
+		for range time.Tick(FILTER_STATS_INTERVAL) {
+			counts := []string{}
+			for _, count := range filter.Dropped() {
+				counts = append(counts, fmt.Sprintf("%s: %d",
+					count.Name, count.Dropped))
+			}
+			log.Info("Events dropped by filters: %s",
+				strings.Join(counts, "; "))
+		}
+	}()
 
-	if serverUrl == "" {
-		log.Fatal("error: no server url provided")
+	return filter
+}
+
+// newRuleMap returns the rules loaded from input.rules, or nil if there
+// are none. The rules are shared by all inputs.
+func newRuleMap() *rules.RuleMap {
+	ruleList := viper.GetStringSlice("input.rules")
+	if len(ruleList) == 0 {
+		return nil
 	}
+	return rules.NewRuleMap(ruleList)
+}
 
-	client := agent.NewClient()
-	client.SetBaseUrl(serverUrl)
+func configureInput(input input, eventFilter *agent.EventFilter,
+	ruleMap *rules.RuleMap) {
+	input.AddFilter(&eve.TagsFilter{})
+	if eventFilter != nil {
+		input.AddFilter(eventFilter)
+	}
+	for field, value := range viper.GetStringMap("input.custom-fields") {
+		input.AddCustomField(field, value)
+	}
+	if ruleMap != nil {
+		input.AddFilter(ruleMap)
+	}
+}
 
-	log.Info("Username: %s; password: %s", serverUsername, serverPassword)
+// sensorID returns the stable ID the agent registers with: sensor.id if
+// set, otherwise an ID generated and stored in the bookmark directory.
+// Without a bookmark directory the ID is derived from the hostname.
+func sensorID(bookmarkDirectory string, hostname string) string {
+	if id := viper.GetString("sensor.id"); id != "" {
+		return id
+	}
+	if bookmarkDirectory != "" {
+		id, err := agent.LoadSensorID(filepath.Join(bookmarkDirectory,
+			"sensor-id"))
+		if err != nil {
+			log.Fatalf("Failed to load sensor ID: %v", err)
+		}
+		return id
+	}
+	log.Warning("No sensor.id or bookmark-directory set, using a sensor ID derived from the hostname")
+	hash := sha256.Sum256([]byte(hostname))
+	return hex.EncodeToString(hash[:16])
+}
 
-	if serverUsername != "" || serverPassword != "" {
-		client.SetUsernamePassword(serverUsername, serverPassword)
+// startHeartbeats sends heartbeats to the EveBox outputs until stop is
+// closed.
+func startHeartbeats(bookmarkDirectory string, outputs []*output,
+	metrics *agent.Metrics, stop <-chan bool) {
+	clients := []*agent.Client{}
+	for _, output := range outputs {
+		if output.client != nil {
+			clients = append(clients, output.client)
+		}
+	}
+	if len(clients) == 0 {
+		return
 	}
 
-	version, err := client.GetVersion()
+	hostname, err := os.Hostname()
 	if err != nil {
-		log.Error("Failed to query server for version, will continue: %v", err)
-	} else {
-		log.Info("Connected to EveBox version %s", version.Get("version"))
+		log.Warning("Failed to get hostname: %v", err)
 	}
+	heartbeater := &agent.Heartbeater{
+		SensorID: sensorID(bookmarkDirectory, hostname),
+		Name:     viper.GetString("sensor.name"),
+		Hostname: hostname,
+		Interval: viper.GetDuration("heartbeat.interval"),
+		Metrics:  metrics,
+	}
+	for _, client := range clients {
+		heartbeater.AddClient(client)
+	}
+	log.Info("Sending heartbeats as sensor ID %s every %v",
+		heartbeater.SensorID, heartbeater.Interval)
+	go heartbeater.Run(stop)
+}
+
+// startMetricsServer serves the metrics and readiness check on address.
+func startMetricsServer(address string, metrics *agent.Metrics) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		log.Fatalf("Failed to start metrics server: %v", err)
+	}
+	log.Info("Serving metrics on http://%s/metrics", listener.Addr())
+	go func() {
+		err := http.Serve(listener, metrics.Handler())
+		log.Error("Metrics server stopped: %v", err)
+	}()
+}
+
+func Main(args []string) {
+
+	initViper()
+	configure(args)
 
 	if !viper.InConfig("input") {
 		log.Fatal("No input configured.")
 	}
-	path := viper.GetString("input.filename")
+	paths := inputPaths()
+	socketPath := viper.GetString("input.socket.path")
+	redisKey := viper.GetString("input.redis.key")
+	if len(paths) == 0 && socketPath == "" && redisKey == "" {
+		log.Fatal("No input filenames, socket or redis configured.")
+	}
+
+	bookmarkDirectory := viper.GetString("bookmark-directory")
+	outputs := loadOutputs(bookmarkDirectory)
+
+	eventFilter := newEventFilter()
+	ruleMap := newRuleMap()
+
+	metrics := agent.NewMetrics()
+	metrics.SetEventFilter(eventFilter)
+	for _, output := range outputs {
+		if output.spool != nil {
+			metrics.AddSpool(output.name, output.spool)
+		}
+	}
 
-	eveFileProcessor := evereader.EveFileProcessor{
-		Filename:          path,
-		BookmarkDirectory: viper.GetString("bookmark-directory"),
-		Sink:              agent.NewEventChannel(client),
+	// Each output reads the files with its own bookmarks.
+	eveFileProcessors := []*evereader.MultiFileProcessor{}
+	if len(paths) > 0 {
+		for i, output := range outputs {
+			eveFileProcessor := &evereader.MultiFileProcessor{
+				Patterns:          paths,
+				BookmarkDirectory: bookmarkDirectory,
+				BookmarkName:      output.name,
+				NewSink:           output.newSink,
+			}
+			// Every output reads the same events, only count those
+			// dropped by filters for the first.
+			filter := eventFilter
+			if i > 0 && eventFilter != nil {
+				filter = eventFilter.Uncounted()
+			}
+			configureInput(eveFileProcessor, filter, ruleMap)
+			eveFileProcessor.Start()
+			metrics.AddSource(eveFileProcessor)
+			eveFileProcessors = append(eveFileProcessors, eveFileProcessor)
+		}
 	}
-	eveFileProcessor.AddFilter(&eve.TagsFilter{})
-	for field, value := range viper.GetStringMap("input.custom-fields") {
-		eveFileProcessor.AddCustomField(field, value)
+
+	var socketProcessor *evereader.SocketProcessor
+	if socketPath != "" {
+		socketProcessor = &evereader.SocketProcessor{
+			Path: socketPath,
+			Type: viper.GetString("input.socket.type"),
+			Sink: newSink(outputs),
+		}
+		configureInput(socketProcessor, eventFilter, ruleMap)
+		if err := socketProcessor.Start(); err != nil {
+			log.Fatalf("Failed to listen on %s: %v", socketPath, err)
+		}
+		metrics.AddSource(socketProcessor)
 	}
 
-	ruleList := viper.GetStringSlice("input.rules")
-	if ruleList != nil {
-		ruleMap := rules.NewRuleMap(ruleList)
-		eveFileProcessor.AddFilter(ruleMap)
+	var redisProcessor *evereader.RedisProcessor
+	if redisKey != "" {
+		redisProcessor = newRedisProcessor()
+		redisProcessor.Sink = newSink(outputs)
+		configureInput(redisProcessor, eventFilter, ruleMap)
+		if err := redisProcessor.Start(); err != nil {
+			log.Fatalf("Failed to start redis input: %v", err)
+		}
+		metrics.AddSource(redisProcessor)
+	}
+
+	if address := viper.GetString("metrics.address"); address != "" {
+		startMetricsServer(address, metrics)
 	}
 
-	eveFileProcessor.Start()
+	stopHeartbeats := make(chan bool)
+	if viper.GetBool("heartbeat.enabled") {
+		startHeartbeats(bookmarkDirectory, outputs, metrics, stopHeartbeats)
+	}
 
-	sigchan := make(chan os.Signal)
+	sigchan := make(chan os.Signal, 1)
 	signal.Notify(sigchan, os.Interrupt, syscall.SIGTERM)
 	for sig := range sigchan {
 		log.Info("Got signal %d, stopping.", sig)
-		eveFileProcessor.Stop()
+		for _, eveFileProcessor := range eveFileProcessors {
+			eveFileProcessor.Stop()
+		}
+		if socketProcessor != nil {
+			socketProcessor.Stop()
+		}
+		if redisProcessor != nil {
+			redisProcessor.Stop()
+		}
+		close(stopHeartbeats)
+		for _, output := range outputs {
+			output.stop()
+		}
 		break
 	}
 }
@@ -0,0 +1,344 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package agent
+
+import (
+	"github.com/jasonish/evebox/agent"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/elasticsearch"
+	"github.com/jasonish/evebox/log"
+	"github.com/spf13/viper"
+	"path/filepath"
+	"regexp"
+)
+
+// Output types.
+const (
+	OUTPUT_EVEBOX        = "evebox"
+	OUTPUT_ELASTICSEARCH = "elasticsearch"
+	OUTPUT_FILE          = "file"
+)
+
+const DEFAULT_ELASTICSEARCH_INDEX = "logstash"
+
+// Output names are used in bookmark and spool filenames.
+var outputNamePattern = regexp.MustCompile("^[A-Za-z0-9_-]*$")
+
+type tlsConfig struct {
+	CA          string
+	Certificate string
+	Key         string
+}
+
+// outputConfig is an output as configured in the outputs list, or the
+// server option.
+type outputConfig struct {
+	Name string
+
+	// OUTPUT_EVEBOX (the default), OUTPUT_ELASTICSEARCH or OUTPUT_FILE.
+	Type string
+
+	// EveBox and Elastic Search options.
+	URL                     string
+	Username                string
+	Password                string
+	DisableCertificateCheck bool `mapstructure:"disable-certificate-check"`
+
+	// EveBox options.
+	Token       string
+	Compression string
+	TLS         tlsConfig
+
+	// Elastic Search options.
+	Index   string
+	DocType string `mapstructure:"doc-type"`
+
+	// File options.
+	Filename  string
+	MaxSizeMB int64 `mapstructure:"max-size-mb"`
+	MaxFiles  int   `mapstructure:"max-files"`
+}
+
+// output is a destination events are sent to. Each output reads the input
+// files with its own bookmarks, so one slow output doesn't hold up the
+// others.
+type output struct {
+	name    string
+	newSink func() core.EveEventSink
+	stop    func()
+
+	// The client and spool of an EveBox output, the spool only if
+	// spooling.
+	client *agent.Client
+	spool  *agent.Spool
+}
+
+func (o *output) String() string {
+	if o.name == "" {
+		return "default"
+	}
+	return o.name
+}
+
+// serverOutputConfig returns the output configured by the server option.
+func serverOutputConfig() outputConfig {
+	config := outputConfig{Type: OUTPUT_EVEBOX}
+	switch viper.Get("server").(type) {
+	case string:
+		config.URL = viper.GetString("server")
+	case map[string]interface{}:
+		config.URL = viper.GetString("server.url")
+		config.Username = viper.GetString("server.username")
+		config.Password = viper.GetString("server.password")
+		config.Token = viper.GetString("server.token")
+		config.Compression = viper.GetString("server.compression")
+		config.TLS = tlsConfig{
+			CA:          viper.GetString("server.tls.ca"),
+			Certificate: viper.GetString("server.tls.certificate"),
+			Key:         viper.GetString("server.tls.key"),
+		}
+	}
+	return config
+}
+
+// loadOutputs opens the outputs configured in the outputs list, or the
+// server if there is no list.
+func loadOutputs(bookmarkDirectory string) []*output {
+	var configs []outputConfig
+	if viper.IsSet("outputs") {
+		if err := viper.UnmarshalKey("outputs", &configs); err != nil {
+			log.Fatalf("Bad outputs: %v", err)
+		}
+		if len(configs) == 0 {
+			log.Fatal("No outputs configured.")
+		}
+		if viper.InConfig("server") || flagset.Changed("server") {
+			log.Warning("Ignoring server, outputs are configured")
+		}
+	} else {
+		configs = []outputConfig{serverOutputConfig()}
+	}
+
+	names := map[string]bool{}
+	outputs := []*output{}
+	for _, config := range configs {
+		if !outputNamePattern.MatchString(config.Name) {
+			log.Fatalf("Bad output name %q: only letters, numbers, - and _ are allowed",
+				config.Name)
+		}
+		if names[config.Name] {
+			if config.Name == "" {
+				log.Fatal("Only one output may be unnamed.")
+			}
+			log.Fatalf("Duplicate output name %s.", config.Name)
+		}
+		names[config.Name] = true
+
+		if !config.DisableCertificateCheck {
+			config.DisableCertificateCheck =
+				viper.GetBool("disable-certificate-check")
+		}
+
+		var output *output
+		switch config.Type {
+		case OUTPUT_EVEBOX, "":
+			output = newEveBoxOutput(config, bookmarkDirectory)
+		case OUTPUT_ELASTICSEARCH:
+			output = newElasticSearchOutput(config)
+		case OUTPUT_FILE:
+			output = newFileOutput(config)
+		default:
+			log.Fatalf("Unknown output type %s.", config.Type)
+		}
+		output.name = config.Name
+		outputs = append(outputs, output)
+	}
+	return outputs
+}
+
+func newEveBoxOutput(config outputConfig, bookmarkDirectory string) *output {
+	if config.URL == "" {
+		log.Fatal("error: no server url provided")
+	}
+
+	client := agent.NewClient()
+	client.SetBaseUrl(config.URL)
+
+	if config.Token != "" {
+		log.Info("Authenticating to %s with sensor token", config.URL)
+		client.SetToken(config.Token)
+	} else if config.Username != "" || config.Password != "" {
+		log.Info("Authenticating to %s as user %s", config.URL,
+			config.Username)
+		client.SetUsernamePassword(config.Username, config.Password)
+	}
+
+	client.DisableCertCheck(config.DisableCertificateCheck)
+	if config.TLS.CA != "" {
+		if err := client.SetCA(config.TLS.CA); err != nil {
+			log.Fatal(err)
+		}
+		log.Info("Verifying server certificate with CA %s", config.TLS.CA)
+	}
+	if config.TLS.Certificate != "" {
+		err := client.SetClientCertificate(config.TLS.Certificate,
+			config.TLS.Key)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Info("Authenticating with client certificate %s",
+			config.TLS.Certificate)
+	}
+
+	compression := config.Compression
+	if compression == "" {
+		compression = agent.COMPRESSION_AUTO
+	}
+	if err := client.SetCompression(compression); err != nil {
+		log.Fatalf("Bad compression for %s: %v", config.URL, err)
+	}
+
+	version, err := client.GetVersion()
+	if err != nil {
+		log.Error("Failed to query server %s for version, will continue: %v",
+			config.URL, err)
+	} else {
+		log.Info("Connected to EveBox version %s at %s",
+			version.Get("version"), config.URL)
+	}
+
+	spool := configureSpool(bookmarkDirectory, config.Name)
+	stopSpool := make(chan bool)
+	if spool != nil {
+		go spool.Drain(client, stopSpool)
+	}
+
+	return &output{
+		client: client,
+		spool:  spool,
+		newSink: func() core.EveEventSink {
+			if spool != nil {
+				return agent.NewSpoolingEventSink(client, spool)
+			}
+			return agent.NewEventChannel(client)
+		},
+		stop: func() {
+			close(stopSpool)
+		},
+	}
+}
+
+func newElasticSearchOutput(config outputConfig) *output {
+	if config.URL == "" {
+		log.Fatal("error: no elasticsearch url provided")
+	}
+	if config.Index == "" {
+		config.Index = DEFAULT_ELASTICSEARCH_INDEX
+	}
+
+	es := elasticsearch.New(elasticsearch.Config{
+		BaseURL:          config.URL,
+		DisableCertCheck: config.DisableCertificateCheck,
+		Username:         config.Username,
+		Password:         config.Password,
+		Index:            config.Index,
+		DocType:          config.DocType,
+	})
+	response, err := es.Ping()
+	if err != nil {
+		log.Error("Failed to ping Elastic Search at %s, will continue: %v",
+			config.URL, err)
+	} else {
+		log.Info("Connected to Elastic Search v%s at %s",
+			response.Version.Number, config.URL)
+	}
+
+	return &output{
+		newSink: func() core.EveEventSink {
+			return elasticsearch.NewIndexer(es)
+		},
+		stop: func() {},
+	}
+}
+
+func newFileOutput(config outputConfig) *output {
+	if config.Filename == "" {
+		log.Fatal("error: no output filename provided")
+	}
+	file := agent.NewRotatingFile(config.Filename,
+		config.MaxSizeMB*1024*1024, config.MaxFiles)
+	log.Info("Writing events to %s", config.Filename)
+	return &output{
+		newSink: func() core.EveEventSink {
+			return agent.NewFileSink(file)
+		},
+		stop: func() {
+			file.Close()
+		},
+	}
+}
+
+// newSink returns a sink sending events to all the outputs, for inputs that
+// can't be read separately for each output.
+func newSink(outputs []*output) core.EveEventSink {
+	if len(outputs) == 1 {
+		return outputs[0].newSink()
+	}
+	sinks := []core.EveEventSink{}
+	for _, output := range outputs {
+		sinks = append(sinks, output.newSink())
+	}
+	return agent.NewFanoutSink(sinks...)
+}
+
+// configureSpool opens the spool that batches are written to while the
+// server can't be reached, returning nil if spooling is disabled. Named
+// outputs have their own directory in the spool directory.
+func configureSpool(bookmarkDirectory string, name string) *agent.Spool {
+	if !viper.GetBool("spool.enabled") {
+		return nil
+	}
+	directory := viper.GetString("spool.directory")
+	if directory == "" {
+		if bookmarkDirectory == "" {
+			log.Warning("Not spooling events while the server is unreachable, no spool.directory or bookmark-directory set")
+			return nil
+		}
+		directory = filepath.Join(bookmarkDirectory, "spool")
+	}
+	if name != "" {
+		directory = filepath.Join(directory, name)
+	}
+	maxSize := viper.GetInt64("spool.max-size-mb") * 1024 * 1024
+	spool, err := agent.NewSpool(directory, maxSize)
+	if err != nil {
+		log.Fatalf("Failed to open spool: %v", err)
+	}
+	log.Info("Spooling events to %s while the server is unreachable (%d batches queued)",
+		directory, spool.Len())
+	return spool
+}
@@ -28,12 +28,46 @@ package config
 
 import (
 	"fmt"
+	"github.com/jasonish/evebox/core"
 	"github.com/jasonish/evebox/sqlite/configdb"
 	"github.com/ogier/pflag"
+	"github.com/spf13/viper"
 	"log"
 	"os"
 )
 
+// The policy passwords set with the config tool must meet, from the
+// server configuration file if provided.
+var passwordPolicy = core.DefaultPasswordPolicy()
+
+// loadPasswordPolicy loads the password policy from the server
+// configuration file.
+func loadPasswordPolicy(filename string) error {
+	viper.SetConfigFile(filename)
+	if err := viper.ReadInConfig(); err != nil {
+		return err
+	}
+	viper.SetDefault("authentication.password-policy.min-length",
+		core.DEFAULT_PASSWORD_MIN_LENGTH)
+	policy, err := core.NewPasswordPolicy(
+		viper.GetInt("authentication.password-policy.min-length"),
+		viper.GetInt("authentication.password-policy.min-classes"),
+		viper.GetString("authentication.password-policy.breached-list"))
+	if err != nil {
+		return err
+	}
+	passwordPolicy = policy
+	return nil
+}
+
+// newUserStore returns a user store that checks new passwords against
+// the password policy.
+func newUserStore(db *configdb.ConfigDB) *configdb.UserStore {
+	userStore := configdb.NewUserStore(db.DB)
+	userStore.SetPasswordPolicy(passwordPolicy)
+	return userStore
+}
+
 func usage(flagset *pflag.FlagSet) {
 	fmt.Fprintf(os.Stderr,
 		"Usage: evebox config -D <dir> <command> [args...]\n")
@@ -43,6 +77,9 @@ func usage(flagset *pflag.FlagSet) {
 	fmt.Fprintf(os.Stderr, `
 Commands:
     users
+    suppressions
+    sensors
+    lockouts
 
 `)
 }
@@ -50,6 +87,7 @@ Commands:
 func Main(args []string) {
 
 	var dataDirectory string
+	var configFilename string
 
 	flagset := pflag.NewFlagSet("evebox config", pflag.ExitOnError)
 	flagset.Usage = func() {
@@ -58,6 +96,8 @@ func Main(args []string) {
 	flagset.SetInterspersed(false)
 	flagset.StringVarP(&dataDirectory, "data-directory", "D",
 		"", "Data directory")
+	flagset.StringVarP(&configFilename, "config", "c",
+		"", "Server configuration file, for the password policy")
 	flagset.Parse(args)
 
 	commandArgs := flagset.Args()
@@ -70,6 +110,12 @@ func Main(args []string) {
 		log.Fatal("error: --data-directory is required")
 	}
 
+	if configFilename != "" {
+		if err := loadPasswordPolicy(configFilename); err != nil {
+			log.Fatalf("error: failed to load password policy: %v", err)
+		}
+	}
+
 	db, err := configdb.NewConfigDB(dataDirectory)
 	if err != nil {
 		log.Fatalf("error: %v", err)
@@ -80,6 +126,12 @@ func Main(args []string) {
 	switch command {
 	case "users":
 		UsersMain(db, args)
+	case "suppressions":
+		SuppressionsMain(db, args)
+	case "sensors":
+		SensorsMain(db, args)
+	case "lockouts":
+		LockoutsMain(db, args)
 	default:
 		fmt.Fprintf(os.Stderr, "error: unknown command: %s", command)
 		os.Exit(1)
@@ -0,0 +1,90 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package config
+
+import (
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/server/auth"
+	"github.com/jasonish/evebox/sqlite/configdb"
+	"github.com/jasonish/evebox/util"
+	"os"
+)
+
+func LockoutsMain(db *configdb.ConfigDB, args []string) {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, `Usage: lockouts <command>
+
+Commands:
+    list
+    unlock <username>
+    unlock-address <address>
+
+`)
+	}
+
+	if len(args) < 1 {
+		usage()
+		return
+	}
+
+	store := configdb.NewLoginFailureStore(db.DB)
+	auditStore := configdb.NewAuditStore(db.DB)
+
+	unlock := func(kind string, value string) {
+		err := auth.Unlock(store, auditStore, kind, value, "cli", "")
+		if err == core.ErrNoLoginFailures {
+			fatal("No failed logins for %s %s", kind, value)
+		} else if err != nil {
+			fatal("Failed to unlock %s: %v", value, err)
+		}
+		println("OK")
+	}
+
+	switch args[0] {
+	case "list":
+		lockouts, err := store.FindLockouts()
+		if err != nil {
+			fatal("%v", err)
+		}
+		for _, lockout := range lockouts {
+			println("%s", util.ToJson(lockout))
+		}
+	case "unlock":
+		if len(args) != 2 {
+			fatal("Usage: lockouts unlock <username>")
+		}
+		unlock(core.LOCKOUT_USERNAME, args[1])
+	case "unlock-address":
+		if len(args) != 2 {
+			fatal("Usage: lockouts unlock-address <address>")
+		}
+		unlock(core.LOCKOUT_ADDRESS, args[1])
+	default:
+		usage()
+	}
+}
@@ -0,0 +1,90 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package config
+
+import (
+	"fmt"
+	"github.com/jasonish/evebox/sqlite/configdb"
+	"github.com/jasonish/evebox/util"
+	"os"
+	"strconv"
+)
+
+func SensorsMain(db *configdb.ConfigDB, args []string) {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, `Usage: sensors <command>
+
+Commands:
+    list
+    add <name>
+    rm <id>
+
+`)
+	}
+
+	if len(args) < 1 {
+		usage()
+		return
+	}
+
+	store := configdb.NewSensorStore(db.DB)
+
+	switch args[0] {
+	case "list":
+		sensors, err := store.FindSensors()
+		if err != nil {
+			fatal("%v", err)
+		}
+		for _, sensor := range sensors {
+			println("%s", util.ToJson(sensor))
+		}
+	case "add":
+		if len(args) != 2 {
+			fatal("Usage: sensors add <name>")
+		}
+		token, sensor, err := store.AddSensor(args[1])
+		if err != nil {
+			fatal("Failed to add sensor: %v", err)
+		}
+		printerr("Sensor added with ID %d. Configure the agent with the token:", sensor.Id)
+		println("%s", token)
+	case "rm":
+		if len(args) != 2 {
+			fatal("Usage: sensors rm <id>")
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			fatal("error: bad sensor id: %s", args[1])
+		}
+		if err := store.DeleteSensor(id); err != nil {
+			fatal("Failed to delete sensor: %v", err)
+		}
+		println("OK")
+	default:
+		usage()
+	}
+}
@@ -0,0 +1,123 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package config
+
+import (
+	"fmt"
+	"github.com/jasonish/evebox/rules"
+	"github.com/jasonish/evebox/sqlite/configdb"
+	"github.com/jasonish/evebox/suppression"
+	"github.com/jasonish/evebox/util"
+	"github.com/spf13/pflag"
+	"os"
+)
+
+func SuppressionsMain(db *configdb.ConfigDB, args []string) {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, `Usage: suppressions <command>
+
+Commands:
+    list
+    export
+
+`)
+	}
+
+	if len(args) < 1 {
+		usage()
+		return
+	}
+
+	switch args[0] {
+	case "list":
+		suppressionsList(db, args[1:])
+	case "export":
+		suppressionsExport(db, args[1:])
+	default:
+		usage()
+	}
+}
+
+func suppressionsList(db *configdb.ConfigDB, args []string) {
+	store := configdb.NewSuppressionStore(db.DB)
+	suppressions, err := store.FindAllSuppressions()
+	if err != nil {
+		fatal("%v", err)
+	}
+	for _, suppression := range suppressions {
+		println("%s", util.ToJson(suppression))
+	}
+}
+
+// suppressionsExport writes the suppressions out as a Suricata
+// threshold.config.
+func suppressionsExport(db *configdb.ConfigDB, args []string) {
+	var ruleFiles []string
+	var output string
+	options := suppression.ThresholdOptions{}
+
+	flagset := pflag.NewFlagSet("suppressions export", pflag.ExitOnError)
+	flagset.StringSliceVar(&ruleFiles, "rules", nil,
+		"Rule files to get signature names from")
+	flagset.StringVarP(&output, "output", "o", "",
+		"Output filename (default stdout)")
+	flagset.StringVar(&options.Sensor, "sensor", "",
+		"Include suppressions for this sensor")
+	flagset.IntVar(&options.LimitCount, "limit-count", 0,
+		"Limit signature only suppressions to this many alerts instead of suppressing")
+	flagset.IntVar(&options.LimitSeconds, "limit-seconds", 3600,
+		"Time period for --limit-count")
+	flagset.Parse(args)
+
+	if options.LimitCount > 0 && options.LimitSeconds < 1 {
+		fatal("error: --limit-seconds must be greater than 0")
+	}
+
+	var ruleMap *rules.RuleMap
+	if len(ruleFiles) > 0 {
+		ruleMap = rules.NewRuleMap(ruleFiles)
+	}
+
+	store := configdb.NewSuppressionStore(db.DB)
+	suppressions, err := store.FindAllSuppressions()
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	out := os.Stdout
+	if output != "" {
+		out, err = os.Create(output)
+		if err != nil {
+			fatal("error: %v", err)
+		}
+		defer out.Close()
+	}
+
+	if err := suppression.WriteThresholdConfig(out, suppressions, ruleMap, options); err != nil {
+		fatal("error: %v", err)
+	}
+}
@@ -52,6 +52,10 @@ Commands:
     add
     rm
     passwd
+    role
+    disable
+    enable
+    totp
 
 `)
 	}
@@ -70,6 +74,14 @@ Commands:
 		usersRemove(db, args[1:])
 	case "passwd":
 		usersPasswd(db, args[1:])
+	case "role":
+		usersRole(db, args[1:])
+	case "disable":
+		usersSetDisabled(db, args[1:], true)
+	case "enable":
+		usersSetDisabled(db, args[1:], false)
+	case "totp":
+		usersTotp(db, args[1:])
 	default:
 		usage()
 	}
@@ -114,6 +126,7 @@ func UsersAdd(db *configdb.ConfigDB, args []string) {
 	var username string
 	var password string
 	var githubUsername string
+	var role string
 
 	flagset := pflag.NewFlagSet("users add", pflag.ExitOnError)
 	flagset.StringVarP(&username, "username", "u", "",
@@ -122,15 +135,23 @@ func UsersAdd(db *configdb.ConfigDB, args []string) {
 		"Password")
 	flagset.StringVar(&githubUsername, "github-username", "",
 		"GitHub username (for Oauth2)")
+	flagset.StringVar(&role, "role", core.ROLE_ANALYST,
+		"Role: viewer, analyst or admin")
 	flagset.Parse(args)
 
+	if !core.IsValidRole(role) {
+		fatal("error: invalid role: %s", role)
+	}
+
 	// Some validation.
 	if password != "" && githubUsername != "" {
 		fatal("error: password and external user-id may not be used together")
 	}
 
-	userstore := configdb.NewUserStore(db.DB)
-	user := core.User{}
+	userstore := newUserStore(db)
+	user := core.User{
+		Role: role,
+	}
 
 	if username == "" {
 		username = readString("Enter username")
@@ -178,7 +199,7 @@ func UsersAdd(db *configdb.ConfigDB, args []string) {
 }
 
 func UsersList(db *configdb.ConfigDB, args []string) {
-	userstore := configdb.NewUserStore(db.DB)
+	userstore := newUserStore(db)
 
 	users, err := userstore.FindAll()
 	if err != nil {
@@ -190,7 +211,7 @@ func UsersList(db *configdb.ConfigDB, args []string) {
 }
 
 func checkForUsername(db *configdb.ConfigDB, username string) bool {
-	userstore := configdb.NewUserStore(db.DB)
+	userstore := newUserStore(db)
 	_, err := userstore.FindByUsername(username)
 	if err == nil {
 		return true
@@ -210,7 +231,7 @@ func usersRemove(db *configdb.ConfigDB, args []string) {
 		username = readString("Username to remove")
 	}
 
-	userstore := configdb.NewUserStore(db.DB)
+	userstore := newUserStore(db)
 	err := userstore.DeleteByUsername(username)
 	if err != nil {
 		printerr("Failed to delete user: %v", err)
@@ -242,9 +263,16 @@ func getGitHubUser(username string) (user auth.GitHubUser, err error) {
 func usersPasswd(db *configdb.ConfigDB, args []string) {
 	var username string
 	var password string
+	var requireChange bool
 
 	var err error
 
+	flagset := pflag.NewFlagSet("users passwd", pflag.ExitOnError)
+	flagset.BoolVar(&requireChange, "require-change", false,
+		"Require the user to change the password on their next login")
+	flagset.Parse(args)
+	args = flagset.Args()
+
 	if len(args) > 0 {
 		username = args[0]
 	}
@@ -267,10 +295,90 @@ func usersPasswd(db *configdb.ConfigDB, args []string) {
 		}
 	}
 
-	userStore := configdb.NewUserStore(db.DB)
+	userStore := newUserStore(db)
 
-	err = userStore.UpdatePassword(username, password)
+	if requireChange {
+		err = userStore.ResetPassword(username, password)
+	} else {
+		err = userStore.UpdatePassword(username, password)
+	}
 	if err != nil {
 		fatal("Failed to update password: %v", err)
 	}
 }
+
+func usersRole(db *configdb.ConfigDB, args []string) {
+	if len(args) != 2 {
+		fatal("Usage: users role <username> <viewer|analyst|admin>")
+	}
+	username := args[0]
+	role := args[1]
+
+	userStore := newUserStore(db)
+	if err := userStore.SetRole(username, role); err != nil {
+		fatal("Failed to set role: %v", err)
+	}
+	println("OK")
+}
+
+func usersSetDisabled(db *configdb.ConfigDB, args []string, disabled bool) {
+	if len(args) != 1 {
+		if disabled {
+			fatal("Usage: users disable <username>")
+		}
+		fatal("Usage: users enable <username>")
+	}
+	userStore := newUserStore(db)
+	if err := userStore.SetDisabled(args[0], disabled); err != nil {
+		fatal("Failed to update user: %v", err)
+	}
+	println("OK")
+}
+
+func usersTotp(db *configdb.ConfigDB, args []string) {
+	if len(args) != 2 {
+		fatal("Usage: users totp <enroll|disable|status> <username>")
+	}
+	command := args[0]
+	username := args[1]
+
+	userStore := newUserStore(db)
+	user, err := userStore.FindByUsername(username)
+	if err != nil {
+		fatal("Failed to find user %s: %v", username, err)
+	}
+	totpStore := configdb.NewTotpStore(db.DB)
+
+	switch command {
+	case "enroll":
+		enrollment, err := totpStore.EnrollTotp(user)
+		if err != nil {
+			fatal("Failed to enroll: %v", err)
+		}
+		println("Add the following to an authenticator app:")
+		println("    Secret: %s", enrollment.Secret)
+		println("    URI: %s", enrollment.URI)
+		code := readString("Enter a code from the authenticator app to confirm")
+		codes, err := totpStore.ConfirmTotp(user, code)
+		if err != nil {
+			fatal("Failed to confirm: %v", err)
+		}
+		println("Two-factor authentication enabled. Recovery codes:")
+		for _, code := range codes {
+			println("    %s", code)
+		}
+	case "disable":
+		if err := totpStore.DisableTotp(user); err != nil {
+			fatal("Failed to disable: %v", err)
+		}
+		println("OK")
+	case "status":
+		status, err := totpStore.GetTotpStatus(user)
+		if err != nil {
+			fatal("Failed to get status: %v", err)
+		}
+		println("%s", util.ToJson(status))
+	default:
+		fatal("Usage: users totp <enroll|disable|status> <username>")
+	}
+}
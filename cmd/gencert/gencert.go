@@ -34,8 +34,12 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"fmt"
 	"github.com/jasonish/evebox/log"
+	"github.com/pkg/errors"
 	"github.com/spf13/pflag"
+	"io"
+	"io/ioutil"
 	"math/big"
 	"net"
 	"os"
@@ -45,14 +49,61 @@ import (
 
 const RSA_BITS = 2048
 
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: evebox gencert [command] [options]
+
+Without a command a server certificate is generated, self signed unless
+a CA is provided with --ca.
+
+Commands:
+    ca        Generate a CA to sign server and agent certificates with
+    agent     Generate an agent client certificate signed by a CA
+
+Run "evebox gencert <command> --help" for command options.
+`)
+}
+
 func Main(args []string) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "ca":
+			caMain(args[1:])
+			return
+		case "agent":
+			agentMain(args[1:])
+			return
+		case "help":
+			usage()
+			return
+		}
+	}
+	serverMain(args)
+}
+
+func parseFlags(flagset *pflag.FlagSet, args []string) {
+	if err := flagset.Parse(args); err != nil {
+		if err == pflag.ErrHelp {
+			os.Exit(0)
+		}
+		os.Exit(1)
+	}
+}
+
+func serverMain(args []string) {
 
 	var hostname string
 	var org string
 	var duration int
 	var outputFilename string
+	var caFilename string
+	var caKeyFilename string
 
 	flagset := pflag.NewFlagSet("gencert", 0)
+	flagset.Usage = func() {
+		usage()
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		flagset.PrintDefaults()
+	}
 	flagset.StringVar(&hostname, "hostname", "",
 		"Hostname or IP address (one or more, comma separated)")
 	flagset.StringVar(&org, "org", "EveBox User", "Organization name")
@@ -60,25 +111,142 @@ func Main(args []string) {
 		"Duration that certificate is valid for in days")
 	flagset.StringVarP(&outputFilename, "outputFilename", "o", "",
 		"Output file (eg. evebox.pem)")
-	if err := flagset.Parse(args); err != nil {
-		if err == pflag.ErrHelp {
-			os.Exit(0)
+	flagset.StringVar(&caFilename, "ca", "",
+		"CA certificate to sign with instead of self signing")
+	flagset.StringVar(&caKeyFilename, "ca-key", "",
+		"CA private key (default: the --ca file)")
+	parseFlags(flagset, args)
+
+	key := generateKey()
+
+	template := newTemplate(pkix.Name{Organization: []string{org}}, duration)
+	template.KeyUsage = x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+
+	log.Printf("Orgnanization: %s", org)
+	log.Printf("Hostnames: %s", hostname)
+
+	hosts := strings.Split(hostname, ",")
+	for _, host := range hosts {
+		if addr := net.ParseIP(host); addr != nil {
+			log.Info("Adding IP address %s", host)
+			template.IPAddresses = append(template.IPAddresses, addr)
+		} else {
+			log.Info("Adding hostname %s.", host)
+			template.DNSNames = append(template.DNSNames, host)
 		}
-		os.Exit(1)
 	}
 
+	parent, parentKey := template, key
+	if caFilename != "" {
+		parent, parentKey = mustLoadCA(caFilename, caKeyFilename)
+		log.Printf("Signing with CA: %s", parent.Subject.CommonName)
+	}
+
+	derBytes := createCertificate(template, parent, key, parentKey)
+	writeOutput(outputFilename, derBytes, key)
+}
+
+func caMain(args []string) {
+	var name string
+	var org string
+	var duration int
+	var outputFilename string
+	var keyOutputFilename string
+
+	flagset := pflag.NewFlagSet("gencert ca", 0)
+	flagset.StringVar(&name, "name", "EveBox CA", "CA common name")
+	flagset.StringVar(&org, "org", "EveBox User", "Organization name")
+	flagset.IntVar(&duration, "duration", 3650,
+		"Duration that certificate is valid for in days")
+	flagset.StringVarP(&outputFilename, "outputFilename", "o", "",
+		"Output file for the CA certificate (eg. ca.pem)")
+	flagset.StringVar(&keyOutputFilename, "key-output", "",
+		"Output file for the CA private key (eg. ca-key.pem)")
+	parseFlags(flagset, args)
+
+	if outputFilename == "" || keyOutputFilename == "" {
+		log.Fatal("error: --outputFilename and --key-output are required")
+	}
+
+	key := generateKey()
+
+	template := newTemplate(pkix.Name{
+		CommonName:   name,
+		Organization: []string{org},
+	}, duration)
+	template.IsCA = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign |
+		x509.KeyUsageDigitalSignature
+
+	log.Printf("CA: %s", name)
+
+	derBytes := createCertificate(template, template, key, key)
+	writeOutput(outputFilename, derBytes, nil)
+	writeOutput(keyOutputFilename, nil, key)
+}
+
+func agentMain(args []string) {
+	var name string
+	var org string
+	var duration int
+	var outputFilename string
+	var caFilename string
+	var caKeyFilename string
+
+	flagset := pflag.NewFlagSet("gencert agent", 0)
+	flagset.StringVar(&name, "name", "",
+		"Sensor name, used as the certificate common name")
+	flagset.StringVar(&org, "org", "EveBox User", "Organization name")
+	flagset.IntVar(&duration, "duration", 365,
+		"Duration that certificate is valid for in days")
+	flagset.StringVarP(&outputFilename, "outputFilename", "o", "",
+		"Output file (eg. sensor-1.pem)")
+	flagset.StringVar(&caFilename, "ca", "", "CA certificate to sign with")
+	flagset.StringVar(&caKeyFilename, "ca-key", "",
+		"CA private key (default: the --ca file)")
+	parseFlags(flagset, args)
+
+	if name == "" {
+		log.Fatal("error: --name is required")
+	}
+	if caFilename == "" {
+		log.Fatal("error: --ca is required")
+	}
+
+	parent, parentKey := mustLoadCA(caFilename, caKeyFilename)
+	key := generateKey()
+
+	template := newTemplate(pkix.Name{
+		CommonName:   name,
+		Organization: []string{org},
+	}, duration)
+	template.KeyUsage = x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+
+	log.Printf("Sensor: %s", name)
+	log.Printf("Signing with CA: %s", parent.Subject.CommonName)
+
+	derBytes := createCertificate(template, parent, key, parentKey)
+	writeOutput(outputFilename, derBytes, key)
+}
+
+func generateKey() *rsa.PrivateKey {
 	key, err := rsa.GenerateKey(rand.Reader, RSA_BITS)
 	if err != nil {
 		log.Fatalf("Failed to generate private key: %v", err)
 	}
+	log.Printf("Key type: RSA")
+	log.Printf("Key bits: %d", RSA_BITS)
+	return key
+}
 
+// newTemplate returns a certificate template with a random serial number,
+// valid from now for the given number of days.
+func newTemplate(subject pkix.Name, duration int) *x509.Certificate {
 	notBefore := time.Now()
 	notAfter := notBefore.AddDate(0, 0, duration)
 
-	log.Printf("Orgnanization: %s", org)
-	log.Printf("Hostnames: %s", hostname)
-	log.Printf("Key type: RSA")
-	log.Printf("Key bits: %d", RSA_BITS)
 	log.Printf("Valid not before: %v", notBefore)
 	log.Printf("Valid not after: %v", notAfter)
 
@@ -87,51 +255,115 @@ func Main(args []string) {
 	if err != nil {
 		log.Fatalf("Failed to generate serial number: %v", err)
 	}
-	template := x509.Certificate{
-		SerialNumber: serialNumber,
-		Subject: pkix.Name{
-			Organization: []string{org},
-		},
+	return &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               subject,
 		NotBefore:             notBefore,
 		NotAfter:              notAfter,
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
 	}
+}
 
-	hosts := strings.Split(hostname, ",")
-	for _, host := range hosts {
-		if addr := net.ParseIP(host); addr != nil {
-			log.Info("Adding IP address %s", host)
-			template.IPAddresses = append(template.IPAddresses, addr)
-		} else {
-			log.Info("Adding hostname %s.", host)
-			template.DNSNames = append(template.DNSNames, host)
-		}
+func createCertificate(template *x509.Certificate, parent *x509.Certificate,
+	key *rsa.PrivateKey, parentKey *rsa.PrivateKey) []byte {
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, parent,
+		&key.PublicKey, parentKey)
+	if err != nil {
+		log.Fatalf("Failed to create certificate: %v", err)
+	}
+	return derBytes
+}
+
+// loadCA loads a CA certificate and its RSA private key. The key may be in
+// the certificate file.
+func loadCA(certFilename string, keyFilename string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	if keyFilename == "" {
+		keyFilename = certFilename
 	}
 
-	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template,
-		&key.PublicKey, key)
+	certPem, err := ioutil.ReadFile(certFilename)
 	if err != nil {
-		log.Fatalf("Failed to create certificate: %v", err)
+		return nil, nil, err
+	}
+	block := findPemBlock(certPem, "CERTIFICATE")
+	if block == nil {
+		return nil, nil, errors.Errorf("no certificate found in %s", certFilename)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !cert.IsCA {
+		return nil, nil, errors.Errorf("%s is not a CA certificate", certFilename)
 	}
 
-	output := os.Stdout
+	keyPem, err := ioutil.ReadFile(keyFilename)
+	if err != nil {
+		return nil, nil, err
+	}
+	block = findPemBlock(keyPem, "RSA PRIVATE KEY")
+	if block == nil {
+		return nil, nil, errors.Errorf("no RSA private key found in %s", keyFilename)
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	if outputFilename != "" {
-		output, err = os.Create(outputFilename)
+	return cert, key, nil
+}
+
+func mustLoadCA(certFilename string, keyFilename string) (*x509.Certificate, *rsa.PrivateKey) {
+	cert, key, err := loadCA(certFilename, keyFilename)
+	if err != nil {
+		log.Fatalf("Failed to load CA: %v", err)
+	}
+	return cert, key
+}
+
+func findPemBlock(buf []byte, blockType string) *pem.Block {
+	for {
+		var block *pem.Block
+		block, buf = pem.Decode(buf)
+		if block == nil {
+			return nil
+		}
+		if block.Type == blockType {
+			return block
+		}
+	}
+}
+
+// writeOutput writes the certificate and key, either of which may be nil,
+// to the named file or stdout. Files holding a key are only readable by the
+// owner.
+func writeOutput(filename string, derBytes []byte, key *rsa.PrivateKey) {
+	var output io.Writer = os.Stdout
+
+	if filename != "" {
+		mode := os.FileMode(0644)
+		if key != nil {
+			mode = 0600
+		}
+		file, err := os.OpenFile(filename,
+			os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
 		if err != nil {
-			log.Fatal("Failed to open %s for writing: %v",
-				outputFilename, err)
+			log.Fatalf("Failed to open %s for writing: %v",
+				filename, err)
 		}
-		defer output.Close()
+		defer file.Close()
+		output = file
 	}
 
-	pem.Encode(output, &pem.Block{
-		Type: "CERTIFICATE", Bytes: derBytes,
-	})
-	pem.Encode(output, &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(key),
-	})
+	if derBytes != nil {
+		pem.Encode(output, &pem.Block{
+			Type: "CERTIFICATE", Bytes: derBytes,
+		})
+	}
+	if key != nil {
+		pem.Encode(output, &pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		})
+	}
 }
@@ -44,12 +44,14 @@ import (
 	"github.com/jasonish/evebox/server"
 	"github.com/jasonish/evebox/sqlite"
 	"github.com/jasonish/evebox/sqlite/configdb"
+	"github.com/jasonish/evebox/suppression"
 	// User-Agent Parser is currently not compatible with ARM architecture.
 	// For more information, see https://github.com/ua-parser/uap-go/issues/38
 	// "github.com/jasonish/evebox/useragent"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -61,6 +63,7 @@ const DEFAULT_ELASTICSEARCH_INDEX = "logstash"
 const HTTP_TLS_ENABLED_KEY = "http.tls.enabled"
 const HTTP_TLS_CERT_KEY = "http.tls.certificate"
 const HTTP_TLS_KEY_KEY = "http.tls.key"
+const HTTP_TLS_CLIENT_CA_KEY = "http.tls.client-ca"
 
 var opts struct {
 	Port               uint16
@@ -101,6 +104,37 @@ func initViper() {
 	viper.BindEnv("authentication.required",
 		"EVEBOX_AUTHENTICATION_REQUIRED")
 
+	viper.SetDefault("authentication.agents.required", false)
+	viper.BindEnv("authentication.agents.required",
+		"EVEBOX_AUTHENTICATION_AGENTS_REQUIRED")
+
+	viper.SetDefault("authentication.agents.require-certificate", false)
+	viper.BindEnv("authentication.agents.require-certificate",
+		"EVEBOX_AUTHENTICATION_AGENTS_REQUIRE_CERTIFICATE")
+
+	viper.SetDefault("authentication.agents.max-unauthenticated", 100)
+	viper.BindEnv("authentication.agents.max-unauthenticated",
+		"EVEBOX_AUTHENTICATION_AGENTS_MAX_UNAUTHENTICATED")
+
+	viper.SetDefault("authentication.session.timeout", "1h")
+	viper.BindEnv("authentication.session.timeout",
+		"EVEBOX_AUTHENTICATION_SESSION_TIMEOUT")
+
+	viper.SetDefault("authentication.session.persistent", false)
+	viper.BindEnv("authentication.session.persistent",
+		"EVEBOX_AUTHENTICATION_SESSION_PERSISTENT")
+
+	viper.SetDefault("authentication.lockout.enabled", true)
+	viper.SetDefault("authentication.lockout.username-threshold", 5)
+	viper.SetDefault("authentication.lockout.address-threshold", 20)
+	viper.SetDefault("authentication.lockout.delay", "1m")
+	viper.SetDefault("authentication.lockout.max-delay", "1h")
+	viper.SetDefault("authentication.lockout.reset-after", "24h")
+
+	viper.SetDefault("authentication.password-policy.min-length",
+		core.DEFAULT_PASSWORD_MIN_LENGTH)
+	viper.SetDefault("authentication.password-policy.min-classes", 0)
+
 	viper.SetDefault("authentication.type", "username")
 	viper.BindEnv("authentication.type",
 		"EVEBOX_AUTHENTICATION_TYPE")
@@ -111,6 +145,11 @@ func initViper() {
 	viper.BindEnv("authentication.github.client-id", "GITHUB_CLIENT_ID")
 	viper.BindEnv("authentication.github.client-secret", "GITHUB_CLIENT_SECRET")
 
+	viper.BindEnv("authentication.oidc.client-id", "OIDC_CLIENT_ID")
+	viper.BindEnv("authentication.oidc.client-secret", "OIDC_CLIENT_SECRET")
+
+	viper.BindEnv("authentication.ldap.bind-password", "LDAP_BIND_PASSWORD")
+
 	// Defaults for PostgreSQL database.
 	viper.SetDefault("database.postgresql.managed", true)
 	viper.BindEnv("database.postgresql.managed", "PGMANAGED")
@@ -155,12 +194,43 @@ func configure(config *appcontext.Config) {
 	config.Http.TlsEnabled = viper.GetBool(HTTP_TLS_ENABLED_KEY)
 	config.Http.TlsCertificate = viper.GetString(HTTP_TLS_CERT_KEY)
 	config.Http.TlsKey = viper.GetString(HTTP_TLS_KEY_KEY)
+	config.Http.TlsClientCA = viper.GetString(HTTP_TLS_CLIENT_CA_KEY)
 
 	config.Http.ReverseProxy = viper.GetBool("http.reverse-proxy")
 	config.Http.RequestLogging = viper.GetBool("http.request-logging")
 
 	config.LetsEncryptHostname = viper.GetString("letsencrypt.hostname")
 
+	config.Authentication.Agents.Required =
+		viper.GetBool("authentication.agents.required")
+	config.Authentication.Agents.RequireCertificate =
+		viper.GetBool("authentication.agents.require-certificate")
+	config.Authentication.Agents.MaxUnauthenticated =
+		viper.GetInt("authentication.agents.max-unauthenticated")
+	if config.Authentication.Agents.RequireCertificate &&
+		(!config.Http.TlsEnabled || config.Http.TlsClientCA == "") {
+		log.Fatalf("authentication.agents.require-certificate requires http.tls.enabled and http.tls.client-ca")
+	}
+
+	sessionTimeout, err := parseDuration(
+		viper.GetString("authentication.session.timeout"), time.Minute)
+	if err != nil {
+		log.Fatalf("Bad value for authentication.session.timeout: %v", err)
+	}
+	config.Authentication.Session.Timeout = sessionTimeout
+	config.Authentication.Session.Persistent =
+		viper.GetBool("authentication.session.persistent")
+
+	// The password policy applies to users added with the config tool
+	// and API even if authentication is not required.
+	passwordPolicy := &config.Authentication.PasswordPolicy
+	passwordPolicy.MinLength =
+		viper.GetInt("authentication.password-policy.min-length")
+	passwordPolicy.MinClasses =
+		viper.GetInt("authentication.password-policy.min-classes")
+	passwordPolicy.BreachedList =
+		viper.GetString("authentication.password-policy.breached-list")
+
 	config.Authentication.Required = viper.GetBool("authentication.required")
 	if config.Authentication.Required {
 
@@ -179,7 +249,95 @@ func configure(config *appcontext.Config) {
 				viper.GetString("authentication.github.client-id")
 			github.Callback = viper.GetString("authentication.github.callback")
 		}
+
+		// OpenID Connect.
+		oidc := &config.Authentication.Oidc
+		oidc.Enabled = viper.GetBool("authentication.oidc.enabled")
+		if oidc.Enabled {
+			oidc.Issuer = viper.GetString("authentication.oidc.issuer")
+			oidc.ClientID = viper.GetString("authentication.oidc.client-id")
+			oidc.ClientSecret =
+				viper.GetString("authentication.oidc.client-secret")
+			oidc.Callback = viper.GetString("authentication.oidc.callback")
+			oidc.Scopes = viper.GetStringSlice("authentication.oidc.scopes")
+			oidc.UsernameClaim =
+				viper.GetString("authentication.oidc.username-claim")
+			oidc.RoleClaim = viper.GetString("authentication.oidc.role-claim")
+			oidc.Roles = viper.GetStringMapStringSlice(
+				"authentication.oidc.roles")
+			oidc.DefaultRole =
+				viper.GetString("authentication.oidc.default-role")
+			oidc.AutoProvision =
+				viper.GetBool("authentication.oidc.auto-provision")
+		}
+
+		// LDAP.
+		ldap := &config.Authentication.Ldap
+		ldap.Enabled = viper.GetBool("authentication.ldap.enabled")
+		if ldap.Enabled {
+			ldap.Url = viper.GetString("authentication.ldap.url")
+			ldap.StartTLS = viper.GetBool("authentication.ldap.starttls")
+			ldap.TlsCA = viper.GetString("authentication.ldap.tls-ca")
+			ldap.InsecureSkipVerify =
+				viper.GetBool("authentication.ldap.insecure-skip-verify")
+			ldap.BindDN = viper.GetString("authentication.ldap.bind-dn")
+			ldap.BindPassword =
+				viper.GetString("authentication.ldap.bind-password")
+			ldap.BaseDN = viper.GetString("authentication.ldap.base-dn")
+			ldap.UserFilter =
+				viper.GetString("authentication.ldap.user-filter")
+			ldap.GroupAttribute =
+				viper.GetString("authentication.ldap.group-attribute")
+			ldap.Roles = viper.GetStringMapStringSlice(
+				"authentication.ldap.roles")
+			ldap.DefaultRole =
+				viper.GetString("authentication.ldap.default-role")
+			ldap.Fallback = viper.GetBool("authentication.ldap.fallback")
+		}
+
+		// Lockout of failed logins.
+		lockout := &config.Authentication.Lockout
+		lockout.Enabled = viper.GetBool("authentication.lockout.enabled")
+		if lockout.Enabled {
+			lockout.UsernameThreshold =
+				viper.GetInt("authentication.lockout.username-threshold")
+			lockout.AddressThreshold =
+				viper.GetInt("authentication.lockout.address-threshold")
+			for _, option := range []struct {
+				name  string
+				value *time.Duration
+			}{
+				{"delay", &lockout.Delay},
+				{"max-delay", &lockout.MaxDelay},
+				{"reset-after", &lockout.ResetAfter},
+			} {
+				key := "authentication.lockout." + option.name
+				*option.value, err = parseDuration(viper.GetString(key),
+					time.Second)
+				if err != nil {
+					log.Fatalf("Bad value for %s: %v", key, err)
+				}
+			}
+		}
+	}
+}
+
+// parseDuration parses a duration such as "8h", or a plain number of
+// seconds, that must be at least min.
+func parseDuration(value string, min time.Duration) (time.Duration, error) {
+	var timeout time.Duration
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		timeout = time.Duration(seconds) * time.Second
+	} else {
+		timeout, err = time.ParseDuration(value)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if timeout < min {
+		return 0, fmt.Errorf("must be at least %s: %s", min, value)
 	}
+	return timeout, nil
 }
 
 func Main(args []string) {
@@ -328,7 +486,49 @@ func Main(args []string) {
 	}
 
 	// Not sure about doing this with an in-memory store right now.
-	appContext.Userstore = configdb.NewUserStore(appContext.ConfigDB.DB)
+	userStore := configdb.NewUserStore(appContext.ConfigDB.DB)
+	passwordPolicy := appContext.Config.Authentication.PasswordPolicy
+	policy, err := core.NewPasswordPolicy(passwordPolicy.MinLength,
+		passwordPolicy.MinClasses, passwordPolicy.BreachedList)
+	if err != nil {
+		log.Fatalf("Failed to configure password policy: %v", err)
+	}
+	if policy.BreachedCount() > 0 {
+		log.Info("Loaded %d known breached passwords", policy.BreachedCount())
+	}
+	userStore.SetPasswordPolicy(policy)
+	appContext.Userstore = userStore
+
+	inputRules := viper.GetStringSlice("input.rules")
+	if len(inputRules) > 0 {
+		appContext.RuleMap = rules.NewRuleMap(inputRules)
+	}
+
+	sensorStore := configdb.NewSensorStore(appContext.ConfigDB.DB)
+	sensorStore.MaxUnauthenticated =
+		appContext.Config.Authentication.Agents.MaxUnauthenticated
+	appContext.SensorStore = sensorStore
+	appContext.ApiTokenStore = configdb.NewApiTokenStore(appContext.ConfigDB.DB)
+	appContext.TotpStore = configdb.NewTotpStore(appContext.ConfigDB.DB)
+	appContext.LoginFailureStore =
+		configdb.NewLoginFailureStore(appContext.ConfigDB.DB)
+	appContext.AuditStore = configdb.NewAuditStore(appContext.ConfigDB.DB)
+	if appContext.Config.Authentication.Session.Persistent {
+		if appContext.ConfigDB.InMemory {
+			log.Warning("Persistent sessions enabled with an in-memory configuration database; sessions will not survive a restart")
+		}
+		appContext.PersistentSessionStore =
+			configdb.NewSessionStore(appContext.ConfigDB.DB)
+	}
+	appContext.PreferencesStore = configdb.NewPreferencesStore(appContext.ConfigDB.DB)
+	appContext.SavedSearchStore = configdb.NewSavedSearchStore(appContext.ConfigDB.DB)
+
+	appContext.SuppressionStore = configdb.NewSuppressionStore(appContext.ConfigDB.DB)
+	appContext.SuppressionFilter, err = suppression.NewFilter(appContext.SuppressionStore)
+	if err != nil {
+		log.Fatalf("Failed to load suppressions: %v", err)
+	}
+	appContext.SuppressionFilter.Start()
 
 	switch viper.GetString("database.type") {
 	case "elasticsearch":
@@ -478,37 +678,69 @@ func initInternalEveReader(appContext *appcontext.AppContext, inputStart bool) {
 		return
 	}
 	log.Info("Configuring internal eve log reader")
-	filename := viper.GetString("input.filename")
+	paths := viper.GetStringSlice("input.filenames")
+	if filename := viper.GetString("input.filename"); filename != "" {
+		paths = append([]string{filename}, paths...)
+	}
+	redisKey := viper.GetString("input.redis.key")
+	if len(paths) == 0 && redisKey == "" {
+		log.Fatal("No input filenames or redis configured.")
+	}
 	bookmarkDirectory := viper.GetString("input.bookmark-directory")
 
-	eventSink := appContext.DataStore.GetEveEventSink()
-	if eventSink == nil {
+	if appContext.DataStore.GetEveEventSink() == nil {
 		log.Fatal("Selected datastore does not provide an event sink.")
 	}
 
-	eveFileProcessor := &evereader.EveFileProcessor{
-		Filename:          filename,
-		BookmarkDirectory: bookmarkDirectory,
-		Sink:              eventSink,
-		End:               !inputStart,
+	if len(paths) > 0 {
+		eveFileProcessor := &evereader.MultiFileProcessor{
+			Patterns:          paths,
+			BookmarkDirectory: bookmarkDirectory,
+			NewSink:           appContext.DataStore.GetEveEventSink,
+			End:               !inputStart,
+		}
+		configureInput(appContext, eveFileProcessor)
+		eveFileProcessor.Start()
 	}
 
-	eveFileProcessor.AddFilter(&eve.TagsFilter{})
-	eveFileProcessor.AddFilter(eve.NewGeoipFilter(appContext.GeoIpService))
+	if redisKey != "" {
+		redisProcessor := &evereader.RedisProcessor{
+			Address:       viper.GetString("input.redis.address"),
+			Password:      viper.GetString("input.redis.password"),
+			Database:      viper.GetInt("input.redis.database"),
+			Mode:          viper.GetString("input.redis.mode"),
+			Key:           redisKey,
+			ProcessingKey: viper.GetString("input.redis.processing-key"),
+			Sink:          appContext.DataStore.GetEveEventSink(),
+		}
+		configureInput(appContext, redisProcessor)
+		if err := redisProcessor.Start(); err != nil {
+			log.Fatalf("Failed to start redis input: %v", err)
+		}
+	}
+}
+
+// An input the filters and custom fields from the configuration are added to.
+type input interface {
+	AddFilter(filter eve.EveFilter)
+	AddCustomField(field string, value interface{})
+}
+
+func configureInput(appContext *appcontext.AppContext, input input) {
+	input.AddFilter(&eve.TagsFilter{})
+	input.AddFilter(eve.NewGeoipFilter(appContext.GeoIpService))
 
 	// User-Agent Parser is currently not compatible with ARM architecture.
 	// For more information, see https://github.com/ua-parser/uap-go/issues/38
-	// eveFileProcessor.AddFilter(&useragent.EveUserAgentFilter{})
+	// input.AddFilter(&useragent.EveUserAgentFilter{})
 
-	inputRules := viper.GetStringSlice("input.rules")
-	if inputRules != nil {
-		ruleMap := rules.NewRuleMap(inputRules)
-		eveFileProcessor.AddFilter(ruleMap)
+	if appContext.RuleMap != nil {
+		input.AddFilter(appContext.RuleMap)
 	}
 
+	input.AddFilter(appContext.SuppressionFilter)
+
 	for field, value := range viper.GetStringMap("input.custom-fields") {
-		eveFileProcessor.AddCustomField(field, value)
+		input.AddCustomField(field, value)
 	}
-
-	eveFileProcessor.Start()
 }
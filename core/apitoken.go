@@ -0,0 +1,61 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package core
+
+import (
+	"github.com/pkg/errors"
+	"time"
+)
+
+var ErrNoApiToken = errors.New("api token does not exist")
+var ErrBadApiToken = errors.New("invalid or expired api token")
+
+// ApiToken is a named token a user can use to access the API from scripts
+// without logging in. Only a hash of the token itself is stored.
+type ApiToken struct {
+	Id       int64      `json:"id"`
+	Name     string     `json:"name"`
+	Username string     `json:"username"`
+	Created  time.Time  `json:"created"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	LastUsed *time.Time `json:"last_used,omitempty"`
+}
+
+type ApiTokenStore interface {
+	// AddApiToken creates a new token for the user, returning the token.
+	// The token can't be retrieved again after this.
+	AddApiToken(user User, name string, expires *time.Time) (string, ApiToken, error)
+
+	FindApiTokens(user User) ([]ApiToken, error)
+
+	// DeleteApiToken deletes one of the user's tokens.
+	DeleteApiToken(user User, id int64) error
+
+	// FindUserByApiToken returns the user a token belongs to, if the
+	// token is valid and not expired, and records the token as used.
+	FindUserByApiToken(token string) (User, error)
+}
@@ -0,0 +1,59 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package core
+
+import (
+	"time"
+)
+
+// Audit actions.
+const (
+	AUDIT_LOCKOUT = "lockout"
+	AUDIT_UNLOCK  = "unlock"
+)
+
+// AuditEntry records a security relevant event, such as an account being
+// locked out.
+type AuditEntry struct {
+	Id        int64     `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+
+	// The user that did the action, empty if done by the system.
+	Username   string `json:"username,omitempty"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+
+	Action  string `json:"action"`
+	Target  string `json:"target,omitempty"`
+	Details string `json:"details,omitempty"`
+}
+
+type AuditStore interface {
+	AddAuditEntry(entry AuditEntry) error
+
+	// FindAuditEntries returns the most recent entries first.
+	FindAuditEntries(limit int) ([]AuditEntry, error)
+}
@@ -54,6 +54,11 @@ type Datastore interface {
 	CommentOnEventId(eventId string, user User, comment string) error
 	CommentOnAlertGroup(p AlertGroupQueryParams, user User, comment string) error
 	FlowHistogram(options FlowHistogramOptions) (interface{}, error)
+
+	// BulkAction applies an action to all events matching the query in
+	// the params and returns the number of events updated, or the number
+	// of events that would be updated if DryRun is set.
+	BulkAction(p BulkActionParams, user User) (int64, error)
 }
 
 type UnimplementedDatastore struct {
@@ -120,6 +125,10 @@ func (s *UnimplementedDatastore) FindNetflow(options EventQueryOptions, sortBy s
 	return nil, NotImplementedError
 }
 
+func (s *UnimplementedDatastore) BulkAction(p BulkActionParams, user User) (int64, error) {
+	return 0, NotImplementedError
+}
+
 func (s *UnimplementedDatastore) FlowHistogram(options FlowHistogramOptions) (interface{}, error) {
 	return nil, NotImplementedError
 }
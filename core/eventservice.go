@@ -59,6 +59,28 @@ type AlertQueryOptions struct {
 	MustNotHaveTags []string
 }
 
+// Actions that can be applied to all events matching a query.
+const (
+	BULK_ACTION_ARCHIVE  = "archive"
+	BULK_ACTION_ESCALATE = "escalate"
+	BULK_ACTION_LABEL    = "label"
+)
+
+// BulkActionParams holds the parameters for applying an action to all
+// events matching an arbitrary query, rather than to a single alert group.
+type BulkActionParams struct {
+	CommonQueryOptions
+
+	// The action to apply, one of the BULK_ACTION constants.
+	Action string
+
+	// Labels to add to each event for BULK_ACTION_LABEL.
+	Labels []string
+
+	// If true, only count the events that would be updated.
+	DryRun bool
+}
+
 type EventQueryOptions struct {
 	CommonQueryOptions
 
@@ -0,0 +1,73 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package core
+
+import (
+	"github.com/pkg/errors"
+	"time"
+)
+
+var ErrNoLoginFailures = errors.New("no login failures recorded")
+
+// What login failures are counted by.
+const (
+	LOCKOUT_USERNAME = "username"
+	LOCKOUT_ADDRESS  = "address"
+)
+
+// LoginFailures is the count of recent failed logins for a username or
+// remote address, and when it is locked out until.
+type LoginFailures struct {
+	Kind        string     `json:"kind"`
+	Value       string     `json:"value"`
+	Count       int        `json:"count"`
+	LastFailure time.Time  `json:"last_failure"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+}
+
+func (f LoginFailures) IsLocked(now time.Time) bool {
+	return f.LockedUntil != nil && now.Before(*f.LockedUntil)
+}
+
+type LoginFailureStore interface {
+	// GetLoginFailures returns the failures for a username or address,
+	// with a count of 0 if there are none.
+	GetLoginFailures(kind string, value string) (LoginFailures, error)
+
+	SaveLoginFailures(failures LoginFailures) error
+
+	DeleteLoginFailures(kind string, value string) error
+
+	// FindLockouts returns the usernames and addresses currently locked
+	// out.
+	FindLockouts() ([]LoginFailures, error)
+
+	// DeleteExpiredLoginFailures deletes the failures last recorded
+	// before a time that are not locked out at now, returning the number
+	// deleted.
+	DeleteExpiredLoginFailures(before time.Time, now time.Time) (int64, error)
+}
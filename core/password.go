@@ -0,0 +1,182 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package core
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"strings"
+	"unicode"
+)
+
+const DEFAULT_PASSWORD_MIN_LENGTH = 8
+
+var ErrPasswordChangeRequired = errors.New("password change required")
+
+// PasswordPolicyError is returned when a password is rejected by the
+// password policy.
+type PasswordPolicyError struct {
+	Reason string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password rejected: " + e.Reason
+}
+
+// PasswordPolicy is the policy new passwords must meet.
+type PasswordPolicy struct {
+	MinLength int
+
+	// How many of the character classes (lower case, upper case, digits
+	// and other) a password must contain.
+	MinClasses int
+
+	// Upper case hex SHA-1 hashes of known breached passwords.
+	breached map[string]bool
+}
+
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength: DEFAULT_PASSWORD_MIN_LENGTH,
+	}
+}
+
+// NewPasswordPolicy creates a password policy, loading known breached
+// passwords from breachedList if not empty.
+func NewPasswordPolicy(minLength int, minClasses int, breachedList string) (PasswordPolicy, error) {
+	policy := PasswordPolicy{
+		MinLength:  minLength,
+		MinClasses: minClasses,
+	}
+	if minClasses > 4 {
+		return policy, errors.Errorf("there are only 4 character classes: %d", minClasses)
+	}
+	if breachedList != "" {
+		if err := policy.LoadBreachedList(breachedList); err != nil {
+			return policy, err
+		}
+	}
+	return policy, nil
+}
+
+func hashPassword(password string) string {
+	hash := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(hash[:]))
+}
+
+func isSha1Hex(value string) bool {
+	if len(value) != sha1.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
+
+// LoadBreachedList loads known breached passwords from a file with one
+// per line. Lines may be plain passwords or SHA-1 hashes, optionally
+// followed by ":count" as in the Have I Been Pwned downloads. Lines
+// starting with # are ignored.
+func (p *PasswordPolicy) LoadBreachedList(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return errors.Wrap(err, "failed to open breached password list")
+	}
+	defer file.Close()
+
+	if p.breached == nil {
+		p.breached = map[string]bool{}
+	}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hash := line
+		if i := strings.IndexByte(line, ':'); i == sha1.Size*2 {
+			hash = line[:i]
+		}
+		if isSha1Hex(hash) {
+			p.breached[strings.ToUpper(hash)] = true
+		} else {
+			p.breached[hashPassword(line)] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "failed to read breached password list")
+	}
+	return nil
+}
+
+// BreachedCount returns the number of known breached passwords loaded.
+func (p PasswordPolicy) BreachedCount() int {
+	return len(p.breached)
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	return lower + upper + digit + other
+}
+
+// Check returns a PasswordPolicyError if the password for the user does
+// not meet the policy.
+func (p PasswordPolicy) Check(username string, password string) error {
+	if len([]rune(password)) < p.MinLength {
+		return &PasswordPolicyError{
+			Reason: fmt.Sprintf("must be at least %d characters", p.MinLength),
+		}
+	}
+	if characterClasses(password) < p.MinClasses {
+		return &PasswordPolicyError{
+			Reason: fmt.Sprintf("must contain at least %d of lower case, upper case, digits and other characters",
+				p.MinClasses),
+		}
+	}
+	if username != "" && strings.EqualFold(password, username) {
+		return &PasswordPolicyError{Reason: "must not be the username"}
+	}
+	if p.breached[hashPassword(password)] {
+		return &PasswordPolicyError{Reason: "known to have been breached"}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package core
+
+import (
+	"github.com/pkg/errors"
+	"time"
+)
+
+// Preferences are per-user settings for the user interface.
+type Preferences struct {
+	// Default time range for queries, a duration like "24h" or "all".
+	TimeRange string `json:"time_range,omitempty"`
+
+	// How alerts are grouped in the inbox.
+	InboxGroupBy string `json:"inbox_group_by,omitempty"`
+
+	// Timezone to display timestamps in, "local" or a location name such
+	// as "America/Regina".
+	Timezone string `json:"timezone,omitempty"`
+
+	// Number of events to show per page.
+	PageSize int `json:"page_size,omitempty"`
+
+	// Other settings the client wants to save.
+	Extra map[string]interface{} `json:"extra,omitempty"`
+}
+
+func (p Preferences) Validate() error {
+	if p.TimeRange != "" && p.TimeRange != "all" {
+		if _, err := time.ParseDuration(p.TimeRange); err != nil {
+			return errors.Wrap(err, "bad time_range")
+		}
+	}
+	if p.Timezone != "" && p.Timezone != "local" {
+		if _, err := time.LoadLocation(p.Timezone); err != nil {
+			return errors.Wrap(err, "bad timezone")
+		}
+	}
+	if p.PageSize < 0 {
+		return errors.New("page_size must not be negative")
+	}
+	return nil
+}
+
+type PreferencesStore interface {
+	// GetPreferences returns the user's preferences, or empty preferences
+	// if the user has none saved.
+	GetPreferences(user User) (Preferences, error)
+
+	// SetPreferences replaces the user's preferences.
+	SetPreferences(user User, preferences Preferences) error
+}
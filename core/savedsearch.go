@@ -0,0 +1,101 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package core
+
+import (
+	"github.com/pkg/errors"
+	"time"
+)
+
+var ErrNoSavedSearch = errors.New("saved search does not exist")
+
+// SavedSearch is a named query string, event type and time range saved by
+// a user. Shared saved searches are visible to all users, but can only be
+// modified by the owner.
+type SavedSearch struct {
+	Id          int64     `json:"id"`
+	Name        string    `json:"name"`
+	QueryString string    `json:"query_string,omitempty"`
+	EventType   string    `json:"event_type,omitempty"`
+	TimeRange   string    `json:"time_range,omitempty"`
+	Owner       string    `json:"owner"`
+	Shared      bool      `json:"shared"`
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+}
+
+func (s SavedSearch) Validate() error {
+	if s.Name == "" {
+		return errors.New("name is required")
+	}
+	if s.TimeRange != "" {
+		if _, err := time.ParseDuration(s.TimeRange); err != nil {
+			return errors.Wrap(err, "bad time_range")
+		}
+	}
+	return nil
+}
+
+// IsVisibleTo returns true if the saved search is owned by, or shared
+// with, the user.
+func (s SavedSearch) IsVisibleTo(username string) bool {
+	return s.Shared || s.Owner == username
+}
+
+// Apply adds the saved search to query options. The query string is
+// combined with any query string already set, the event type and time range
+// are only used if the options don't already have them.
+func (s SavedSearch) Apply(options *CommonQueryOptions) {
+	if s.QueryString != "" {
+		if options.QueryString != "" {
+			options.QueryString = s.QueryString + " " + options.QueryString
+		} else {
+			options.QueryString = s.QueryString
+		}
+	}
+	if options.EventType == "" {
+		options.EventType = s.EventType
+	}
+	if options.TimeRange == "" && options.MinTs.IsZero() && options.MaxTs.IsZero() {
+		options.TimeRange = s.TimeRange
+	}
+}
+
+type SavedSearchStore interface {
+	AddSavedSearch(search SavedSearch) (int64, error)
+	UpdateSavedSearch(search SavedSearch) error
+	DeleteSavedSearch(id int64) error
+	FindSavedSearchById(id int64) (SavedSearch, error)
+
+	// FindSavedSearches returns the saved searches owned by the user
+	// along with those shared by other users.
+	FindSavedSearches(username string) ([]SavedSearch, error)
+
+	// FindSavedSearchByName finds a saved search by name, preferring one
+	// owned by the user over one shared by another user.
+	FindSavedSearchByName(username string, name string) (SavedSearch, error)
+}
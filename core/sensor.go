@@ -0,0 +1,145 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package core
+
+import (
+	"github.com/pkg/errors"
+	"time"
+)
+
+var ErrNoSensor = errors.New("sensor does not exist")
+var ErrBadSensorToken = errors.New("invalid sensor token")
+var ErrSensorIdInUse = errors.New("sensor ID is in use by an authenticated sensor")
+var ErrSensorLimit = errors.New("too many unauthenticated sensors")
+
+// The interval agents send heartbeats at by default, and the number of
+// missed intervals after which a sensor is considered silent.
+const DEFAULT_HEARTBEAT_INTERVAL = 60 * time.Second
+const SENSOR_SILENT_INTERVALS = 3
+
+// Heartbeat is sent periodically by agents to register with the server and
+// report their status.
+type Heartbeat struct {
+	// Stable ID generated by the agent.
+	SensorID string `json:"sensor_id"`
+
+	// Name to register an unauthenticated agent as. Authenticated agents
+	// use the name they are authenticated as.
+	Name string `json:"name,omitempty"`
+
+	Hostname string `json:"hostname"`
+	Version  string `json:"version"`
+
+	// Seconds between heartbeats.
+	Interval int64 `json:"interval"`
+
+	SensorStatus
+}
+
+// SensorStatus is the status reported in a heartbeat.
+type SensorStatus struct {
+	// Events committed per second over the last interval, across all
+	// inputs.
+	EventsPerSecond float64 `json:"events_per_second"`
+
+	Inputs []SensorInput `json:"inputs"`
+}
+
+// SensorInput is the status of an input file, socket or Redis key, and the
+// output it is read for.
+type SensorInput struct {
+	Input           string  `json:"input"`
+	Output          string  `json:"output,omitempty"`
+	Events          uint64  `json:"events"`
+	EventsPerSecond float64 `json:"events_per_second"`
+
+	// Bytes left to read, files only.
+	Lag int64 `json:"lag_bytes"`
+}
+
+// How a sensor authenticated when it was registered.
+const (
+	SENSOR_AUTH_TOKEN       = "token"
+	SENSOR_AUTH_CERTIFICATE = "certificate"
+	SENSOR_AUTH_NONE        = "none"
+)
+
+// Sensor is an agent allowed to submit events to the server. The sensor
+// name is the identity stamped on to the events it submits.
+type Sensor struct {
+	Id          int64      `json:"id"`
+	Name        string     `json:"name"`
+	Created     time.Time  `json:"created"`
+	LastSeen    *time.Time `json:"last_seen,omitempty"`
+	LastAddress string     `json:"last_address,omitempty"`
+
+	// One of the SENSOR_AUTH values, empty if unknown.
+	Authentication string `json:"authentication,omitempty"`
+
+	// Registry details from the sensor's heartbeats.
+	SensorID          string        `json:"sensor_id,omitempty"`
+	Hostname          string        `json:"hostname,omitempty"`
+	Version           string        `json:"version,omitempty"`
+	LastHeartbeat     *time.Time    `json:"last_heartbeat,omitempty"`
+	HeartbeatInterval int64         `json:"heartbeat_interval,omitempty"`
+	Status            *SensorStatus `json:"status,omitempty"`
+
+	// Set if the sensor has missed SENSOR_SILENT_INTERVALS heartbeats.
+	Silent bool `json:"silent"`
+}
+
+// IsSilent returns true if the sensor has sent heartbeats but has missed
+// SENSOR_SILENT_INTERVALS of them.
+func (s *Sensor) IsSilent(now time.Time) bool {
+	if s.LastHeartbeat == nil {
+		return false
+	}
+	interval := time.Duration(s.HeartbeatInterval) * time.Second
+	if interval <= 0 {
+		interval = DEFAULT_HEARTBEAT_INTERVAL
+	}
+	return now.Sub(*s.LastHeartbeat) > SENSOR_SILENT_INTERVALS*interval
+}
+
+type SensorStore interface {
+	// AddSensor creates a sensor, returning the token it authenticates
+	// with. The token can't be retrieved again after this.
+	AddSensor(name string) (string, Sensor, error)
+
+	FindSensors() ([]Sensor, error)
+	DeleteSensor(id int64) error
+
+	// FindSensorByToken returns the sensor a token was issued to, and
+	// records the sensor as seen from the address.
+	FindSensorByToken(token string, addr string) (Sensor, error)
+
+	// RecordHeartbeat registers or updates the sensor sending a
+	// heartbeat. The name is that of an authenticated sensor, or empty
+	// for an unauthenticated agent, which is registered by its sensor ID
+	// and can only update sensors registered unauthenticated.
+	RecordHeartbeat(name string, heartbeat Heartbeat, addr string) (Sensor, error)
+}
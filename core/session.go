@@ -0,0 +1,61 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package core
+
+import (
+	"github.com/pkg/errors"
+	"time"
+)
+
+var ErrNoSession = errors.New("session does not exist")
+
+// PersistentSession is a login session as stored in the configuration
+// database so it survives a restart. Only a hash of the session ID is
+// stored, and the hash is what is used to refer to a session in the API.
+type PersistentSession struct {
+	IdHash     string    `json:"id"`
+	User       User      `json:"-"`
+	RemoteAddr string    `json:"remote_addr"`
+	Created    time.Time `json:"created"`
+	Expires    time.Time `json:"expires"`
+}
+
+type PersistentSessionStore interface {
+	// SaveSession inserts a session, or updates it if one with the same
+	// ID hash already exists.
+	SaveSession(session PersistentSession) error
+
+	// FindSession returns an unexpired session by the hash of its ID.
+	FindSession(idHash string) (PersistentSession, error)
+
+	// FindSessionsByUser returns the unexpired sessions of a user.
+	FindSessionsByUser(user User) ([]PersistentSession, error)
+
+	DeleteSession(idHash string) error
+
+	DeleteExpiredSessions() (int64, error)
+}
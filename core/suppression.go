@@ -0,0 +1,133 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package core
+
+import (
+	"github.com/pkg/errors"
+	"net"
+	"strings"
+	"time"
+)
+
+// Actions taken on an alert matching a suppression.
+const (
+	// Archive the alert so it never shows up in the inbox.
+	SUPPRESSION_ACTION_ARCHIVE = "archive"
+
+	// Drop the alert so it is never stored.
+	SUPPRESSION_ACTION_DROP = "drop"
+)
+
+var ErrNoSuppression = errors.New("suppression does not exist")
+
+// Suppression is a rule for alerts that should be archived or dropped as
+// they are received.
+type Suppression struct {
+	Id int64 `json:"id"`
+
+	// Signature ID to match, 0 for any signature.
+	SignatureID uint64 `json:"signature_id,omitempty"`
+
+	// Source and destination address or network in CIDR notation.
+	SrcCIDR  string `json:"src_cidr,omitempty"`
+	DestCIDR string `json:"dest_cidr,omitempty"`
+
+	// Sensor name, matched against the host field of the event.
+	Sensor string `json:"sensor,omitempty"`
+
+	Action   string `json:"action"`
+	Reason   string `json:"reason,omitempty"`
+	Username string `json:"username,omitempty"`
+
+	Created time.Time `json:"created"`
+
+	// Optional expiry, the suppression no longer applies after this time.
+	Expires *time.Time `json:"expires,omitempty"`
+
+	// Number of alerts matched and time of the most recent match.
+	Hits    uint64     `json:"hits"`
+	LastHit *time.Time `json:"last_hit,omitempty"`
+}
+
+// IsExpired returns true if the suppression has an expiry and it is before
+// the provided time.
+func (s Suppression) IsExpired(now time.Time) bool {
+	return s.Expires != nil && s.Expires.Before(now)
+}
+
+// Validate checks that the suppression has a valid action and at least one
+// criterion to match on.
+func (s Suppression) Validate() error {
+	switch s.Action {
+	case SUPPRESSION_ACTION_ARCHIVE, SUPPRESSION_ACTION_DROP:
+	default:
+		return errors.Errorf("invalid suppression action: %s", s.Action)
+	}
+	if s.SignatureID == 0 && s.SrcCIDR == "" && s.DestCIDR == "" && s.Sensor == "" {
+		return errors.New("suppression must match on at least one field")
+	}
+	if _, err := ParseCIDR(s.SrcCIDR); err != nil {
+		return errors.Wrap(err, "bad src_cidr")
+	}
+	if _, err := ParseCIDR(s.DestCIDR); err != nil {
+		return errors.Wrap(err, "bad dest_cidr")
+	}
+	return nil
+}
+
+// ParseCIDR parses an address or network in CIDR notation. A single address
+// is treated as a network containing only that address. An empty string
+// returns a nil network.
+func ParseCIDR(cidr string) (*net.IPNet, error) {
+	if cidr == "" {
+		return nil, nil
+	}
+	if !strings.Contains(cidr, "/") {
+		ip := net.ParseIP(cidr)
+		if ip == nil {
+			return nil, errors.Errorf("invalid address: %s", cidr)
+		}
+		if ip.To4() != nil {
+			cidr = cidr + "/32"
+		} else {
+			cidr = cidr + "/128"
+		}
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	return network, err
+}
+
+type SuppressionStore interface {
+	AddSuppression(suppression Suppression) (int64, error)
+	UpdateSuppression(suppression Suppression) error
+	FindAllSuppressions() ([]Suppression, error)
+	FindSuppressionById(id int64) (Suppression, error)
+	DeleteSuppression(id int64) error
+
+	// AddSuppressionHits adds count to the hit counter of a suppression.
+	AddSuppressionHits(id int64, count uint64, lastHit time.Time) error
+}
@@ -0,0 +1,72 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package core
+
+import (
+	"github.com/pkg/errors"
+)
+
+var ErrNoTotp = errors.New("two-factor authentication is not enabled")
+var ErrBadTotpCode = errors.New("invalid two-factor authentication code")
+var ErrTotpEnabled = errors.New("two-factor authentication is already enabled")
+
+// TotpEnrollment is a pending TOTP enrollment. It must be confirmed with
+// a code from the authenticator app before it is enabled.
+type TotpEnrollment struct {
+	Secret string `json:"secret"`
+
+	// otpauth:// URI to show as a QR code.
+	URI string `json:"uri"`
+}
+
+type TotpStatus struct {
+	Enabled                bool `json:"enabled"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+type TotpStore interface {
+	// EnrollTotp starts a new enrollment for the user, replacing any
+	// pending enrollment. An enabled enrollment is left in place until
+	// the new one is confirmed.
+	EnrollTotp(user User) (TotpEnrollment, error)
+
+	// ConfirmTotp enables the pending enrollment if the code is valid,
+	// returning new recovery codes. The recovery codes can't be
+	// retrieved again.
+	ConfirmTotp(user User, code string) ([]string, error)
+
+	GetTotpStatus(user User) (TotpStatus, error)
+
+	// VerifyTotp checks a code from the authenticator app, or a recovery
+	// code which is then used up. A code can only be used once.
+	VerifyTotp(user User, code string) error
+
+	// RegenerateRecoveryCodes replaces the user's recovery codes.
+	RegenerateRecoveryCodes(user User) ([]string, error)
+
+	DisableTotp(user User) error
+}
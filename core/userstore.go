@@ -26,6 +26,42 @@
 
 package core
 
+import "github.com/pkg/errors"
+
+var ErrNoUsername = errors.New("username does not exist")
+var ErrUserDisabled = errors.New("user is disabled")
+var ErrUserNotLinkable = errors.New("user can not be linked to an external identity")
+
+// External providers that users can log in with.
+const (
+	PROVIDER_OIDC = "oidc"
+	PROVIDER_LDAP = "ldap"
+)
+
+// User roles. Each role is allowed to do everything the roles before it
+// are allowed to do.
+const (
+	// Read only access to events and reports.
+	ROLE_VIEWER = "viewer"
+
+	// Can also triage events: archive, escalate, comment and label.
+	ROLE_ANALYST = "analyst"
+
+	// Can also manage users and configuration.
+	ROLE_ADMIN = "admin"
+)
+
+var roleLevels = map[string]int{
+	ROLE_VIEWER:  1,
+	ROLE_ANALYST: 2,
+	ROLE_ADMIN:   3,
+}
+
+func IsValidRole(role string) bool {
+	_, ok := roleLevels[role]
+	return ok
+}
+
 type User struct {
 	Id       string `json:"id,omitempty"`
 	Username string `json:"username,omitempty"`
@@ -36,6 +72,22 @@ type User struct {
 
 	GitHubUsername string `json:"github_username,omitempty"`
 	GitHubID       int64  `json:"github_id,omitempty"`
+
+	Role string `json:"role,omitempty"`
+
+	// The external provider the user logs in with, and their identity at
+	// it: the issuer and subject for OIDC, or the DN for LDAP. Empty for
+	// local users.
+	Provider   string `json:"provider,omitempty"`
+	ExternalId string `json:"external_id,omitempty"`
+
+	// Disabled users can't log in, and their sessions and API tokens
+	// no longer work.
+	Disabled bool `json:"disabled,omitempty"`
+
+	// Set after an admin resets the password. The user must change
+	// their password before doing anything else.
+	PasswordChangeRequired bool `json:"password_change_required,omitempty"`
 }
 
 func NewAnonymousUser(username string) User {
@@ -43,9 +95,19 @@ func NewAnonymousUser(username string) User {
 		Id:        username,
 		Username:  username,
 		Anonymous: true,
+
+		// Without real authentication everyone can do everything.
+		Role: ROLE_ADMIN,
 	}
 }
 
+// HasRole returns true if the user has the role, or a role that includes
+// it.
+func (u User) HasRole(role string) bool {
+	level, ok := roleLevels[u.Role]
+	return ok && level >= roleLevels[role]
+}
+
 func (u User) IsValid() bool {
 	if u.Id != "" && u.Username != "" {
 		return true
@@ -58,6 +120,37 @@ type UserStore interface {
 	FindByUsername(username string) (User, error)
 	FindByUsernamePassword(username string, password string) (User, error)
 	FindByGitHubUsername(username string) (User, error)
+
+	// FindByExternalId finds the user with an identity at an external
+	// provider.
+	FindByExternalId(provider string, externalId string) (User, error)
+
+	// LinkExternalId sets the external identity of an existing user.
+	// Users with a local password, a GitHub login or an identity already,
+	// or that were added for another provider, can't be linked and
+	// ErrUserNotLinkable is returned.
+	LinkExternalId(username string, provider string, externalId string) error
+
+	// UpdatePassword sets a new password chosen by the user.
 	UpdatePassword(username string, password string) error
+
+	// ResetPassword sets a password chosen by an admin, which the user
+	// must change on their next login.
+	ResetPassword(username string, password string) error
+
 	FindAll() ([]User, error)
+
+	// SetRole changes the role of a user.
+	SetRole(username string, role string) error
+
+	// UpdateUser updates the full name, email, GitHub username and role
+	// of the user with the same username.
+	UpdateUser(user User) error
+
+	SetDisabled(username string, disabled bool) error
+
+	// DeleteByUsername deletes a user, along with their API tokens,
+	// two-factor authentication, preferences, saved searches and
+	// sessions.
+	DeleteByUsername(username string) error
 }
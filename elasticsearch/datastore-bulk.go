@@ -0,0 +1,120 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package elasticsearch
+
+import (
+	"github.com/jasonish/evebox/core"
+	"github.com/pkg/errors"
+	"time"
+)
+
+func (s *DataStore) buildBulkActionQuery(p core.BulkActionParams) (*EventQuery, error) {
+	query := EventQuery{}
+	query.AddFilter(ExistsQuery("event_type"))
+
+	if p.EventType != "" {
+		query.AddFilter(TermQuery("event_type", p.EventType))
+	}
+
+	if p.QueryString != "" {
+		query.AddFilter(QueryString(p.QueryString))
+	}
+
+	if p.TimeRange != "" {
+		if err := query.AddTimeRangeFilter(p.TimeRange); err != nil {
+			return nil, errors.Wrap(err, "bad time range")
+		}
+	}
+
+	if !p.MinTs.IsZero() {
+		query.AddFilter(RangeGte("@timestamp", FormatTimestampUTC(p.MinTs)))
+	}
+
+	if !p.MaxTs.IsZero() {
+		query.AddFilter(RangeLte("@timestamp", FormatTimestampUTC(p.MaxTs)))
+	}
+
+	return &query, nil
+}
+
+// BulkAction archives, escalates or labels all events matching the query
+// using the update_by_query API.
+func (s *DataStore) BulkAction(p core.BulkActionParams, user core.User) (int64, error) {
+	query, err := s.buildBulkActionQuery(p)
+	if err != nil {
+		return 0, err
+	}
+
+	history := HistoryEntry{
+		Username:  user.Username,
+		Timestamp: FormatTimestampUTC(time.Now()),
+	}
+
+	var tags []string
+
+	switch p.Action {
+	case core.BULK_ACTION_ARCHIVE:
+		tags = []string{"archived", "evebox.archived"}
+		history.Action = ACTION_ARCHIVED
+	case core.BULK_ACTION_ESCALATE:
+		tags = []string{"escalated", "evebox.escalated"}
+		history.Action = ACTION_ESCALATED
+	case core.BULK_ACTION_LABEL:
+		if len(p.Labels) == 0 {
+			return 0, errors.New("no labels provided")
+		}
+		tags = p.Labels
+		history.Action = ACTION_LABELED
+		history.Labels = p.Labels
+	default:
+		return 0, errors.Errorf("unsupported bulk action: %s", p.Action)
+	}
+
+	if p.DryRun {
+		query.MustNotHaveAllTags(tags)
+		return s.countByQuery(query)
+	}
+
+	return s.addTagsByQuery(query, tags, history)
+}
+
+// countByQuery returns the number of events matching the query.
+func (s *DataStore) countByQuery(query *EventQuery) (int64, error) {
+	query.SetSize(0)
+	response, err := s.es.Search(query)
+	if err != nil {
+		return 0, err
+	}
+	if response.Status != 0 {
+		reason := response.GetFirstRootCause()
+		if reason == "" {
+			reason = "unknown"
+		}
+		return 0, errors.Errorf("search failed: %s", reason)
+	}
+	return int64(response.Hits.Total), nil
+}
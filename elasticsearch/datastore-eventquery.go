@@ -92,7 +92,7 @@ func (s *DataStore) EventQuery(options core.EventQueryOptions) (interface{}, err
 		if reason == "" {
 			reason = "unknown"
 		}
-		err := fmt.Errorf(reason)
+		err := fmt.Errorf("%s", reason)
 		log.Warning("Search error: %v", err)
 		return nil, err
 	}
@@ -27,9 +27,11 @@
 package elasticsearch
 
 import (
+	"encoding/json"
 	"github.com/jasonish/evebox/core"
 	"github.com/jasonish/evebox/eve"
 	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/util"
 	"github.com/pkg/errors"
 	"time"
 )
@@ -129,11 +131,14 @@ const ACTION_DEESCALATED = "de-escalated"
 
 const ACTION_COMMENT = "comment"
 
+const ACTION_LABELED = "labeled"
+
 type HistoryEntry struct {
-	Timestamp string `json:"timestamp"`
-	Username  string `json:"username"`
-	Action    string `json:"action"`
-	Comment   string `json:"comment,omitempty"`
+	Timestamp string   `json:"timestamp"`
+	Username  string   `json:"username"`
+	Action    string   `json:"action"`
+	Comment   string   `json:"comment,omitempty"`
+	Labels    []string `json:"labels,omitempty"`
 }
 
 func (s *DataStore) buildAlertGroupQuery(p core.AlertGroupQueryParams) *EventQuery {
@@ -153,15 +158,15 @@ func (s *DataStore) buildAlertGroupQuery(p core.AlertGroupQueryParams) *EventQue
 // only available in Elastic Search v5+.
 func (s *DataStore) AddTagsToAlertGroupsByQuery(p core.AlertGroupQueryParams, tags []string, action HistoryEntry) error {
 	log.Println("AddTagsToAlertGroupsByQuery")
-	var mustNot []interface{}
-	for _, tag := range tags {
-		mustNot = append(mustNot, TermQuery("tags", tag))
-	}
+	_, err := s.addTagsByQuery(s.buildAlertGroupQuery(p), tags, action)
+	return err
+}
 
-	query := s.buildAlertGroupQuery(p)
-	if len(mustNot) > 0 {
-		query.Query.Bool.MustNot = mustNot
-	}
+// addTagsByQuery adds the tags and the history entry to all events matching
+// the query, skipping events that already have the tags. The number of
+// events updated is returned.
+func (s *DataStore) addTagsByQuery(query *EventQuery, tags []string, action HistoryEntry) (int64, error) {
+	query.MustNotHaveAllTags(tags)
 	query.Script = &Script{
 		Lang: "painless",
 		Inline: `
@@ -192,12 +197,21 @@ func (s *DataStore) AddTagsToAlertGroupsByQuery(p core.AlertGroupQueryParams, ta
 	response, err := s.es.doUpdateByQuery(query)
 	if err != nil {
 		log.Error("failed to update by query: %v", err)
-		return err
+		return 0, err
 	}
 	log.Info("Events updated: %v; failures=%d",
 		response.Get("updated"), len(response.GetMapList("failures")))
 
-	return nil
+	number, ok := response.Get("updated").(json.Number)
+	if !ok {
+		return 0, errors.Errorf("no update count in response: %s",
+			util.ToJson(response))
+	}
+	updated, err := number.Int64()
+	if err != nil {
+		return 0, errors.Wrap(err, "bad update count")
+	}
+	return updated, nil
 }
 
 // ArchiveAlertGroup is a specialization of AddTagsToAlertGroup.
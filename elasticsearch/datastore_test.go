@@ -0,0 +1,50 @@
+package elasticsearch
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAddTagsByQueryErrors(t *testing.T) {
+	var status int
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	datastore, err := NewDataStore(New(Config{
+		BaseURL: server.URL,
+		Index:   "logstash",
+	}))
+	require.Nil(t, err)
+	query := func() *EventQuery {
+		q := NewEventQuery()
+		return &q
+	}
+
+	// An error status.
+	status = http.StatusBadRequest
+	body = `{"error": {"type": "search_phase_execution_exception"}}`
+	_, err = datastore.addTagsByQuery(query(), []string{"archived"}, HistoryEntry{})
+	assert.NotNil(t, err)
+
+	// An error in a successful response.
+	status = http.StatusOK
+	_, err = datastore.addTagsByQuery(query(), []string{"archived"}, HistoryEntry{})
+	assert.NotNil(t, err)
+
+	// No update count.
+	body = `{}`
+	_, err = datastore.addTagsByQuery(query(), []string{"archived"}, HistoryEntry{})
+	assert.NotNil(t, err)
+
+	body = `{"updated": 3, "failures": []}`
+	updated, err := datastore.addTagsByQuery(query(), []string{"archived"}, HistoryEntry{})
+	require.Nil(t, err)
+	assert.Equal(t, int64(3), updated)
+}
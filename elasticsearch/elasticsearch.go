@@ -391,12 +391,18 @@ func (s *ElasticSearch) doUpdateByQuery(query interface{}) (util.JsonMap, error)
 	if err != nil {
 		return nil, err
 	}
+	if rawResponse.StatusCode >= 400 {
+		return nil, errors.Errorf("%s %s", rawResponse.Status, string(body))
+	}
 
 	decoder := json.NewDecoder(bytes.NewReader(body))
 	decoder.UseNumber()
 	if err := decoder.Decode(&response); err != nil {
 		return nil, err
 	}
+	if response.Get("error") != nil {
+		return nil, errors.Errorf("update by query failed: %s", string(body))
+	}
 
 	return response, nil
 }
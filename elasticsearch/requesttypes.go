@@ -113,6 +113,23 @@ func (q *EventQuery) MustNot(query interface{}) {
 	q.Query.Bool.MustNot = append(q.Query.Bool.MustNot, query)
 }
 
+// MustNotHaveAllTags excludes events that already have every one of the
+// provided tags.
+func (q *EventQuery) MustNotHaveAllTags(tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+	filter := []interface{}{}
+	for _, tag := range tags {
+		filter = append(filter, TermQuery("tags", tag))
+	}
+	q.MustNot(map[string]interface{}{
+		"bool": Bool{
+			Filter: filter,
+		},
+	})
+}
+
 func (q *EventQuery) SortBy(field string, order string) *EventQuery {
 	q.Sort = []interface{}{
 		Sort(field, order),
@@ -202,6 +202,47 @@ func (e EveEvent) AddTag(tag string) {
 	e["tags"] = tags
 }
 
+// HasTag returns true if the event has the provided tag.
+func (e EveEvent) HasTag(tag string) bool {
+	tags, ok := e["tags"].([]interface{})
+	if !ok {
+		return false
+	}
+	for _, existing := range tags {
+		if existing == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveTag removes the provided tag from the event, if it has it.
+func (e EveEvent) RemoveTag(tag string) {
+	tags, ok := e["tags"].([]interface{})
+	if !ok {
+		return
+	}
+	kept := []interface{}{}
+	for _, existing := range tags {
+		if existing != tag {
+			kept = append(kept, existing)
+		}
+	}
+	e["tags"] = kept
+}
+
+// Drop marks the event as dropped. A filter can use this to tell the
+// caller that the event should not be sent to the event sink.
+func (e EveEvent) Drop() {
+	e["__dropped"] = true
+}
+
+// IsDropped returns true if the event was dropped by a filter.
+func (e EveEvent) IsDropped() bool {
+	dropped, _ := e["__dropped"].(bool)
+	return dropped
+}
+
 func asUint16(in interface{}) uint16 {
 	if number, ok := in.(json.Number); ok {
 		asInt64, err := number.Int64()
@@ -58,15 +58,14 @@ type Bookmarker struct {
 // exists. The true reading will start at the end of the file, if false the
 // reading will start from the beginning of the file.
 func NewBookmarker(reader *FollowingReader, directory string, end bool) (*Bookmarker, error) {
-	var bookmarkFilename string
+	return NewNamedBookmarker(reader, directory, "", end)
+}
 
-	if directory == "" {
-		bookmarkFilename = fmt.Sprintf("%s.bookmark", reader.filename)
-	} else {
-		hash := md5.Sum([]byte(reader.filename))
-		bookmarkFilename = fmt.Sprintf("%s/%x.bookmark",
-			directory, hash)
-	}
+// NewNamedBookmarker is like NewBookmarker, but the name is added to the
+// bookmark filename so several readers of the same file, such as one for
+// each output of the agent, can each have their own bookmark.
+func NewNamedBookmarker(reader *FollowingReader, directory string, name string, end bool) (*Bookmarker, error) {
+	bookmarkFilename := BookmarkFilename(reader.filename, directory, name)
 
 	log.Info("Using bookmark file %s", bookmarkFilename)
 
@@ -80,6 +79,19 @@ func NewBookmarker(reader *FollowingReader, directory string, end bool) (*Bookma
 	return bookmarker, nil
 }
 
+// BookmarkFilename returns the filename of the bookmark for a file.
+func BookmarkFilename(filename string, directory string, name string) string {
+	suffix := "bookmark"
+	if name != "" {
+		suffix = fmt.Sprintf("%s.bookmark", name)
+	}
+	if directory == "" {
+		return fmt.Sprintf("%s.%s", filename, suffix)
+	}
+	hash := md5.Sum([]byte(filename))
+	return fmt.Sprintf("%s/%x.%s", directory, hash, suffix)
+}
+
 // GetBookmark returns a bookmark for the readers current location.
 func (b *Bookmarker) GetBookmark() *Bookmark {
 	bookmark := Bookmark{}
@@ -96,23 +108,29 @@ func (b *Bookmarker) GetBookmark() *Bookmark {
 }
 
 func (b *Bookmarker) WriteBookmark(bookmark *Bookmark) error {
-	file, err := os.Create(b.Filename)
+	return WriteBookmarkFile(b.Filename, bookmark)
+}
+
+func (b *Bookmarker) ReadBookmark() (*Bookmark, error) {
+	return ReadBookmarkFile(b.Filename)
+}
+
+func WriteBookmarkFile(filename string, bookmark *Bookmark) error {
+	file, err := os.Create(filename)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
 	encoder := json.NewEncoder(file)
-	err = encoder.Encode(bookmark)
-	if err != nil {
-		return err
-	}
-	return nil
+	return encoder.Encode(bookmark)
 }
 
-func (b *Bookmarker) ReadBookmark() (*Bookmark, error) {
-	file, err := os.Open(b.Filename)
+func ReadBookmarkFile(filename string) (*Bookmark, error) {
+	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
+	defer file.Close()
 	decoder := json.NewDecoder(file)
 	decoder.UseNumber()
 	var bookmark Bookmark
@@ -160,7 +178,8 @@ func (b *Bookmarker) Init(end bool) error {
 		err = b.Reader.SkipTo(bookmark.Offset)
 		if err != nil {
 			if end {
-				log.Error("Failed to skip to line %d, will skip to end of file: %s", err)
+				log.Error("Failed to skip to line %d, will skip to end of file: %s",
+					bookmark.Offset, err)
 				b.Reader.SkipToEnd()
 			}
 		}
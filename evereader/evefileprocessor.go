@@ -27,6 +27,7 @@
 package evereader
 
 import (
+	"fmt"
 	"github.com/jasonish/evebox/core"
 	"github.com/jasonish/evebox/eve"
 	"github.com/jasonish/evebox/log"
@@ -37,6 +38,12 @@ import (
 
 const BATCH_SIZE = 1000
 
+// QueuedSink is implemented by sinks that queue batches before sending
+// them on, such as the agent's spool, so the queue depth can be logged.
+type QueuedSink interface {
+	QueueDepth() int
+}
+
 // EveFileProcessor processes eve files by reading events from reader, applying
 // any filters then sending the events to an event sink.
 type EveFileProcessor struct {
@@ -44,6 +51,10 @@ type EveFileProcessor struct {
 	BookmarkDirectory string
 	Sink              core.EveEventSink
 
+	// Optional name added to the bookmark filename and log messages, for
+	// when the file is read more than once.
+	BookmarkName string
+
 	filters []eve.EveFilter
 
 	customFields map[string]interface{}
@@ -58,6 +69,8 @@ type EveFileProcessor struct {
 
 	wg sync.WaitGroup
 
+	metrics metrics
+
 	// Internal metrics counting.
 	lastStatCount uint64
 	lastStatTime  time.Time
@@ -101,7 +114,8 @@ func (p *EveFileProcessor) Run() {
 			goto Retry
 		}
 
-		bookmarker, err = NewBookmarker(reader, p.BookmarkDirectory, p.End)
+		bookmarker, err = NewNamedBookmarker(reader, p.BookmarkDirectory,
+			p.BookmarkName, p.End)
 		if err != nil {
 			log.Warning("Failed to get bookmarker (will try again): %v", err)
 			reader.Close()
@@ -127,6 +141,9 @@ func (p *EveFileProcessor) process(reader *FollowingReader, bookmarker *Bookmark
 
 	count := uint64(0)
 
+	// Events dropped by filters since the last bookmark update.
+	dropped := uint64(0)
+
 	for {
 		eof := false
 
@@ -145,15 +162,22 @@ func (p *EveFileProcessor) process(reader *FollowingReader, bookmarker *Bookmark
 		}
 
 		if !eof {
+			p.metrics.addRead()
 			for _, filter := range p.filters {
 				filter.Filter(event)
 			}
-			p.addCustomFields(event)
-			if err := p.Sink.Submit(event); err != nil {
-				log.Error("Failed to submit event: %v", err)
-				continue
+			if event.IsDropped() {
+				p.metrics.addDropped()
+				dropped++
+			} else {
+				p.addCustomFields(event)
+				if err := p.Sink.Submit(event); err != nil {
+					log.Error("Failed to submit event: %v", err)
+					continue
+				}
+				p.metrics.addSubmitted()
+				count++
 			}
-			count++
 		}
 
 		// On every EOF or batch size, commit.
@@ -163,20 +187,40 @@ func (p *EveFileProcessor) process(reader *FollowingReader, bookmarker *Bookmark
 				log.Error("Commit failed: %v", err)
 				return err
 			}
-			log.Debug("Committed %d events in %v", count,
-				time.Now().Sub(start))
+			duration := time.Now().Sub(start)
+			log.Debug("Committed %d events in %v", count, duration)
+			p.metrics.commitDone(count, duration)
 			bookmarker.UpdateBookmark()
 			p.count += count
 			count = 0
+			dropped = 0
+			p.updateLag(reader)
+		} else if count == 0 && dropped > 0 &&
+			(eof || dropped%BATCH_SIZE == 0) {
+			// Everything read since the last commit was dropped, there is
+			// nothing to commit but the bookmark can move past it.
+			bookmarker.UpdateBookmark()
+			dropped = 0
+			if eof {
+				p.updateLag(reader)
+			}
+		} else if eof {
+			p.updateLag(reader)
 		}
 
 		// Print stats.
 		now := time.Now()
 		if now.Sub(p.lastStatTime).Seconds() > 60 {
-			log.Info("Total: %d; last minute: %d; EOFs: %d",
+			queued := ""
+			if sink, ok := p.Sink.(QueuedSink); ok {
+				queued = fmt.Sprintf("; queued batches: %d", sink.QueueDepth())
+			}
+			log.Info("%s: total: %d; last minute: %d; EOFs: %d%s",
+				p.name(),
 				p.count,
 				p.count-p.lastStatCount,
-				p.eofs)
+				p.eofs,
+				queued)
 			p.lastStatCount = p.count
 			p.lastStatTime = now
 			p.eofs = 0
@@ -195,6 +239,24 @@ func (p *EveFileProcessor) process(reader *FollowingReader, bookmarker *Bookmark
 	return nil
 }
 
+func (p *EveFileProcessor) name() string {
+	if p.BookmarkName != "" {
+		return fmt.Sprintf("%s (%s)", p.Filename, p.BookmarkName)
+	}
+	return p.Filename
+}
+
+func (p *EveFileProcessor) updateLag(reader *FollowingReader) {
+	if lag, err := reader.Lag(); err == nil {
+		p.metrics.setLag(lag)
+	}
+}
+
+// Metrics returns the processor's counters.
+func (p *EveFileProcessor) Metrics() ProcessorMetrics {
+	return p.metrics.snapshot(p.Filename, p.BookmarkName)
+}
+
 func (p *EveFileProcessor) commit() error {
 	for {
 		_, err := p.Sink.Commit()
@@ -204,6 +266,7 @@ func (p *EveFileProcessor) commit() error {
 		if p.stop {
 			return err
 		}
+		p.metrics.commitFailed()
 		log.Error("Failed to commit events, will try again: %v", err)
 		time.Sleep(1 * time.Second)
 		continue
@@ -0,0 +1,216 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package evereader
+
+import (
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/eve"
+	"github.com/jasonish/evebox/log"
+	"time"
+)
+
+// How often events received on a socket or Redis channel are committed if
+// a batch hasn't filled up.
+const FLUSH_INTERVAL = 1 * time.Second
+
+// lineProcessor is the part of an input common to inputs that receive eve
+// events as lines, such as the socket and Redis inputs, rather than reading
+// them from a file. It decodes the lines, applies filters and custom fields,
+// and commits them to the sink in batches.
+type lineProcessor struct {
+	name string
+	sink core.EveEventSink
+
+	filters      []eve.EveFilter
+	customFields map[string]interface{}
+
+	lines chan []byte
+	stop  chan bool
+
+	// Total number of events processed.
+	count uint64
+
+	metrics metrics
+
+	lastStatCount uint64
+	lastStatTime  time.Time
+}
+
+func (p *lineProcessor) AddFilter(filter eve.EveFilter) {
+	p.filters = append(p.filters, filter)
+}
+
+func (p *lineProcessor) AddCustomField(field string, value interface{}) {
+	if p.customFields == nil {
+		p.customFields = make(map[string]interface{})
+	}
+	p.customFields[field] = value
+}
+
+// init prepares the processor to receive lines, name is used in log
+// messages.
+func (p *lineProcessor) init(name string, sink core.EveEventSink) {
+	p.name = name
+	p.sink = sink
+	p.lines = make(chan []byte, BATCH_SIZE)
+	p.stop = make(chan bool)
+	p.lastStatTime = time.Now()
+}
+
+func (p *lineProcessor) stopping() bool {
+	select {
+	case <-p.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// queue passes a line to the batching loop, blocking while a commit is
+// being retried. Returns false if stopping.
+func (p *lineProcessor) queue(line []byte) bool {
+	select {
+	case p.lines <- line:
+		return true
+	case <-p.stop:
+		return false
+	}
+}
+
+// run is the batching loop for queued lines, committing every BATCH_SIZE
+// events or FLUSH_INTERVAL until stopped.
+func (p *lineProcessor) run() {
+	ticker := time.NewTicker(FLUSH_INTERVAL)
+	defer ticker.Stop()
+
+	count := uint64(0)
+
+	commit := func() {
+		if count == 0 {
+			return
+		}
+		if err := p.commit(count); err != nil {
+			log.Error("Commit failed, %d events lost: %v", count, err)
+		}
+		count = 0
+	}
+
+	for {
+		select {
+		case line := <-p.lines:
+			if p.submit(line) {
+				count++
+			}
+			if count >= BATCH_SIZE {
+				commit()
+			}
+		case <-ticker.C:
+			commit()
+			p.logStats()
+		case <-p.stop:
+			// Commit what has already been received.
+			for len(p.lines) > 0 {
+				if p.submit(<-p.lines) {
+					count++
+				}
+			}
+			commit()
+			return
+		}
+	}
+}
+
+// submit decodes and filters a line, submitting it to the sink. Returns
+// true if the event was submitted.
+func (p *lineProcessor) submit(line []byte) bool {
+	event, err := eve.NewEveEventFromBytes(line)
+	if err != nil {
+		log.Error("Maleformed event error: %v", err)
+		return false
+	}
+	p.metrics.addRead()
+	for _, filter := range p.filters {
+		filter.Filter(event)
+	}
+	if event.IsDropped() {
+		p.metrics.addDropped()
+		return false
+	}
+	for key := range p.customFields {
+		event[key] = p.customFields[key]
+	}
+	if err := p.sink.Submit(event); err != nil {
+		log.Error("Failed to submit event: %v", err)
+		return false
+	}
+	p.metrics.addSubmitted()
+	return true
+}
+
+// commit commits the count events submitted, retrying until it succeeds
+// or the processor is stopped.
+func (p *lineProcessor) commit(count uint64) error {
+	start := time.Now()
+	for {
+		_, err := p.sink.Commit()
+		if err == nil {
+			break
+		}
+		if p.stopping() {
+			return err
+		}
+		p.metrics.commitFailed()
+		log.Error("Failed to commit events, will try again: %v", err)
+		time.Sleep(1 * time.Second)
+	}
+	duration := time.Now().Sub(start)
+	log.Debug("Committed %d events in %v", count, duration)
+	p.metrics.commitDone(count, duration)
+	p.count += count
+	return nil
+}
+
+// Metrics returns the processor's counters.
+func (p *lineProcessor) Metrics() []ProcessorMetrics {
+	return []ProcessorMetrics{p.metrics.snapshot(p.name, "")}
+}
+
+func (p *lineProcessor) logStats() {
+	now := time.Now()
+	if now.Sub(p.lastStatTime).Seconds() <= 60 {
+		return
+	}
+	queued := ""
+	if sink, ok := p.sink.(QueuedSink); ok {
+		queued = fmt.Sprintf("; queued batches: %d", sink.QueueDepth())
+	}
+	log.Info("%s: total: %d; last minute: %d%s", p.name, p.count,
+		p.count-p.lastStatCount, queued)
+	p.lastStatCount = p.count
+	p.lastStatTime = now
+}
@@ -0,0 +1,133 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package evereader
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ProcessorMetrics are the counters of a processor at a point in time.
+type ProcessorMetrics struct {
+	// The file, socket path or Redis key read.
+	Input string `json:"input"`
+
+	// The bookmark name of a file processor, naming the output it reads
+	// the file for.
+	Output string `json:"output"`
+
+	// Events read, including those dropped by filters.
+	Read uint64 `json:"events_read"`
+
+	// Events dropped by filters.
+	Dropped uint64 `json:"events_dropped"`
+
+	// Events submitted to the sink, and those of them committed.
+	Submitted uint64 `json:"events_submitted"`
+	Committed uint64 `json:"events_committed"`
+
+	// Number of successful commits, and the time taken by them in
+	// seconds including retries.
+	Commits           uint64  `json:"commits"`
+	CommitSeconds     float64 `json:"commit_seconds"`
+	LastCommitSeconds float64 `json:"last_commit_seconds"`
+
+	// Number of failed commits that were retried.
+	Retries uint64 `json:"commit_retries"`
+
+	// True while a failed commit is being retried.
+	Failing bool `json:"failing"`
+
+	// Bytes left to read in the file, always 0 for other inputs.
+	Lag int64 `json:"lag_bytes"`
+}
+
+// metrics are the counters of a processor, updated while it runs and safe
+// to read from another goroutine.
+type metrics struct {
+	read        uint64
+	dropped     uint64
+	submitted   uint64
+	committed   uint64
+	commits     uint64
+	retries     uint64
+	commitNanos int64
+	lastCommit  int64
+	lag         int64
+	failing     int32
+}
+
+func (m *metrics) addRead() {
+	atomic.AddUint64(&m.read, 1)
+}
+
+func (m *metrics) addDropped() {
+	atomic.AddUint64(&m.dropped, 1)
+}
+
+func (m *metrics) addSubmitted() {
+	atomic.AddUint64(&m.submitted, 1)
+}
+
+// commitFailed records a failed commit that will be retried.
+func (m *metrics) commitFailed() {
+	atomic.AddUint64(&m.retries, 1)
+	atomic.StoreInt32(&m.failing, 1)
+}
+
+// commitDone records a successful commit of count events that took
+// duration.
+func (m *metrics) commitDone(count uint64, duration time.Duration) {
+	atomic.AddUint64(&m.committed, count)
+	atomic.AddUint64(&m.commits, 1)
+	atomic.AddInt64(&m.commitNanos, int64(duration))
+	atomic.StoreInt64(&m.lastCommit, int64(duration))
+	atomic.StoreInt32(&m.failing, 0)
+}
+
+func (m *metrics) setLag(lag int64) {
+	atomic.StoreInt64(&m.lag, lag)
+}
+
+func (m *metrics) snapshot(input string, output string) ProcessorMetrics {
+	return ProcessorMetrics{
+		Input:     input,
+		Output:    output,
+		Read:      atomic.LoadUint64(&m.read),
+		Dropped:   atomic.LoadUint64(&m.dropped),
+		Submitted: atomic.LoadUint64(&m.submitted),
+		Committed: atomic.LoadUint64(&m.committed),
+		Commits:   atomic.LoadUint64(&m.commits),
+		CommitSeconds: time.Duration(
+			atomic.LoadInt64(&m.commitNanos)).Seconds(),
+		LastCommitSeconds: time.Duration(
+			atomic.LoadInt64(&m.lastCommit)).Seconds(),
+		Retries: atomic.LoadUint64(&m.retries),
+		Failing: atomic.LoadInt32(&m.failing) == 1,
+		Lag:     atomic.LoadInt64(&m.lag),
+	}
+}
@@ -0,0 +1,323 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package evereader
+
+import (
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/eve"
+	"github.com/jasonish/evebox/log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often to look for new files matching the patterns.
+const DEFAULT_SCAN_INTERVAL = 10 * time.Second
+
+// MultiFileProcessor runs an EveFileProcessor for each file matching a
+// list of paths and glob patterns, starting new processors as new files
+// appear. Each file has its own event sink and bookmark.
+type MultiFileProcessor struct {
+	// Paths or glob patterns to read.
+	Patterns []string
+
+	BookmarkDirectory string
+
+	// Optional name added to bookmark filenames, so the same files can be
+	// read by more than one processor.
+	BookmarkName string
+
+	// Creates the sink for a file. Each file gets its own sink so
+	// commits for one file don't include events from another.
+	NewSink func() core.EveEventSink
+
+	// Start reading files found at startup at the end if no valid
+	// bookmark exists. Files that appear later are read from the
+	// beginning, unless they are a rotated copy of a file already being
+	// read.
+	End bool
+
+	ScanInterval time.Duration
+
+	filters      []eve.EveFilter
+	customFields map[string]interface{}
+
+	lock       sync.Mutex
+	processors map[string]*EveFileProcessor
+
+	// The device and inode of each file at the last scan, to recognize
+	// files that have been renamed by log rotation.
+	sys map[string]interface{}
+
+	stop chan bool
+	wg   sync.WaitGroup
+}
+
+func (p *MultiFileProcessor) AddFilter(filter eve.EveFilter) {
+	p.filters = append(p.filters, filter)
+}
+
+func (p *MultiFileProcessor) AddCustomField(field string, value interface{}) {
+	if p.customFields == nil {
+		p.customFields = make(map[string]interface{})
+	}
+	p.customFields[field] = value
+}
+
+// findFiles returns the files matching the patterns. Paths without glob
+// characters are returned even if they don't exist yet, so they are read
+// once created.
+func (p *MultiFileProcessor) findFiles() []string {
+	seen := map[string]bool{}
+	files := []string{}
+	for _, pattern := range p.Patterns {
+		if !hasGlob(pattern) {
+			if !seen[pattern] {
+				seen[pattern] = true
+				files = append(files, pattern)
+			}
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			log.Error("Bad input pattern %s: %v", pattern, err)
+			continue
+		}
+		for _, match := range matches {
+			// Don't read our own bookmarks if they match the pattern.
+			if seen[match] || strings.HasSuffix(match, ".bookmark") {
+				continue
+			}
+			if info, err := os.Stat(match); err != nil || info.IsDir() {
+				continue
+			}
+			seen[match] = true
+			files = append(files, match)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+func hasGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// Files returns the files currently being read.
+func (p *MultiFileProcessor) Files() []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	files := make([]string, 0, len(p.processors))
+	for filename := range p.processors {
+		files = append(files, filename)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// scan starts a processor for each new file, and stops the processors of
+// files matched by a glob that have been removed.
+func (p *MultiFileProcessor) scan(initial bool) {
+	files := p.findFiles()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	found := map[string]bool{}
+	for _, filename := range files {
+		found[filename] = true
+		if _, ok := p.processors[filename]; ok {
+			continue
+		}
+		end := p.End && initial
+		if initial {
+			p.resumeRenamed(filename, files)
+		} else if rotated := p.renamedFrom(filename); rotated != "" {
+			// The processor of the original file reads it to the
+			// end before opening the new file.
+			log.Info("Found new input file %s, a rotated copy of %s, will only read new events",
+				filename, rotated)
+			end = true
+		} else {
+			log.Info("Found new input file %s", filename)
+		}
+		processor := &EveFileProcessor{
+			Filename:          filename,
+			BookmarkDirectory: p.BookmarkDirectory,
+			BookmarkName:      p.BookmarkName,
+			Sink:              p.NewSink(),
+			End:               end,
+		}
+		for _, filter := range p.filters {
+			processor.AddFilter(filter)
+		}
+		for field, value := range p.customFields {
+			processor.AddCustomField(field, value)
+		}
+		processor.Start()
+		p.processors[filename] = processor
+	}
+
+	for filename, processor := range p.processors {
+		if found[filename] {
+			continue
+		}
+		if _, err := os.Stat(filename); err == nil {
+			continue
+		}
+		log.Info("Input file %s has been removed, no longer reading", filename)
+		processor.Stop()
+		delete(p.processors, filename)
+	}
+
+	p.sys = map[string]interface{}{}
+	for filename := range p.processors {
+		if info, err := os.Stat(filename); err == nil {
+			p.sys[filename] = GetSys(info)
+		}
+	}
+}
+
+// renamedFrom returns the file being read that filename was at the last
+// scan, or an empty string if it is a new file.
+func (p *MultiFileProcessor) renamedFrom(filename string) string {
+	if !sysIdentifiesFile {
+		return ""
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return ""
+	}
+	sys := GetSys(info)
+	for other, otherSys := range p.sys {
+		if other != filename && SameSys(otherSys, sys) {
+			return other
+		}
+	}
+	return ""
+}
+
+// resumeRenamed looks for a file that was rotated while not running. If
+// the bookmark of another file is for filename, and filename doesn't have
+// a valid bookmark of its own, it is copied so reading resumes where it
+// left off instead of starting over.
+func (p *MultiFileProcessor) resumeRenamed(filename string, files []string) {
+	if !sysIdentifiesFile {
+		return
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return
+	}
+	sys := GetSys(info)
+	bookmarkFilename := BookmarkFilename(filename, p.BookmarkDirectory, p.BookmarkName)
+	if bookmark, err := ReadBookmarkFile(bookmarkFilename); err == nil {
+		if bookmark.Path == filename && SameSys(bookmark.Sys, sys) {
+			return
+		}
+	}
+	for _, other := range files {
+		if other == filename {
+			continue
+		}
+		bookmark, err := ReadBookmarkFile(
+			BookmarkFilename(other, p.BookmarkDirectory, p.BookmarkName))
+		if err != nil || !SameSys(bookmark.Sys, sys) {
+			continue
+		}
+		log.Info("Input file %s was rotated from %s, resuming at line %d",
+			filename, other, bookmark.Offset)
+		bookmark.Path = filename
+		if err := WriteBookmarkFile(bookmarkFilename, bookmark); err != nil {
+			log.Error("Failed to write bookmark %s: %v", bookmarkFilename, err)
+		}
+		return
+	}
+}
+
+func (p *MultiFileProcessor) Start() {
+	if p.ScanInterval <= 0 {
+		p.ScanInterval = DEFAULT_SCAN_INTERVAL
+	}
+	p.processors = map[string]*EveFileProcessor{}
+	p.stop = make(chan bool)
+	p.scan(true)
+	if len(p.processors) == 0 {
+		log.Warning("No input files found matching %s, will keep looking",
+			strings.Join(p.Patterns, ", "))
+	}
+	p.wg.Add(1)
+	go p.run()
+}
+
+func (p *MultiFileProcessor) run() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.ScanInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.scan(false)
+		}
+	}
+}
+
+// Metrics returns the counters of each file being read.
+func (p *MultiFileProcessor) Metrics() []ProcessorMetrics {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	metrics := make([]ProcessorMetrics, 0, len(p.processors))
+	for _, processor := range p.processors {
+		metrics = append(metrics, processor.Metrics())
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].Input < metrics[j].Input
+	})
+	return metrics
+}
+
+func (p *MultiFileProcessor) Stop() {
+	close(p.stop)
+	p.wg.Wait()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var wg sync.WaitGroup
+	for _, processor := range p.processors {
+		wg.Add(1)
+		go func(processor *EveFileProcessor) {
+			defer wg.Done()
+			processor.Stop()
+		}(processor)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,337 @@
+/* Copyright (c) 2019 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package evereader
+
+import (
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/eve"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Collects the events committed by all the sinks it creates.
+type testSinks struct {
+	lock      sync.Mutex
+	committed []eve.EveEvent
+	sinks     int
+}
+
+type testSink struct {
+	sinks   *testSinks
+	pending []eve.EveEvent
+}
+
+func (s *testSinks) NewSink() core.EveEventSink {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sinks++
+	return &testSink{sinks: s}
+}
+
+func (s *testSinks) Committed() []eve.EveEvent {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]eve.EveEvent{}, s.committed...)
+}
+
+func (s *testSink) Submit(event eve.EveEvent) error {
+	s.pending = append(s.pending, event)
+	return nil
+}
+
+func (s *testSink) Commit() (interface{}, error) {
+	s.sinks.lock.Lock()
+	defer s.sinks.lock.Unlock()
+	s.sinks.committed = append(s.sinks.committed, s.pending...)
+	s.pending = nil
+	return nil, nil
+}
+
+func writeEvents(t *testing.T, filename string, count int) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	require.Nil(t, err)
+	defer file.Close()
+	for i := 0; i < count; i++ {
+		fmt.Fprintf(file, `{"timestamp": "2019-01-02T03:04:05.000000-0600", "event_type": "test", "filename": "%s"}`+"\n",
+			filepath.Base(filename))
+	}
+}
+
+func waitForEvents(t *testing.T, sinks *testSinks, count int) []eve.EveEvent {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if events := sinks.Committed(); len(events) >= count {
+			return events
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	require.Fail(t, "timed out waiting for events",
+		"have %d, want %d", len(sinks.Committed()), count)
+	return nil
+}
+
+func countByFilename(events []eve.EveEvent) map[string]int {
+	counts := map[string]int{}
+	for _, event := range events {
+		counts[event["filename"].(string)]++
+	}
+	return counts
+}
+
+func TestMultiFileProcessor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evebox-multifile")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	writeEvents(t, filepath.Join(dir, "eve-1.json"), 2)
+	writeEvents(t, filepath.Join(dir, "eve-2.json"), 3)
+	writeEvents(t, filepath.Join(dir, "other.log"), 1)
+
+	sinks := &testSinks{}
+	processor := &MultiFileProcessor{
+		Patterns:     []string{filepath.Join(dir, "eve-*")},
+		NewSink:      sinks.NewSink,
+		ScanInterval: 50 * time.Millisecond,
+	}
+	processor.Start()
+	defer processor.Stop()
+
+	events := waitForEvents(t, sinks, 5)
+	assert.Equal(t, map[string]int{"eve-1.json": 2, "eve-2.json": 3},
+		countByFilename(events))
+	assert.Equal(t, 2, sinks.sinks)
+
+	metrics := processor.Metrics()
+	require.Len(t, metrics, 2)
+	assert.Equal(t, filepath.Join(dir, "eve-1.json"), metrics[0].Input)
+	assert.Equal(t, uint64(2), metrics[0].Read)
+	assert.Equal(t, uint64(2), metrics[0].Committed)
+	assert.Equal(t, uint64(3), metrics[1].Committed)
+	assert.Equal(t, int64(0), metrics[1].Lag)
+
+	// A new file is picked up and read from the beginning, even though
+	// the bookmarks of the other files also match the pattern.
+	writeEvents(t, filepath.Join(dir, "eve-3.json"), 4)
+	events = waitForEvents(t, sinks, 9)
+	assert.Equal(t, 4, countByFilename(events)["eve-3.json"])
+	assert.Equal(t, []string{
+		filepath.Join(dir, "eve-1.json"),
+		filepath.Join(dir, "eve-2.json"),
+		filepath.Join(dir, "eve-3.json"),
+	}, processor.Files())
+
+	// Removed files are no longer read.
+	require.Nil(t, os.Remove(filepath.Join(dir, "eve-1.json")))
+	deadline := time.Now().Add(5 * time.Second)
+	for len(processor.Files()) != 2 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.Len(t, processor.Files(), 2)
+}
+
+func TestMultiFileProcessorBookmarks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evebox-multifile")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	bookmarks := filepath.Join(dir, "bookmarks")
+	require.Nil(t, os.Mkdir(bookmarks, 0755))
+
+	writeEvents(t, filepath.Join(dir, "a.json"), 1)
+	writeEvents(t, filepath.Join(dir, "b.json"), 1)
+
+	patterns := []string{filepath.Join(dir, "*.json")}
+	sinks := &testSinks{}
+	processor := &MultiFileProcessor{
+		Patterns:          patterns,
+		BookmarkDirectory: bookmarks,
+		NewSink:           sinks.NewSink,
+	}
+	processor.Start()
+	waitForEvents(t, sinks, 2)
+	processor.Stop()
+
+	matches, err := filepath.Glob(filepath.Join(bookmarks, "*.bookmark"))
+	require.Nil(t, err)
+	assert.Len(t, matches, 2)
+
+	// After a restart only the new events are read.
+	writeEvents(t, filepath.Join(dir, "b.json"), 2)
+	sinks = &testSinks{}
+	processor = &MultiFileProcessor{
+		Patterns:          patterns,
+		BookmarkDirectory: bookmarks,
+		NewSink:           sinks.NewSink,
+	}
+	processor.Start()
+	defer processor.Stop()
+	events := waitForEvents(t, sinks, 2)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, map[string]int{"b.json": 2},
+		countByFilename(sinks.Committed()))
+	assert.Len(t, events, 2)
+}
+
+func TestMultiFileProcessorBookmarkName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evebox-multifile")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	bookmarks := filepath.Join(dir, "bookmarks")
+	require.Nil(t, os.Mkdir(bookmarks, 0755))
+
+	writeEvents(t, filepath.Join(dir, "a.json"), 3)
+
+	// Processors with different bookmark names each read all the events.
+	patterns := []string{filepath.Join(dir, "*.json")}
+	for _, name := range []string{"", "one", "two"} {
+		sinks := &testSinks{}
+		processor := &MultiFileProcessor{
+			Patterns:          patterns,
+			BookmarkDirectory: bookmarks,
+			BookmarkName:      name,
+			NewSink:           sinks.NewSink,
+		}
+		processor.Start()
+		waitForEvents(t, sinks, 3)
+		processor.Stop()
+	}
+
+	matches, err := filepath.Glob(filepath.Join(bookmarks, "*.bookmark"))
+	require.Nil(t, err)
+	assert.Len(t, matches, 3)
+	matches, err = filepath.Glob(filepath.Join(bookmarks, "*.one.bookmark"))
+	require.Nil(t, err)
+	assert.Len(t, matches, 1)
+}
+
+// dropFilter drops every event.
+type dropFilter struct{}
+
+func (f dropFilter) Filter(event eve.EveEvent) {
+	event.Drop()
+}
+
+func TestMultiFileProcessorBookmarkDropped(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evebox-multifile")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	bookmarks := filepath.Join(dir, "bookmarks")
+	require.Nil(t, os.Mkdir(bookmarks, 0755))
+
+	writeEvents(t, filepath.Join(dir, "a.json"), 3)
+
+	// With every event dropped there is nothing to commit, but the
+	// bookmark is still updated.
+	patterns := []string{filepath.Join(dir, "*.json")}
+	processor := &MultiFileProcessor{
+		Patterns:          patterns,
+		BookmarkDirectory: bookmarks,
+		NewSink:           (&testSinks{}).NewSink,
+	}
+	processor.AddFilter(dropFilter{})
+	processor.Start()
+	waitFor(t, func() bool {
+		matches, _ := filepath.Glob(filepath.Join(bookmarks, "*.bookmark"))
+		return len(matches) == 1
+	})
+	processor.Stop()
+
+	// After a restart the dropped events are not read again.
+	writeEvents(t, filepath.Join(dir, "a.json"), 1)
+	sinks := &testSinks{}
+	processor = &MultiFileProcessor{
+		Patterns:          patterns,
+		BookmarkDirectory: bookmarks,
+		NewSink:           sinks.NewSink,
+	}
+	processor.Start()
+	defer processor.Stop()
+	waitForEvents(t, sinks, 1)
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(t, sinks.Committed(), 1)
+}
+
+func TestMultiFileProcessorRotation(t *testing.T) {
+	if !sysIdentifiesFile {
+		t.Skip("renamed files are not recognized on this platform")
+	}
+	dir, err := ioutil.TempDir("", "evebox-multifile")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	bookmarks := filepath.Join(dir, "bookmarks")
+	require.Nil(t, os.Mkdir(bookmarks, 0755))
+
+	filename := filepath.Join(dir, "eve.json")
+	writeEvents(t, filename, 2)
+
+	patterns := []string{filepath.Join(dir, "eve.json*")}
+	sinks := &testSinks{}
+	processor := &MultiFileProcessor{
+		Patterns:          patterns,
+		BookmarkDirectory: bookmarks,
+		NewSink:           sinks.NewSink,
+		ScanInterval:      50 * time.Millisecond,
+	}
+	processor.Start()
+	waitForEvents(t, sinks, 2)
+
+	// A rotated copy matching the pattern is not read again.
+	require.Nil(t, os.Rename(filename, filename+".1"))
+	writeEvents(t, filename, 1)
+	waitFor(t, func() bool {
+		return len(processor.Files()) == 2
+	})
+	waitForEvents(t, sinks, 3)
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, map[string]int{"eve.json": 3},
+		countByFilename(sinks.Committed()))
+	processor.Stop()
+
+	// Rotated while not running, the rotated copy resumes from the
+	// bookmark of the file it was renamed from.
+	writeEvents(t, filename, 1)
+	require.Nil(t, os.Rename(filename, filename+".2"))
+	writeEvents(t, filename+".2", 1)
+	writeEvents(t, filename, 2)
+	sinks = &testSinks{}
+	processor = &MultiFileProcessor{
+		Patterns:          patterns,
+		BookmarkDirectory: bookmarks,
+		NewSink:           sinks.NewSink,
+	}
+	processor.Start()
+	defer processor.Stop()
+	waitForEvents(t, sinks, 4)
+	time.Sleep(200 * time.Millisecond)
+	assert.Len(t, sinks.Committed(), 4)
+}
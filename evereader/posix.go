@@ -31,67 +31,62 @@ package evereader
 import (
 	"encoding/json"
 	"os"
+	"strconv"
 	"syscall"
 )
 
+// Bookmarks record which file they are for, so a renamed file can be
+// recognized.
+const sysIdentifiesFile = true
+
 func GetSys(fileinfo os.FileInfo) interface{} {
 	stat, ok := fileinfo.Sys().(*syscall.Stat_t)
 	if !ok {
 		return nil
 	}
 	return map[string]interface{}{
+		"dev":   uint64(stat.Dev),
 		"inode": stat.Ino,
 	}
 }
 
-func SameSys(a interface{}, b interface{}) bool {
-
-	aa, ok := a.(map[string]interface{})
-	if !ok {
-		return false
-	}
-
-	bb, ok := b.(map[string]interface{})
+// sysValue returns a value from the Sys of a bookmark, as read from JSON,
+// or from GetSys.
+func sysValue(sys interface{}, key string) (uint64, bool) {
+	m, ok := sys.(map[string]interface{})
 	if !ok {
-		return false
+		return 0, false
 	}
-
-	var aaa uint64
-	var bbb uint64
-
-	switch t := aa["inode"].(type) {
+	switch t := m[key].(type) {
 	case json.Number:
-		tmp, err := t.Int64()
+		value, err := strconv.ParseUint(t.String(), 10, 64)
 		if err != nil {
-			return false
+			return 0, false
 		}
-		aaa = uint64(tmp)
+		return value, true
 	case uint64:
-		bbb = t
+		return t, true
 	case int64:
-		bbb = uint64(t)
-	default:
-		return false
+		return uint64(t), true
 	}
+	return 0, false
+}
 
-	switch t := bb["inode"].(type) {
-	case json.Number:
-		tmp, err := t.Int64()
-		if err != nil {
-			return false
-		}
-		bbb = uint64(tmp)
-	case uint64:
-		bbb = t
-	case int64:
-		bbb = uint64(t)
-	default:
+// SameSys returns true if a and b are for the same inode, and device if
+// both have one. Older bookmarks only have the inode.
+func SameSys(a interface{}, b interface{}) bool {
+	aInode, ok := sysValue(a, "inode")
+	if !ok {
 		return false
 	}
-
-	if aaa != bbb {
+	bInode, ok := sysValue(b, "inode")
+	if !ok || aInode != bInode {
+		return false
+	}
+	aDev, aOk := sysValue(a, "dev")
+	bDev, bOk := sysValue(b, "dev")
+	if aOk && bOk && aDev != bDev {
 		return false
 	}
-
 	return true
 }
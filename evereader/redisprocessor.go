@@ -0,0 +1,295 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package evereader
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/log"
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+// Redis input modes, matching the Suricata eve redis output modes.
+const (
+	REDIS_MODE_LIST    = "list"
+	REDIS_MODE_CHANNEL = "channel"
+)
+
+// The address connected to if none is set.
+const REDIS_DEFAULT_ADDRESS = "127.0.0.1:6379"
+
+// Timeout for connecting and for the replies to commands that don't
+// block.
+const REDIS_TIMEOUT = 10 * time.Second
+
+// How long to block waiting for events on a list before checking if the
+// processor is being stopped.
+const REDIS_POP_TIMEOUT = 1 * time.Second
+
+// RedisProcessor consumes events from a Redis list or pub/sub channel,
+// applies any filters then sends the events to an event sink in batches.
+//
+// Events are consumed from the tail of a list, the oldest events with
+// Suricata's list mode which pushes on to the head. Each event is atomically
+// moved to a processing list until the batch it is in has been committed,
+// so events are not lost if the processor is stopped, or the sink fails,
+// before a commit. Events left on the processing list are submitted again
+// on start, so events may be submitted more than once but are never lost.
+//
+// Events published to a channel are only received while subscribed.
+type RedisProcessor struct {
+	Address  string
+	Password string
+	Database int
+
+	// REDIS_MODE_LIST or REDIS_MODE_CHANNEL.
+	Mode string
+
+	// List or channel name.
+	Key string
+
+	// List events are moved to while being processed. Must be unique to
+	// each processor consuming the same list. Defaults to the key with a
+	// ":processing" suffix.
+	ProcessingKey string
+
+	Sink core.EveEventSink
+
+	lineProcessor
+
+	lock sync.Mutex
+	conn redis.Conn
+
+	wg sync.WaitGroup
+}
+
+func (p *RedisProcessor) Start() error {
+	switch p.Mode {
+	case REDIS_MODE_LIST, "":
+		if p.ProcessingKey == "" {
+			p.ProcessingKey = p.Key + ":processing"
+		}
+	case REDIS_MODE_CHANNEL:
+	default:
+		return errors.Errorf("unsupported redis mode: %s", p.Mode)
+	}
+	if p.Key == "" {
+		return errors.New("no redis key provided")
+	}
+
+	p.init(p.Key, p.Sink)
+
+	p.wg.Add(1)
+	go p.connect()
+
+	if p.Mode == REDIS_MODE_CHANNEL {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.run()
+		}()
+	}
+
+	return nil
+}
+
+// Stop stops consuming events, committing events already received.
+func (p *RedisProcessor) Stop() {
+	close(p.stop)
+
+	// Receiving from a channel blocks until a message arrives, while list
+	// pops time out so the current batch can finish.
+	if p.Mode == REDIS_MODE_CHANNEL {
+		p.lock.Lock()
+		if p.conn != nil {
+			p.conn.Close()
+		}
+		p.lock.Unlock()
+	}
+
+	p.wg.Wait()
+}
+
+// connect connects to the server and consumes events, reconnecting on
+// failure until stopped.
+func (p *RedisProcessor) connect() {
+	defer p.wg.Done()
+	for {
+		conn, err := p.dial()
+		if err != nil {
+			log.Warning("Failed to connect to redis (will try again): %v", err)
+		} else {
+			p.lock.Lock()
+			if p.stopping() {
+				p.lock.Unlock()
+				conn.Close()
+				return
+			}
+			p.conn = conn
+			p.lock.Unlock()
+
+			if p.Mode == REDIS_MODE_CHANNEL {
+				err = p.subscribe(conn)
+			} else {
+				err = p.consumeList(conn)
+			}
+			conn.Close()
+
+			if err != nil && !p.stopping() {
+				log.Error("Redis error (will reconnect): %v", err)
+			}
+		}
+
+		if p.stopping() {
+			return
+		}
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// dial connects to the server, authenticating with the password and
+// selecting the database if set.
+func (p *RedisProcessor) dial() (redis.Conn, error) {
+	address := p.Address
+	if address == "" {
+		address = REDIS_DEFAULT_ADDRESS
+	}
+	return redis.Dial("tcp", address,
+		redis.DialPassword(p.Password),
+		redis.DialDatabase(p.Database),
+		redis.DialConnectTimeout(REDIS_TIMEOUT),
+		redis.DialReadTimeout(REDIS_TIMEOUT),
+		redis.DialWriteTimeout(REDIS_TIMEOUT))
+}
+
+func (p *RedisProcessor) subscribe(conn redis.Conn) error {
+	psc := redis.PubSubConn{Conn: conn}
+	if err := psc.Subscribe(p.Key); err != nil {
+		return err
+	}
+	log.Info("Subscribed to redis channel %s", p.Key)
+	for {
+		// Messages are waited for without a timeout, closing the
+		// connection interrupts it.
+		switch reply := psc.ReceiveWithTimeout(0).(type) {
+		case redis.Message:
+			if !p.queue(reply.Data) {
+				return nil
+			}
+		case error:
+			return reply
+		}
+	}
+}
+
+func (p *RedisProcessor) consumeList(conn redis.Conn) error {
+	if err := p.recover(conn); err != nil {
+		return err
+	}
+
+	log.Info("Reading events from redis list %s", p.Key)
+
+	blockTimeout := REDIS_TIMEOUT + REDIS_POP_TIMEOUT
+	blockSeconds := int(REDIS_POP_TIMEOUT / time.Second)
+
+	for !p.stopping() {
+		line, err := redis.Bytes(redis.DoWithTimeout(conn, blockTimeout,
+			"BRPOPLPUSH", p.Key, p.ProcessingKey, blockSeconds))
+		if err == redis.ErrNil {
+			p.logStats()
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		count := uint64(0)
+		for {
+			if p.submit(line) {
+				count++
+			}
+			if count >= BATCH_SIZE {
+				break
+			}
+			line, err = redis.Bytes(conn.Do("RPOPLPUSH", p.Key,
+				p.ProcessingKey))
+			if err == redis.ErrNil {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := p.commitProcessing(conn, count); err != nil {
+			return err
+		}
+		p.logStats()
+	}
+
+	return nil
+}
+
+// recover submits events left on the processing list by a previous run
+// that didn't commit them.
+func (p *RedisProcessor) recover(conn redis.Conn) error {
+	lines, err := redis.ByteSlices(conn.Do("LRANGE", p.ProcessingKey, 0, -1))
+	if err != nil {
+		return err
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	log.Info("Recovering %d uncommitted events from redis list %s",
+		len(lines), p.ProcessingKey)
+
+	// Newer events are at the head of the processing list.
+	count := uint64(0)
+	for i := len(lines) - 1; i >= 0; i-- {
+		if p.submit(lines[i]) {
+			count++
+		}
+	}
+
+	return p.commitProcessing(conn, count)
+}
+
+// commitProcessing commits the events submitted and then removes them from
+// the processing list.
+func (p *RedisProcessor) commitProcessing(conn redis.Conn, count uint64) error {
+	if count > 0 {
+		if err := p.commit(count); err != nil {
+			// Leave the events on the processing list.
+			return err
+		}
+	}
+	_, err := conn.Do("DEL", p.ProcessingKey)
+	return err
+}
@@ -0,0 +1,195 @@
+/* Copyright (c) 2019 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package evereader
+
+import (
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jasonish/evebox/eve"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// failingSink fails every commit.
+type failingSink struct{}
+
+func (s *failingSink) Submit(event eve.EveEvent) error {
+	return nil
+}
+
+func (s *failingSink) Commit() (interface{}, error) {
+	return nil, errors.New("commit failed")
+}
+
+func redisEvent(id int) string {
+	return fmt.Sprintf(`{"timestamp": "2019-01-02T03:04:05.000000-0600", "event_type": "test", "id": %d}`, id)
+}
+
+// lpush pushes values on to the head of a list, like LPUSH with several
+// values.
+func lpush(t *testing.T, server *miniredis.Miniredis, key string, values ...string) {
+	for _, value := range values {
+		_, err := server.Lpush(key, value)
+		require.Nil(t, err)
+	}
+}
+
+// listLen returns the length of a list, 0 if it doesn't exist.
+func listLen(server *miniredis.Miniredis, key string) int {
+	values, _ := server.List(key)
+	return len(values)
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	require.Fail(t, "timed out")
+}
+
+func TestRedisProcessorList(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	lpush(t, server, "suricata", redisEvent(1), "not json", redisEvent(2),
+		redisEvent(3))
+
+	sinks := &testSinks{}
+	processor := &RedisProcessor{
+		Address: server.Addr(),
+		Key:     "suricata",
+		Sink:    sinks.NewSink(),
+	}
+	processor.AddCustomField("sensor", "test")
+	require.Nil(t, processor.Start())
+	defer processor.Stop()
+
+	events := waitForEvents(t, sinks, 3)
+	require.Len(t, events, 3)
+	for i, event := range events {
+		assert.Equal(t, fmt.Sprintf("%d", i+1), fmt.Sprintf("%v", event["id"]))
+		assert.Equal(t, "test", event["sensor"])
+	}
+
+	// Once committed the events are removed from the processing list.
+	waitFor(t, func() bool {
+		return listLen(server, "suricata:processing") == 0
+	})
+	assert.Equal(t, 0, listLen(server, "suricata"))
+}
+
+func TestRedisProcessorListRecovery(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	lpush(t, server, "suricata", redisEvent(1), redisEvent(2))
+
+	// While commits fail the events stay on the processing list.
+	processor := &RedisProcessor{
+		Address: server.Addr(),
+		Key:     "suricata",
+		Sink:    &failingSink{},
+	}
+	require.Nil(t, processor.Start())
+	waitFor(t, func() bool {
+		return listLen(server, "suricata:processing") == 2
+	})
+	processor.Stop()
+	assert.Equal(t, 0, listLen(server, "suricata"))
+	assert.Equal(t, 2, listLen(server, "suricata:processing"))
+
+	// And are recovered on the next start.
+	sinks := &testSinks{}
+	processor = &RedisProcessor{
+		Address: server.Addr(),
+		Key:     "suricata",
+		Sink:    sinks.NewSink(),
+	}
+	require.Nil(t, processor.Start())
+	defer processor.Stop()
+
+	events := waitForEvents(t, sinks, 2)
+	require.Len(t, events, 2)
+	assert.Equal(t, "1", fmt.Sprintf("%v", events[0]["id"]))
+	waitFor(t, func() bool {
+		return listLen(server, "suricata:processing") == 0
+	})
+}
+
+func TestRedisProcessorChannel(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	sinks := &testSinks{}
+	processor := &RedisProcessor{
+		Address: server.Addr(),
+		Mode:    REDIS_MODE_CHANNEL,
+		Key:     "suricata",
+		Sink:    sinks.NewSink(),
+	}
+	require.Nil(t, processor.Start())
+	defer processor.Stop()
+
+	waitFor(t, func() bool {
+		return server.PubSubNumSub("suricata")["suricata"] == 1
+	})
+	server.Publish("suricata", redisEvent(1))
+	server.Publish("suricata", redisEvent(2))
+
+	events := waitForEvents(t, sinks, 2)
+	assert.Len(t, events, 2)
+}
+
+func TestRedisProcessorAuth(t *testing.T) {
+	server := miniredis.RunT(t)
+	server.RequireAuth("secret")
+	server.Select(1)
+	lpush(t, server, "suricata", redisEvent(1))
+
+	sinks := &testSinks{}
+	processor := &RedisProcessor{
+		Address:  server.Addr(),
+		Password: "secret",
+		Database: 1,
+		Key:      "suricata",
+		Sink:     sinks.NewSink(),
+	}
+	require.Nil(t, processor.Start())
+	defer processor.Stop()
+
+	events := waitForEvents(t, sinks, 1)
+	assert.Len(t, events, 1)
+}
+
+func TestRedisProcessorBadMode(t *testing.T) {
+	processor := &RedisProcessor{Key: "suricata", Mode: "stream"}
+	assert.NotNil(t, processor.Start())
+}
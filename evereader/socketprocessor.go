@@ -0,0 +1,217 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package evereader
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/log"
+	"github.com/pkg/errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Socket types matching the Suricata eve filetype options.
+const (
+	SOCKET_STREAM = "stream"
+	SOCKET_DGRAM  = "dgram"
+)
+
+// The largest datagram that can be received, larger events are truncated
+// and fail to decode.
+const MAX_DATAGRAM_SIZE = 1024 * 1024
+
+// SocketProcessor listens on a unix socket for events written by Suricata's
+// unix_stream or unix_dgram eve output, applies any filters then sends the
+// events to an event sink in batches.
+type SocketProcessor struct {
+	Path string
+
+	// SOCKET_STREAM or SOCKET_DGRAM.
+	Type string
+
+	Sink core.EveEventSink
+
+	lineProcessor
+
+	listener   net.Listener
+	packetConn net.PacketConn
+
+	lock  sync.Mutex
+	conns map[net.Conn]bool
+
+	wg sync.WaitGroup
+}
+
+// Start listens on the socket, replacing a stale socket left behind by a
+// previous run.
+func (p *SocketProcessor) Start() error {
+	if info, err := os.Lstat(p.Path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return errors.Errorf("%s exists and is not a socket", p.Path)
+		}
+		if err := os.Remove(p.Path); err != nil {
+			return err
+		}
+	}
+
+	p.init(p.Path, p.Sink)
+	p.conns = map[net.Conn]bool{}
+
+	switch p.Type {
+	case SOCKET_STREAM, "":
+		listener, err := net.Listen("unix", p.Path)
+		if err != nil {
+			return err
+		}
+		p.listener = listener
+		p.wg.Add(1)
+		go p.accept()
+	case SOCKET_DGRAM:
+		conn, err := net.ListenPacket("unixgram", p.Path)
+		if err != nil {
+			return err
+		}
+		p.packetConn = conn
+		p.wg.Add(1)
+		go p.readDatagrams()
+	default:
+		return errors.Errorf("unsupported socket type: %s", p.Type)
+	}
+
+	log.Info("Listening for events on %s socket %s", p.socketType(), p.Path)
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.run()
+	}()
+
+	return nil
+}
+
+// Stop closes the socket and any connections, committing events already
+// received.
+func (p *SocketProcessor) Stop() {
+	close(p.stop)
+	if p.listener != nil {
+		p.listener.Close()
+	}
+	if p.packetConn != nil {
+		p.packetConn.Close()
+	}
+	p.lock.Lock()
+	for conn := range p.conns {
+		conn.Close()
+	}
+	p.lock.Unlock()
+	p.wg.Wait()
+	os.Remove(p.Path)
+}
+
+func (p *SocketProcessor) socketType() string {
+	if p.Type == "" {
+		return SOCKET_STREAM
+	}
+	return p.Type
+}
+
+func (p *SocketProcessor) accept() {
+	defer p.wg.Done()
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			if p.stopping() {
+				return
+			}
+			log.Error("Failed to accept connection on %s: %v", p.Path, err)
+			time.Sleep(1 * time.Second)
+			continue
+		}
+		log.Info("Accepted connection on %s", p.Path)
+		p.lock.Lock()
+		p.conns[conn] = true
+		p.lock.Unlock()
+		p.wg.Add(1)
+		go p.readStream(conn)
+	}
+}
+
+func (p *SocketProcessor) readStream(conn net.Conn) {
+	defer p.wg.Done()
+	defer func() {
+		p.lock.Lock()
+		delete(p.conns, conn)
+		p.lock.Unlock()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			if !p.queue(line) {
+				return
+			}
+		}
+		if err != nil {
+			if !p.stopping() {
+				log.Info("Connection on %s closed: %v", p.Path, err)
+			}
+			return
+		}
+	}
+}
+
+func (p *SocketProcessor) readDatagrams() {
+	defer p.wg.Done()
+	buf := make([]byte, MAX_DATAGRAM_SIZE)
+	for {
+		n, _, err := p.packetConn.ReadFrom(buf)
+		if err != nil {
+			if p.stopping() {
+				return
+			}
+			log.Error("Failed to read from %s: %v", p.Path, err)
+			time.Sleep(1 * time.Second)
+			continue
+		}
+
+		// Normally one event per datagram, but be lenient.
+		for _, line := range bytes.Split(buf[:n], []byte("\n")) {
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			if !p.queue(append([]byte{}, line...)) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+/* Copyright (c) 2019 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package evereader
+
+import (
+	"github.com/jasonish/evebox/eve"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testSocketEvent = `{"timestamp": "2019-01-02T03:04:05.000000-0600", "event_type": "test"}`
+
+func startSocketProcessor(t *testing.T, socketType string) (*SocketProcessor, *testSinks, func()) {
+	dir, err := ioutil.TempDir("", "evebox-socket")
+	require.Nil(t, err)
+
+	sinks := &testSinks{}
+	processor := &SocketProcessor{
+		Path: filepath.Join(dir, "eve.sock"),
+		Type: socketType,
+		Sink: sinks.NewSink(),
+	}
+	processor.AddFilter(&eve.TagsFilter{})
+	processor.AddCustomField("sensor", "test")
+	require.Nil(t, processor.Start())
+
+	return processor, sinks, func() {
+		processor.Stop()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSocketProcessorStream(t *testing.T) {
+	processor, sinks, cleanup := startSocketProcessor(t, SOCKET_STREAM)
+	defer cleanup()
+
+	conn, err := net.Dial("unix", processor.Path)
+	require.Nil(t, err)
+	defer conn.Close()
+
+	// A malformed event is skipped.
+	_, err = conn.Write([]byte(testSocketEvent + "\nnot json\n" + testSocketEvent + "\n"))
+	require.Nil(t, err)
+
+	events := waitForEvents(t, sinks, 2)
+	assert.Len(t, events, 2)
+	assert.Equal(t, "test", events[0]["sensor"])
+	assert.NotNil(t, events[0]["tags"])
+}
+
+func TestSocketProcessorDgram(t *testing.T) {
+	processor, sinks, cleanup := startSocketProcessor(t, SOCKET_DGRAM)
+	defer cleanup()
+
+	conn, err := net.Dial("unixgram", processor.Path)
+	require.Nil(t, err)
+	defer conn.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err = conn.Write([]byte(testSocketEvent))
+		require.Nil(t, err)
+	}
+
+	events := waitForEvents(t, sinks, 3)
+	assert.Len(t, events, 3)
+	assert.Equal(t, "test", events[2]["sensor"])
+}
+
+func TestSocketProcessorStaleSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evebox-socket")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// Leave a socket behind as if a previous run didn't clean up.
+	path := filepath.Join(dir, "eve.sock")
+	listener, err := net.Listen("unix", path)
+	require.Nil(t, err)
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+
+	processor := &SocketProcessor{Path: path, Sink: (&testSinks{}).NewSink()}
+	require.Nil(t, processor.Start())
+	processor.Stop()
+
+	// A regular file is not replaced.
+	require.Nil(t, ioutil.WriteFile(path, []byte{}, 0644))
+	assert.NotNil(t, processor.Start())
+}
@@ -28,6 +28,10 @@
 
 package evereader
 
+// Bookmarks don't record which file they are for, so renamed files are
+// not recognized.
+const sysIdentifiesFile = false
+
 // The argument should actually be os.FileInfo, but that doesn't seem valid
 // on Windows??  Doesn't matter, its not used anyways.
 func GetSys(_ interface{}) interface{} {
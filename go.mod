@@ -1,13 +1,18 @@
 module github.com/jasonish/evebox
 
 require (
+	github.com/alicebob/miniredis/v2 v2.17.0
 	github.com/cespare/reflex v0.2.0 // indirect
+	github.com/coreos/go-oidc/v3 v3.5.0
 	github.com/davecgh/go-spew v1.1.1
 	github.com/fsnotify/fsnotify v1.4.7
+	github.com/go-jose/go-jose/v3 v3.0.3
+	github.com/go-ldap/ldap/v3 v3.4.6
 	github.com/gobuffalo/envy v1.6.8
 	github.com/gobuffalo/packd v0.0.0-20181111195323-b2e760a5f0ff
 	github.com/gobuffalo/packr v1.21.9
-	github.com/golang/protobuf v1.2.0
+	github.com/golang/protobuf v1.5.2
+	github.com/gomodule/redigo v1.8.9
 	github.com/google/gopacket v0.0.0-20181029225859-d533435fee71
 	github.com/gorilla/context v1.1.1
 	github.com/gorilla/handlers v1.4.0
@@ -37,15 +42,15 @@ require (
 	github.com/spf13/jwalterweatherman v1.0.0
 	github.com/spf13/pflag v1.0.3
 	github.com/spf13/viper v1.2.1
-	github.com/stretchr/testify v1.2.2
+	github.com/stretchr/testify v1.8.0
 	github.com/ua-parser/uap-go v0.0.0-20181003033359-705feb871b1a
-	golang.org/x/crypto v0.0.0-20181030022821-bc7917b19d8f
-	golang.org/x/net v0.0.0-20181029044818-c44066c5c816
-	golang.org/x/oauth2 v0.0.0-20181017192945-9dcd33a902f4
-	golang.org/x/sync v0.0.0-20181108010431-42b317875d0f
-	golang.org/x/sys v0.0.0-20181029174526-d69651ed3497
-	golang.org/x/text v0.3.0
-	google.golang.org/appengine v1.2.0
+	golang.org/x/crypto v0.19.0
+	golang.org/x/net v0.10.0
+	golang.org/x/oauth2 v0.3.0
+	golang.org/x/sync v0.1.0
+	golang.org/x/sys v0.17.0
+	golang.org/x/text v0.14.0
+	google.golang.org/appengine v1.6.7
 	gopkg.in/routeros.v2 v2.0.0-20171228113335-2dc19c12445c
 	gopkg.in/yaml.v2 v2.2.1
 )
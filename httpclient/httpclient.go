@@ -29,6 +29,7 @@ package httpclient
 import (
 	"bytes"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"github.com/jasonish/evebox/log"
@@ -45,8 +46,15 @@ type HttpClient struct {
 	redirectBaseUrl  string
 	username         string
 	password         string
+	token            string
 	disableCertCheck bool
-	httpClient       *http.Client
+
+	// CA certificates to verify the server with instead of the system
+	// roots, and an optional client certificate to present.
+	rootCAs     *x509.CertPool
+	certificate *tls.Certificate
+
+	httpClient *http.Client
 }
 
 func NewHttpClient() *HttpClient {
@@ -63,6 +71,55 @@ func (c *HttpClient) SetBaseUrl(baseUrl string) {
 
 func (c *HttpClient) DisableCertCheck(disableCertCheck bool) {
 	c.disableCertCheck = disableCertCheck
+	c.updateTransport()
+}
+
+// SetCA pins the CA certificates in the PEM file, the server certificate
+// must be signed by one of them instead of a system trusted CA.
+func (c *HttpClient) SetCA(filename string) error {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return errors.Wrap(err, "failed to read CA")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(buf) {
+		return errors.Errorf("no certificates found in %s", filename)
+	}
+	c.rootCAs = pool
+	c.updateTransport()
+	return nil
+}
+
+// SetClientCertificate sets a certificate to present to the server. The key
+// may be in the same file as the certificate.
+func (c *HttpClient) SetClientCertificate(certFile string, keyFile string) error {
+	if keyFile == "" {
+		keyFile = certFile
+	}
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return errors.Wrap(err, "failed to load client certificate")
+	}
+	c.certificate = &certificate
+	c.updateTransport()
+	return nil
+}
+
+func (c *HttpClient) tlsConfig() *tls.Config {
+	config := &tls.Config{
+		InsecureSkipVerify: c.disableCertCheck,
+		RootCAs:            c.rootCAs,
+	}
+	if c.certificate != nil {
+		config.Certificates = []tls.Certificate{*c.certificate}
+	}
+	return config
+}
+
+func (c *HttpClient) updateTransport() {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = c.tlsConfig()
+	c.httpClient.Transport = transport
 }
 
 func (c *HttpClient) SetUsernamePassword(username string, password string) {
@@ -70,10 +127,14 @@ func (c *HttpClient) SetUsernamePassword(username string, password string) {
 	c.password = password
 }
 
+// SetBearerToken sets a token to send in an "Authorization: Bearer" header
+// instead of a username and password.
+func (c *HttpClient) SetBearerToken(token string) {
+	c.token = token
+}
+
 func (c *HttpClient) DialTLS(network string, addr string) (net.Conn, error) {
-	return tls.Dial(network, addr, &tls.Config{
-		InsecureSkipVerify: c.disableCertCheck,
-	})
+	return tls.Dial(network, addr, c.tlsConfig())
 }
 
 func (c *HttpClient) CheckRedirect(request *http.Request, via []*http.Request) error {
@@ -89,7 +150,9 @@ func (c *HttpClient) CheckRedirect(request *http.Request, via []*http.Request) e
 }
 
 func (c *HttpClient) Do(request *http.Request) (*http.Response, error) {
-	if c.username != "" || c.password != "" {
+	if c.token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.token)
+	} else if c.username != "" || c.password != "" {
 		request.SetBasicAuth(c.username, c.password)
 	}
 	response, err := c.httpClient.Do(request)
@@ -100,6 +163,16 @@ func (c *HttpClient) Do(request *http.Request) (*http.Response, error) {
 }
 
 func (c *HttpClient) Request(method string, path string, contentType string, body io.Reader) (*http.Response, error) {
+	headers := http.Header{}
+	if contentType != "" {
+		headers.Set("Content-Type", contentType)
+	}
+	return c.RequestWithHeaders(method, path, headers, body)
+}
+
+// RequestWithHeaders is like Request but sets all the provided headers on
+// the request.
+func (c *HttpClient) RequestWithHeaders(method string, path string, headers http.Header, body io.Reader) (*http.Response, error) {
 	baseUrl := c.baseUrl
 	if c.redirectBaseUrl != "" && (method == "POST" || method == "PUT") {
 		baseUrl = c.redirectBaseUrl
@@ -108,8 +181,10 @@ func (c *HttpClient) Request(method string, path string, contentType string, bod
 	if err != nil {
 		return nil, err
 	}
-	if contentType != "" {
-		request.Header.Set("Content-Type", contentType)
+	for key, values := range headers {
+		for _, value := range values {
+			request.Header.Add(key, value)
+		}
 	}
 	return c.Do(request)
 }
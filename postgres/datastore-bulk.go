@@ -0,0 +1,159 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package postgres
+
+import (
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/elasticsearch"
+	"github.com/jasonish/evebox/eve"
+	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/util"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+// BulkAction archives, escalates or labels all events matching the query
+// with a single set based update. Labels are stored in the tags list of the
+// event metadata.
+func (d *PgDatastore) BulkAction(p core.BulkActionParams, user core.User) (int64, error) {
+	filters := []string{}
+	args := []interface{}{}
+
+	if p.EventType != "" {
+		filters = append(filters, fmt.Sprintf(
+			"events_source.source->>'event_type' = $%d", len(args)+1))
+		args = append(args, p.EventType)
+	}
+
+	if p.QueryString != "" {
+		parseQueryString(p.QueryString, &filters, &args)
+	}
+
+	minTs := p.MinTs
+	if p.TimeRange != "" {
+		duration, err := time.ParseDuration(p.TimeRange)
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to parse time range")
+		}
+		minTs = time.Now().Add(duration * -1)
+	}
+
+	if !minTs.IsZero() {
+		filters = append(filters,
+			fmt.Sprintf("events.timestamp >= $%d::timestamptz", len(args)+1),
+			fmt.Sprintf("events_source.timestamp >= $%d::timestamptz", len(args)+1))
+		args = append(args, minTs)
+	}
+
+	if !p.MaxTs.IsZero() {
+		filters = append(filters,
+			fmt.Sprintf("events.timestamp <= $%d::timestamptz", len(args)+1),
+			fmt.Sprintf("events_source.timestamp <= $%d::timestamptz", len(args)+1))
+		args = append(args, p.MaxTs)
+	}
+
+	history := elasticsearch.HistoryEntry{
+		Timestamp: eve.FormatTimestampUTC(time.Now()),
+		Username:  user.Username,
+	}
+
+	// The column updates, and the metadata the history entry is added to.
+	var set []string
+	metadata := "metadata"
+
+	switch p.Action {
+	case core.BULK_ACTION_ARCHIVE:
+		filters = append(filters, "events.archived = false")
+		set = append(set, "archived = true")
+		history.Action = elasticsearch.ACTION_ARCHIVED
+	case core.BULK_ACTION_ESCALATE:
+		filters = append(filters, "events.escalated = false")
+		set = append(set, "escalated = true")
+		history.Action = elasticsearch.ACTION_ESCALATED
+	case core.BULK_ACTION_LABEL:
+		if len(p.Labels) == 0 {
+			return 0, errors.New("no labels provided")
+		}
+		filters = append(filters, fmt.Sprintf(
+			"NOT coalesce(events.metadata->'tags', '[]'::jsonb) @> $%d::jsonb",
+			len(args)+1))
+		metadata = fmt.Sprintf(`jsonb_set(
+      metadata,
+      '{"tags"}',
+      (select jsonb_agg(distinct tag) from jsonb_array_elements(
+        coalesce(metadata->'tags', '[]'::jsonb) || $%d::jsonb) as tag)
+    )`, len(args)+1)
+		args = append(args, util.ToJson(p.Labels))
+		history.Action = elasticsearch.ACTION_LABELED
+		history.Labels = p.Labels
+	default:
+		return 0, errors.Errorf("unsupported bulk action: %s", p.Action)
+	}
+
+	where := "events.uuid = events_source.uuid"
+	if len(filters) > 0 {
+		where = fmt.Sprintf("%s AND %s", where, strings.Join(filters, " AND "))
+	}
+
+	if p.DryRun {
+		var count int64
+		sqlTemplate := fmt.Sprintf(
+			"select count(*) from events, events_source where %s", where)
+		if err := d.pg.QueryRow(sqlTemplate, args...).Scan(&count); err != nil {
+			return 0, errors.Wrap(err, "query failed")
+		}
+		return count, nil
+	}
+
+	set = append(set, fmt.Sprintf(`metadata = jsonb_set(
+    %s,
+    '{"history"}',
+    case when metadata->'history' is null then '[]'::jsonb
+      else metadata->'history' end || $%d::jsonb
+    )`, metadata, len(args)+1))
+
+	sqlTemplate := fmt.Sprintf(`update events
+set
+  %s
+where
+  uuid in (
+    select events.uuid from events, events_source
+    where %s
+  )
+`, strings.Join(set, ",\n  "), where)
+	args = append(args, util.ToJson(history))
+
+	qstart := time.Now()
+	result, err := d.pg.Exec(sqlTemplate, args...)
+	log.Info("Update time: %v", time.Now().Sub(qstart))
+	if err != nil {
+		return 0, errors.Wrap(err, "query failed")
+	}
+	return result.RowsAffected()
+}
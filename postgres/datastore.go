@@ -60,7 +60,8 @@ func (d *PgDatastore) GetEveEventSink() core.EveEventSink {
 func (d *PgDatastore) GetEventById(eventId string) (map[string]interface{}, error) {
 	sqlTemplate := `
 SELECT
-  e.uuid, e.archived, e.escalated, e.metadata->>'history', s.source
+  e.uuid, e.archived, e.escalated, e.metadata->>'history',
+  e.metadata->>'tags', s.source
 FROM
   events as e, events_source as s
 WHERE
@@ -76,8 +77,10 @@ WHERE
 		var archived bool
 		var escalated bool
 		var rawHistory sql.NullString
+		var rawTags sql.NullString
 		var rawSource string
-		err = rows.Scan(&eventId, &archived, &escalated, &rawHistory, &rawSource)
+		err = rows.Scan(&eventId, &archived, &escalated, &rawHistory,
+			&rawTags, &rawSource)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to scan result")
 		}
@@ -95,6 +98,8 @@ WHERE
 			source.AddTag("escalated")
 		}
 
+		addMetadataTags(source, rawTags)
+
 		if rawHistory.Valid {
 			var history []interface{}
 			if err := json.Unmarshal([]byte(rawHistory.String), &history); err != nil {
@@ -538,7 +543,8 @@ func (s *PgDatastore) EventQuery(options core.EventQueryOptions) (interface{}, e
 select
   events_source.uuid,
   events_source.source,
-  events.archived
+  events.archived,
+  events.metadata->>'tags'
 from events_source, events
 where
   events_source.source->>'event_type' != 'stats'
@@ -589,7 +595,8 @@ limit 500
 		var eventId string
 		var rawSource string
 		var archived bool
-		if err := rows.Scan(&eventId, &rawSource, &archived); err != nil {
+		var rawTags sql.NullString
+		if err := rows.Scan(&eventId, &rawSource, &archived, &rawTags); err != nil {
 			log.Error("Failed to scan raw: %v", err)
 			continue
 		}
@@ -604,6 +611,8 @@ limit 500
 			source.AddTag("evebox.archived")
 		}
 
+		addMetadataTags(source, rawTags)
+
 		events = append(events, map[string]interface{}{
 			"_id":     eventId,
 			"_source": source,
@@ -615,6 +624,22 @@ limit 500
 	}, nil
 }
 
+// addMetadataTags adds the tags stored in the event metadata, such as labels
+// added by a bulk action, to the event.
+func addMetadataTags(source eve.EveEvent, rawTags sql.NullString) {
+	if !rawTags.Valid {
+		return
+	}
+	var tags []string
+	if err := json.Unmarshal([]byte(rawTags.String), &tags); err != nil {
+		log.Error("Failed to decode metadata tags: %v", err)
+		return
+	}
+	for _, tag := range tags {
+		source.AddTag(tag)
+	}
+}
+
 func dumpQuery(query string, args []interface{}) {
 	for i, arg := range args {
 		placeholder := fmt.Sprintf("$%d", i+1)
@@ -634,7 +659,7 @@ func dumpQuery(query string, args []interface{}) {
 				fmt.Sprintf("'%s'", arg), -1)
 		default:
 			query = strings.Replace(query,
-				fmt.Sprintf("$%d", i+1), fmt.Sprintf("$q", arg), -1)
+				fmt.Sprintf("$%d", i+1), fmt.Sprintf("%q", arg), -1)
 		}
 	}
 	log.Println(strings.Replace(query, "\n", " ", -1))
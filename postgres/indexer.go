@@ -96,7 +96,8 @@ func (i *PgEventIndexer) Submit(event eve.EveEvent) error {
 	var archived bool
 
 	if event.EventType() == "alert" {
-		archived = false
+		// May already be archived by a suppression.
+		archived = event.HasTag("archived")
 	} else {
 		archived = true
 	}
@@ -0,0 +1,26 @@
+CREATE TABLE audit_log (
+  id              INTEGER PRIMARY KEY,
+
+  -- Seconds since the epoch.
+  timestamp       INTEGER NOT NULL,
+
+  username        string,
+  remote_addr     string,
+  action          string NOT NULL,
+  target          string,
+  details         string
+);
+
+CREATE TABLE login_failures (
+  -- "username" or "address".
+  kind            string NOT NULL,
+  value           string NOT NULL,
+
+  count           INTEGER NOT NULL,
+
+  -- Timestamps are in seconds since the epoch.
+  last_failure    INTEGER NOT NULL,
+  locked_until    INTEGER,
+
+  PRIMARY KEY (kind, value)
+);
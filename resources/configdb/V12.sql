@@ -0,0 +1,2 @@
+-- Set when an admin resets a password, until the user changes it.
+ALTER TABLE users ADD COLUMN password_change_required INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,35 @@
+-- Agents register themselves with a stable sensor ID and report their
+-- status in heartbeats. Agents authenticated with a client certificate,
+-- or not at all, are registered too, so the table is recreated to make
+-- the token optional.
+CREATE TABLE sensors_new (
+  id              INTEGER PRIMARY KEY,
+  name            string UNIQUE NOT NULL,
+
+  -- SHA-256 hash of the sensor token, hex encoded. Null for sensors
+  -- registered by a heartbeat.
+  token_hash      string UNIQUE,
+
+  -- Timestamps are in seconds since the epoch.
+  created         INTEGER NOT NULL,
+  last_seen       INTEGER,
+  last_address    string,
+
+  -- Stable ID generated by the agent, set by its first heartbeat.
+  sensor_id       string UNIQUE,
+  hostname        string,
+  version         string,
+
+  -- Time of the last heartbeat, the interval in seconds the agent sends
+  -- them at, and the status reported as JSON.
+  last_heartbeat  INTEGER,
+  heartbeat_interval INTEGER,
+  status          string
+);
+
+INSERT INTO sensors_new (id, name, token_hash, created, last_seen, last_address)
+  SELECT id, name, token_hash, created, last_seen, last_address FROM sensors;
+
+DROP TABLE sensors;
+
+ALTER TABLE sensors_new RENAME TO sensors;
@@ -0,0 +1,8 @@
+-- The external provider a user logs in with, such as "oidc" or "ldap",
+-- and their identity at it. Logins from a provider are matched on the
+-- identity, not the username.
+ALTER TABLE users ADD COLUMN provider string;
+ALTER TABLE users ADD COLUMN external_id string;
+
+CREATE UNIQUE INDEX users_external_id_index
+  ON users (provider, external_id);
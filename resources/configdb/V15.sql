@@ -0,0 +1,7 @@
+-- How each sensor authenticated when it was registered: "token",
+-- "certificate" or "none". Unauthenticated heartbeats can only update
+-- sensors registered with "none". Sensors registered by a heartbeat
+-- before this are left null, and are treated as authenticated.
+ALTER TABLE sensors ADD COLUMN authentication string;
+
+UPDATE sensors SET authentication = 'token' WHERE token_hash IS NOT NULL;
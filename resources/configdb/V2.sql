@@ -0,0 +1,21 @@
+CREATE TABLE suppressions (
+  id              INTEGER PRIMARY KEY,
+
+  -- Match criteria. Null matches anything.
+  signature_id    INTEGER,
+  src_cidr        string,
+  dest_cidr       string,
+  sensor          string,
+
+  -- One of "archive" or "drop".
+  action          string NOT NULL,
+  reason          string,
+  username        string,
+
+  -- Timestamps are in seconds since the epoch.
+  created         INTEGER NOT NULL,
+  expires         INTEGER,
+
+  hits            INTEGER NOT NULL DEFAULT 0,
+  last_hit        INTEGER
+);
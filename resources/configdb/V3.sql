@@ -0,0 +1,17 @@
+CREATE TABLE saved_searches (
+  id              INTEGER PRIMARY KEY,
+  name            string NOT NULL,
+  query_string    string,
+  event_type      string,
+  time_range      string,
+
+  -- Username of the owner.
+  owner           string NOT NULL,
+  shared          INTEGER NOT NULL DEFAULT 0,
+
+  -- Timestamps are in seconds since the epoch.
+  created         INTEGER NOT NULL,
+  updated         INTEGER NOT NULL,
+
+  UNIQUE (owner, name)
+);
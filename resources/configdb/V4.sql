@@ -0,0 +1,10 @@
+CREATE TABLE preferences (
+  -- The user ID. Anonymous users are keyed by username.
+  user_id         string PRIMARY KEY,
+
+  -- The preferences as a JSON object.
+  preferences     string NOT NULL,
+
+  -- Seconds since the epoch.
+  updated         INTEGER NOT NULL
+);
@@ -0,0 +1,3 @@
+-- Users that existed before roles were added could do everything, so
+-- they become admins.
+ALTER TABLE users ADD COLUMN role string NOT NULL DEFAULT 'admin';
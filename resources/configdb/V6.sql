@@ -0,0 +1,18 @@
+CREATE TABLE api_tokens (
+  id              INTEGER PRIMARY KEY,
+
+  -- The uuid of the user the token belongs to.
+  user_id         string NOT NULL,
+  name            string NOT NULL,
+
+  -- SHA-256 hash of the token, hex encoded.
+  token_hash      string UNIQUE NOT NULL,
+
+  -- Timestamps are in seconds since the epoch.
+  created         INTEGER NOT NULL,
+  expires         INTEGER,
+  last_used       INTEGER
+);
+
+CREATE INDEX api_tokens_user_id_index
+  ON api_tokens (user_id);
@@ -0,0 +1,12 @@
+CREATE TABLE sensors (
+  id              INTEGER PRIMARY KEY,
+  name            string UNIQUE NOT NULL,
+
+  -- SHA-256 hash of the sensor token, hex encoded.
+  token_hash      string UNIQUE NOT NULL,
+
+  -- Timestamps are in seconds since the epoch.
+  created         INTEGER NOT NULL,
+  last_seen       INTEGER,
+  last_address    string
+);
@@ -0,0 +1,15 @@
+CREATE TABLE sessions (
+  -- SHA-256 hash of the session ID, hex encoded.
+  id_hash         string PRIMARY KEY,
+
+  -- The uuid of the user the session belongs to.
+  user_id         string NOT NULL,
+  remote_addr     string,
+
+  -- Timestamps are in seconds since the epoch.
+  created         INTEGER NOT NULL,
+  expires         INTEGER NOT NULL
+);
+
+CREATE INDEX sessions_user_id_index
+  ON sessions (user_id);
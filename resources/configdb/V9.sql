@@ -0,0 +1,27 @@
+CREATE TABLE user_totp (
+  -- The uuid of the user.
+  user_id         string PRIMARY KEY,
+
+  -- Base32 encoded secret of the enabled enrollment, if any, and of a
+  -- pending enrollment that has not been confirmed yet.
+  secret          string,
+  pending_secret  string,
+
+  -- The last time step counter a code was accepted for, so a code can't
+  -- be used twice.
+  last_counter    INTEGER,
+
+  -- Timestamps are in seconds since the epoch.
+  enabled         INTEGER
+);
+
+CREATE TABLE user_recovery_codes (
+  id              INTEGER PRIMARY KEY,
+  user_id         string NOT NULL,
+
+  -- SHA-256 hash of the code, hex encoded.
+  code_hash       string NOT NULL
+);
+
+CREATE INDEX user_recovery_codes_user_id_index
+  ON user_recovery_codes (user_id);
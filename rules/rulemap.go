@@ -62,7 +62,9 @@ func NewRuleMap(paths []string) *RuleMap {
 
 	rulemap.reload()
 
-	go rulemap.watchFiles()
+	if watcher != nil {
+		go rulemap.watchFiles()
+	}
 
 	return rulemap
 }
@@ -98,6 +100,9 @@ func (r *RuleMap) reload() {
 		if err := loadRulesFromFile(&rules, filename); err != nil {
 			log.Warning("Failed to load rules from %s: %v", filename, err)
 		}
+		if r.watcher == nil {
+			continue
+		}
 		if err := r.watcher.Add(filename); err != nil {
 			log.Warning("Failed to add watch for %s: %v", filename, err)
 		}
@@ -109,9 +114,12 @@ func (r *RuleMap) reload() {
 }
 
 func (r *RuleMap) FindById(id uint64) *idsrules.Rule {
+	if r == nil {
+		return nil
+	}
 	r.lock.RLock()
 	defer r.lock.RUnlock()
-	if r == nil || r.rules == nil {
+	if r.rules == nil {
 		return nil
 	}
 	if rule, ok := r.rules[id]; ok {
@@ -54,6 +54,8 @@ import (
 //     max_ts: specify the latest timestamp for the range of the query.
 //         format: YYYY-MM-DDTHH:MM:SS.UUUUUUZ
 //                 YYYY-MM-DDTHH:MM:SS.UUUUUU-0600
+//
+//     saved_search: the ID or name of a saved search to apply to the query.
 func (c *ApiContext) AlertsHandler(w *ResponseWriter, r *http.Request) error {
 
 	options := core.AlertQueryOptions{}
@@ -86,6 +88,10 @@ func (c *ApiContext) AlertsHandler(w *ResponseWriter, r *http.Request) error {
 		options.QueryString = r.FormValue("queryString")
 	}
 
+	if err := c.applySavedSearch(r, &options.CommonQueryOptions); err != nil {
+		return err
+	}
+
 	alerts, err := c.appContext.DataStore.AlertQuery(options)
 	if err != nil {
 		return err
@@ -0,0 +1,134 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package api
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/server/sessions"
+	"github.com/pkg/errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type ApiTokenRequest struct {
+	Name    string     `json:"name"`
+	Expires *time.Time `json:"expires"`
+}
+
+type ApiTokenResponse struct {
+	core.ApiToken
+
+	// The token, only returned when it is created.
+	Token string `json:"token"`
+}
+
+func (c *ApiContext) apiTokenStore(user core.User) (core.ApiTokenStore, error) {
+	if c.appContext.ApiTokenStore == nil {
+		return nil, newHttpErrorResponse(http.StatusNotImplemented,
+			errors.New("api tokens not supported"))
+	}
+	if user.Anonymous {
+		return nil, newHttpErrorResponse(http.StatusBadRequest,
+			errors.New("api tokens require authentication to be enabled"))
+	}
+	return c.appContext.ApiTokenStore, nil
+}
+
+// ApiTokenListHandler lists the API tokens of the logged in user.
+func (c *ApiContext) ApiTokenListHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+	store, err := c.apiTokenStore(session.User)
+	if err != nil {
+		return err
+	}
+	tokens, err := store.FindApiTokens(session.User)
+	if err != nil {
+		return err
+	}
+	return w.OkJSON(map[string]interface{}{
+		"api_tokens": tokens,
+	})
+}
+
+// ApiTokenCreateHandler creates an API token for the logged in user. This
+// is the only time the token is returned.
+func (c *ApiContext) ApiTokenCreateHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+	store, err := c.apiTokenStore(session.User)
+	if err != nil {
+		return err
+	}
+
+	var request ApiTokenRequest
+	if err := DecodeRequestBody(r, &request); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+	if request.Name == "" {
+		return newHttpErrorResponse(http.StatusBadRequest,
+			errors.New("name is required"))
+	}
+
+	token, apiToken, err := store.AddApiToken(session.User, request.Name,
+		request.Expires)
+	if err != nil {
+		log.Error("Failed to create API token: %v", err)
+		return err
+	}
+	log.Info("User %s created API token %d (%s)", session.User.Username,
+		apiToken.Id, apiToken.Name)
+
+	return w.OkJSON(ApiTokenResponse{
+		ApiToken: apiToken,
+		Token:    token,
+	})
+}
+
+// ApiTokenDeleteHandler revokes one of the logged in user's API tokens.
+func (c *ApiContext) ApiTokenDeleteHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+	store, err := c.apiTokenStore(session.User)
+	if err != nil {
+		return err
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest,
+			errors.Wrap(err, "bad api token id"))
+	}
+	if err := store.DeleteApiToken(session.User, id); err != nil {
+		if err == core.ErrNoApiToken {
+			return httpNotFoundResponse(fmt.Sprintf("No API token with ID %d", id))
+		}
+		return err
+	}
+	log.Info("User %s revoked API token %d", session.User.Username, id)
+	return w.Ok()
+}
@@ -0,0 +1,155 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package api
+
+import (
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/eve"
+	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/server/sessions"
+	"github.com/pkg/errors"
+	"net/http"
+)
+
+type BulkActionRequest struct {
+	// One of archive, escalate or label.
+	Action string `json:"action"`
+
+	QueryString string `json:"query_string"`
+
+	// The event type to apply the action to, defaults to alert.
+	EventType string `json:"event_type"`
+
+	TimeRange string `json:"time_range"`
+	MinTs     string `json:"min_ts"`
+	MaxTs     string `json:"max_ts"`
+
+	// Labels to add for the label action.
+	Labels []string `json:"labels"`
+
+	// Only return the number of events that would be updated.
+	DryRun bool `json:"dry_run"`
+}
+
+type BulkActionResponse struct {
+	Count  int64 `json:"count"`
+	DryRun bool  `json:"dry_run"`
+}
+
+func (r *BulkActionRequest) ToCoreBulkActionParams() (core.BulkActionParams, error) {
+	params := core.BulkActionParams{
+		Action: r.Action,
+		Labels: r.Labels,
+		DryRun: r.DryRun,
+	}
+
+	params.QueryString = r.QueryString
+	params.TimeRange = r.TimeRange
+
+	params.EventType = r.EventType
+	if params.EventType == "" {
+		params.EventType = "alert"
+	}
+
+	if r.TimeRange != "" && (r.MinTs != "" || r.MaxTs != "") {
+		return params, errors.New("time_range not allowed with min_ts or max_ts")
+	}
+
+	if r.MinTs != "" {
+		minTs, err := eve.ParseTimestamp(r.MinTs)
+		if err != nil {
+			return params, errors.Wrap(err, "bad min_ts format")
+		}
+		params.MinTs = minTs
+	}
+
+	if r.MaxTs != "" {
+		maxTs, err := eve.ParseTimestamp(r.MaxTs)
+		if err != nil {
+			return params, errors.Wrap(err, "bad max_ts format")
+		}
+		params.MaxTs = maxTs
+	}
+
+	return params, nil
+}
+
+// BulkActionHandler handles POST requests to /api/1/bulk, applying an
+// archive, escalate or label action to all events matching a query.
+//
+// A query string is required so a bad request can't accidentally archive
+// every event. With dry_run set the number of events that would be updated
+// is returned without updating them.
+func (c *ApiContext) BulkActionHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+
+	var request BulkActionRequest
+	if err := DecodeRequestBody(r, &request); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+
+	switch request.Action {
+	case core.BULK_ACTION_ARCHIVE, core.BULK_ACTION_ESCALATE:
+	case core.BULK_ACTION_LABEL:
+		if len(request.Labels) == 0 {
+			return newHttpErrorResponse(http.StatusBadRequest,
+				errors.New("labels required for label action"))
+		}
+	default:
+		return newHttpErrorResponse(http.StatusBadRequest,
+			errors.Errorf("unsupported action: %s", request.Action))
+	}
+
+	if request.QueryString == "" {
+		return newHttpErrorResponse(http.StatusBadRequest,
+			errors.New("query_string required"))
+	}
+
+	params, err := request.ToCoreBulkActionParams()
+	if err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+
+	count, err := c.appContext.DataStore.BulkAction(params, session.User)
+	if err != nil {
+		log.Error("Bulk %s failed: %v", request.Action, err)
+		return err
+	}
+
+	if request.DryRun {
+		log.Info("Bulk %s by user %s would update %d events: %s",
+			request.Action, session.Username(), count, request.QueryString)
+	} else {
+		log.Info("Bulk %s by user %s updated %d events: %s",
+			request.Action, session.Username(), count, request.QueryString)
+	}
+
+	return w.OkJSON(BulkActionResponse{
+		Count:  count,
+		DryRun: request.DryRun,
+	})
+}
@@ -27,6 +27,7 @@
 package api
 
 import (
+	"github.com/jasonish/evebox/server/sessions"
 	"github.com/spf13/viper"
 	"net/http"
 )
@@ -68,8 +69,12 @@ func (c *ApiContext) ConfigHandler(w *ResponseWriter, r *http.Request) error {
 	}
 
 	response.Defaults = make(map[string]interface{})
-	if c.appContext.DefaultTimeRange != "" {
-		response.Defaults["time_range"] = c.appContext.DefaultTimeRange
+	defaultTimeRange := c.appContext.DefaultTimeRange
+	if session, ok := r.Context().Value("session").(*sessions.Session); ok {
+		defaultTimeRange = c.appContext.GetDefaultTimeRange(session.User)
+	}
+	if defaultTimeRange != "" {
+		response.Defaults["time_range"] = defaultTimeRange
 		response.Defaults["force_time_range"] = c.appContext.ForceDefaultTimeRange
 	}
 
@@ -123,6 +123,14 @@ func (r *apiRouter) POST(path string, handler apiHandlerFunc) {
 	r.router.POST(path, apiFuncWrapper(handler))
 }
 
+func (r *apiRouter) PUT(path string, handler apiHandlerFunc) {
+	r.router.PUT(path, apiFuncWrapper(handler))
+}
+
+func (r *apiRouter) DELETE(path string, handler apiHandlerFunc) {
+	r.router.DELETE(path, apiFuncWrapper(handler))
+}
+
 func (r *apiRouter) OPTIONS(path string, handler apiHandlerFunc) {
 	r.router.OPTIONS(path, apiFuncWrapper(handler))
 }
@@ -131,6 +139,9 @@ type ApiContext struct {
 	appContext    *appcontext.AppContext
 	sessionStore  *sessions.SessionStore
 	authenticator auth.Authenticator
+
+	// If set, failed password checks are counted as failed logins.
+	lockout *auth.Lockout
 }
 
 func NewApiContext(appContext *appcontext.AppContext,
@@ -142,36 +153,140 @@ func NewApiContext(appContext *appcontext.AppContext,
 	}
 }
 
+func (c *ApiContext) SetLockout(lockout *auth.Lockout) {
+	c.lockout = lockout
+}
+
+// requireLogin wraps a handler so it is only called if there is a logged
+// in user, even one that must change their password.
+func requireLogin(handler apiHandlerFunc) apiHandlerFunc {
+	return func(w *ResponseWriter, r *http.Request) error {
+		session, ok := r.Context().Value("session").(*sessions.Session)
+		if !ok || session == nil {
+			return newHttpErrorResponse(http.StatusUnauthorized,
+				errors.New("authentication required"))
+		}
+		return handler(w, r)
+	}
+}
+
+// requireRole wraps a handler so it is only called if the logged in user
+// has the role. Otherwise a 403 is returned.
+func requireRole(role string, handler apiHandlerFunc) apiHandlerFunc {
+	return func(w *ResponseWriter, r *http.Request) error {
+		session, ok := r.Context().Value("session").(*sessions.Session)
+		if !ok || session == nil {
+			return newHttpErrorResponse(http.StatusUnauthorized,
+				errors.New("authentication required"))
+		}
+		if session.User.PasswordChangeRequired {
+			return ApiError{
+				Status:  http.StatusForbidden,
+				Message: core.ErrPasswordChangeRequired.Error(),
+			}
+		}
+		if !session.HasRole(role) {
+			return newHttpErrorResponse(http.StatusForbidden,
+				errors.Errorf("permission denied: %s role required", role))
+		}
+		return handler(w, r)
+	}
+}
+
 func (c *ApiContext) InitRoutes(router *router.Router) {
 	r := apiRouter{router}
 
+	viewer := func(handler apiHandlerFunc) apiHandlerFunc {
+		return requireRole(core.ROLE_VIEWER, handler)
+	}
+	analyst := func(handler apiHandlerFunc) apiHandlerFunc {
+		return requireRole(core.ROLE_ANALYST, handler)
+	}
+	admin := func(handler apiHandlerFunc) apiHandlerFunc {
+		return requireRole(core.ROLE_ADMIN, handler)
+	}
+
+	// Public.
 	r.POST("/login", c.LoginHandler)
 	r.OPTIONS("/login", c.LoginOptions)
 	r.GET("/logout", c.LogoutHandler)
-
-	r.GET("/alerts", c.AlertsHandler)
-	r.POST("/alert-group/archive", c.AlertGroupArchiveHandler)
-	r.POST("/alert-group/star", c.EscalateAlertGroupHandler)
-	r.POST("/alert-group/unstar", c.DeEscalateAlertGroupHandler)
-	r.POST("/alert-group/comment", c.CommentOnAlertGroupHandler)
-
 	r.GET("/version", c.VersionHandler)
 	r.POST("/submit", c.SubmitHandler)
-	r.POST("/eve2pcap", c.Eve2PcapHandler)
-	r.POST("/query", c.QueryHandler)
-	r.GET("/config", c.ConfigHandler)
-	r.POST("/event/{id}/archive", c.ArchiveEventHandler)
-	r.POST("/event/{id}/escalate", c.EscalateEventHandler)
-	r.POST("/event/{id}/de-escalate", c.DeEscalateEventHandler)
-	r.POST("/event/{id}/comment", c.CommentOnEventHandler)
-	r.GET("/event/{id}", c.GetEventByIdHandler)
-	r.GET("/event-query", c.EventQueryHandler)
-	r.GET("/report/dns/requests/rrnames", c.ReportDnsRequestRrnames)
-	r.POST("/report/dns/requests/rrnames", c.ReportDnsRequestRrnames)
-	r.GET("/netflow", c.NetflowHandler)
-	r.GET("/report/agg", c.ReportAggs)
-	r.GET("/report/histogram", c.ReportHistogram)
-	r.POST("/find-flow", c.FindFlowHandler)
-
-	r.GET("/flow/histogram", c.FlowHistogram)
+	r.POST("/sensors/heartbeat", c.SensorHeartbeatHandler)
+
+	r.GET("/alerts", viewer(c.AlertsHandler))
+	r.POST("/alert-group/archive", analyst(c.AlertGroupArchiveHandler))
+	r.POST("/alert-group/star", analyst(c.EscalateAlertGroupHandler))
+	r.POST("/alert-group/unstar", analyst(c.DeEscalateAlertGroupHandler))
+	r.POST("/alert-group/comment", analyst(c.CommentOnAlertGroupHandler))
+	r.POST("/bulk", analyst(c.BulkActionHandler))
+
+	r.GET("/suppressions", viewer(c.SuppressionListHandler))
+	r.POST("/suppressions", admin(c.SuppressionCreateHandler))
+	r.GET("/suppressions/export", viewer(c.SuppressionExportHandler))
+	r.GET("/suppressions/{id}", viewer(c.SuppressionGetHandler))
+	r.PUT("/suppressions/{id}", admin(c.SuppressionUpdateHandler))
+	r.DELETE("/suppressions/{id}", admin(c.SuppressionDeleteHandler))
+
+	// Preferences and saved searches belong to the user, so any role can
+	// manage their own.
+	r.GET("/preferences", viewer(c.GetPreferencesHandler))
+	r.PUT("/preferences", viewer(c.PutPreferencesHandler))
+
+	r.GET("/sensors", admin(c.SensorListHandler))
+	r.POST("/sensors", admin(c.SensorCreateHandler))
+	r.DELETE("/sensors/{id}", admin(c.SensorDeleteHandler))
+
+	r.GET("/sessions", viewer(c.SessionListHandler))
+	r.DELETE("/sessions/{id}", viewer(c.SessionRevokeHandler))
+
+	r.GET("/totp", viewer(c.TotpStatusHandler))
+	r.POST("/totp/enroll", viewer(c.TotpEnrollHandler))
+	r.POST("/totp/confirm", viewer(c.TotpConfirmHandler))
+	r.POST("/totp/recovery-codes", viewer(c.TotpRecoveryCodesHandler))
+	r.POST("/totp/disable", viewer(c.TotpDisableHandler))
+
+	// Allowed for users that must change their password.
+	r.POST("/password", requireLogin(c.PasswordChangeHandler))
+
+	r.GET("/users", admin(c.UserListHandler))
+	r.POST("/users", admin(c.UserCreateHandler))
+	r.GET("/users/{username}", admin(c.UserGetHandler))
+	r.PUT("/users/{username}", admin(c.UserUpdateHandler))
+	r.DELETE("/users/{username}", admin(c.UserDeleteHandler))
+	r.POST("/users/{username}/disable", admin(c.UserDisableHandler))
+	r.POST("/users/{username}/enable", admin(c.UserEnableHandler))
+	r.POST("/users/{username}/password", admin(c.UserPasswordHandler))
+
+	r.GET("/lockouts", admin(c.LockoutListHandler))
+	r.POST("/lockouts/unlock", admin(c.LockoutUnlockHandler))
+	r.GET("/audit", admin(c.AuditListHandler))
+
+	r.GET("/api-tokens", viewer(c.ApiTokenListHandler))
+	r.POST("/api-tokens", viewer(c.ApiTokenCreateHandler))
+	r.DELETE("/api-tokens/{id}", viewer(c.ApiTokenDeleteHandler))
+
+	r.GET("/saved-searches", viewer(c.SavedSearchListHandler))
+	r.POST("/saved-searches", viewer(c.SavedSearchCreateHandler))
+	r.GET("/saved-searches/{id}", viewer(c.SavedSearchGetHandler))
+	r.PUT("/saved-searches/{id}", viewer(c.SavedSearchUpdateHandler))
+	r.DELETE("/saved-searches/{id}", viewer(c.SavedSearchDeleteHandler))
+
+	r.POST("/eve2pcap", viewer(c.Eve2PcapHandler))
+	r.POST("/query", viewer(c.QueryHandler))
+	r.GET("/config", viewer(c.ConfigHandler))
+	r.POST("/event/{id}/archive", analyst(c.ArchiveEventHandler))
+	r.POST("/event/{id}/escalate", analyst(c.EscalateEventHandler))
+	r.POST("/event/{id}/de-escalate", analyst(c.DeEscalateEventHandler))
+	r.POST("/event/{id}/comment", analyst(c.CommentOnEventHandler))
+	r.GET("/event/{id}", viewer(c.GetEventByIdHandler))
+	r.GET("/event-query", viewer(c.EventQueryHandler))
+	r.GET("/report/dns/requests/rrnames", viewer(c.ReportDnsRequestRrnames))
+	r.POST("/report/dns/requests/rrnames", viewer(c.ReportDnsRequestRrnames))
+	r.GET("/netflow", viewer(c.NetflowHandler))
+	r.GET("/report/agg", viewer(c.ReportAggs))
+	r.GET("/report/histogram", viewer(c.ReportHistogram))
+	r.POST("/find-flow", viewer(c.FindFlowHandler))
+
+	r.GET("/flow/histogram", viewer(c.FlowHistogram))
 }
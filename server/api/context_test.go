@@ -0,0 +1,131 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package api
+
+import (
+	"context"
+	"github.com/jasonish/evebox/appcontext"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/server/router"
+	"github.com/jasonish/evebox/server/sessions"
+	"github.com/jasonish/evebox/sqlite/configdb"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testSessionHeader = "x-evebox-session-id"
+
+// apiTest serves the API routes backed by an in-memory configuration
+// database.
+type apiTest struct {
+	appContext   *appcontext.AppContext
+	apiContext   *ApiContext
+	sessionStore *sessions.SessionStore
+	handler      http.Handler
+}
+
+func newApiTest(t *testing.T) *apiTest {
+	db, err := configdb.NewConfigDB(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	appContext := &appcontext.AppContext{
+		ConfigDB:         db,
+		Userstore:        configdb.NewUserStore(db.DB),
+		SavedSearchStore: configdb.NewSavedSearchStore(db.DB),
+		TotpStore:        configdb.NewTotpStore(db.DB),
+		PreferencesStore: configdb.NewPreferencesStore(db.DB),
+	}
+
+	sessionStore := sessions.NewSessionStore()
+	sessionStore.Header = testSessionHeader
+
+	r := router.NewRouter()
+	apiContext := NewApiContext(appContext, sessionStore, nil)
+	apiContext.InitRoutes(r.Subrouter("/api/1"))
+
+	// Like the server session handler, but requests without a session
+	// are left for the handlers to reject.
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if session := sessionStore.FindSession(req); session != nil {
+			req = req.WithContext(context.WithValue(req.Context(),
+				"session", session))
+		}
+		r.Router.ServeHTTP(w, req)
+	})
+
+	return &apiTest{
+		appContext:   appContext,
+		apiContext:   apiContext,
+		sessionStore: sessionStore,
+		handler:      handler,
+	}
+}
+
+// addUser adds a user to the user store and returns it as loaded back.
+func (a *apiTest) addUser(t *testing.T, username string, role string) core.User {
+	_, err := a.appContext.Userstore.AddUser(core.User{
+		Username: username,
+		Role:     role,
+	}, username+"-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := a.appContext.Userstore.FindByUsername(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// login creates a session for the user and returns its ID.
+func (a *apiTest) login(user core.User) string {
+	session := a.sessionStore.NewSession()
+	session.User = user
+	a.sessionStore.Put(session)
+	return session.Id
+}
+
+func (a *apiTest) request(method string, path string, sessionId string, body string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	r := httptest.NewRequest(method, path, reader)
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if sessionId != "" {
+		r.Header.Set(testSessionHeader, sessionId)
+	}
+	w := httptest.NewRecorder()
+	a.handler.ServeHTTP(w, r)
+	return w
+}
@@ -120,6 +120,10 @@ func (c *ApiContext) EventQueryHandler(w *ResponseWriter, r *http.Request) error
 	options.EventType = r.FormValue("event_type")
 	options.Size, _ = strconv.ParseInt(r.FormValue("size"), 0, 64)
 
+	if err := c.applySavedSearch(r, &options.CommonQueryOptions); err != nil {
+		return err
+	}
+
 	response, err := c.appContext.DataStore.EventQuery(options)
 	if err != nil {
 		return err
@@ -0,0 +1,129 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package api
+
+import (
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/server/auth"
+	"github.com/jasonish/evebox/server/sessions"
+	"github.com/pkg/errors"
+	"net/http"
+	"strconv"
+)
+
+type UnlockRequest struct {
+	Username string `json:"username"`
+	Address  string `json:"address"`
+}
+
+func (c *ApiContext) loginFailureStore() (core.LoginFailureStore, error) {
+	if c.appContext.LoginFailureStore == nil {
+		return nil, newHttpErrorResponse(http.StatusNotImplemented,
+			errors.New("lockouts not supported"))
+	}
+	return c.appContext.LoginFailureStore, nil
+}
+
+// LockoutListHandler returns the usernames and addresses currently locked
+// out.
+func (c *ApiContext) LockoutListHandler(w *ResponseWriter, r *http.Request) error {
+	store, err := c.loginFailureStore()
+	if err != nil {
+		return err
+	}
+	lockouts, err := store.FindLockouts()
+	if err != nil {
+		return err
+	}
+	return w.OkJSON(map[string]interface{}{
+		"lockouts": lockouts,
+	})
+}
+
+// LockoutUnlockHandler clears the failed logins of a username or address.
+func (c *ApiContext) LockoutUnlockHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+	store, err := c.loginFailureStore()
+	if err != nil {
+		return err
+	}
+
+	var request UnlockRequest
+	if err := DecodeRequestBody(r, &request); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+	kind, value := core.LOCKOUT_USERNAME, request.Username
+	if request.Address != "" {
+		if request.Username != "" {
+			return newHttpErrorResponse(http.StatusBadRequest,
+				errors.New("only one of username or address may be provided"))
+		}
+		kind, value = core.LOCKOUT_ADDRESS, request.Address
+	}
+	if value == "" {
+		return newHttpErrorResponse(http.StatusBadRequest,
+			errors.New("username or address is required"))
+	}
+
+	err = auth.Unlock(store, c.appContext.AuditStore, kind, value,
+		session.User.Username, r.RemoteAddr)
+	if err != nil {
+		if err == core.ErrNoLoginFailures {
+			return httpNotFoundResponse(fmt.Sprintf("No failed logins for %s %s", kind, value))
+		}
+		return err
+	}
+	log.Info("User %s unlocked %s %s", session.User.Username, kind, value)
+	return w.Ok()
+}
+
+// AuditListHandler returns the most recent audit log entries, limited by
+// the "size" parameter.
+func (c *ApiContext) AuditListHandler(w *ResponseWriter, r *http.Request) error {
+	if c.appContext.AuditStore == nil {
+		return newHttpErrorResponse(http.StatusNotImplemented,
+			errors.New("audit log not supported"))
+	}
+	size := 100
+	if value := r.FormValue("size"); value != "" {
+		var err error
+		size, err = strconv.Atoi(value)
+		if err != nil || size < 1 {
+			return newHttpErrorResponse(http.StatusBadRequest,
+				errors.New("bad size"))
+		}
+	}
+	entries, err := c.appContext.AuditStore.FindAuditEntries(size)
+	if err != nil {
+		return err
+	}
+	return w.OkJSON(map[string]interface{}{
+		"entries": entries,
+	})
+}
@@ -28,8 +28,11 @@ package api
 
 import (
 	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/server/auth"
 	"github.com/jasonish/evebox/server/sessions"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 type LoginOptionsResponse struct {
@@ -56,6 +59,11 @@ func (c *ApiContext) LoginOptions(w *ResponseWriter, r *http.Request) error {
 			response.Authentication.Types =
 				append(response.Authentication.Types, "github")
 		}
+
+		if c.appContext.Config.Authentication.Oidc.Enabled {
+			response.Authentication.Types =
+				append(response.Authentication.Types, "oidc")
+		}
 	}
 
 	return w.OkJSON(response)
@@ -63,10 +71,24 @@ func (c *ApiContext) LoginOptions(w *ResponseWriter, r *http.Request) error {
 
 type LoginSuccessResponse struct {
 	SessionID string `json:"session_id"`
+
+	// If set the user must change their password with /api/1/password
+	// before using any other API.
+	PasswordChangeRequired bool `json:"password_change_required,omitempty"`
 }
 
 func (c *ApiContext) LoginHandler(w *ResponseWriter, r *http.Request) error {
 	session, err := c.authenticator.Login(r)
+	if totpRequired, ok := err.(*auth.TotpRequiredError); ok {
+		return w.StatusJSON(http.StatusUnauthorized, map[string]interface{}{
+			"status":        http.StatusUnauthorized,
+			"totp_required": true,
+			"login_token":   totpRequired.LoginToken,
+		})
+	}
+	if lockedOut, ok := err.(*auth.LockedOutError); ok {
+		return lockedOutError(w, lockedOut)
+	}
 	if err != nil {
 		return ApiError{
 			Status:  http.StatusUnauthorized,
@@ -74,10 +96,22 @@ func (c *ApiContext) LoginHandler(w *ResponseWriter, r *http.Request) error {
 		}
 	}
 	return w.OkJSON(LoginSuccessResponse{
-		SessionID: session.Id,
+		SessionID:              session.Id,
+		PasswordChangeRequired: session.User.PasswordChangeRequired,
 	})
 }
 
+// lockedOutError responds to a locked out login with the time the client
+// can try again.
+func lockedOutError(w *ResponseWriter, err *auth.LockedOutError) error {
+	retryAfter := int(time.Until(err.Until).Seconds()) + 1
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	return ApiError{
+		Status:  http.StatusTooManyRequests,
+		Message: err.Error(),
+	}
+}
+
 func (c *ApiContext) LogoutHandler(w *ResponseWriter, r *http.Request) error {
 	session, ok := r.Context().Value("session").(*sessions.Session)
 	if !ok {
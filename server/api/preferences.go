@@ -0,0 +1,85 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package api
+
+import (
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/server/sessions"
+	"github.com/pkg/errors"
+	"net/http"
+)
+
+func (c *ApiContext) preferencesStore() (core.PreferencesStore, error) {
+	if c.appContext.PreferencesStore == nil {
+		return nil, newHttpErrorResponse(http.StatusNotImplemented,
+			errors.New("preferences not supported"))
+	}
+	return c.appContext.PreferencesStore, nil
+}
+
+// GetPreferencesHandler returns the preferences of the logged in user.
+func (c *ApiContext) GetPreferencesHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+	store, err := c.preferencesStore()
+	if err != nil {
+		return err
+	}
+	preferences, err := store.GetPreferences(session.User)
+	if err != nil {
+		return err
+	}
+	return w.OkJSON(preferences)
+}
+
+// PutPreferencesHandler replaces the preferences of the logged in user.
+func (c *ApiContext) PutPreferencesHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+	store, err := c.preferencesStore()
+	if err != nil {
+		return err
+	}
+	if session.User.Anonymous {
+		return newHttpErrorResponse(http.StatusBadRequest,
+			errors.New("saving preferences requires authentication to be enabled"))
+	}
+
+	var preferences core.Preferences
+	if err := DecodeRequestBody(r, &preferences); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+	if err := preferences.Validate(); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+
+	if err := store.SetPreferences(session.User, preferences); err != nil {
+		log.Error("Failed to save preferences for %s: %v",
+			session.User.Username, err)
+		return err
+	}
+	return w.OkJSON(preferences)
+}
@@ -0,0 +1,57 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package api
+
+import (
+	"github.com/jasonish/evebox/core"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestPutPreferences(t *testing.T) {
+	test := newApiTest(t)
+	user := test.addUser(t, "joe", core.ROLE_VIEWER)
+	session := test.login(user)
+
+	body := `{"time_range": "24h", "page_size": 50}`
+	w := test.request("PUT", "/api/1/preferences", session, body)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = test.request("GET", "/api/1/preferences", session, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, body, w.Body.String())
+
+	w = test.request("PUT", "/api/1/preferences", session, `{"page_size": -1}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// With authentication disabled there is no user to save them for.
+	anonymous := test.login(core.NewAnonymousUser("anonymous"))
+	w = test.request("PUT", "/api/1/preferences", anonymous, body)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = test.request("GET", "/api/1/preferences", anonymous, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,226 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package api
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/server/sessions"
+	"github.com/pkg/errors"
+	"net/http"
+	"strconv"
+)
+
+type SavedSearchRequest struct {
+	Name        string `json:"name"`
+	QueryString string `json:"query_string"`
+	EventType   string `json:"event_type"`
+	TimeRange   string `json:"time_range"`
+	Shared      bool   `json:"shared"`
+}
+
+func (r *SavedSearchRequest) ToCoreSavedSearch() core.SavedSearch {
+	return core.SavedSearch{
+		Name:        r.Name,
+		QueryString: r.QueryString,
+		EventType:   r.EventType,
+		TimeRange:   r.TimeRange,
+		Shared:      r.Shared,
+	}
+}
+
+func (c *ApiContext) savedSearchStore() (core.SavedSearchStore, error) {
+	if c.appContext.SavedSearchStore == nil {
+		return nil, newHttpErrorResponse(http.StatusNotImplemented,
+			errors.New("saved searches not supported"))
+	}
+	return c.appContext.SavedSearchStore, nil
+}
+
+// findSavedSearch looks up a saved search by ID or name that is visible to
+// the user.
+func (c *ApiContext) findSavedSearch(user core.User, idOrName string) (core.SavedSearch, error) {
+	store, err := c.savedSearchStore()
+	if err != nil {
+		return core.SavedSearch{}, err
+	}
+	var search core.SavedSearch
+	if id, perr := strconv.ParseInt(idOrName, 10, 64); perr == nil {
+		search, err = store.FindSavedSearchById(id)
+		if err == nil && !search.IsVisibleTo(user.Username) {
+			err = core.ErrNoSavedSearch
+		}
+	} else {
+		search, err = store.FindSavedSearchByName(user.Username, idOrName)
+	}
+	if err != nil {
+		if err == core.ErrNoSavedSearch {
+			return search, httpNotFoundResponse(
+				fmt.Sprintf("No saved search %s", idOrName))
+		}
+		return search, err
+	}
+	return search, nil
+}
+
+// applySavedSearch applies the saved search named by the saved_search
+// request parameter, if any, to the query options.
+func (c *ApiContext) applySavedSearch(r *http.Request, options *core.CommonQueryOptions) error {
+	name := r.FormValue("saved_search")
+	if name == "" {
+		return nil
+	}
+	session := r.Context().Value("session").(*sessions.Session)
+	search, err := c.findSavedSearch(session.User, name)
+	if err != nil {
+		return err
+	}
+	search.Apply(options)
+	return nil
+}
+
+// findOwnSavedSearch finds the saved search named in the request path,
+// only if owned by the user.
+func (c *ApiContext) findOwnSavedSearch(r *http.Request) (core.SavedSearch, error) {
+	session := r.Context().Value("session").(*sessions.Session)
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return core.SavedSearch{}, newHttpErrorResponse(http.StatusBadRequest,
+			errors.Wrap(err, "bad saved search id"))
+	}
+	search, err := c.findSavedSearch(session.User, mux.Vars(r)["id"])
+	if err != nil {
+		return search, err
+	}
+	if search.Owner != session.User.Username {
+		return search, newHttpErrorResponse(http.StatusForbidden,
+			errors.Errorf("saved search %d is owned by another user", id))
+	}
+	return search, nil
+}
+
+func (c *ApiContext) SavedSearchListHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+	store, err := c.savedSearchStore()
+	if err != nil {
+		return err
+	}
+	searches, err := store.FindSavedSearches(session.User.Username)
+	if err != nil {
+		return err
+	}
+	return w.OkJSON(map[string]interface{}{
+		"saved_searches": searches,
+	})
+}
+
+func (c *ApiContext) SavedSearchGetHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+	search, err := c.findSavedSearch(session.User, mux.Vars(r)["id"])
+	if err != nil {
+		return err
+	}
+	return w.OkJSON(search)
+}
+
+func (c *ApiContext) SavedSearchCreateHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+	store, err := c.savedSearchStore()
+	if err != nil {
+		return err
+	}
+
+	var request SavedSearchRequest
+	if err := DecodeRequestBody(r, &request); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+	search := request.ToCoreSavedSearch()
+	search.Owner = session.User.Username
+	if err := search.Validate(); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+
+	id, err := store.AddSavedSearch(search)
+	if err != nil {
+		log.Error("Failed to add saved search: %v", err)
+		return err
+	}
+	search, err = store.FindSavedSearchById(id)
+	if err != nil {
+		return err
+	}
+	return w.OkJSON(search)
+}
+
+func (c *ApiContext) SavedSearchUpdateHandler(w *ResponseWriter, r *http.Request) error {
+	store, err := c.savedSearchStore()
+	if err != nil {
+		return err
+	}
+	existing, err := c.findOwnSavedSearch(r)
+	if err != nil {
+		return err
+	}
+
+	var request SavedSearchRequest
+	if err := DecodeRequestBody(r, &request); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+	search := request.ToCoreSavedSearch()
+	search.Id = existing.Id
+	if err := search.Validate(); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+
+	if err := store.UpdateSavedSearch(search); err != nil {
+		log.Error("Failed to update saved search: %v", err)
+		return err
+	}
+	search, err = store.FindSavedSearchById(search.Id)
+	if err != nil {
+		return err
+	}
+	return w.OkJSON(search)
+}
+
+func (c *ApiContext) SavedSearchDeleteHandler(w *ResponseWriter, r *http.Request) error {
+	store, err := c.savedSearchStore()
+	if err != nil {
+		return err
+	}
+	search, err := c.findOwnSavedSearch(r)
+	if err != nil {
+		return err
+	}
+	if err := store.DeleteSavedSearch(search.Id); err != nil {
+		log.Error("Failed to delete saved search: %v", err)
+		return err
+	}
+	return w.Ok()
+}
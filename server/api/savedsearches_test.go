@@ -0,0 +1,67 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package api
+
+import (
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+func TestSavedSearchGetPrivateOfOtherUser(t *testing.T) {
+	a := newApiTest(t)
+	a.addUser(t, "alice", core.ROLE_VIEWER)
+	bob := a.addUser(t, "bob", core.ROLE_VIEWER)
+
+	privateId, err := a.appContext.SavedSearchStore.AddSavedSearch(core.SavedSearch{
+		Name:  "scans",
+		Owner: "alice",
+	})
+	require.Nil(t, err)
+	sharedId, err := a.appContext.SavedSearchStore.AddSavedSearch(core.SavedSearch{
+		Name:   "shared-scans",
+		Owner:  "alice",
+		Shared: true,
+	})
+	require.Nil(t, err)
+
+	bobSession := a.login(bob)
+
+	w := a.request("GET", fmt.Sprintf("/api/1/saved-searches/%d", privateId),
+		bobSession, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = a.request("GET", fmt.Sprintf("/api/1/saved-searches/%d", sharedId),
+		bobSession, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = a.request("GET", "/api/1/saved-searches/9999", bobSession, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
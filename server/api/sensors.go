@@ -0,0 +1,164 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package api
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/server/sessions"
+	"github.com/pkg/errors"
+	"net/http"
+	"strconv"
+)
+
+type SensorRequest struct {
+	Name string `json:"name"`
+}
+
+type SensorResponse struct {
+	core.Sensor
+
+	// The token, only returned when the sensor is created.
+	Token string `json:"token"`
+}
+
+func (c *ApiContext) sensorStore() (core.SensorStore, error) {
+	if c.appContext.SensorStore == nil {
+		return nil, newHttpErrorResponse(http.StatusNotImplemented,
+			errors.New("sensors not supported"))
+	}
+	return c.appContext.SensorStore, nil
+}
+
+func (c *ApiContext) SensorListHandler(w *ResponseWriter, r *http.Request) error {
+	store, err := c.sensorStore()
+	if err != nil {
+		return err
+	}
+	sensors, err := store.FindSensors()
+	if err != nil {
+		return err
+	}
+	return w.OkJSON(map[string]interface{}{
+		"sensors": sensors,
+	})
+}
+
+// SensorCreateHandler issues a token for a new sensor. This is the only
+// time the token is returned.
+func (c *ApiContext) SensorCreateHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+	store, err := c.sensorStore()
+	if err != nil {
+		return err
+	}
+
+	var request SensorRequest
+	if err := DecodeRequestBody(r, &request); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+	if request.Name == "" {
+		return newHttpErrorResponse(http.StatusBadRequest,
+			errors.New("name is required"))
+	}
+
+	token, sensor, err := store.AddSensor(request.Name)
+	if err != nil {
+		log.Error("Failed to add sensor: %v", err)
+		return err
+	}
+	log.Info("User %s added sensor %s", session.User.Username, sensor.Name)
+
+	return w.OkJSON(SensorResponse{
+		Sensor: sensor,
+		Token:  token,
+	})
+}
+
+func (c *ApiContext) SensorDeleteHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+	store, err := c.sensorStore()
+	if err != nil {
+		return err
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest,
+			errors.Wrap(err, "bad sensor id"))
+	}
+	if err := store.DeleteSensor(id); err != nil {
+		if err == core.ErrNoSensor {
+			return httpNotFoundResponse(fmt.Sprintf("No sensor with ID %d", id))
+		}
+		return err
+	}
+	log.Info("User %s deleted sensor %d", session.User.Username, id)
+	return w.Ok()
+}
+
+// SensorHeartbeatHandler records a heartbeat from an agent, registering
+// the agent as a sensor if it isn't already. Agents authenticate as they
+// do for event submission.
+func (c *ApiContext) SensorHeartbeatHandler(w *ResponseWriter, r *http.Request) error {
+	name, err := c.authenticateSensor(r)
+	if err != nil {
+		log.Warning("Rejecting heartbeat from %s: %v", r.RemoteAddr, err)
+		return newHttpErrorResponse(http.StatusUnauthorized, err)
+	}
+	store, err := c.sensorStore()
+	if err != nil {
+		return err
+	}
+
+	var heartbeat core.Heartbeat
+	if err := DecodeRequestBody(r, &heartbeat); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+	if heartbeat.SensorID == "" {
+		return newHttpErrorResponse(http.StatusBadRequest,
+			errors.New("sensor_id is required"))
+	}
+
+	sensor, err := store.RecordHeartbeat(name, heartbeat, r.RemoteAddr)
+	if err == core.ErrSensorIdInUse {
+		log.Warning("Rejecting heartbeat from %s: %v", r.RemoteAddr, err)
+		return newHttpErrorResponse(http.StatusUnauthorized, err)
+	}
+	if err == core.ErrSensorLimit {
+		log.Warning("Rejecting heartbeat from %s: %v", r.RemoteAddr, err)
+		return newHttpErrorResponse(http.StatusForbidden, err)
+	}
+	if err != nil {
+		log.Error("Failed to record heartbeat from %s: %v", r.RemoteAddr, err)
+		return err
+	}
+	log.Debug("Heartbeat from sensor %s at %s", sensor.Name, r.RemoteAddr)
+
+	return w.OkJSON(sensor)
+}
@@ -0,0 +1,76 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package api
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/server/sessions"
+	"net/http"
+)
+
+type SessionResponse struct {
+	core.PersistentSession
+
+	// True if this is the session making the request.
+	Current bool `json:"current"`
+}
+
+// SessionListHandler lists the active sessions of the logged in user.
+func (c *ApiContext) SessionListHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+	found, err := c.sessionStore.FindByUser(session.User)
+	if err != nil {
+		return err
+	}
+	currentHash := sessions.HashId(session.Id)
+	response := []SessionResponse{}
+	for _, s := range found {
+		response = append(response, SessionResponse{
+			PersistentSession: s,
+			Current:           s.IdHash == currentHash,
+		})
+	}
+	return w.OkJSON(map[string]interface{}{
+		"sessions": response,
+	})
+}
+
+// SessionRevokeHandler ends one of the logged in user's sessions.
+func (c *ApiContext) SessionRevokeHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+	id := mux.Vars(r)["id"]
+	if err := c.sessionStore.Revoke(session.User, id); err != nil {
+		if err == core.ErrNoSession {
+			return httpNotFoundResponse("No session with ID " + id)
+		}
+		return err
+	}
+	log.Info("User %s revoked a session", session.User.Username)
+	return w.Ok()
+}
@@ -28,34 +28,172 @@ package api
 
 import (
 	"bufio"
+	"compress/gzip"
+	"fmt"
 	"github.com/jasonish/evebox/eve"
 	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/server/auth"
 	"github.com/jasonish/evebox/useragent"
+	"github.com/pkg/errors"
 	"io"
 	"net/http"
 )
 
+// authenticateSensor returns the name of the sensor submitting events. A
+// sensor is identified by a verified TLS client certificate, using the
+// common name, or by a sensor token in an "Authorization: Bearer" header.
+//
+// An empty name is returned if the request has no credentials and agents
+// are not required to authenticate. If client certificates are required
+// requests without one are rejected even if they have a token.
+func (c *ApiContext) authenticateSensor(r *http.Request) (string, error) {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		name := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		if name != "" {
+			return name, nil
+		}
+	}
+
+	if c.appContext.Config.Authentication.Agents.RequireCertificate {
+		return "", errors.New("client certificate required")
+	}
+
+	token := auth.BearerToken(r)
+	if token != "" && c.appContext.SensorStore != nil {
+		sensor, err := c.appContext.SensorStore.FindSensorByToken(token,
+			r.RemoteAddr)
+		if err != nil {
+			return "", err
+		}
+		return sensor.Name, nil
+	}
+
+	if c.appContext.Config.Authentication.Agents.Required {
+		return "", errors.New("sensor authentication required")
+	}
+	return "", nil
+}
+
+// setSensor stamps the authenticated sensor name on to the event, replacing
+// any sensor name the event was submitted with.
+func setSensor(event eve.EveEvent, sensor string) {
+	evebox, ok := event["evebox"].(map[string]interface{})
+	if !ok {
+		if sensor == "" {
+			return
+		}
+		evebox = map[string]interface{}{}
+		event["evebox"] = evebox
+	}
+	if sensor == "" {
+		delete(evebox, "sensor")
+	} else {
+		evebox["sensor"] = sensor
+	}
+}
+
+// Tags only EveBox sets, removed from submitted events so agents can't
+// archive alerts before they reach the inbox. Suppressions may still
+// archive them.
+var reservedTags = []string{"archived", "evebox.archived"}
+
 type SubmitResponse struct {
 	Count int
+
+	// Number of events dropped by suppressions, included in Count.
+	Dropped int `json:",omitempty"`
+}
+
+// The content encodings accepted for event submissions, advertised to agents
+// in the version response.
+var submitEncodings = []string{"gzip"}
+
+// The maximum decompressed size of a submission. A small gzip body can
+// expand to gigabytes.
+var maxSubmitSize int64 = 256 * 1024 * 1024
+
+var errSubmitTooLarge = errors.New("decompressed request body too large")
+
+type unsupportedEncodingError string
+
+func (e unsupportedEncodingError) Error() string {
+	return fmt.Sprintf("unsupported content encoding: %s", string(e))
+}
+
+// submitBody returns a reader for the request body, decoding it according to
+// the Content-Encoding header.
+func submitBody(r *http.Request) (io.ReadCloser, error) {
+	switch encoding := r.Header.Get("Content-Encoding"); encoding {
+	case "", "identity":
+		return r.Body, nil
+	case "gzip":
+		reader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		return &gzipBody{
+			Closer: reader,
+			limited: &io.LimitedReader{
+				R: reader,
+				N: maxSubmitSize + 1,
+			},
+		}, nil
+	default:
+		return nil, unsupportedEncodingError(encoding)
+	}
+}
+
+// gzipBody is a gzip decoded request body that fails with
+// errSubmitTooLarge once more than maxSubmitSize bytes have been read.
+type gzipBody struct {
+	io.Closer
+	limited *io.LimitedReader
+}
+
+func (b *gzipBody) Read(p []byte) (int, error) {
+	n, err := b.limited.Read(p)
+	if err == io.EOF && b.limited.N == 0 {
+		return n, errSubmitTooLarge
+	}
+	return n, err
 }
 
 // Consumes events from agents and adds them to the database.
 func (c *ApiContext) SubmitHandler(w *ResponseWriter, r *http.Request) error {
 
+	sensor, err := c.authenticateSensor(r)
+	if err != nil {
+		log.Warning("Rejecting events from %s: %v", r.RemoteAddr, err)
+		return newHttpErrorResponse(http.StatusUnauthorized, err)
+	}
+
 	count := 0
+	dropped := 0
 
 	eventSink := c.appContext.DataStore.GetEveEventSink()
 	geoFilter := eve.NewGeoipFilter(c.appContext.GeoIpService)
 	tagsFilter := eve.TagsFilter{}
 	uaFilter := useragent.EveUserAgentFilter{}
 
-	reader := bufio.NewReader(r.Body)
+	body, err := submitBody(r)
+	if err != nil {
+		if _, ok := err.(unsupportedEncodingError); ok {
+			return newHttpErrorResponse(http.StatusUnsupportedMediaType, err)
+		}
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+	defer body.Close()
+
+	reader := bufio.NewReader(body)
 	for {
 		eof := false
 		line, err := reader.ReadBytes('\n')
 		if err != nil {
 			if err == io.EOF {
 				eof = true
+			} else if err == errSubmitTooLarge {
+				return newHttpErrorResponse(
+					http.StatusRequestEntityTooLarge, err)
 			} else {
 				log.Error("read error: %v", err)
 				return err
@@ -72,11 +210,22 @@ func (c *ApiContext) SubmitHandler(w *ResponseWriter, r *http.Request) error {
 			return err
 		}
 
+		setSensor(event, sensor)
+		for _, tag := range reservedTags {
+			event.RemoveTag(tag)
+		}
 		tagsFilter.Filter(event)
 		geoFilter.Filter(event)
 		uaFilter.Filter(event)
+		if c.appContext.SuppressionFilter != nil {
+			c.appContext.SuppressionFilter.Filter(event)
+		}
 
-		eventSink.Submit(event)
+		if event.IsDropped() {
+			dropped++
+		} else {
+			eventSink.Submit(event)
+		}
 
 		count++
 
@@ -85,12 +234,12 @@ func (c *ApiContext) SubmitHandler(w *ResponseWriter, r *http.Request) error {
 		}
 	}
 
-	_, err := eventSink.Commit()
+	_, err = eventSink.Commit()
 	if err != nil {
 		log.Error("Failed to commit events: %v", err)
 		return err
 	}
 	log.Debug("Added %d events from %v", count, r.RemoteAddr)
 
-	return w.OkJSON(SubmitResponse{Count: count})
+	return w.OkJSON(SubmitResponse{Count: count, Dropped: dropped})
 }
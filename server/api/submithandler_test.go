@@ -0,0 +1,105 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/eve"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSubmitBodyGzipLimit(t *testing.T) {
+	defer func(size int64) { maxSubmitSize = size }(maxSubmitSize)
+	maxSubmitSize = 1024
+
+	compress := func(size int) []byte {
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		writer.Write(bytes.Repeat([]byte("a"), size))
+		require.Nil(t, writer.Close())
+		return buf.Bytes()
+	}
+
+	r := httptest.NewRequest("POST", "/api/1/submit",
+		bytes.NewReader(compress(1024)))
+	r.Header.Set("Content-Encoding", "gzip")
+	body, err := submitBody(r)
+	require.Nil(t, err)
+	buf, err := ioutil.ReadAll(body)
+	assert.Nil(t, err)
+	assert.Equal(t, 1024, len(buf))
+	assert.Nil(t, body.Close())
+
+	r = httptest.NewRequest("POST", "/api/1/submit",
+		bytes.NewReader(compress(1025)))
+	r.Header.Set("Content-Encoding", "gzip")
+	body, err = submitBody(r)
+	require.Nil(t, err)
+	_, err = ioutil.ReadAll(body)
+	assert.Equal(t, errSubmitTooLarge, err)
+}
+
+// submitDatastore collects the events submitted to it.
+type submitDatastore struct {
+	core.UnimplementedDatastore
+	events []eve.EveEvent
+}
+
+func (d *submitDatastore) GetEveEventSink() core.EveEventSink {
+	return d
+}
+
+func (d *submitDatastore) Submit(event eve.EveEvent) error {
+	d.events = append(d.events, event)
+	return nil
+}
+
+func (d *submitDatastore) Commit() (interface{}, error) {
+	return nil, nil
+}
+
+func TestSubmitRemovesArchivedTags(t *testing.T) {
+	a := newApiTest(t)
+	datastore := &submitDatastore{}
+	a.appContext.DataStore = datastore
+
+	w := a.request("POST", "/api/1/submit", "",
+		`{"timestamp": "2019-01-02T03:04:05.000000-0600", "event_type": "alert", "tags": ["archived", "evebox.archived", "other"]}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Len(t, datastore.events, 1)
+	event := datastore.events[0]
+	assert.False(t, event.HasTag("archived"))
+	assert.False(t, event.HasTag("evebox.archived"))
+	assert.True(t, event.HasTag("other"))
+}
@@ -0,0 +1,274 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package api
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/server/sessions"
+	"github.com/jasonish/evebox/suppression"
+	"github.com/pkg/errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type SuppressionRequest struct {
+	SignatureID uint64     `json:"signature_id"`
+	SrcCIDR     string     `json:"src_cidr"`
+	DestCIDR    string     `json:"dest_cidr"`
+	Sensor      string     `json:"sensor"`
+	Action      string     `json:"action"`
+	Reason      string     `json:"reason"`
+	Expires     *time.Time `json:"expires"`
+}
+
+func (r *SuppressionRequest) ToCoreSuppression() core.Suppression {
+	action := r.Action
+	if action == "" {
+		action = core.SUPPRESSION_ACTION_ARCHIVE
+	}
+	return core.Suppression{
+		SignatureID: r.SignatureID,
+		SrcCIDR:     r.SrcCIDR,
+		DestCIDR:    r.DestCIDR,
+		Sensor:      r.Sensor,
+		Action:      action,
+		Reason:      r.Reason,
+		Expires:     r.Expires,
+	}
+}
+
+func (c *ApiContext) suppressionStore() (core.SuppressionStore, error) {
+	if c.appContext.SuppressionStore == nil {
+		return nil, newHttpErrorResponse(http.StatusNotImplemented,
+			errors.New("suppressions not supported"))
+	}
+	return c.appContext.SuppressionStore, nil
+}
+
+// reloadSuppressions makes changes to the suppressions take effect. Any
+// pending hit counts are also written out.
+func (c *ApiContext) reloadSuppressions() {
+	if c.appContext.SuppressionFilter != nil {
+		if err := c.appContext.SuppressionFilter.Reload(); err != nil {
+			log.Error("Failed to reload suppressions: %v", err)
+		}
+	}
+}
+
+func suppressionId(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return 0, newHttpErrorResponse(http.StatusBadRequest,
+			errors.Wrap(err, "bad suppression id"))
+	}
+	return id, nil
+}
+
+func (c *ApiContext) SuppressionListHandler(w *ResponseWriter, r *http.Request) error {
+	store, err := c.suppressionStore()
+	if err != nil {
+		return err
+	}
+
+	// Flush so the hit counts returned are current.
+	if c.appContext.SuppressionFilter != nil {
+		c.appContext.SuppressionFilter.Flush()
+	}
+
+	suppressions, err := store.FindAllSuppressions()
+	if err != nil {
+		return err
+	}
+	return w.OkJSON(map[string]interface{}{
+		"suppressions": suppressions,
+	})
+}
+
+func (c *ApiContext) SuppressionGetHandler(w *ResponseWriter, r *http.Request) error {
+	store, err := c.suppressionStore()
+	if err != nil {
+		return err
+	}
+	id, err := suppressionId(r)
+	if err != nil {
+		return err
+	}
+	if c.appContext.SuppressionFilter != nil {
+		c.appContext.SuppressionFilter.Flush()
+	}
+	suppression, err := store.FindSuppressionById(id)
+	if err != nil {
+		if err == core.ErrNoSuppression {
+			return httpNotFoundResponse(fmt.Sprintf("No suppression with ID %d", id))
+		}
+		return err
+	}
+	return w.OkJSON(suppression)
+}
+
+func (c *ApiContext) SuppressionCreateHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+	store, err := c.suppressionStore()
+	if err != nil {
+		return err
+	}
+
+	var request SuppressionRequest
+	if err := DecodeRequestBody(r, &request); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+
+	suppression := request.ToCoreSuppression()
+	suppression.Username = session.User.Username
+	if err := suppression.Validate(); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+
+	id, err := store.AddSuppression(suppression)
+	if err != nil {
+		log.Error("Failed to add suppression: %v", err)
+		return err
+	}
+	log.Info("User %s added suppression %d", session.User.Username, id)
+	c.reloadSuppressions()
+
+	suppression, err = store.FindSuppressionById(id)
+	if err != nil {
+		return err
+	}
+	return w.OkJSON(suppression)
+}
+
+func (c *ApiContext) SuppressionUpdateHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+	store, err := c.suppressionStore()
+	if err != nil {
+		return err
+	}
+	id, err := suppressionId(r)
+	if err != nil {
+		return err
+	}
+
+	var request SuppressionRequest
+	if err := DecodeRequestBody(r, &request); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+
+	suppression := request.ToCoreSuppression()
+	suppression.Id = id
+	if err := suppression.Validate(); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+
+	if err := store.UpdateSuppression(suppression); err != nil {
+		if err == core.ErrNoSuppression {
+			return httpNotFoundResponse(fmt.Sprintf("No suppression with ID %d", id))
+		}
+		log.Error("Failed to update suppression: %v", err)
+		return err
+	}
+	log.Info("User %s updated suppression %d", session.User.Username, id)
+	c.reloadSuppressions()
+
+	suppression, err = store.FindSuppressionById(id)
+	if err != nil {
+		return err
+	}
+	return w.OkJSON(suppression)
+}
+
+func (c *ApiContext) SuppressionDeleteHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+	store, err := c.suppressionStore()
+	if err != nil {
+		return err
+	}
+	id, err := suppressionId(r)
+	if err != nil {
+		return err
+	}
+
+	// Flush first so hits for the deleted suppression aren't left pending.
+	c.reloadSuppressions()
+
+	if err := store.DeleteSuppression(id); err != nil {
+		if err == core.ErrNoSuppression {
+			return httpNotFoundResponse(fmt.Sprintf("No suppression with ID %d", id))
+		}
+		log.Error("Failed to delete suppression: %v", err)
+		return err
+	}
+	log.Info("User %s deleted suppression %d", session.User.Username, id)
+	c.reloadSuppressions()
+
+	return w.Ok()
+}
+
+// SuppressionExportHandler returns the suppressions as a Suricata
+// threshold.config.
+//
+// Query parameters:
+//   - sensor: include suppressions specific to this sensor
+//   - limit_count, limit_seconds: export signature only suppressions as
+//     a limit threshold instead of suppressing the signature
+func (c *ApiContext) SuppressionExportHandler(w *ResponseWriter, r *http.Request) error {
+	store, err := c.suppressionStore()
+	if err != nil {
+		return err
+	}
+
+	options := suppression.ThresholdOptions{
+		Sensor: r.FormValue("sensor"),
+	}
+	if r.FormValue("limit_count") != "" {
+		options.LimitCount, err = strconv.Atoi(r.FormValue("limit_count"))
+		if err != nil || options.LimitCount < 1 {
+			return newHttpErrorResponse(http.StatusBadRequest,
+				errors.New("bad limit_count"))
+		}
+		options.LimitSeconds, err = strconv.Atoi(r.FormValue("limit_seconds"))
+		if err != nil || options.LimitSeconds < 1 {
+			return newHttpErrorResponse(http.StatusBadRequest,
+				errors.New("limit_seconds required with limit_count"))
+		}
+	}
+
+	suppressions, err := store.FindAllSuppressions()
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("content-type", "text/plain")
+	w.Header().Set("content-disposition", "attachment; filename=threshold.config")
+	return suppression.WriteThresholdConfig(w, suppressions,
+		c.appContext.RuleMap, options)
+}
@@ -0,0 +1,167 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package api
+
+import (
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/server/sessions"
+	"github.com/pkg/errors"
+	"net/http"
+)
+
+type TotpCodeRequest struct {
+	Code string `json:"code"`
+}
+
+func (c *ApiContext) totpStore(user core.User) (core.TotpStore, error) {
+	if c.appContext.TotpStore == nil {
+		return nil, newHttpErrorResponse(http.StatusNotImplemented,
+			errors.New("two-factor authentication not supported"))
+	}
+	if user.Anonymous {
+		return nil, newHttpErrorResponse(http.StatusBadRequest,
+			errors.New("two-factor authentication requires authentication to be enabled"))
+	}
+	return c.appContext.TotpStore, nil
+}
+
+// verifyTotpRequest checks the code in the request body against the
+// user's current enrollment.
+func verifyTotpRequest(store core.TotpStore, user core.User, r *http.Request) error {
+	var request TotpCodeRequest
+	if err := DecodeRequestBody(r, &request); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+	if err := store.VerifyTotp(user, request.Code); err != nil {
+		if err == core.ErrBadTotpCode || err == core.ErrNoTotp {
+			return newHttpErrorResponse(http.StatusBadRequest, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// TotpStatusHandler returns the two-factor status of the logged in user.
+func (c *ApiContext) TotpStatusHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+	store, err := c.totpStore(session.User)
+	if err != nil {
+		return err
+	}
+	status, err := store.GetTotpStatus(session.User)
+	if err != nil {
+		return err
+	}
+	return w.OkJSON(status)
+}
+
+// TotpEnrollHandler starts an enrollment, returning the secret and
+// provisioning URI. It must be confirmed with TotpConfirmHandler. If
+// two-factor authentication is already enabled it must be disabled
+// first, which requires a current code.
+func (c *ApiContext) TotpEnrollHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+	store, err := c.totpStore(session.User)
+	if err != nil {
+		return err
+	}
+	enrollment, err := store.EnrollTotp(session.User)
+	if err != nil {
+		if err == core.ErrTotpEnabled {
+			return newHttpErrorResponse(http.StatusConflict, err)
+		}
+		return err
+	}
+	return w.OkJSON(enrollment)
+}
+
+func (c *ApiContext) TotpConfirmHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+	store, err := c.totpStore(session.User)
+	if err != nil {
+		return err
+	}
+	var request TotpCodeRequest
+	if err := DecodeRequestBody(r, &request); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+	codes, err := store.ConfirmTotp(session.User, request.Code)
+	if err != nil {
+		if err == core.ErrBadTotpCode {
+			return newHttpErrorResponse(http.StatusBadRequest, err)
+		}
+		if err == core.ErrTotpEnabled {
+			return newHttpErrorResponse(http.StatusConflict, err)
+		}
+		return err
+	}
+	log.Info("User %s enabled two-factor authentication",
+		session.User.Username)
+	return w.OkJSON(map[string]interface{}{
+		"recovery_codes": codes,
+	})
+}
+
+// TotpRecoveryCodesHandler replaces the user's recovery codes. A current
+// code is required.
+func (c *ApiContext) TotpRecoveryCodesHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+	store, err := c.totpStore(session.User)
+	if err != nil {
+		return err
+	}
+	if err := verifyTotpRequest(store, session.User, r); err != nil {
+		return err
+	}
+	codes, err := store.RegenerateRecoveryCodes(session.User)
+	if err != nil {
+		return err
+	}
+	return w.OkJSON(map[string]interface{}{
+		"recovery_codes": codes,
+	})
+}
+
+// TotpDisableHandler disables two-factor authentication for the user. A
+// current code is required.
+func (c *ApiContext) TotpDisableHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+	store, err := c.totpStore(session.User)
+	if err != nil {
+		return err
+	}
+	if err := verifyTotpRequest(store, session.User, r); err != nil {
+		return err
+	}
+	if err := store.DisableTotp(session.User); err != nil {
+		return err
+	}
+	log.Info("User %s disabled two-factor authentication",
+		session.User.Username)
+	return w.Ok()
+}
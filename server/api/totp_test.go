@@ -0,0 +1,68 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTotpEnrollWhenEnabled(t *testing.T) {
+	a := newApiTest(t)
+	alice := a.login(a.addUser(t, "alice", core.ROLE_VIEWER))
+
+	w := a.request("POST", "/api/1/totp/enroll", alice, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var enrollment core.TotpEnrollment
+	require.Nil(t, json.NewDecoder(w.Body).Decode(&enrollment))
+
+	// Enrolling again before confirming starts over.
+	w = a.request("POST", "/api/1/totp/enroll", alice, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Nil(t, json.NewDecoder(w.Body).Decode(&enrollment))
+
+	code, err := totp.CodeForCounter(enrollment.Secret,
+		totp.Counter(time.Now()))
+	require.Nil(t, err)
+	w = a.request("POST", "/api/1/totp/confirm", alice,
+		fmt.Sprintf(`{"code": "%s"}`, code))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Once enabled, the secret can't be replaced by a new enrollment.
+	w = a.request("POST", "/api/1/totp/enroll", alice, "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = a.request("POST", "/api/1/totp/confirm", alice,
+		fmt.Sprintf(`{"code": "%s"}`, code))
+	assert.NotEqual(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,367 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package api
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/server/auth"
+	"github.com/jasonish/evebox/server/sessions"
+	"github.com/pkg/errors"
+	"net/http"
+)
+
+type UserCreateRequest struct {
+	Username       string `json:"username"`
+	FullName       string `json:"full_name"`
+	Email          string `json:"email"`
+	GitHubUsername string `json:"github_username"`
+	Role           string `json:"role"`
+	Password       string `json:"password"`
+}
+
+// UserUpdateRequest only changes the fields that are provided.
+type UserUpdateRequest struct {
+	FullName       *string `json:"full_name"`
+	Email          *string `json:"email"`
+	GitHubUsername *string `json:"github_username"`
+	Role           *string `json:"role"`
+}
+
+type UserPasswordRequest struct {
+	Password string `json:"password"`
+
+	// Require the user to change the password on their next login.
+	// Defaults to true.
+	RequireChange *bool `json:"require_change"`
+}
+
+type PasswordChangeRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// passwordError returns a 400 for passwords rejected by the password
+// policy.
+func passwordError(err error) error {
+	if _, ok := err.(*core.PasswordPolicyError); ok {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+	return err
+}
+
+func (c *ApiContext) userStore() (core.UserStore, error) {
+	if c.appContext.Userstore == nil {
+		return nil, newHttpErrorResponse(http.StatusNotImplemented,
+			errors.New("user management not supported"))
+	}
+	return c.appContext.Userstore, nil
+}
+
+// findUser returns the user named in the request path.
+func (c *ApiContext) findUser(r *http.Request) (core.UserStore, core.User, error) {
+	store, err := c.userStore()
+	if err != nil {
+		return nil, core.User{}, err
+	}
+	username := mux.Vars(r)["username"]
+	user, err := store.FindByUsername(username)
+	if err != nil {
+		if err == core.ErrNoUsername {
+			return nil, user, httpNotFoundResponse(
+				fmt.Sprintf("No user with username %s", username))
+		}
+		return nil, user, err
+	}
+	return store, user, nil
+}
+
+// logoutUser deletes the sessions of a user that has been disabled,
+// deleted, had their role changed or their password reset.
+func (c *ApiContext) logoutUser(user core.User) {
+	if err := c.sessionStore.DeleteByUser(user); err != nil {
+		log.Error("Failed to delete sessions of user %s: %v",
+			user.Username, err)
+	}
+}
+
+func (c *ApiContext) UserListHandler(w *ResponseWriter, r *http.Request) error {
+	store, err := c.userStore()
+	if err != nil {
+		return err
+	}
+	users, err := store.FindAll()
+	if err != nil {
+		return err
+	}
+	return w.OkJSON(map[string]interface{}{
+		"users": users,
+	})
+}
+
+func (c *ApiContext) UserGetHandler(w *ResponseWriter, r *http.Request) error {
+	_, user, err := c.findUser(r)
+	if err != nil {
+		return err
+	}
+	return w.OkJSON(user)
+}
+
+func (c *ApiContext) UserCreateHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+	store, err := c.userStore()
+	if err != nil {
+		return err
+	}
+
+	var request UserCreateRequest
+	if err := DecodeRequestBody(r, &request); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+	if request.Username == "" {
+		return newHttpErrorResponse(http.StatusBadRequest,
+			errors.New("username is required"))
+	}
+	if request.Role != "" && !core.IsValidRole(request.Role) {
+		return newHttpErrorResponse(http.StatusBadRequest,
+			errors.Errorf("invalid role: %s", request.Role))
+	}
+	if _, err := store.FindByUsername(request.Username); err == nil {
+		return newHttpErrorResponse(http.StatusConflict,
+			errors.Errorf("user %s already exists", request.Username))
+	}
+
+	_, err = store.AddUser(core.User{
+		Username:       request.Username,
+		FullName:       request.FullName,
+		Email:          request.Email,
+		GitHubUsername: request.GitHubUsername,
+		Role:           request.Role,
+	}, request.Password)
+	if err != nil {
+		return passwordError(err)
+	}
+	user, err := store.FindByUsername(request.Username)
+	if err != nil {
+		return err
+	}
+	log.Info("User %s added user %s with role %s", session.User.Username,
+		user.Username, user.Role)
+	return w.OkJSON(user)
+}
+
+func (c *ApiContext) UserUpdateHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+	store, user, err := c.findUser(r)
+	if err != nil {
+		return err
+	}
+
+	var request UserUpdateRequest
+	if err := DecodeRequestBody(r, &request); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+	if request.FullName != nil {
+		user.FullName = *request.FullName
+	}
+	if request.Email != nil {
+		user.Email = *request.Email
+	}
+	if request.GitHubUsername != nil {
+		user.GitHubUsername = *request.GitHubUsername
+	}
+	oldRole := user.Role
+	if request.Role != nil {
+		if !core.IsValidRole(*request.Role) {
+			return newHttpErrorResponse(http.StatusBadRequest,
+				errors.Errorf("invalid role: %s", *request.Role))
+		}
+		// So the last admin can't accidentally lock everyone out.
+		if user.Id == session.User.Id && *request.Role != core.ROLE_ADMIN {
+			return newHttpErrorResponse(http.StatusBadRequest,
+				errors.New("admins can't remove their own admin role"))
+		}
+		user.Role = *request.Role
+	}
+
+	if err := store.UpdateUser(user); err != nil {
+		return err
+	}
+	// Sessions hold a copy of the user, including the role.
+	if user.Role != oldRole {
+		c.logoutUser(user)
+	}
+	log.Info("User %s updated user %s", session.User.Username, user.Username)
+	return w.OkJSON(user)
+}
+
+func (c *ApiContext) setUserDisabled(w *ResponseWriter, r *http.Request, disabled bool) error {
+	session := r.Context().Value("session").(*sessions.Session)
+	store, user, err := c.findUser(r)
+	if err != nil {
+		return err
+	}
+	if disabled && user.Id == session.User.Id {
+		return newHttpErrorResponse(http.StatusBadRequest,
+			errors.New("users can't disable themselves"))
+	}
+	if err := store.SetDisabled(user.Username, disabled); err != nil {
+		return err
+	}
+	if disabled {
+		c.logoutUser(user)
+		log.Info("User %s disabled user %s", session.User.Username,
+			user.Username)
+	} else {
+		log.Info("User %s enabled user %s", session.User.Username,
+			user.Username)
+	}
+	return w.Ok()
+}
+
+func (c *ApiContext) UserDisableHandler(w *ResponseWriter, r *http.Request) error {
+	return c.setUserDisabled(w, r, true)
+}
+
+func (c *ApiContext) UserEnableHandler(w *ResponseWriter, r *http.Request) error {
+	return c.setUserDisabled(w, r, false)
+}
+
+func (c *ApiContext) UserDeleteHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+	store, user, err := c.findUser(r)
+	if err != nil {
+		return err
+	}
+	if user.Id == session.User.Id {
+		return newHttpErrorResponse(http.StatusBadRequest,
+			errors.New("users can't delete themselves"))
+	}
+	if err := store.DeleteByUsername(user.Username); err != nil {
+		return err
+	}
+	c.logoutUser(user)
+	log.Info("User %s deleted user %s", session.User.Username, user.Username)
+	return w.Ok()
+}
+
+// UserPasswordHandler resets the password of a user, logging them out
+// everywhere. Unless admins reset their own password, the user must
+// change it on their next login.
+func (c *ApiContext) UserPasswordHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+	store, user, err := c.findUser(r)
+	if err != nil {
+		return err
+	}
+	var request UserPasswordRequest
+	if err := DecodeRequestBody(r, &request); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+	if request.Password == "" {
+		return newHttpErrorResponse(http.StatusBadRequest,
+			errors.New("password is required"))
+	}
+	requireChange := user.Id != session.User.Id
+	if request.RequireChange != nil {
+		requireChange = *request.RequireChange
+	}
+	if requireChange {
+		err = store.ResetPassword(user.Username, request.Password)
+	} else {
+		err = store.UpdatePassword(user.Username, request.Password)
+	}
+	if err != nil {
+		return passwordError(err)
+	}
+	if user.Id != session.User.Id {
+		c.logoutUser(user)
+	}
+	log.Info("User %s reset the password of user %s", session.User.Username,
+		user.Username)
+	return w.Ok()
+}
+
+// PasswordChangeHandler changes the password of the logged in user. This
+// is the only API allowed for users that must change their password.
+func (c *ApiContext) PasswordChangeHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+	store, err := c.userStore()
+	if err != nil {
+		return err
+	}
+	if session.User.Anonymous {
+		return newHttpErrorResponse(http.StatusBadRequest,
+			errors.New("changing passwords requires authentication to be enabled"))
+	}
+
+	var request PasswordChangeRequest
+	if err := DecodeRequestBody(r, &request); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+	if request.NewPassword == "" {
+		return newHttpErrorResponse(http.StatusBadRequest,
+			errors.New("new_password is required"))
+	}
+	if request.NewPassword == request.CurrentPassword {
+		return newHttpErrorResponse(http.StatusBadRequest,
+			errors.New("new password must be different"))
+	}
+
+	// Checking the current password is subject to the same lockout as
+	// logins, so a session can't be used to guess it.
+	username := session.User.Username
+	if c.lockout != nil {
+		if err := c.lockout.Check(username, auth.RemoteHost(r)); err != nil {
+			return lockedOutError(w, err.(*auth.LockedOutError))
+		}
+	}
+	_, err = store.FindByUsernamePassword(username, request.CurrentPassword)
+	if err != nil {
+		log.Warning("User %s failed to change password from %s: %v",
+			username, r.RemoteAddr, err)
+		if c.lockout != nil {
+			c.lockout.RecordFailure(username, auth.RemoteHost(r))
+		}
+		return newHttpErrorResponse(http.StatusBadRequest,
+			errors.New("current password is incorrect"))
+	}
+	if c.lockout != nil {
+		c.lockout.RecordSuccess(username)
+	}
+
+	err = store.UpdatePassword(session.User.Username, request.NewPassword)
+	if err != nil {
+		return passwordError(err)
+	}
+	session.User.PasswordChangeRequired = false
+	c.sessionStore.Put(session)
+	log.Info("User %s changed their password", session.User.Username)
+	return w.Ok()
+}
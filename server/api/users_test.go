@@ -0,0 +1,94 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package api
+
+import (
+	"github.com/jasonish/evebox/appcontext"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/server/auth"
+	"github.com/jasonish/evebox/sqlite/configdb"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestUserUpdateRoleLogsOutUser(t *testing.T) {
+	a := newApiTest(t)
+	admin := a.addUser(t, "admin", core.ROLE_ADMIN)
+	bob := a.addUser(t, "bob", core.ROLE_ADMIN)
+
+	adminSession := a.login(admin)
+	bobSession := a.login(bob)
+
+	w := a.request("GET", "/api/1/users", bobSession, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Updates that don't change the role keep the session.
+	w = a.request("PUT", "/api/1/users/bob", adminSession,
+		`{"full_name": "Bob"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = a.request("GET", "/api/1/users", bobSession, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = a.request("PUT", "/api/1/users/bob", adminSession,
+		`{"role": "viewer"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// The demoted user's session must not keep the admin role.
+	w = a.request("GET", "/api/1/users", bobSession, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = a.request("GET", "/api/1/users", adminSession, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestPasswordChangeLockout(t *testing.T) {
+	a := newApiTest(t)
+	a.apiContext.SetLockout(auth.NewLockout(appcontext.LockoutConfig{
+		Enabled:           true,
+		UsernameThreshold: 3,
+		AddressThreshold:  10,
+		Delay:             time.Minute,
+		MaxDelay:          time.Hour,
+		ResetAfter:        time.Hour,
+	}, configdb.NewLoginFailureStore(a.appContext.ConfigDB.DB), nil))
+	bob := a.addUser(t, "bob", core.ROLE_VIEWER)
+	session := a.login(bob)
+
+	for i := 0; i < 3; i++ {
+		w := a.request("POST", "/api/1/password", session,
+			`{"current_password": "guess", "new_password": "new-password"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	}
+
+	// Locked out, even with the right password.
+	w := a.request("POST", "/api/1/password", session,
+		`{"current_password": "bob-password", "new_password": "new-password"}`)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEqual(t, "", w.Header().Get("Retry-After"))
+}
@@ -34,12 +34,17 @@ import (
 type VersionResponse struct {
 	Version  string `json:"version"`
 	Revision string `json:"revision"`
+
+	// The content encodings accepted by the submit handler. Agents use
+	// this to decide if they can compress their submissions.
+	SubmitEncodings []string `json:"submit_encodings"`
 }
 
 func (c *ApiContext) VersionHandler(w *ResponseWriter, r *http.Request) error {
 	response := VersionResponse{
-		core.BuildVersion,
-		core.BuildRev,
+		Version:         core.BuildVersion,
+		Revision:        core.BuildRev,
+		SubmitEncodings: submitEncodings,
 	}
 	return w.OkJSON(response)
 }
@@ -37,6 +37,17 @@ const SESSION_KEY = "x-evebox-session-id"
 var ErrNoUsername = errors.New("no username provided")
 var ErrNoPassword = errors.New("no password provided")
 
+// TotpRequiredError is returned from Login when the password is correct
+// but the user must also provide a two-factor authentication code.
+type TotpRequiredError struct {
+	// Token to complete the login with, along with the code.
+	LoginToken string
+}
+
+func (e *TotpRequiredError) Error() string {
+	return "two-factor authentication code required"
+}
+
 type AuthenticationRequiredResponse struct {
 	Types []string `json:"types"`
 }
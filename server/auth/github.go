@@ -163,8 +163,15 @@ func (g *GitHubAuthenticator) Callback(w http.ResponseWriter, r *http.Request) {
 			"Access denied - GitHub user does not exist in local database.")
 		return
 	}
+	if user.Disabled {
+		log.Warning("Refusing GitHub login of disabled user %s", user.Username)
+		handleError(w, r, session, http.StatusUnauthorized,
+			"Access denied - user is disabled.")
+		return
+	}
 	session.User = user
 	session.RemoteAddr = r.RemoteAddr
+	g.SessionStore.Put(session)
 
 	log.Info("User %s logged in (via GitHub) from %s", user.Username,
 		r.RemoteAddr)
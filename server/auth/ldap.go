@@ -0,0 +1,248 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/jasonish/evebox/appcontext"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/log"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const ldapDefaultUserFilter = "(uid={username})"
+const ldapDefaultGroupAttribute = "memberOf"
+
+// Timeout for connecting to the server, and for each request.
+const ldapTimeout = 10 * time.Second
+
+var errLdapUserNotFound = errors.New("user not found in directory")
+var errLdapBadPassword = errors.New("bad username or password")
+
+// The operations used on an LDAP connection, so tests can provide their
+// own directory.
+type ldapConn interface {
+	StartTLS(config *tls.Config) error
+	Bind(dn string, password string) error
+	Search(request *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close() error
+}
+
+// LdapPasswordChecker checks passwords by binding to an LDAP directory as
+// the user. Users are added to the local user store on their first login
+// without a password, so they can have preferences, API tokens and so
+// on.
+type LdapPasswordChecker struct {
+	config    appcontext.LdapAuthConfig
+	tlsConfig *tls.Config
+	userStore core.UserStore
+	dial      func() (ldapConn, error)
+}
+
+func NewLdapPasswordChecker(config appcontext.LdapAuthConfig, userStore core.UserStore) (*LdapPasswordChecker, error) {
+	if config.Url == "" {
+		return nil, errors.New("LDAP URL required")
+	}
+	if config.BaseDN == "" {
+		return nil, errors.New("LDAP base DN required")
+	}
+	if config.UserFilter == "" {
+		config.UserFilter = ldapDefaultUserFilter
+	}
+	if !strings.Contains(config.UserFilter, "{username}") {
+		return nil, errors.New("LDAP user filter must contain {username}")
+	}
+	if config.GroupAttribute == "" {
+		config.GroupAttribute = ldapDefaultGroupAttribute
+	}
+	if config.DefaultRole != "" && !core.IsValidRole(config.DefaultRole) {
+		return nil, errors.Errorf("invalid LDAP default role: %s",
+			config.DefaultRole)
+	}
+	for role := range config.Roles {
+		if !core.IsValidRole(role) {
+			return nil, errors.Errorf("invalid LDAP role: %s", role)
+		}
+	}
+
+	u, err := url.Parse(config.Url)
+	if err != nil {
+		return nil, errors.Wrap(err, "bad LDAP URL")
+	}
+
+	// The server name is needed to verify the certificate after StartTLS.
+	tlsConfig := &tls.Config{
+		ServerName:         u.Hostname(),
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+	if config.TlsCA != "" {
+		buf, err := ioutil.ReadFile(config.TlsCA)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read LDAP CA")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(buf) {
+			return nil, errors.Errorf("no certificates found in %s",
+				config.TlsCA)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	c := &LdapPasswordChecker{
+		config:    config,
+		tlsConfig: tlsConfig,
+		userStore: userStore,
+	}
+	c.dial = func() (ldapConn, error) {
+		conn, err := ldap.DialURL(c.config.Url,
+			ldap.DialWithDialer(&net.Dialer{Timeout: ldapTimeout}),
+			ldap.DialWithTLSConfig(c.tlsConfig))
+		if err != nil {
+			return nil, err
+		}
+		conn.SetTimeout(ldapTimeout)
+		return conn, nil
+	}
+	return c, nil
+}
+
+// connect connects to the directory, binding as the search user if
+// configured.
+func (c *LdapPasswordChecker) connect() (ldapConn, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to LDAP server")
+	}
+	if c.config.StartTLS {
+		if err := conn.StartTLS(c.tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.config.BindDN != "" {
+		if err := conn.Bind(c.config.BindDN, c.config.BindPassword); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "failed to bind as search user")
+		}
+	}
+	return conn, nil
+}
+
+// authenticate finds the user in the directory and binds as them,
+// returning the directory entry.
+func (c *LdapPasswordChecker) authenticate(username string, password string) (*ldap.Entry, error) {
+	conn, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	filter := strings.Replace(c.config.UserFilter, "{username}",
+		ldap.EscapeFilter(username), -1)
+	result, err := conn.Search(ldap.NewSearchRequest(c.config.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		filter, []string{
+			c.config.GroupAttribute, "cn", "displayName", "mail",
+		}, nil))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, errors.Wrap(err, "LDAP search failed")
+	}
+	if result == nil || len(result.Entries) == 0 {
+		return nil, errLdapUserNotFound
+	}
+	if len(result.Entries) > 1 {
+		return nil, errors.Errorf(
+			"LDAP search found more than one user for %s", username)
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, errLdapBadPassword
+		}
+		return nil, errors.Wrap(err, "failed to bind as user")
+	}
+
+	return entry, nil
+}
+
+func (c *LdapPasswordChecker) CheckPassword(username string, password string) (core.User, error) {
+	entry, err := c.authenticate(username, password)
+	if err != nil {
+		// Local users are only tried if the user is not in the
+		// directory at all, or the directory is not available. A
+		// wrong password for a directory user does not fall back.
+		if c.config.Fallback && err != errLdapBadPassword {
+			if err != errLdapUserNotFound {
+				log.Warning("LDAP authentication of %s failed, trying local users: %v",
+					username, err)
+			}
+			return c.userStore.FindByUsernamePassword(username, password)
+		}
+		return core.User{}, err
+	}
+
+	role := ""
+	if len(c.config.Roles) > 0 {
+		role = mapRole(entry.GetAttributeValues(c.config.GroupAttribute), c.config.Roles)
+		if role == "" {
+			role = c.config.DefaultRole
+		}
+		if role == "" {
+			return core.User{}, errors.Errorf(
+				"user %s is not in a group with a role", username)
+		}
+	}
+
+	user := core.User{
+		Username:   username,
+		Email:      firstValue(entry.GetAttributeValues("mail")),
+		FullName:   firstValue(entry.GetAttributeValues("displayName")),
+		Provider:   core.PROVIDER_LDAP,
+		ExternalId: entry.DN,
+	}
+	if user.FullName == "" {
+		user.FullName = firstValue(entry.GetAttributeValues("cn"))
+	}
+
+	return syncUser(c.userStore, user, role, c.config.DefaultRole, true,
+		"LDAP")
+}
+
+func firstValue(values []string) string {
+	if len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
@@ -0,0 +1,232 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package auth
+
+import (
+	"crypto/tls"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/jasonish/evebox/appcontext"
+	"github.com/jasonish/evebox/core"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+// A directory with users by DN, with their passwords and groups.
+type fakeDirectory struct {
+	passwords map[string]string
+	entries   []*ldap.Entry
+	down      bool
+	filters   []string
+	startTLS  bool
+}
+
+type fakeLdapConn struct {
+	directory *fakeDirectory
+}
+
+func (c *fakeLdapConn) StartTLS(config *tls.Config) error {
+	c.directory.startTLS = true
+	return nil
+}
+
+func (c *fakeLdapConn) Bind(dn string, password string) error {
+	if password == "" || c.directory.passwords[dn] != password {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials,
+			errors.New("invalid credentials"))
+	}
+	return nil
+}
+
+func (c *fakeLdapConn) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	c.directory.filters = append(c.directory.filters, request.Filter)
+	result := &ldap.SearchResult{}
+	for _, entry := range c.directory.entries {
+		uid := strings.TrimPrefix(strings.Split(entry.DN, ",")[0], "uid=")
+		if request.Filter == "(uid="+uid+")" {
+			result.Entries = append(result.Entries, entry)
+		}
+	}
+	return result, nil
+}
+
+func (c *fakeLdapConn) Close() error {
+	return nil
+}
+
+func newTestLdapChecker(t *testing.T, config appcontext.LdapAuthConfig, userStore core.UserStore) (*LdapPasswordChecker, *fakeDirectory) {
+	directory := &fakeDirectory{
+		passwords: map[string]string{
+			"cn=evebox,dc=example,dc=com":   "service",
+			"uid=alice,dc=example,dc=com":   "alice-password",
+			"uid=bob,dc=example,dc=com":     "bob-password",
+			"uid=mallory,dc=example,dc=com": "mallory-password",
+		},
+		entries: []*ldap.Entry{
+			ldap.NewEntry("uid=alice,dc=example,dc=com",
+				map[string][]string{
+					"memberOf": {"cn=SOC,dc=example,dc=com",
+						"cn=evebox-admins,dc=example,dc=com"},
+					"cn":   {"Alice"},
+					"mail": {"alice@example.com"},
+				}),
+			ldap.NewEntry("uid=bob,dc=example,dc=com",
+				map[string][]string{
+					"memberOf": {"cn=soc,dc=example,dc=com"},
+				}),
+			ldap.NewEntry("uid=mallory,dc=example,dc=com", nil),
+		},
+	}
+	config.Url = "ldap://localhost"
+	config.BaseDN = "dc=example,dc=com"
+	config.BindDN = "cn=evebox,dc=example,dc=com"
+	config.BindPassword = "service"
+	checker, err := NewLdapPasswordChecker(config, userStore)
+	require.Nil(t, err)
+	checker.dial = func() (ldapConn, error) {
+		if directory.down {
+			return nil, errors.New("connection refused")
+		}
+		return &fakeLdapConn{directory}, nil
+	}
+	return checker, directory
+}
+
+func TestLdapPasswordChecker(t *testing.T) {
+	userStore := &memoryUserStore{users: map[string]core.User{}}
+	userStore.AddUser(core.User{Username: "local", Role: core.ROLE_ADMIN},
+		"local-password")
+
+	checker, directory := newTestLdapChecker(t, appcontext.LdapAuthConfig{
+		StartTLS: true,
+		Roles: map[string][]string{
+			core.ROLE_ADMIN:   {"cn=evebox-admins,dc=example,dc=com"},
+			core.ROLE_ANALYST: {"cn=soc,dc=example,dc=com"},
+		},
+		Fallback: true,
+	}, userStore)
+
+	// Directory users are added on first login with their mapped role.
+	user, err := checker.CheckPassword("alice", "alice-password")
+	require.Nil(t, err)
+	assert.True(t, directory.startTLS)
+	assert.Equal(t, core.ROLE_ADMIN, user.Role)
+	assert.Equal(t, "Alice", userStore.users["alice"].FullName)
+	assert.Equal(t, "alice@example.com", userStore.users["alice"].Email)
+
+	user, err = checker.CheckPassword("bob", "bob-password")
+	require.Nil(t, err)
+	assert.Equal(t, core.ROLE_ANALYST, user.Role)
+
+	_, err = checker.CheckPassword("alice", "wrong")
+	assert.NotNil(t, err)
+
+	// Not in a mapped group, and no default role.
+	_, err = checker.CheckPassword("mallory", "mallory-password")
+	assert.NotNil(t, err)
+	_, ok := userStore.users["mallory"]
+	assert.False(t, ok)
+
+	// The username is escaped in the filter.
+	_, err = checker.CheckPassword("*)(uid=*", "x")
+	assert.NotNil(t, err)
+	assert.Equal(t, "(uid=\\2a\\29\\28uid=\\2a)",
+		directory.filters[len(directory.filters)-1])
+
+	// Local users are a fallback if not in the directory...
+	user, err = checker.CheckPassword("local", "local-password")
+	require.Nil(t, err)
+	assert.Equal(t, "local", user.Username)
+	_, err = checker.CheckPassword("local", "wrong")
+	assert.NotNil(t, err)
+
+	// ... or the directory is down.
+	directory.down = true
+	_, err = checker.CheckPassword("local", "local-password")
+	assert.Nil(t, err)
+	_, err = checker.CheckPassword("alice", "alice-password")
+	assert.NotNil(t, err)
+}
+
+func TestLdapPasswordCheckerNoFallback(t *testing.T) {
+	userStore := &memoryUserStore{users: map[string]core.User{}}
+	userStore.AddUser(core.User{Username: "local"}, "local-password")
+
+	checker, _ := newTestLdapChecker(t, appcontext.LdapAuthConfig{
+		DefaultRole: core.ROLE_VIEWER,
+	}, userStore)
+
+	_, err := checker.CheckPassword("local", "local-password")
+	assert.NotNil(t, err)
+
+	// Without role mapping new users get the default role.
+	user, err := checker.CheckPassword("mallory", "mallory-password")
+	require.Nil(t, err)
+	assert.Equal(t, core.ROLE_VIEWER, user.Role)
+}
+
+func TestLdapPasswordCheckerIdentity(t *testing.T) {
+	userStore := &memoryUserStore{users: map[string]core.User{
+		"bob": {Id: "1", Username: "bob", Role: core.ROLE_VIEWER,
+			Provider: core.PROVIDER_OIDC, ExternalId: "https://idp bob"},
+	}}
+	userStore.AddUser(core.User{Username: "alice", Role: core.ROLE_VIEWER},
+		"local-password")
+
+	checker, directory := newTestLdapChecker(t, appcontext.LdapAuthConfig{
+		Roles: map[string][]string{
+			core.ROLE_ADMIN: {"cn=evebox-admins,dc=example,dc=com"},
+		},
+		DefaultRole: core.ROLE_VIEWER,
+		Fallback:    true,
+	}, userStore)
+
+	// A directory user can't take over a local user with a password,
+	// or give them a role.
+	_, err := checker.CheckPassword("alice", "alice-password")
+	assert.NotNil(t, err)
+	assert.Equal(t, core.ROLE_VIEWER, userStore.users["alice"].Role)
+	assert.Equal(t, "", userStore.users["alice"].ExternalId)
+
+	// Or a user of another provider.
+	_, err = checker.CheckPassword("bob", "bob-password")
+	assert.NotNil(t, err)
+	assert.Equal(t, core.PROVIDER_OIDC, userStore.users["bob"].Provider)
+
+	// Users are matched on their DN.
+	user, err := checker.CheckPassword("mallory", "mallory-password")
+	require.Nil(t, err)
+	assert.Equal(t, core.PROVIDER_LDAP, user.Provider)
+	assert.Equal(t, "uid=mallory,dc=example,dc=com", user.ExternalId)
+
+	directory.entries[2] = ldap.NewEntry("uid=mallory,ou=new,dc=example,dc=com", nil)
+	directory.passwords["uid=mallory,ou=new,dc=example,dc=com"] = "mallory-password"
+	_, err = checker.CheckPassword("mallory", "mallory-password")
+	assert.NotNil(t, err)
+}
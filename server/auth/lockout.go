@@ -0,0 +1,230 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package auth
+
+import (
+	"fmt"
+	"github.com/jasonish/evebox/appcontext"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// LockedOutError is returned when a login is refused because the username
+// or remote address has too many recent failed logins.
+type LockedOutError struct {
+	Until time.Time
+}
+
+func (e *LockedOutError) Error() string {
+	return "too many failed logins, try again later"
+}
+
+// Lockout counts failed logins by username and remote address, locking
+// them out for an exponentially increasing time once a threshold is
+// reached.
+type Lockout struct {
+	config     appcontext.LockoutConfig
+	store      core.LoginFailureStore
+	auditStore core.AuditStore
+	lock       sync.Mutex
+
+	// For tests.
+	now func() time.Time
+}
+
+func NewLockout(config appcontext.LockoutConfig, store core.LoginFailureStore,
+	auditStore core.AuditStore) *Lockout {
+	return &Lockout{
+		config:     config,
+		store:      store,
+		auditStore: auditStore,
+		now:        time.Now,
+	}
+}
+
+// RemoteHost returns the address of the client without the port.
+func RemoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (l *Lockout) threshold(kind string) int {
+	if kind == core.LOCKOUT_USERNAME {
+		return l.config.UsernameThreshold
+	}
+	return l.config.AddressThreshold
+}
+
+// Check returns a LockedOutError if the username or address is locked
+// out. It should be called before checking the password.
+func (l *Lockout) Check(username string, addr string) error {
+	now := l.now()
+	for _, key := range [][2]string{
+		{core.LOCKOUT_USERNAME, username},
+		{core.LOCKOUT_ADDRESS, addr},
+	} {
+		if key[1] == "" || l.threshold(key[0]) <= 0 {
+			continue
+		}
+		failures, err := l.store.GetLoginFailures(key[0], key[1])
+		if err != nil {
+			log.Error("Failed to get login failures: %v", err)
+			continue
+		}
+		if failures.IsLocked(now) {
+			return &LockedOutError{Until: *failures.LockedUntil}
+		}
+	}
+	return nil
+}
+
+// RecordFailure records a failed login for the username and address.
+func (l *Lockout) RecordFailure(username string, addr string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.recordFailure(core.LOCKOUT_USERNAME, username, addr)
+	l.recordFailure(core.LOCKOUT_ADDRESS, addr, addr)
+}
+
+func (l *Lockout) recordFailure(kind string, value string, addr string) {
+	threshold := l.threshold(kind)
+	if value == "" || threshold <= 0 {
+		return
+	}
+	now := l.now()
+	failures, err := l.store.GetLoginFailures(kind, value)
+	if err != nil {
+		log.Error("Failed to get login failures: %v", err)
+		return
+	}
+	if failures.IsLocked(now) {
+		// Already locked, the password wasn't checked.
+		return
+	}
+	if now.Sub(failures.LastFailure) > l.config.ResetAfter {
+		failures.Count = 0
+	}
+	failures.Count++
+	failures.LastFailure = now
+	failures.LockedUntil = nil
+
+	if failures.Count >= threshold {
+		until := now.Add(l.delay(failures.Count - threshold))
+		failures.LockedUntil = &until
+		log.Warning("Locking out %s %s until %s after %d failed logins",
+			kind, value, until.Format(time.RFC3339), failures.Count)
+		l.audit(core.AuditEntry{
+			RemoteAddr: addr,
+			Action:     core.AUDIT_LOCKOUT,
+			Target:     fmt.Sprintf("%s:%s", kind, value),
+			Details: fmt.Sprintf("%d failed logins, locked until %s",
+				failures.Count, until.UTC().Format(time.RFC3339)),
+		})
+	}
+
+	if err := l.store.SaveLoginFailures(failures); err != nil {
+		log.Error("Failed to save login failures: %v", err)
+	}
+}
+
+// delay returns the lockout time after the given number of failures past
+// the threshold.
+func (l *Lockout) delay(extra int) time.Duration {
+	delay := l.config.Delay
+	for i := 0; i < extra && delay < l.config.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > l.config.MaxDelay {
+		delay = l.config.MaxDelay
+	}
+	return delay
+}
+
+// RecordSuccess clears the failures of a username after a successful
+// login. Failures by address are left to expire, so one known password
+// doesn't reset the count for guessing others.
+func (l *Lockout) RecordSuccess(username string) {
+	err := l.store.DeleteLoginFailures(core.LOCKOUT_USERNAME, username)
+	if err != nil && err != core.ErrNoLoginFailures {
+		log.Error("Failed to clear login failures: %v", err)
+	}
+}
+
+// Reap deletes failures that would be reset by the next failure and are
+// no longer locked out, so failed logins with random usernames don't
+// accumulate.
+func (l *Lockout) Reap() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.now()
+	count, err := l.store.DeleteExpiredLoginFailures(
+		now.Add(-l.config.ResetAfter), now)
+	if err != nil {
+		log.Error("Failed to delete expired login failures: %v", err)
+		return
+	}
+	if count > 0 {
+		log.Debug("Deleted %d expired login failures", count)
+	}
+}
+
+func (l *Lockout) audit(entry core.AuditEntry) {
+	if l.auditStore == nil {
+		return
+	}
+	if err := l.auditStore.AddAuditEntry(entry); err != nil {
+		log.Error("Failed to add audit entry: %v", err)
+	}
+}
+
+// Unlock clears the failures of a username or address, recording who did
+// it in the audit log.
+func Unlock(store core.LoginFailureStore, auditStore core.AuditStore,
+	kind string, value string, username string, remoteAddr string) error {
+	if err := store.DeleteLoginFailures(kind, value); err != nil {
+		return err
+	}
+	if auditStore != nil {
+		err := auditStore.AddAuditEntry(core.AuditEntry{
+			Username:   username,
+			RemoteAddr: remoteAddr,
+			Action:     core.AUDIT_UNLOCK,
+			Target:     fmt.Sprintf("%s:%s", kind, value),
+		})
+		if err != nil {
+			log.Error("Failed to add audit entry: %v", err)
+		}
+	}
+	return nil
+}
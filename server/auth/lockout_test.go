@@ -0,0 +1,254 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package auth
+
+import (
+	"github.com/jasonish/evebox/appcontext"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/server/sessions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type memoryLoginFailureStore struct {
+	failures map[string]core.LoginFailures
+}
+
+func (s *memoryLoginFailureStore) GetLoginFailures(kind string, value string) (core.LoginFailures, error) {
+	failures, ok := s.failures[kind+":"+value]
+	if !ok {
+		return core.LoginFailures{Kind: kind, Value: value}, nil
+	}
+	return failures, nil
+}
+
+func (s *memoryLoginFailureStore) SaveLoginFailures(failures core.LoginFailures) error {
+	s.failures[failures.Kind+":"+failures.Value] = failures
+	return nil
+}
+
+func (s *memoryLoginFailureStore) DeleteLoginFailures(kind string, value string) error {
+	if _, ok := s.failures[kind+":"+value]; !ok {
+		return core.ErrNoLoginFailures
+	}
+	delete(s.failures, kind+":"+value)
+	return nil
+}
+
+func (s *memoryLoginFailureStore) FindLockouts() ([]core.LoginFailures, error) {
+	lockouts := []core.LoginFailures{}
+	for _, failures := range s.failures {
+		if failures.IsLocked(time.Now()) {
+			lockouts = append(lockouts, failures)
+		}
+	}
+	return lockouts, nil
+}
+
+func (s *memoryLoginFailureStore) DeleteExpiredLoginFailures(before time.Time, now time.Time) (int64, error) {
+	count := int64(0)
+	for key, failures := range s.failures {
+		if failures.LastFailure.Before(before) && !failures.IsLocked(now) {
+			delete(s.failures, key)
+			count++
+		}
+	}
+	return count, nil
+}
+
+type memoryAuditStore struct {
+	entries []core.AuditEntry
+}
+
+func (s *memoryAuditStore) AddAuditEntry(entry core.AuditEntry) error {
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func (s *memoryAuditStore) FindAuditEntries(limit int) ([]core.AuditEntry, error) {
+	return s.entries, nil
+}
+
+func newTestLockout(now *time.Time) (*Lockout, *memoryLoginFailureStore, *memoryAuditStore) {
+	store := &memoryLoginFailureStore{failures: map[string]core.LoginFailures{}}
+	auditStore := &memoryAuditStore{}
+	lockout := NewLockout(appcontext.LockoutConfig{
+		Enabled:           true,
+		UsernameThreshold: 3,
+		AddressThreshold:  10,
+		Delay:             time.Minute,
+		MaxDelay:          5 * time.Minute,
+		ResetAfter:        time.Hour,
+	}, store, auditStore)
+	lockout.now = func() time.Time {
+		return *now
+	}
+	return lockout, store, auditStore
+}
+
+func TestLockoutBackoff(t *testing.T) {
+	now := time.Now()
+	lockout, store, auditStore := newTestLockout(&now)
+
+	for i := 0; i < 2; i++ {
+		lockout.RecordFailure("admin", "10.16.1.10")
+		assert.Nil(t, lockout.Check("admin", "10.16.1.10"))
+	}
+
+	lockout.RecordFailure("admin", "10.16.1.10")
+	err := lockout.Check("admin", "10.16.1.11")
+	require.IsType(t, &LockedOutError{}, err)
+	assert.Equal(t, now.Add(time.Minute), err.(*LockedOutError).Until)
+	require.Len(t, auditStore.entries, 1)
+	assert.Equal(t, core.AUDIT_LOCKOUT, auditStore.entries[0].Action)
+	assert.Equal(t, "username:admin", auditStore.entries[0].Target)
+
+	// Other usernames are not locked out.
+	assert.Nil(t, lockout.Check("other", "10.16.1.10"))
+
+	// Failures while locked out don't extend the lockout.
+	lockout.RecordFailure("admin", "10.16.1.10")
+	failures, _ := store.GetLoginFailures(core.LOCKOUT_USERNAME, "admin")
+	assert.Equal(t, 3, failures.Count)
+
+	// Each failure after the lockout doubles it, up to the maximum.
+	expected := []time.Duration{
+		2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute,
+	}
+	for _, delay := range expected {
+		now = now.Add(10 * time.Minute)
+		assert.Nil(t, lockout.Check("admin", "10.16.1.10"))
+		lockout.RecordFailure("admin", "10.16.1.10")
+		err := lockout.Check("admin", "10.16.1.10")
+		require.IsType(t, &LockedOutError{}, err)
+		assert.Equal(t, now.Add(delay), err.(*LockedOutError).Until)
+	}
+
+	// Failures are forgotten after the reset time.
+	now = now.Add(2 * time.Hour)
+	lockout.RecordFailure("admin", "10.16.1.10")
+	failures, _ = store.GetLoginFailures(core.LOCKOUT_USERNAME, "admin")
+	assert.Equal(t, 1, failures.Count)
+	assert.Nil(t, lockout.Check("admin", "10.16.1.10"))
+
+	// A successful login clears the username, but not the address.
+	lockout.RecordSuccess("admin")
+	failures, _ = store.GetLoginFailures(core.LOCKOUT_USERNAME, "admin")
+	assert.Equal(t, 0, failures.Count)
+	failures, _ = store.GetLoginFailures(core.LOCKOUT_ADDRESS, "10.16.1.10")
+	assert.NotEqual(t, 0, failures.Count)
+}
+
+func TestLockoutByAddress(t *testing.T) {
+	now := time.Now()
+	lockout, _, _ := newTestLockout(&now)
+
+	// Guessing different usernames from one address.
+	for i := 0; i < 10; i++ {
+		lockout.RecordFailure(string(rune('a'+i)), "10.16.1.10")
+	}
+	assert.IsType(t, &LockedOutError{}, lockout.Check("new-user", "10.16.1.10"))
+	assert.Nil(t, lockout.Check("new-user", "10.16.1.11"))
+}
+
+func TestUnlock(t *testing.T) {
+	now := time.Now()
+	lockout, store, auditStore := newTestLockout(&now)
+
+	for i := 0; i < 3; i++ {
+		lockout.RecordFailure("admin", "10.16.1.10")
+	}
+	require.NotNil(t, lockout.Check("admin", ""))
+
+	require.Nil(t, Unlock(store, auditStore, core.LOCKOUT_USERNAME, "admin",
+		"root", "10.16.1.20"))
+	assert.Nil(t, lockout.Check("admin", ""))
+	last := auditStore.entries[len(auditStore.entries)-1]
+	assert.Equal(t, core.AUDIT_UNLOCK, last.Action)
+	assert.Equal(t, "root", last.Username)
+
+	assert.Equal(t, core.ErrNoLoginFailures, Unlock(store, auditStore,
+		core.LOCKOUT_USERNAME, "admin", "root", ""))
+}
+
+func TestLoginLockout(t *testing.T) {
+	userStore := &memoryUserStore{users: map[string]core.User{}}
+	userStore.AddUser(core.User{Username: "alice"}, "alice-password")
+
+	now := time.Now()
+	lockout, _, _ := newTestLockout(&now)
+	a := NewUsernamePasswordAuthenticator(sessions.NewSessionStore(), userStore)
+	a.SetLockout(lockout)
+
+	for i := 0; i < 3; i++ {
+		_, err := a.Login(loginRequest(url.Values{
+			"username": {"alice"}, "password": {"wrong"},
+		}))
+		require.NotNil(t, err)
+	}
+
+	// Locked out, even with the right password.
+	_, err := a.Login(loginRequest(url.Values{
+		"username": {"alice"}, "password": {"alice-password"},
+	}))
+	assert.IsType(t, &LockedOutError{}, err)
+
+	now = now.Add(2 * time.Minute)
+	session, err := a.Login(loginRequest(url.Values{
+		"username": {"alice"}, "password": {"alice-password"},
+	}))
+	require.Nil(t, err)
+	assert.Equal(t, "alice", session.User.Username)
+}
+
+func TestLockoutReap(t *testing.T) {
+	now := time.Now()
+	lockout, store, _ := newTestLockout(&now)
+
+	lockout.RecordFailure("alice", "10.1.1.1")
+	for i := 0; i < 3; i++ {
+		lockout.RecordFailure("bob", "10.1.1.2")
+	}
+	assert.Len(t, store.failures, 4)
+
+	// Nothing has expired yet.
+	lockout.Reap()
+	assert.Len(t, store.failures, 4)
+
+	// Once reset the failures are deleted, unless still locked out.
+	now = now.Add(2 * time.Hour)
+	failures := store.failures["username:bob"]
+	until := now.Add(time.Minute)
+	failures.LockedUntil = &until
+	store.failures["username:bob"] = failures
+	lockout.Reap()
+	assert.Len(t, store.failures, 1)
+	assert.Contains(t, store.failures, "username:bob")
+}
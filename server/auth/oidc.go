@@ -0,0 +1,412 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/jasonish/evebox/appcontext"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/server/sessions"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const oidcDefaultUsernameClaim = "preferred_username"
+
+// How long a user has to complete a login at the provider.
+const oidcLoginTimeout = 10 * time.Minute
+
+// Allowed clock difference between EveBox and the provider when checking
+// the ID token expiry time.
+const oidcClockSkew = time.Minute
+
+// A login that has been sent to the provider, by state parameter.
+type oidcPendingLogin struct {
+	sessionId string
+	verifier  string
+	nonce     string
+	created   time.Time
+}
+
+// OidcAuthenticator authenticates users with a generic OpenID Connect
+// provider, using the authorization code flow with PKCE.
+type OidcAuthenticator struct {
+	config       appcontext.OidcAuthConfig
+	verifier     *oidc.IDTokenVerifier
+	oauthConfig  *oauth2.Config
+	SessionStore *sessions.SessionStore
+	userStore    core.UserStore
+	httpClient   *http.Client
+
+	lock    sync.Mutex
+	pending map[string]oidcPendingLogin
+}
+
+// NewOidc creates an OpenID Connect authenticator, discovering the
+// provider endpoints from the issuer.
+func NewOidc(config appcontext.OidcAuthConfig, userStore core.UserStore) (*OidcAuthenticator, error) {
+	if config.Issuer == "" {
+		return nil, errors.New("OIDC issuer required")
+	}
+	if config.ClientID == "" {
+		return nil, errors.New("OIDC client ID required")
+	}
+	if config.Callback == "" {
+		return nil, errors.New("OIDC callback URL required")
+	}
+	if config.UsernameClaim == "" {
+		config.UsernameClaim = oidcDefaultUsernameClaim
+	}
+	if config.DefaultRole != "" && !core.IsValidRole(config.DefaultRole) {
+		return nil, errors.Errorf("invalid OIDC default role: %s",
+			config.DefaultRole)
+	}
+	for role := range config.Roles {
+		if !core.IsValidRole(role) {
+			return nil, errors.Errorf("invalid OIDC role: %s", role)
+		}
+	}
+
+	o := &OidcAuthenticator{
+		config:     config,
+		userStore:  userStore,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		pending:    map[string]oidcPendingLogin{},
+	}
+
+	// The provider keeps the context to fetch its keys with later.
+	provider, err := oidc.NewProvider(o.context(), config.Issuer)
+	if err != nil {
+		return nil, errors.Wrap(err, "OIDC discovery failed")
+	}
+	o.verifier = provider.Verifier(&oidc.Config{
+		ClientID: config.ClientID,
+		Now: func() time.Time {
+			return time.Now().Add(-oidcClockSkew)
+		},
+	})
+
+	o.oauthConfig = &oauth2.Config{
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret,
+		RedirectURL:  config.Callback,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       append([]string{oidc.ScopeOpenID}, config.Scopes...),
+	}
+
+	return o, nil
+}
+
+// context returns a context for requests to the provider, using our own
+// HTTP client.
+func (o *OidcAuthenticator) context() context.Context {
+	return oidc.ClientContext(context.Background(), o.httpClient)
+}
+
+func randomString() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// pkceChallenge returns the S256 code challenge for a verifier.
+func pkceChallenge(verifier string) string {
+	hash := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// Handler starts a login, returning the URL to redirect the user to as
+// JSON.
+func (o *OidcAuthenticator) Handler(w http.ResponseWriter, r *http.Request) {
+	session := o.SessionStore.FindSession(r)
+	if session == nil {
+		log.Info("Creating session for OIDC authentication request.")
+		session = o.SessionStore.NewSession()
+		o.SessionStore.Put(session)
+		w.Header().Set(o.SessionStore.Header, session.Id)
+	}
+
+	if r.FormValue("fail-redirect") != "" {
+		session.Other["oidc-fail-redirect"] = r.FormValue("fail-redirect")
+	}
+
+	if r.FormValue("success-redirect") != "" {
+		session.Other["oidc-success-redirect"] =
+			r.FormValue("success-redirect")
+	}
+
+	state, err := randomString()
+	if err != nil {
+		o.handleError(w, r, session, http.StatusInternalServerError,
+			"Failed to start login.")
+		return
+	}
+	verifier, err := randomString()
+	if err != nil {
+		o.handleError(w, r, session, http.StatusInternalServerError,
+			"Failed to start login.")
+		return
+	}
+	nonce, err := randomString()
+	if err != nil {
+		o.handleError(w, r, session, http.StatusInternalServerError,
+			"Failed to start login.")
+		return
+	}
+
+	o.lock.Lock()
+	now := time.Now()
+	for key, pending := range o.pending {
+		if now.Sub(pending.created) > oidcLoginTimeout {
+			delete(o.pending, key)
+		}
+	}
+	o.pending[state] = oidcPendingLogin{
+		sessionId: session.Id,
+		verifier:  verifier,
+		nonce:     nonce,
+		created:   now,
+	}
+	o.lock.Unlock()
+
+	url := o.oauthConfig.AuthCodeURL(state,
+		oauth2.SetAuthURLParam("code_challenge", pkceChallenge(verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+		oauth2.SetAuthURLParam("nonce", nonce))
+
+	encoder := json.NewEncoder(w)
+	encoder.Encode(map[string]interface{}{
+		"redirect": url,
+	})
+}
+
+func (o *OidcAuthenticator) handleError(w http.ResponseWriter, r *http.Request, session *sessions.Session, status int, message string) {
+	if session != nil {
+		redirectUrl, ok := session.Other["oidc-fail-redirect"].(string)
+		if ok {
+			redirectUrl = fmt.Sprintf("%s;error=%s", redirectUrl,
+				message)
+			http.Redirect(w, r, redirectUrl, http.StatusTemporaryRedirect)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(status)
+	w.Write([]byte(message))
+}
+
+// Callback completes a login when the provider redirects the user back
+// to EveBox.
+func (o *OidcAuthenticator) Callback(w http.ResponseWriter, r *http.Request) {
+	state := r.FormValue("state")
+
+	o.lock.Lock()
+	pending, ok := o.pending[state]
+	delete(o.pending, state)
+	o.lock.Unlock()
+
+	if !ok || time.Since(pending.created) > oidcLoginTimeout {
+		log.Error("Did not find pending login for OIDC callback.")
+		o.handleError(w, r, nil, http.StatusUnauthorized,
+			"No pending OIDC login.")
+		return
+	}
+
+	session := o.SessionStore.Get(pending.sessionId)
+	if session == nil {
+		log.Error("Did not find session for OIDC callback.")
+		o.handleError(w, r, nil, http.StatusUnauthorized,
+			"No session for OIDC authentication.")
+		return
+	}
+
+	if errorCode := r.FormValue("error"); errorCode != "" {
+		log.Error("OIDC provider returned error: %s: %s", errorCode,
+			r.FormValue("error_description"))
+		o.handleError(w, r, session, http.StatusUnauthorized,
+			"Login failed at the identity provider.")
+		return
+	}
+
+	token, err := o.oauthConfig.Exchange(o.context(), r.FormValue("code"),
+		oauth2.SetAuthURLParam("code_verifier", pending.verifier))
+	if err != nil {
+		log.Error("OIDC exchange failed: %v", err)
+		o.handleError(w, r, session, http.StatusUnauthorized,
+			"OIDC exchange failed.")
+		return
+	}
+
+	rawIdToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		log.Error("OIDC token response has no ID token")
+		o.handleError(w, r, session, http.StatusUnauthorized,
+			"Login failed: No ID token received.")
+		return
+	}
+
+	claims, err := o.verifyIdToken(rawIdToken, pending.nonce)
+	if err != nil {
+		log.Error("Invalid OIDC ID token: %v", err)
+		o.handleError(w, r, session, http.StatusUnauthorized,
+			"Login failed: Invalid ID token.")
+		return
+	}
+
+	user, err := o.findUser(claims)
+	if err != nil {
+		log.Error("OIDC login denied: %v", err)
+		o.handleError(w, r, session, http.StatusUnauthorized,
+			"Access denied.")
+		return
+	}
+
+	session.User = user
+	session.RemoteAddr = r.RemoteAddr
+	o.SessionStore.Put(session)
+
+	log.Info("User %s logged in (via OIDC) from %s", user.Username,
+		r.RemoteAddr)
+
+	redirectUrl, ok := session.Other["oidc-success-redirect"].(string)
+	if ok {
+		http.Redirect(w, r, redirectUrl, http.StatusTemporaryRedirect)
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+}
+
+// verifyIdToken verifies the signature and claims of an ID token,
+// returning the claims.
+func (o *OidcAuthenticator) verifyIdToken(rawIdToken string, nonce string) (map[string]interface{}, error) {
+	idToken, err := o.verifier.Verify(o.context(), rawIdToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// The audience has been checked to include us, if there are other
+	// audiences we must also be the authorized party.
+	if len(idToken.Audience) > 1 {
+		var azp struct {
+			AuthorizedParty string `json:"azp"`
+		}
+		if err := idToken.Claims(&azp); err != nil {
+			return nil, err
+		}
+		if azp.AuthorizedParty != o.config.ClientID {
+			return nil, errors.New("token was not issued for this client")
+		}
+	}
+
+	if idToken.Nonce != nonce {
+		return nil, errors.New("token nonce does not match")
+	}
+	if idToken.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+
+	claims := map[string]interface{}{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// claimStrings returns the value of a claim that may be a string or an
+// array of strings.
+func claimStrings(value interface{}) []string {
+	switch value := value.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := []string{}
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// findUser finds, or provisions, the user for the claims of an ID token.
+// Users are matched on the issuer and subject, the username claim is only
+// used to name new users. If roles are mapped from a claim, the user's
+// role is updated to match.
+func (o *OidcAuthenticator) findUser(claims map[string]interface{}) (core.User, error) {
+	username, _ := claims[o.config.UsernameClaim].(string)
+	if username == "" {
+		return core.User{}, errors.Errorf("token has no %s claim",
+			o.config.UsernameClaim)
+	}
+
+	role := ""
+	if o.config.RoleClaim != "" {
+		role = mapRole(claimStrings(claims[o.config.RoleClaim]),
+			o.config.Roles)
+		if role == "" {
+			role = o.config.DefaultRole
+		}
+		if role == "" {
+			return core.User{}, errors.Errorf("user %s has no role", username)
+		}
+	}
+
+	issuer, _ := claims["iss"].(string)
+	subject, _ := claims["sub"].(string)
+	user := core.User{
+		Username:   username,
+		Provider:   core.PROVIDER_OIDC,
+		ExternalId: oidcExternalId(issuer, subject),
+	}
+	user.FullName, _ = claims["name"].(string)
+	user.Email, _ = claims["email"].(string)
+
+	return syncUser(o.userStore, user, role, o.config.DefaultRole,
+		o.config.AutoProvision, "OIDC")
+}
+
+// oidcExternalId returns the identity of an OIDC user, the subject is only
+// unique for the issuer. Issuer URLs can't contain a space.
+func oidcExternalId(issuer string, subject string) string {
+	return issuer + " " + subject
+}
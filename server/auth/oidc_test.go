@@ -0,0 +1,474 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/go-jose/go-jose/v3"
+	"github.com/jasonish/evebox/appcontext"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/server/sessions"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A user store that holds users in a map, by username.
+type memoryUserStore struct {
+	users     map[string]core.User
+	passwords map[string]string
+}
+
+func (s *memoryUserStore) AddUser(user core.User, password string) (string, error) {
+	user.Id = "id-" + user.Username
+	s.users[user.Username] = user
+	if password != "" {
+		if s.passwords == nil {
+			s.passwords = map[string]string{}
+		}
+		s.passwords[user.Username] = password
+	}
+	return user.Id, nil
+}
+
+func (s *memoryUserStore) FindByUsername(username string) (core.User, error) {
+	user, ok := s.users[username]
+	if !ok {
+		return user, core.ErrNoUsername
+	}
+	return user, nil
+}
+
+func (s *memoryUserStore) FindByUsernamePassword(username string, password string) (core.User, error) {
+	if password == "" || s.passwords[username] != password {
+		return core.User{}, errors.New("bad username or password")
+	}
+	if s.users[username].Disabled {
+		return core.User{}, core.ErrUserDisabled
+	}
+	return s.users[username], nil
+}
+
+func (s *memoryUserStore) FindByGitHubUsername(username string) (core.User, error) {
+	return core.User{}, core.ErrNoUsername
+}
+
+func (s *memoryUserStore) FindByExternalId(provider string, externalId string) (core.User, error) {
+	for _, user := range s.users {
+		if user.Provider == provider && user.ExternalId == externalId {
+			return user, nil
+		}
+	}
+	return core.User{}, core.ErrNoUsername
+}
+
+func (s *memoryUserStore) LinkExternalId(username string, provider string, externalId string) error {
+	user, ok := s.users[username]
+	if !ok {
+		return core.ErrNoUsername
+	}
+	if s.passwords[username] != "" || user.GitHubUsername != "" ||
+		user.ExternalId != "" ||
+		(user.Provider != "" && user.Provider != provider) {
+		return core.ErrUserNotLinkable
+	}
+	user.Provider = provider
+	user.ExternalId = externalId
+	s.users[username] = user
+	return nil
+}
+
+func (s *memoryUserStore) UpdatePassword(username string, password string) error {
+	return nil
+}
+
+func (s *memoryUserStore) ResetPassword(username string, password string) error {
+	return nil
+}
+
+func (s *memoryUserStore) FindAll() ([]core.User, error) {
+	users := []core.User{}
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func (s *memoryUserStore) SetRole(username string, role string) error {
+	user, ok := s.users[username]
+	if !ok {
+		return core.ErrNoUsername
+	}
+	user.Role = role
+	s.users[username] = user
+	return nil
+}
+
+func (s *memoryUserStore) UpdateUser(user core.User) error {
+	existing, ok := s.users[user.Username]
+	if !ok {
+		return core.ErrNoUsername
+	}
+	user.Id = existing.Id
+	user.Disabled = existing.Disabled
+	s.users[user.Username] = user
+	return nil
+}
+
+func (s *memoryUserStore) SetDisabled(username string, disabled bool) error {
+	user, ok := s.users[username]
+	if !ok {
+		return core.ErrNoUsername
+	}
+	user.Disabled = disabled
+	s.users[username] = user
+	return nil
+}
+
+func (s *memoryUserStore) DeleteByUsername(username string) error {
+	if _, ok := s.users[username]; !ok {
+		return core.ErrNoUsername
+	}
+	delete(s.users, username)
+	return nil
+}
+
+// mockIdp is an OpenID Connect provider that issues an ID token with the
+// configured claims for any code, if the PKCE verifier matches.
+type mockIdp struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	// Set from the authorization request.
+	challenge string
+	nonce     string
+
+	claims map[string]interface{}
+}
+
+func newMockIdp(t *testing.T) *mockIdp {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	idp := &mockIdp{
+		key:    key,
+		claims: map[string]interface{}{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{
+			Keys: []jose.JSONWebKey{{
+				Key:       &key.PublicKey,
+				KeyID:     "key1",
+				Algorithm: string(jose.RS256),
+				Use:       "sig",
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if pkceChallenge(r.FormValue("code_verifier")) != idp.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "invalid_grant",
+			})
+			return
+		}
+		claims := map[string]interface{}{
+			"iss":   idp.server.URL,
+			"sub":   "subject",
+			"aud":   "evebox",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"nonce": idp.nonce,
+		}
+		for key, value := range idp.claims {
+			claims[key] = value
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     idp.sign(t, "key1", claims),
+		})
+	})
+	idp.server = httptest.NewServer(mux)
+	return idp
+}
+
+func (idp *mockIdp) sign(t *testing.T, kid string, claims map[string]interface{}) string {
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.RS256,
+		Key:       jose.JSONWebKey{Key: idp.key, KeyID: kid},
+	}, nil)
+	require.Nil(t, err)
+	payload, err := json.Marshal(claims)
+	require.Nil(t, err)
+	signed, err := signer.Sign(payload)
+	require.Nil(t, err)
+	token, err := signed.CompactSerialize()
+	require.Nil(t, err)
+	return token
+}
+
+// login runs a login through the authenticator, returning the callback
+// response and the session.
+func (idp *mockIdp) login(t *testing.T, o *OidcAuthenticator) (*httptest.ResponseRecorder, *sessions.Session) {
+	w := httptest.NewRecorder()
+	o.Handler(w, httptest.NewRequest("GET", "/auth/oidc", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	sessionId := w.Header().Get(o.SessionStore.Header)
+	require.NotEmpty(t, sessionId)
+
+	var response map[string]string
+	require.Nil(t, json.NewDecoder(w.Body).Decode(&response))
+	redirect, err := url.Parse(response["redirect"])
+	require.Nil(t, err)
+	query := redirect.Query()
+	assert.Equal(t, "S256", query.Get("code_challenge_method"))
+	assert.Equal(t, "evebox", query.Get("client_id"))
+	idp.challenge = query.Get("code_challenge")
+	idp.nonce = query.Get("nonce")
+
+	w = httptest.NewRecorder()
+	o.Callback(w, httptest.NewRequest("GET",
+		"/auth/oidc/callback?code=code&state="+query.Get("state"), nil))
+	return w, o.SessionStore.Get(sessionId)
+}
+
+func newTestOidc(t *testing.T, idp *mockIdp, config appcontext.OidcAuthConfig, userStore core.UserStore) *OidcAuthenticator {
+	config.Issuer = idp.server.URL
+	config.ClientID = "evebox"
+	config.Callback = "http://localhost/auth/oidc/callback"
+	o, err := NewOidc(config, userStore)
+	require.Nil(t, err)
+	o.SessionStore = sessions.NewSessionStore()
+	o.SessionStore.Header = "x-evebox-session-id"
+	return o
+}
+
+func TestOidcLogin(t *testing.T) {
+	idp := newMockIdp(t)
+	defer idp.server.Close()
+
+	userStore := &memoryUserStore{users: map[string]core.User{
+		"alice": {Id: "1", Username: "alice", Role: core.ROLE_VIEWER},
+	}}
+	o := newTestOidc(t, idp, appcontext.OidcAuthConfig{}, userStore)
+
+	idp.claims["preferred_username"] = "alice"
+	idp.claims["sub"] = "alice-sub"
+	w, session := idp.login(t, o)
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	require.NotNil(t, session)
+	assert.Equal(t, "alice", session.User.Username)
+	assert.Equal(t, core.ROLE_VIEWER, session.User.Role)
+	assert.Equal(t, oidcExternalId(idp.server.URL, "alice-sub"),
+		userStore.users["alice"].ExternalId)
+
+	// Unknown users are denied without auto-provisioning.
+	idp.claims["preferred_username"] = "bob"
+	idp.claims["sub"] = "bob-sub"
+	w, session = idp.login(t, o)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.False(t, session.User.IsValid())
+
+	// A callback with an unknown state is rejected.
+	w = httptest.NewRecorder()
+	o.Callback(w, httptest.NewRequest("GET",
+		"/auth/oidc/callback?code=code&state=bad", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestOidcRolesAndProvisioning(t *testing.T) {
+	idp := newMockIdp(t)
+	defer idp.server.Close()
+
+	userStore := &memoryUserStore{users: map[string]core.User{
+		"alice": {Id: "1", Username: "alice", Role: core.ROLE_VIEWER},
+	}}
+	o := newTestOidc(t, idp, appcontext.OidcAuthConfig{
+		UsernameClaim: "email",
+		RoleClaim:     "groups",
+		Roles: map[string][]string{
+			core.ROLE_ADMIN:   {"evebox-admins"},
+			core.ROLE_ANALYST: {"soc"},
+		},
+		AutoProvision: true,
+	}, userStore)
+
+	// The highest mapped role is used, and stored.
+	idp.claims["email"] = "alice"
+	idp.claims["sub"] = "alice-sub"
+	idp.claims["groups"] = []string{"soc", "evebox-admins"}
+	_, session := idp.login(t, o)
+	require.NotNil(t, session)
+	assert.Equal(t, core.ROLE_ADMIN, session.User.Role)
+	assert.Equal(t, core.ROLE_ADMIN, userStore.users["alice"].Role)
+
+	// New users are provisioned with the mapped role.
+	idp.claims["email"] = "bob"
+	idp.claims["sub"] = "bob-sub"
+	idp.claims["name"] = "Bob"
+	idp.claims["groups"] = "soc"
+	_, session = idp.login(t, o)
+	assert.Equal(t, "bob", session.User.Username)
+	assert.Equal(t, core.ROLE_ANALYST, userStore.users["bob"].Role)
+	assert.Equal(t, "Bob", userStore.users["bob"].FullName)
+
+	// No mapped role and no default role is denied.
+	idp.claims["email"] = "carol"
+	idp.claims["sub"] = "carol-sub"
+	idp.claims["groups"] = []string{"staff"}
+	w, session := idp.login(t, o)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.False(t, session.User.IsValid())
+	_, ok := userStore.users["carol"]
+	assert.False(t, ok)
+}
+
+func TestOidcIdentity(t *testing.T) {
+	idp := newMockIdp(t)
+	defer idp.server.Close()
+
+	userStore := &memoryUserStore{users: map[string]core.User{
+		"alice": {Id: "1", Username: "alice", Role: core.ROLE_VIEWER,
+			Provider:   core.PROVIDER_OIDC,
+			ExternalId: oidcExternalId(idp.server.URL, "alice-sub")},
+		"ldap": {Id: "2", Username: "ldap", Role: core.ROLE_VIEWER,
+			Provider: core.PROVIDER_LDAP},
+	}}
+	userStore.AddUser(core.User{Username: "admin", Role: core.ROLE_ADMIN},
+		"admin-password")
+	o := newTestOidc(t, idp, appcontext.OidcAuthConfig{
+		RoleClaim:     "groups",
+		Roles:         map[string][]string{core.ROLE_VIEWER: {"staff"}},
+		AutoProvision: true,
+	}, userStore)
+	idp.claims["groups"] = "staff"
+
+	// A user with a local password can't be taken over by a provider
+	// user with the same username, and keeps their role.
+	idp.claims["preferred_username"] = "admin"
+	idp.claims["sub"] = "admin-sub"
+	w, session := idp.login(t, o)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.False(t, session.User.IsValid())
+	assert.Equal(t, core.ROLE_ADMIN, userStore.users["admin"].Role)
+	assert.Equal(t, "", userStore.users["admin"].ExternalId)
+
+	// Nor can a user of another provider.
+	idp.claims["preferred_username"] = "ldap"
+	idp.claims["sub"] = "ldap-sub"
+	w, _ = idp.login(t, o)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Or another OIDC user with the same username.
+	idp.claims["preferred_username"] = "alice"
+	idp.claims["sub"] = "other-sub"
+	w, _ = idp.login(t, o)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Users are found by their identity, even if their username claim
+	// changes.
+	idp.claims["preferred_username"] = "alice.smith"
+	idp.claims["sub"] = "alice-sub"
+	w, session = idp.login(t, o)
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	assert.Equal(t, "alice", session.User.Username)
+	_, ok := userStore.users["alice.smith"]
+	assert.False(t, ok)
+}
+
+func TestOidcVerifyIdToken(t *testing.T) {
+	idp := newMockIdp(t)
+	defer idp.server.Close()
+	o := newTestOidc(t, idp, appcontext.OidcAuthConfig{},
+		&memoryUserStore{users: map[string]core.User{}})
+
+	claims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":   idp.server.URL,
+			"sub":   "subject",
+			"aud":   "evebox",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"nonce": "nonce",
+		}
+	}
+
+	_, err := o.verifyIdToken(idp.sign(t, "key1", claims()), "nonce")
+	assert.Nil(t, err)
+
+	_, err = o.verifyIdToken(idp.sign(t, "key1", claims()), "other")
+	assert.NotNil(t, err)
+
+	expired := claims()
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	_, err = o.verifyIdToken(idp.sign(t, "key1", expired), "nonce")
+	assert.NotNil(t, err)
+
+	audience := claims()
+	audience["aud"] = []string{"other"}
+	_, err = o.verifyIdToken(idp.sign(t, "key1", audience), "nonce")
+	assert.NotNil(t, err)
+
+	issuer := claims()
+	issuer["iss"] = "https://evil.example.com"
+	_, err = o.verifyIdToken(idp.sign(t, "key1", issuer), "nonce")
+	assert.NotNil(t, err)
+
+	_, err = o.verifyIdToken(idp.sign(t, "unknown", claims()), "nonce")
+	assert.NotNil(t, err)
+
+	// Tampering with the payload breaks the signature.
+	payload, _ := json.Marshal(claims())
+	parts := strings.Split(idp.sign(t, "key1", claims()), ".")
+	parts[1] = base64.RawURLEncoding.EncodeToString(append(payload, ' '))
+	_, err = o.verifyIdToken(strings.Join(parts, "."), "nonce")
+	assert.NotNil(t, err)
+
+	// Unsigned tokens are rejected.
+	none, _ := json.Marshal(map[string]string{"alg": "none"})
+	_, err = o.verifyIdToken(base64.RawURLEncoding.EncodeToString(none)+
+		"."+base64.RawURLEncoding.EncodeToString(payload)+".", "nonce")
+	assert.NotNil(t, err)
+}
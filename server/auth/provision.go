@@ -0,0 +1,137 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package auth
+
+import (
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/log"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// mapRole returns the highest role granted by any of the values, where
+// roles maps each role to the values that grant it. Values are compared
+// without case. An empty string is returned if no role is granted.
+func mapRole(values []string, roles map[string][]string) string {
+	mapped := ""
+	for _, value := range values {
+		for role, roleValues := range roles {
+			for _, roleValue := range roleValues {
+				if !strings.EqualFold(value, roleValue) {
+					continue
+				}
+				if mapped == "" || (core.User{Role: role}).HasRole(mapped) {
+					mapped = role
+				}
+			}
+		}
+	}
+	return mapped
+}
+
+// syncUser finds the local user for a user authenticated by an external
+// provider, by the provider and identity set on the external user. A user
+// that doesn't exist is added when provision is true, unless the username
+// is taken by a user that can log in some other way, such as with a local
+// password. If role is not empty the user's role is updated to match.
+func syncUser(userStore core.UserStore, external core.User, role string,
+	defaultRole string, provision bool, provider string) (core.User, error) {
+	if external.Provider == "" || external.ExternalId == "" {
+		return core.User{}, errors.Errorf("%s user %s has no identity",
+			provider, external.Username)
+	}
+	user, err := userStore.FindByExternalId(external.Provider,
+		external.ExternalId)
+	if err == core.ErrNoUsername {
+		user, err = linkUser(userStore, external, provider)
+	}
+	if err == core.ErrNoUsername {
+		if !provision {
+			return user, errors.Errorf("user %s does not exist",
+				external.Username)
+		}
+		if role == "" {
+			role = defaultRole
+		}
+		if role == "" {
+			role = core.ROLE_VIEWER
+		}
+		external.Role = role
+		if _, err := userStore.AddUser(external, ""); err != nil {
+			return user, errors.Wrap(err, "failed to add user")
+		}
+		log.Info("Added user %s with role %s from %s login",
+			external.Username, role, provider)
+		return userStore.FindByExternalId(external.Provider,
+			external.ExternalId)
+	}
+	if err != nil {
+		return user, err
+	}
+	if user.Disabled {
+		return user, core.ErrUserDisabled
+	}
+
+	if role != "" && role != user.Role {
+		if err := userStore.SetRole(user.Username, role); err != nil {
+			return user, errors.Wrap(err, "failed to update role")
+		}
+		log.Info("Updated role of user %s to %s from %s login",
+			user.Username, role, provider)
+		user.Role = role
+	}
+
+	return user, nil
+}
+
+// linkUser links an existing user with the username of the external user
+// to its identity. This is how users added by an admin, or before
+// identities were stored, are matched on their first login.
+func linkUser(userStore core.UserStore, external core.User, provider string) (core.User, error) {
+	user, err := userStore.FindByUsername(external.Username)
+	if err != nil {
+		return user, err
+	}
+	if user.Disabled {
+		return user, core.ErrUserDisabled
+	}
+	err = userStore.LinkExternalId(user.Username, external.Provider,
+		external.ExternalId)
+	if err == core.ErrUserNotLinkable {
+		return user, errors.Errorf(
+			"user %s exists and can not log in with %s", user.Username,
+			provider)
+	}
+	if err != nil {
+		return user, errors.Wrap(err, "failed to link user")
+	}
+	log.Info("Linked user %s to %s identity %s", user.Username, provider,
+		external.ExternalId)
+	user.Provider = external.Provider
+	user.ExternalId = external.ExternalId
+	return user, nil
+}
@@ -0,0 +1,95 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package auth
+
+import (
+	"encoding/json"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/server/sessions"
+	"net/http"
+	"strings"
+)
+
+// TokenAuthenticator wraps another authenticator, accepting API tokens in
+// an "Authorization: Bearer" header in addition to whatever the wrapped
+// authenticator accepts.
+//
+// A token authenticated request gets a session that only lasts for the
+// request, it is not added to the session store.
+type TokenAuthenticator struct {
+	Authenticator
+	tokenStore core.ApiTokenStore
+}
+
+func NewTokenAuthenticator(authenticator Authenticator,
+	tokenStore core.ApiTokenStore) *TokenAuthenticator {
+	return &TokenAuthenticator{
+		Authenticator: authenticator,
+		tokenStore:    tokenStore,
+	}
+}
+
+// BearerToken returns the token from an "Authorization: Bearer" header,
+// or an empty string if there is none.
+func BearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[0:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+func (a *TokenAuthenticator) Authenticate(w http.ResponseWriter, r *http.Request) *sessions.Session {
+	token := BearerToken(r)
+	if token == "" {
+		return a.Authenticator.Authenticate(w, r)
+	}
+
+	user, err := a.tokenStore.FindUserByApiToken(token)
+	if err != nil {
+		if err != core.ErrBadApiToken {
+			log.Error("Failed to authenticate API token: %v", err)
+		} else {
+			log.Warning("Invalid API token from %s", r.RemoteAddr)
+		}
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": http.StatusUnauthorized,
+			"error": map[string]interface{}{
+				"message": core.ErrBadApiToken.Error(),
+			},
+		})
+		return nil
+	}
+
+	session := sessions.NewSession()
+	session.User = user
+	session.RemoteAddr = r.RemoteAddr
+	return session
+}
@@ -34,21 +34,111 @@ import (
 	"github.com/pkg/errors"
 	"github.com/spf13/viper"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 )
 
+// PasswordChecker checks a username and password, returning the user if
+// they are valid.
+type PasswordChecker interface {
+	CheckPassword(username string, password string) (core.User, error)
+}
+
+// userStorePasswordChecker checks passwords against the local user store.
+type userStorePasswordChecker struct {
+	userStore core.UserStore
+}
+
+func (c userStorePasswordChecker) CheckPassword(username string, password string) (core.User, error) {
+	return c.userStore.FindByUsernamePassword(username, password)
+}
+
+// How long a user has to enter their two-factor code after their
+// password, and how many attempts they get.
+const totpLoginTimeout = 5 * time.Minute
+const totpLoginAttempts = 5
+
+// A login waiting for a two-factor code, by login token.
+type totpPendingLogin struct {
+	user     core.User
+	created  time.Time
+	attempts int
+}
+
 type UsernamePasswordAuthenticator struct {
 	sessionStore *sessions.SessionStore
-	userStore    core.UserStore
+	checker      PasswordChecker
+
+	// If set, users with two-factor authentication enabled must also
+	// provide a code.
+	totpStore core.TotpStore
+
+	// If set, failed logins lock out the username and remote address.
+	lockout *Lockout
+
+	lock    sync.Mutex
+	pending map[string]*totpPendingLogin
 }
 
 func NewUsernamePasswordAuthenticator(sessionStore *sessions.SessionStore,
 	userStore core.UserStore) *UsernamePasswordAuthenticator {
+	return NewPasswordCheckerAuthenticator(sessionStore,
+		userStorePasswordChecker{userStore})
+}
+
+// NewPasswordCheckerAuthenticator creates a username and password
+// authenticator that checks passwords with the provided checker instead
+// of the user store.
+func NewPasswordCheckerAuthenticator(sessionStore *sessions.SessionStore,
+	checker PasswordChecker) *UsernamePasswordAuthenticator {
 	return &UsernamePasswordAuthenticator{
 		sessionStore: sessionStore,
-		userStore:    userStore,
+		checker:      checker,
+		pending:      map[string]*totpPendingLogin{},
 	}
 }
 
+func (a *UsernamePasswordAuthenticator) SetTotpStore(totpStore core.TotpStore) {
+	a.totpStore = totpStore
+}
+
+func (a *UsernamePasswordAuthenticator) SetLockout(lockout *Lockout) {
+	a.lockout = lockout
+}
+
+func (a *UsernamePasswordAuthenticator) checkLockout(username string, r *http.Request) error {
+	if a.lockout == nil {
+		return nil
+	}
+	return a.lockout.Check(username, RemoteHost(r))
+}
+
+func (a *UsernamePasswordAuthenticator) recordFailure(username string, r *http.Request) {
+	if a.lockout != nil {
+		a.lockout.RecordFailure(username, RemoteHost(r))
+	}
+}
+
+func (a *UsernamePasswordAuthenticator) recordSuccess(username string) {
+	if a.lockout != nil {
+		a.lockout.RecordSuccess(username)
+	}
+}
+
+// writeLockedOut responds to a locked out login with the time the client
+// can try again.
+func writeLockedOut(w http.ResponseWriter, err *LockedOutError) {
+	retryAfter := int(time.Until(err.Until).Seconds()) + 1
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  http.StatusTooManyRequests,
+		"message": err.Error(),
+	})
+}
+
 func (a *UsernamePasswordAuthenticator) WriteStatusUnauthorized(w http.ResponseWriter) {
 	w.WriteHeader(http.StatusUnauthorized)
 	encoder := json.NewEncoder(w)
@@ -70,7 +160,15 @@ func (a *UsernamePasswordAuthenticator) WriteStatusUnauthorized(w http.ResponseW
 	encoder.Encode(response)
 }
 
+// Login logs a user in with their username and password. If the user has
+// two-factor authentication enabled a code is also required, either in the
+// same request as "totp_code", or in a second request with the login token
+// from the TotpRequiredError returned by the first.
 func (a *UsernamePasswordAuthenticator) Login(r *http.Request) (*sessions.Session, error) {
+	if loginToken := r.FormValue("login_token"); loginToken != "" {
+		return a.loginWithTotp(r, loginToken)
+	}
+
 	username := r.FormValue("username")
 	if username == "" {
 		return nil, ErrNoUsername
@@ -81,18 +179,118 @@ func (a *UsernamePasswordAuthenticator) Login(r *http.Request) (*sessions.Sessio
 		return nil, ErrNoPassword
 	}
 
-	user, err := a.userStore.FindByUsernamePassword(username, password)
+	if err := a.checkLockout(username, r); err != nil {
+		return nil, err
+	}
+
+	user, err := a.checker.CheckPassword(username, password)
 	if err != nil {
+		a.recordFailure(username, r)
 		return nil, errors.Wrap(err, "bad username or password")
 	}
 
+	enabled, err := a.totpEnabled(user)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		code := r.FormValue("totp_code")
+		if code == "" {
+			loginToken, err := a.addPendingLogin(user)
+			if err != nil {
+				return nil, err
+			}
+			return nil, &TotpRequiredError{LoginToken: loginToken}
+		}
+		if err := a.totpStore.VerifyTotp(user, code); err != nil {
+			log.Warning("User %s failed two-factor authentication from %s",
+				user.Username, r.RemoteAddr)
+			a.recordFailure(username, r)
+			return nil, err
+		}
+	}
+
+	a.recordSuccess(username)
+	return a.newSession(r, user), nil
+}
+
+func (a *UsernamePasswordAuthenticator) newSession(r *http.Request, user core.User) *sessions.Session {
 	session := a.sessionStore.NewSession()
 	session.User = user
 	session.RemoteAddr = r.RemoteAddr
 
 	a.sessionStore.Put(session)
 
-	return session, nil
+	return session
+}
+
+func (a *UsernamePasswordAuthenticator) totpEnabled(user core.User) (bool, error) {
+	if a.totpStore == nil {
+		return false, nil
+	}
+	status, err := a.totpStore.GetTotpStatus(user)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get two-factor status")
+	}
+	return status.Enabled, nil
+}
+
+func (a *UsernamePasswordAuthenticator) addPendingLogin(user core.User) (string, error) {
+	loginToken, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	now := time.Now()
+	for key, pending := range a.pending {
+		if now.Sub(pending.created) > totpLoginTimeout {
+			delete(a.pending, key)
+		}
+	}
+	a.pending[loginToken] = &totpPendingLogin{
+		user:    user,
+		created: now,
+	}
+	return loginToken, nil
+}
+
+// loginWithTotp completes a login waiting for a two-factor code.
+func (a *UsernamePasswordAuthenticator) loginWithTotp(r *http.Request, loginToken string) (*sessions.Session, error) {
+	a.lock.Lock()
+	pending, ok := a.pending[loginToken]
+	if ok {
+		pending.attempts++
+		if pending.attempts >= totpLoginAttempts ||
+			time.Since(pending.created) > totpLoginTimeout {
+			delete(a.pending, loginToken)
+		}
+	}
+	a.lock.Unlock()
+
+	if !ok || time.Since(pending.created) > totpLoginTimeout {
+		return nil, errors.New("login expired")
+	}
+
+	username := pending.user.Username
+	if err := a.checkLockout(username, r); err != nil {
+		return nil, err
+	}
+
+	if err := a.totpStore.VerifyTotp(pending.user, r.FormValue("totp_code")); err != nil {
+		log.Warning("User %s failed two-factor authentication from %s",
+			username, r.RemoteAddr)
+		a.recordFailure(username, r)
+		return nil, err
+	}
+
+	a.lock.Lock()
+	delete(a.pending, loginToken)
+	a.lock.Unlock()
+
+	a.recordSuccess(username)
+
+	return a.newSession(r, pending.user), nil
 }
 
 func (a *UsernamePasswordAuthenticator) Authenticate(w http.ResponseWriter, r *http.Request) *sessions.Session {
@@ -108,13 +306,31 @@ func (a *UsernamePasswordAuthenticator) Authenticate(w http.ResponseWriter, r *h
 	if ok && username != "" && password != "" {
 		log.Debug("Authenticating user [%s] with basic auth",
 			username)
-		user, err := a.userStore.FindByUsernamePassword(username,
-			password)
+		if err := a.checkLockout(username, r); err != nil {
+			log.Warning("Refusing basic authentication for user %s: %v",
+				username, err)
+			writeLockedOut(w, err.(*LockedOutError))
+			return nil
+		}
+		user, err := a.checker.CheckPassword(username, password)
 		if err != nil {
-			log.Error("User %s failed to login: %v", err)
+			log.Error("User %s failed to login: %v", username, err)
+			a.recordFailure(username, r)
 			a.WriteStatusUnauthorized(w)
 			return nil
 		}
+
+		// Basic authentication can't provide a second factor, users
+		// with two-factor authentication enabled must use an API token.
+		enabled, err := a.totpEnabled(user)
+		if err != nil || enabled {
+			log.Warning("Rejecting basic authentication for user %s with two-factor authentication",
+				username)
+			a.WriteStatusUnauthorized(w)
+			return nil
+		}
+		a.recordSuccess(username)
+
 		session := &sessions.Session{
 			Id:         a.sessionStore.GenerateID(),
 			User:       user,
@@ -0,0 +1,167 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package auth
+
+import (
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/server/sessions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// A TOTP store where users with two-factor enabled accept one code.
+type fakeTotpStore struct {
+	codes map[string]string
+}
+
+func (s *fakeTotpStore) EnrollTotp(user core.User) (core.TotpEnrollment, error) {
+	return core.TotpEnrollment{}, nil
+}
+
+func (s *fakeTotpStore) ConfirmTotp(user core.User, code string) ([]string, error) {
+	return nil, nil
+}
+
+func (s *fakeTotpStore) GetTotpStatus(user core.User) (core.TotpStatus, error) {
+	_, ok := s.codes[user.Username]
+	return core.TotpStatus{Enabled: ok}, nil
+}
+
+func (s *fakeTotpStore) VerifyTotp(user core.User, code string) error {
+	expected, ok := s.codes[user.Username]
+	if !ok {
+		return core.ErrNoTotp
+	}
+	if code != expected {
+		return core.ErrBadTotpCode
+	}
+	return nil
+}
+
+func (s *fakeTotpStore) RegenerateRecoveryCodes(user core.User) ([]string, error) {
+	return nil, nil
+}
+
+func (s *fakeTotpStore) DisableTotp(user core.User) error {
+	return nil
+}
+
+func loginRequest(values url.Values) *http.Request {
+	r := httptest.NewRequest("POST", "/api/1/login",
+		strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func TestLoginWithTotp(t *testing.T) {
+	userStore := &memoryUserStore{users: map[string]core.User{}}
+	userStore.AddUser(core.User{Username: "alice"}, "alice-password")
+	userStore.AddUser(core.User{Username: "bob"}, "bob-password")
+
+	a := NewUsernamePasswordAuthenticator(sessions.NewSessionStore(), userStore)
+	a.SetTotpStore(&fakeTotpStore{codes: map[string]string{
+		"alice": "123456",
+	}})
+
+	// Users without two-factor only need a password.
+	session, err := a.Login(loginRequest(url.Values{
+		"username": {"bob"}, "password": {"bob-password"},
+	}))
+	require.Nil(t, err)
+	assert.Equal(t, "bob", session.User.Username)
+
+	// The password is checked before a code is asked for.
+	_, err = a.Login(loginRequest(url.Values{
+		"username": {"alice"}, "password": {"wrong"},
+	}))
+	_, ok := err.(*TotpRequiredError)
+	assert.False(t, ok)
+
+	_, err = a.Login(loginRequest(url.Values{
+		"username": {"alice"}, "password": {"alice-password"},
+	}))
+	totpRequired, ok := err.(*TotpRequiredError)
+	require.True(t, ok)
+	require.NotEmpty(t, totpRequired.LoginToken)
+
+	_, err = a.Login(loginRequest(url.Values{
+		"login_token": {totpRequired.LoginToken}, "totp_code": {"000000"},
+	}))
+	assert.Equal(t, core.ErrBadTotpCode, err)
+
+	session, err = a.Login(loginRequest(url.Values{
+		"login_token": {totpRequired.LoginToken}, "totp_code": {"123456"},
+	}))
+	require.Nil(t, err)
+	assert.Equal(t, "alice", session.User.Username)
+
+	// The login token can only be used once.
+	_, err = a.Login(loginRequest(url.Values{
+		"login_token": {totpRequired.LoginToken}, "totp_code": {"123456"},
+	}))
+	assert.NotNil(t, err)
+
+	// The code can be sent along with the password.
+	session, err = a.Login(loginRequest(url.Values{
+		"username": {"alice"}, "password": {"alice-password"},
+		"totp_code": {"123456"},
+	}))
+	require.Nil(t, err)
+	assert.Equal(t, "alice", session.User.Username)
+
+	// Only a few attempts are allowed per login token.
+	_, err = a.Login(loginRequest(url.Values{
+		"username": {"alice"}, "password": {"alice-password"},
+	}))
+	totpRequired = err.(*TotpRequiredError)
+	for i := 0; i < totpLoginAttempts; i++ {
+		_, err = a.Login(loginRequest(url.Values{
+			"login_token": {totpRequired.LoginToken}, "totp_code": {"000000"},
+		}))
+		assert.NotNil(t, err)
+	}
+	_, err = a.Login(loginRequest(url.Values{
+		"login_token": {totpRequired.LoginToken}, "totp_code": {"123456"},
+	}))
+	assert.NotNil(t, err)
+
+	// Basic authentication is refused for users with two-factor.
+	r := httptest.NewRequest("GET", "/api/1/alerts", nil)
+	r.SetBasicAuth("alice", "alice-password")
+	w := httptest.NewRecorder()
+	assert.Nil(t, a.Authenticate(w, r))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	r = httptest.NewRequest("GET", "/api/1/alerts", nil)
+	r.SetBasicAuth("bob", "bob-password")
+	assert.NotNil(t, a.Authenticate(httptest.NewRecorder(), r))
+}
@@ -57,6 +57,14 @@ func (r *Router) POST(path string, handler http.Handler) {
 	r.Router.Handle(path, handler).Methods("POST")
 }
 
+func (r *Router) PUT(path string, handler http.Handler) {
+	r.Router.Handle(path, handler).Methods("PUT")
+}
+
+func (r *Router) DELETE(path string, handler http.Handler) {
+	r.Router.Handle(path, handler).Methods("DELETE")
+}
+
 func (r *Router) OPTIONS(path string, handler http.Handler) {
 	r.Router.Handle(path, handler).Methods("OPTIONS")
 }
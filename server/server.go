@@ -31,6 +31,7 @@ import (
 
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"github.com/gorilla/handlers"
 	"github.com/jasonish/evebox/appcontext"
@@ -41,8 +42,10 @@ import (
 	"github.com/jasonish/evebox/server/auth"
 	"github.com/jasonish/evebox/server/router"
 	"github.com/jasonish/evebox/server/sessions"
+	"github.com/pkg/errors"
 	"github.com/spf13/viper"
 	"golang.org/x/crypto/acme/autocert"
+	"io/ioutil"
 	"path"
 	"strings"
 	"time"
@@ -65,8 +68,10 @@ func isPublic(r *http.Request) bool {
 		"/api/1/logout",
 		"/favicon.ico",
 
-		// Agent's do not require authentication at this time.
+		// Agents authenticate with a sensor token or client
+		// certificate, not a session.
 		"/api/1/submit",
+		"/api/1/sensors/heartbeat",
 	}
 
 	for _, prefix := range prefixes {
@@ -119,13 +124,18 @@ func SessionHandler(handler http.Handler) http.Handler {
 	})
 }
 
-func sessionReaper(sessionStore *sessions.SessionStore) {
+// sessionReaper periodically deletes expired sessions, and expired login
+// failures if logins are locked out.
+func sessionReaper(sessionStore *sessions.SessionStore, lockout *auth.Lockout) {
 	ticker := time.NewTicker(60 * time.Second)
 	go func() {
 		for {
 			<-ticker.C
 			log.Debug("Reaping sessions.")
 			sessionStore.Reap()
+			if lockout != nil {
+				lockout.Reap()
+			}
 		}
 	}()
 	log.Info("Session reaper started")
@@ -139,10 +149,14 @@ type Server struct {
 
 func NewServer(appContext appcontext.AppContext) *Server {
 
-	sessionReaper(sessionStore)
-
 	sessionStore.Header = SESSION_HEADER
+	sessionStore.Timeout = appContext.Config.Authentication.Session.Timeout
+	if appContext.PersistentSessionStore != nil {
+		sessionStore.Backend = appContext.PersistentSessionStore
+		log.Info("Sessions will be persisted in the configuration database")
+	}
 
+	var loginLockout *auth.Lockout
 	authRequired := appContext.Config.Authentication.Required
 	if authRequired {
 		authenticationType := appContext.Config.Authentication.Type
@@ -155,17 +169,47 @@ func NewServer(appContext appcontext.AppContext) *Server {
 			if appContext.ConfigDB.InMemory {
 				log.Fatal("Username/password authentication not supported with in-memory configuration database.")
 			}
-			authenticator = auth.NewUsernamePasswordAuthenticator(sessionStore,
-				appContext.Userstore)
+			var passwordAuthenticator *auth.UsernamePasswordAuthenticator
+			if appContext.Config.Authentication.Ldap.Enabled {
+				checker, err := auth.NewLdapPasswordChecker(
+					appContext.Config.Authentication.Ldap,
+					appContext.Userstore)
+				if err != nil {
+					log.Fatalf("Failed to configure LDAP authentication: %v", err)
+				}
+				passwordAuthenticator = auth.NewPasswordCheckerAuthenticator(
+					sessionStore, checker)
+				log.Info("LDAP authentication configured with server %s",
+					appContext.Config.Authentication.Ldap.Url)
+			} else {
+				passwordAuthenticator = auth.NewUsernamePasswordAuthenticator(
+					sessionStore, appContext.Userstore)
+			}
+			if appContext.TotpStore != nil {
+				passwordAuthenticator.SetTotpStore(appContext.TotpStore)
+			}
+			lockout := appContext.Config.Authentication.Lockout
+			if lockout.Enabled && appContext.LoginFailureStore != nil {
+				loginLockout = auth.NewLockout(lockout,
+					appContext.LoginFailureStore, appContext.AuditStore)
+				passwordAuthenticator.SetLockout(loginLockout)
+			}
+			authenticator = passwordAuthenticator
 		default:
 			log.Fatalf("Unsupported authentication type: %s",
 				authenticationType)
 		}
+		if appContext.ApiTokenStore != nil {
+			authenticator = auth.NewTokenAuthenticator(authenticator,
+				appContext.ApiTokenStore)
+		}
 	} else {
 		log.Info("Authentication disabled.")
 		authenticator = auth.NewAnonymousAuthenticator(sessionStore)
 	}
 
+	sessionReaper(sessionStore, loginLockout)
+
 	router := router.NewRouter()
 
 	server := &Server{
@@ -190,7 +234,28 @@ func NewServer(appContext appcontext.AppContext) *Server {
 		log.Info("GitHub Oauth2 authentication configured")
 	}
 
+	if appContext.Config.Authentication.Oidc.Enabled {
+		oidcAuthenticator, err := auth.NewOidc(
+			appContext.Config.Authentication.Oidc,
+			appContext.Userstore)
+		if err != nil {
+			log.Fatalf("Failed to configure OIDC authentication: %v", err)
+		}
+		oidcAuthenticator.SessionStore = sessionStore
+
+		router.Handle("/auth/oidc", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			oidcAuthenticator.Handler(w, r)
+		}))
+
+		router.Handle("/auth/oidc/callback", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			oidcAuthenticator.Callback(w, r)
+		}))
+		log.Info("OIDC authentication configured with issuer %s",
+			appContext.Config.Authentication.Oidc.Issuer)
+	}
+
 	apiContext := api.NewApiContext(&appContext, sessionStore, authenticator)
+	apiContext.SetLockout(loginLockout)
 	apiContext.InitRoutes(router.Subrouter("/api/1"))
 
 	// Static file server, must be last as it serves as the fallback.
@@ -284,10 +349,39 @@ func (s *Server) Start(host string, port uint16) error {
 		if keyFile == "" {
 			keyFile = config.Http.TlsCertificate
 		}
-		return http.ListenAndServeTLS(listenAddr,
-			config.Http.TlsCertificate,
-			keyFile, root)
+		server := &http.Server{
+			Addr:    listenAddr,
+			Handler: root,
+		}
+		if config.Http.TlsClientCA != "" {
+			tlsConfig, err := clientCATLSConfig(config.Http.TlsClientCA)
+			if err != nil {
+				return err
+			}
+			server.TLSConfig = tlsConfig
+			log.Info("Verifying client certificates with CA %s",
+				config.Http.TlsClientCA)
+		}
+		return server.ListenAndServeTLS(config.Http.TlsCertificate, keyFile)
+	}
+}
+
+// clientCATLSConfig returns a TLS configuration that verifies client
+// certificates against the CA in the provided file. Client certificates
+// are optional at the TLS layer, it is up to handlers to require them.
+func clientCATLSConfig(filename string) (*tls.Config, error) {
+	pem, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read client CA")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.Errorf("no certificates found in %s", filename)
 	}
+	return &tls.Config{
+		ClientCAs:  pool,
+		ClientAuth: tls.VerifyClientCertIfGiven,
+	}, nil
 }
 
 func VersionHeaderWrapper(handler http.Handler) http.Handler {
@@ -0,0 +1,101 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package server
+
+import (
+	"github.com/jasonish/evebox/appcontext"
+	"github.com/jasonish/evebox/server/api"
+	"github.com/jasonish/evebox/server/auth"
+	"github.com/jasonish/evebox/server/router"
+	"github.com/jasonish/evebox/sqlite/configdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Agents must be able to reach their endpoints without a session when
+// authentication is required.
+func TestSessionHandlerAgentEndpoints(t *testing.T) {
+	db, err := configdb.NewConfigDB(":memory:")
+	require.Nil(t, err)
+	appContext := appcontext.AppContext{
+		ConfigDB:    db,
+		SensorStore: configdb.NewSensorStore(db.DB),
+	}
+	appContext.Config.Authentication.Required = true
+	appContext.Config.Authentication.Type = "username"
+
+	defer func(previous auth.Authenticator) {
+		authenticator = previous
+	}(authenticator)
+	authenticator = auth.NewUsernameAuthenticator(sessionStore)
+
+	r := router.NewRouter()
+	api.NewApiContext(&appContext, sessionStore, authenticator).InitRoutes(
+		r.Subrouter("/api/1"))
+	handler := SessionHandler(r.Router)
+
+	token, _, err := appContext.SensorStore.AddSensor("sensor-one")
+	require.Nil(t, err)
+
+	heartbeat := func(token string) int {
+		req := httptest.NewRequest("POST", "/api/1/sensors/heartbeat",
+			strings.NewReader(`{"sensor_id": "0123456789abcdef"}`))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, heartbeat(token))
+
+	sensors, err := appContext.SensorStore.FindSensors()
+	require.Nil(t, err)
+	require.Equal(t, 1, len(sensors))
+	assert.Equal(t, "0123456789abcdef", sensors[0].SensorID)
+
+	// Without a token the agent can't take over the token sensor.
+	assert.Equal(t, http.StatusUnauthorized, heartbeat(""))
+
+	// Agents are rejected by the handler, not by the session handler,
+	// if they must authenticate.
+	appContext.Config.Authentication.Agents.Required = true
+	assert.Equal(t, http.StatusUnauthorized, heartbeat(""))
+	assert.Equal(t, http.StatusOK, heartbeat(token))
+
+	// Other API endpoints still require a session.
+	req := httptest.NewRequest("GET", "/api/1/sensors", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
@@ -36,8 +36,12 @@ type Session struct {
 	Id         string
 	User       core.User
 	RemoteAddr string
+	Created    time.Time
 	Expires    time.Time
 	Other      map[string]interface{}
+
+	// The expiry time last written to the session store backend.
+	persistedExpires time.Time
 }
 
 func NewSession() *Session {
@@ -50,6 +54,18 @@ func (s *Session) Username() string {
 	return s.User.Username
 }
 
+// HasRole returns true if the user of the session has the role.
+func (s *Session) HasRole(role string) bool {
+	return s.User.HasRole(role)
+}
+
+// isPersistable returns true if the session belongs to a user from the
+// user store. Anonymous sessions, and sessions that are not logged in yet,
+// such as during a GitHub login, are kept in memory only.
+func (s *Session) isPersistable() bool {
+	return s.User.IsValid() && !s.User.Anonymous
+}
+
 func (s *Session) String() string {
 	return fmt.Sprintf("{Id: %s; Username: %s}", s.Id, s.User.Username)
 }
@@ -1,6 +1,7 @@
 package sessions
 
 import (
+	"github.com/jasonish/evebox/core"
 	"github.com/stretchr/testify/require"
 	"testing"
 	"time"
@@ -44,3 +45,117 @@ func TestSessionExpireUpdate(t *testing.T) {
 	r.NotNil(session)
 	r.NotEqual(expiration, session.Expires)
 }
+
+type memoryBackend struct {
+	sessions map[string]core.PersistentSession
+}
+
+func (b *memoryBackend) SaveSession(session core.PersistentSession) error {
+	b.sessions[session.IdHash] = session
+	return nil
+}
+
+func (b *memoryBackend) FindSession(idHash string) (core.PersistentSession, error) {
+	session, ok := b.sessions[idHash]
+	if !ok || time.Now().After(session.Expires) {
+		return session, core.ErrNoSession
+	}
+	return session, nil
+}
+
+func (b *memoryBackend) FindSessionsByUser(user core.User) ([]core.PersistentSession, error) {
+	sessions := []core.PersistentSession{}
+	for _, session := range b.sessions {
+		if session.User.Id == user.Id {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+func (b *memoryBackend) DeleteSession(idHash string) error {
+	if _, ok := b.sessions[idHash]; !ok {
+		return core.ErrNoSession
+	}
+	delete(b.sessions, idHash)
+	return nil
+}
+
+func (b *memoryBackend) DeleteExpiredSessions() (int64, error) {
+	return 0, nil
+}
+
+func TestSessionPersist(t *testing.T) {
+	r := require.New(t)
+	backend := &memoryBackend{
+		sessions: map[string]core.PersistentSession{},
+	}
+	user := core.User{Id: "1234", Username: "alice", Role: core.ROLE_ANALYST}
+
+	store := NewSessionStore()
+	store.Timeout = 8 * time.Hour
+	store.Backend = backend
+
+	session := store.NewSession()
+	r.True(session.Expires.After(time.Now().Add(7 * time.Hour)))
+	session.User = user
+	store.Put(session)
+	r.Len(backend.sessions, 1)
+
+	// Only the hash of the ID is stored.
+	_, ok := backend.sessions[session.Id]
+	r.False(ok)
+	_, ok = backend.sessions[HashId(session.Id)]
+	r.True(ok)
+
+	// Anonymous sessions are not persisted.
+	anonymous := store.NewSession()
+	anonymous.User = core.NewAnonymousUser("anonymous")
+	store.Put(anonymous)
+	r.Len(backend.sessions, 1)
+
+	// A new store, as after a restart, finds the session.
+	store = NewSessionStore()
+	store.Backend = backend
+	restored := store.Get(session.Id)
+	r.NotNil(restored)
+	r.Equal(user, restored.User)
+
+	sessions, err := store.FindByUser(user)
+	r.Nil(err)
+	r.Len(sessions, 1)
+
+	r.Equal(core.ErrNoSession, store.Revoke(user, "bad"))
+	r.Equal(core.ErrNoSession,
+		store.Revoke(core.User{Id: "5678", Username: "bob"}, sessions[0].IdHash))
+	r.Nil(store.Revoke(user, sessions[0].IdHash))
+	r.Nil(store.Get(session.Id))
+	r.Len(backend.sessions, 0)
+}
+
+func TestSessionDeleteByUser(t *testing.T) {
+	r := require.New(t)
+	backend := &memoryBackend{
+		sessions: map[string]core.PersistentSession{},
+	}
+	alice := core.User{Id: "1234", Username: "alice", Role: core.ROLE_ANALYST}
+	bob := core.User{Id: "5678", Username: "bob", Role: core.ROLE_ANALYST}
+
+	store := NewSessionStore()
+	store.Backend = backend
+
+	var sessions []*Session
+	for _, user := range []core.User{alice, alice, bob} {
+		session := store.NewSession()
+		session.User = user
+		store.Put(session)
+		sessions = append(sessions, session)
+	}
+	r.Len(backend.sessions, 3)
+
+	r.Nil(store.DeleteByUser(alice))
+	r.Nil(store.Get(sessions[0].Id))
+	r.Nil(store.Get(sessions[1].Id))
+	r.NotNil(store.Get(sessions[2].Id))
+	r.Len(backend.sessions, 1)
+}
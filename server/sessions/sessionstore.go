@@ -27,33 +27,52 @@
 package sessions
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"github.com/jasonish/evebox/core"
 	"github.com/jasonish/evebox/log"
 	"golang.org/x/sync/syncmap"
-	"math/rand"
 	"net/http"
 	"strings"
 	"time"
 )
 
-// Session timeout in seconds. Default 1 hour.
-const TIMEOUT = 3600
+// The default session timeout, used if SessionStore.Timeout is not set.
+const DEFAULT_TIMEOUT = time.Hour
 
-func init() {
-	rand.Seed(time.Now().UnixNano())
-}
+// Only write an updated expiry time to the backend if it has moved by
+// more than this, so every request doesn't result in a write.
+const PERSIST_RESOLUTION = time.Minute
 
 type SessionStore struct {
-	Header   string
+	Header string
+
+	// How long a session can be idle before it expires.
+	Timeout time.Duration
+
+	// Optional backend to persist sessions in so they survive a restart.
+	// Only sessions of users from the user store are persisted.
+	Backend core.PersistentSessionStore
+
 	sessions syncmap.Map
 }
 
 func NewSessionStore() *SessionStore {
-	sessionStore := &SessionStore{}
+	sessionStore := &SessionStore{
+		Timeout: DEFAULT_TIMEOUT,
+	}
 	return sessionStore
 }
 
-// Reap will remove expired sessions.
+// HashId returns the hash of a session ID. This is what is stored in the
+// backend and used to refer to a session without revealing its ID.
+func HashId(id string) string {
+	hash := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(hash[:])
+}
+
 func (s *SessionStore) Reap() {
 	now := time.Now()
 
@@ -73,6 +92,15 @@ func (s *SessionStore) Reap() {
 		}
 		return true
 	})
+
+	if s.Backend != nil {
+		count, err := s.Backend.DeleteExpiredSessions()
+		if err != nil {
+			log.Error("Failed to delete expired sessions: %v", err)
+		} else if count > 0 {
+			log.Info("Deleted %d expired persistent sessions", count)
+		}
+	}
 }
 
 func (s *SessionStore) Get(id string) *Session {
@@ -80,27 +108,174 @@ func (s *SessionStore) Get(id string) *Session {
 	if ok {
 		session := val.(*Session)
 		s.setSessionTimeout(session)
+		s.persist(session, false)
 		return val.(*Session)
 	}
+	if s.Backend != nil && id != "" {
+		session := s.load(id)
+		if session != nil {
+			s.setSessionTimeout(session)
+			s.persist(session, false)
+			s.sessions.Store(session.Id, session)
+			return session
+		}
+	}
 	return nil
 }
 
+// load a session from the backend, for example after a restart.
+func (s *SessionStore) load(id string) *Session {
+	persisted, err := s.Backend.FindSession(HashId(id))
+	if err != nil {
+		if err != core.ErrNoSession {
+			log.Error("Failed to load session: %v", err)
+		}
+		return nil
+	}
+	session := NewSession()
+	session.Id = id
+	session.User = persisted.User
+	session.RemoteAddr = persisted.RemoteAddr
+	session.Created = persisted.Created
+	session.Expires = persisted.Expires
+	session.persistedExpires = persisted.Expires
+	return session
+}
+
+// persist writes a session to the backend, if there is one. Unless
+// forced, the session is only written if its expiry time has moved
+// enough since it was last written.
+func (s *SessionStore) persist(session *Session, force bool) {
+	if s.Backend == nil || !session.isPersistable() {
+		return
+	}
+
+	// Sessions without an expiry time, such as those created for basic
+	// authentication, are for a single request.
+	if !session.Expires.After(time.Now()) {
+		return
+	}
+	if !force && session.Expires.Sub(session.persistedExpires) < PERSIST_RESOLUTION {
+		return
+	}
+	err := s.Backend.SaveSession(core.PersistentSession{
+		IdHash:     HashId(session.Id),
+		User:       session.User,
+		RemoteAddr: session.RemoteAddr,
+		Created:    session.Created,
+		Expires:    session.Expires,
+	})
+	if err != nil {
+		log.Error("Failed to persist session for user %s: %v",
+			session.User.Username, err)
+		return
+	}
+	session.persistedExpires = session.Expires
+}
+
 func (s *SessionStore) Put(session *Session) {
+	if session.Created.IsZero() {
+		session.Created = time.Now()
+	}
 	s.sessions.Store(session.Id, session)
+	s.persist(session, true)
 }
 
 func (s *SessionStore) Delete(session *Session) {
-	s.sessions.Delete(session.Id)
+	s.DeleteById(session.Id)
 }
 
 func (s *SessionStore) DeleteById(id string) {
 	s.sessions.Delete(id)
+	if s.Backend != nil {
+		err := s.Backend.DeleteSession(HashId(id))
+		if err != nil && err != core.ErrNoSession {
+			log.Error("Failed to delete session: %v", err)
+		}
+	}
+}
+
+// FindByUser returns the active sessions of a user.
+func (s *SessionStore) FindByUser(user core.User) ([]core.PersistentSession, error) {
+	if s.Backend != nil && !user.Anonymous && user.Id != "" {
+		return s.Backend.FindSessionsByUser(user)
+	}
+	sessions := []core.PersistentSession{}
+	s.sessions.Range(func(key interface{}, value interface{}) bool {
+		session, ok := value.(*Session)
+		if ok && session.User.Id == user.Id &&
+			session.User.Username == user.Username {
+			sessions = append(sessions, core.PersistentSession{
+				IdHash:     HashId(session.Id),
+				User:       session.User,
+				RemoteAddr: session.RemoteAddr,
+				Created:    session.Created,
+				Expires:    session.Expires,
+			})
+		}
+		return true
+	})
+	return sessions, nil
+}
+
+// DeleteByUser deletes all the sessions of a user, for example when the
+// user is disabled.
+func (s *SessionStore) DeleteByUser(user core.User) error {
+	s.sessions.Range(func(key interface{}, value interface{}) bool {
+		session, ok := value.(*Session)
+		if ok && session.User.Id == user.Id &&
+			session.User.Username == user.Username {
+			s.sessions.Delete(key)
+		}
+		return true
+	})
+	if s.Backend != nil && user.Id != "" {
+		sessions, err := s.Backend.FindSessionsByUser(user)
+		if err != nil {
+			return err
+		}
+		for _, session := range sessions {
+			err := s.Backend.DeleteSession(session.IdHash)
+			if err != nil && err != core.ErrNoSession {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Revoke deletes one of a user's sessions by the hash of its ID.
+func (s *SessionStore) Revoke(user core.User, idHash string) error {
+	sessions, err := s.FindByUser(user)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if session.IdHash != idHash {
+			continue
+		}
+		s.sessions.Range(func(key interface{}, value interface{}) bool {
+			if HashId(key.(string)) == idHash {
+				s.sessions.Delete(key)
+				return false
+			}
+			return true
+		})
+		if s.Backend != nil {
+			err := s.Backend.DeleteSession(idHash)
+			if err != nil && err != core.ErrNoSession {
+				return err
+			}
+		}
+		return nil
+	}
+	return core.ErrNoSession
 }
 
 func (s *SessionStore) GenerateID() string {
 	bytes := make([]byte, 64)
-	for i := 0; i < cap(bytes); i++ {
-		bytes[i] = byte(rand.Intn(255))
+	if _, err := rand.Read(bytes); err != nil {
+		log.Fatalf("Failed to generate session ID: %v", err)
 	}
 	sessionId := base64.StdEncoding.EncodeToString(bytes)
 
@@ -112,14 +287,17 @@ func (s *SessionStore) GenerateID() string {
 }
 
 func (s *SessionStore) setSessionTimeout(session *Session) {
-	session.Expires = time.Now().Add(TIMEOUT * time.Second)
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = DEFAULT_TIMEOUT
+	}
+	session.Expires = time.Now().Add(timeout)
 }
 
-// NewSession creates a new session with a session ID. It DOES NOT add the
-// session to the session store.
 func (s *SessionStore) NewSession() *Session {
 	session := NewSession()
 	session.Id = s.GenerateID()
+	session.Created = time.Now()
 	s.setSessionTimeout(session)
 	return session
 }
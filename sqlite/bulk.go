@@ -0,0 +1,213 @@
+// +build cgo
+
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package sqlite
+
+import (
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/log"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+// The number of events to update per transaction in a bulk action.
+const BULK_BATCH_SIZE = 1000
+
+// SQL expression to append a tag to the tags array of an event source.
+const appendTagExpr = `source = CASE
+  WHEN json_type(source, '$.tags') = 'array'
+    THEN json_insert(source, '$.tags[' || json_array_length(source, '$.tags') || ']', ?)
+  ELSE json_set(source, '$.tags', json_array(?))
+END`
+
+// SQL expression that is true if the event does not have the tag.
+const missingTagExpr = `NOT EXISTS (SELECT 1 FROM json_each(events.source, '$.tags')
+  WHERE json_each.value = ?)`
+
+func buildBulkActionWhere(builder *SqlBuilder, p core.BulkActionParams) error {
+	builder.From("events")
+
+	if p.EventType != "" {
+		builder.WhereEquals("json_extract(events.source, '$.event_type')",
+			p.EventType)
+	}
+
+	if p.QueryString != "" {
+		parseQueryString(builder, p.QueryString, "events")
+	}
+
+	if p.TimeRange != "" {
+		duration, err := time.ParseDuration(p.TimeRange)
+		if err != nil {
+			return errors.Wrap(err, "failed to parse duration string")
+		}
+		builder.WhereGte("events.timestamp",
+			time.Now().Add(duration*-1).UnixNano())
+	}
+
+	if !p.MinTs.IsZero() {
+		builder.WhereGte("events.timestamp", p.MinTs.UnixNano())
+	}
+
+	if !p.MaxTs.IsZero() {
+		builder.WhereLte("events.timestamp", p.MaxTs.UnixNano())
+	}
+
+	return nil
+}
+
+// BulkAction archives, escalates or labels all events matching the query.
+// Updates are done in batches so the database is not locked for the
+// duration of a large update.
+func (s *DataStore) BulkAction(p core.BulkActionParams, user core.User) (int64, error) {
+	builder := SqlBuilder{}
+	if err := buildBulkActionWhere(&builder, p); err != nil {
+		return 0, err
+	}
+
+	switch p.Action {
+	case core.BULK_ACTION_ARCHIVE:
+		builder.WhereEquals("events.archived", 0)
+		if p.DryRun {
+			return s.countWhere(builder)
+		}
+		return s.bulkUpdate("archived = 1", nil, builder)
+	case core.BULK_ACTION_ESCALATE:
+		builder.WhereEquals("events.escalated", 0)
+		if p.DryRun {
+			return s.countWhere(builder)
+		}
+		return s.bulkUpdate("escalated = 1", nil, builder)
+	case core.BULK_ACTION_LABEL:
+		if len(p.Labels) == 0 {
+			return 0, errors.New("no labels provided")
+		}
+		return s.bulkLabel(p, builder)
+	}
+
+	return 0, errors.Errorf("unsupported bulk action: %s", p.Action)
+}
+
+// bulkLabel adds each label to the events matching the query that do not
+// already have it. The number of events missing at least one of the labels
+// is returned.
+func (s *DataStore) bulkLabel(p core.BulkActionParams, builder SqlBuilder) (int64, error) {
+	missing := []string{}
+	args := []interface{}{}
+	for _, label := range p.Labels {
+		missing = append(missing, missingTagExpr)
+		args = append(args, label)
+	}
+
+	countBuilder := builder.Clone()
+	countBuilder.WhereArgs(fmt.Sprintf("(%s)", strings.Join(missing, " OR ")),
+		args...)
+	count, err := s.countWhere(countBuilder)
+	if err != nil || p.DryRun {
+		return count, err
+	}
+
+	for _, label := range p.Labels {
+		labelBuilder := builder.Clone()
+		labelBuilder.WhereArgs(missingTagExpr, label)
+		if _, err := s.bulkUpdate(appendTagExpr,
+			[]interface{}{label, label}, labelBuilder); err != nil {
+			return 0, err
+		}
+	}
+
+	return count, nil
+}
+
+// countWhere returns the number of events matching the where clauses of the
+// builder.
+func (s *DataStore) countWhere(builder SqlBuilder) (int64, error) {
+	builder = builder.Clone()
+	builder.Select("count(*)")
+
+	tx, err := s.db.GetTx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Commit()
+
+	var count int64
+	if err := tx.QueryRow(builder.Build(), builder.args...).Scan(&count); err != nil {
+		return 0, errors.Wrap(err, "failed to count events")
+	}
+	return count, nil
+}
+
+// bulkUpdate applies the set expression to all events matching the builder
+// in batches. The where clauses of the builder must exclude events that have
+// already been updated, otherwise this will not terminate.
+func (s *DataStore) bulkUpdate(set string, setArgs []interface{}, builder SqlBuilder) (int64, error) {
+	builder = builder.Clone()
+	builder.Select("events.rowid")
+	builder.Limit(BULK_BATCH_SIZE)
+
+	query := fmt.Sprintf("UPDATE events SET %s WHERE rowid IN (%s)",
+		set, builder.Build())
+	args := append(append([]interface{}{}, setArgs...), builder.args...)
+
+	var total int64
+
+	for {
+		tx, err := s.db.GetTx()
+		if err != nil {
+			log.Error("Failed to get transaction: %v", err)
+			return total, err
+		}
+
+		execStart := time.Now()
+		r, err := tx.Exec(query, args...)
+		if err != nil {
+			log.Warning("Failed to execute bulk update: %v", err)
+			tx.Rollback()
+			return total, err
+		}
+		count, err := r.RowsAffected()
+		if err != nil {
+			tx.Rollback()
+			return total, err
+		}
+		if err := tx.Commit(); err != nil {
+			return total, err
+		}
+		if count == 0 {
+			break
+		}
+		log.Debug("Bulk updated %d events in %v", count,
+			time.Now().Sub(execStart))
+		total += count
+	}
+
+	return total, nil
+}
@@ -0,0 +1,163 @@
+// +build cgo,json1,fts5
+
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package sqlite
+
+import (
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/eve"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func setupBulkDataStore(t *testing.T) (*DataStore, func()) {
+	dir, err := ioutil.TempDir("", "evebox-sqlite-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := NewSqliteService(path.Join(dir, DB_FILENAME))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	return NewDataStore(db), func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func submitAlert(t *testing.T, sink core.EveEventSink, signatureId int, host string) {
+	event, err := eve.NewEveEventFromString(fmt.Sprintf(`{
+	    "timestamp": "%s",
+	    "event_type": "alert",
+	    "host": "%s",
+	    "src_ip": "10.16.1.10",
+	    "dest_ip": "10.16.1.1",
+	    "alert": {"signature_id": %d, "signature": "Test %d"}
+	}`, eve.FormatTimestamp(time.Now()), host, signatureId, signatureId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Submit(event); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBulkArchive(t *testing.T) {
+	r := require.New(t)
+	ds, teardown := setupBulkDataStore(t)
+	defer teardown()
+
+	sink := ds.GetEveEventSink()
+	submitAlert(t, sink, 2013028, "sensor3")
+	submitAlert(t, sink, 2013028, "sensor3")
+	submitAlert(t, sink, 2013028, "sensor1")
+	submitAlert(t, sink, 2000001, "sensor3")
+	_, err := sink.Commit()
+	r.Nil(err)
+
+	params := core.BulkActionParams{
+		Action: core.BULK_ACTION_ARCHIVE,
+		DryRun: true,
+	}
+	params.EventType = "alert"
+	params.QueryString = "alert.signature_id:2013028 host:sensor3"
+	params.TimeRange = "168h"
+
+	// Dry run only counts.
+	count, err := ds.BulkAction(params, core.User{})
+	r.Nil(err)
+	r.Equal(int64(2), count)
+
+	params.DryRun = false
+	count, err = ds.BulkAction(params, core.User{})
+	r.Nil(err)
+	r.Equal(int64(2), count)
+
+	// Already archived events are not counted again.
+	params.DryRun = true
+	count, err = ds.BulkAction(params, core.User{})
+	r.Nil(err)
+	r.Equal(int64(0), count)
+
+	options := core.AlertQueryOptions{
+		MustNotHaveTags: []string{"archived"},
+	}
+	groups, err := ds.AlertQuery(options)
+	r.Nil(err)
+	var remaining int64
+	for _, group := range groups {
+		remaining += group.Count
+	}
+	r.Equal(int64(2), remaining)
+}
+
+func TestBulkLabel(t *testing.T) {
+	r := require.New(t)
+	ds, teardown := setupBulkDataStore(t)
+	defer teardown()
+
+	sink := ds.GetEveEventSink()
+	submitAlert(t, sink, 2013028, "sensor3")
+	submitAlert(t, sink, 2000001, "sensor3")
+	_, err := sink.Commit()
+	r.Nil(err)
+
+	params := core.BulkActionParams{
+		Action: core.BULK_ACTION_LABEL,
+		Labels: []string{"false-positive", "tuned"},
+	}
+	params.QueryString = "alert.signature_id:2013028"
+
+	count, err := ds.BulkAction(params, core.User{})
+	r.Nil(err)
+	r.Equal(int64(1), count)
+
+	// Labelling again should not duplicate the tags.
+	count, err = ds.BulkAction(params, core.User{})
+	r.Nil(err)
+	r.Equal(int64(0), count)
+
+	events, err := ds.EventQuery(core.EventQueryOptions{
+		CommonQueryOptions: core.CommonQueryOptions{
+			QueryString: "alert.signature_id:2013028",
+		},
+	})
+	r.Nil(err)
+	data := events.(map[string]interface{})["data"].([]interface{})
+	r.Len(data, 1)
+	source := data[0].(map[string]interface{})["_source"].(eve.EveEvent)
+	r.Equal([]interface{}{"false-positive", "tuned"}, source["tags"])
+}
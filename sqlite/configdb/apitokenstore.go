@@ -0,0 +1,187 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package configdb
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+// Prefix for generated tokens, makes them easier to recognize.
+const API_TOKEN_PREFIX = "evebox_"
+
+// Only update the last used time of a token if it is older than this, so
+// every request doesn't result in a write.
+const API_TOKEN_LAST_USED_RESOLUTION = 60
+
+type ApiTokenStore struct {
+	db *sql.DB
+}
+
+func NewApiTokenStore(db *sql.DB) *ApiTokenStore {
+	return &ApiTokenStore{
+		db: db,
+	}
+}
+
+// generateToken generates a random token with the given prefix.
+func generateToken(prefix string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(buf), nil
+}
+
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func (s *ApiTokenStore) AddApiToken(user core.User, name string, expires *time.Time) (string, core.ApiToken, error) {
+	apiToken := core.ApiToken{
+		Name:     name,
+		Username: user.Username,
+		Created:  time.Unix(time.Now().Unix(), 0).UTC(),
+		Expires:  expires,
+	}
+	if user.Id == "" {
+		return "", apiToken, errors.New("user has no id")
+	}
+	if name == "" {
+		return "", apiToken, errors.New("name is required")
+	}
+	token, err := generateToken(API_TOKEN_PREFIX)
+	if err != nil {
+		return "", apiToken, errors.Wrap(err, "failed to generate token")
+	}
+	result, err := s.db.Exec(`insert into api_tokens
+	    (user_id, name, token_hash, created, expires)
+	    values (?, ?, ?, ?, ?)`,
+		user.Id, name, hashToken(token), apiToken.Created.Unix(),
+		toNullTime(expires))
+	if err != nil {
+		return "", apiToken, errors.Wrap(err, "failed to insert api token")
+	}
+	apiToken.Id, err = result.LastInsertId()
+	if err != nil {
+		return "", apiToken, err
+	}
+	return token, apiToken, nil
+}
+
+func (s *ApiTokenStore) FindApiTokens(user core.User) ([]core.ApiToken, error) {
+	rows, err := s.db.Query(`select id, name, created, expires, last_used
+	    from api_tokens where user_id = ? order by id`, user.Id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query api tokens")
+	}
+	defer rows.Close()
+	tokens := []core.ApiToken{}
+	for rows.Next() {
+		token := core.ApiToken{
+			Username: user.Username,
+		}
+		var created int64
+		var expires sql.NullInt64
+		var lastUsed sql.NullInt64
+		if err := rows.Scan(&token.Id, &token.Name, &created, &expires,
+			&lastUsed); err != nil {
+			return nil, errors.Wrap(err, "failed to read api token")
+		}
+		token.Created = time.Unix(created, 0).UTC()
+		token.Expires = fromNullTime(expires)
+		token.LastUsed = fromNullTime(lastUsed)
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *ApiTokenStore) DeleteApiToken(user core.User, id int64) error {
+	result, err := s.db.Exec(
+		"delete from api_tokens where id = ? and user_id = ?", id, user.Id)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete api token")
+	}
+	return checkRowsAffected(result, core.ErrNoApiToken)
+}
+
+func (s *ApiTokenStore) FindUserByApiToken(token string) (core.User, error) {
+	if !strings.HasPrefix(token, API_TOKEN_PREFIX) {
+		return nilUser, core.ErrBadApiToken
+	}
+
+	now := time.Now().Unix()
+	var id int64
+	var userId string
+	err := s.db.QueryRow(`select id, user_id from api_tokens
+	    where token_hash = ? and (expires is null or expires > ?)`,
+		hashToken(token), now).Scan(&id, &userId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nilUser, core.ErrBadApiToken
+		}
+		return nilUser, errors.Wrap(err, "failed to query api token")
+	}
+
+	user, err := findUserById(s.db, userId, core.ErrBadApiToken)
+	if err != nil {
+		return nilUser, err
+	}
+
+	_, err = s.db.Exec(`update api_tokens set last_used = ?
+	    where id = ? and (last_used is null or last_used < ?)`,
+		now, id, now-API_TOKEN_LAST_USED_RESOLUTION)
+	if err != nil {
+		return nilUser, errors.Wrap(err, "failed to update api token")
+	}
+
+	return user, nil
+}
+
+// findUserById finds a user by uuid, returning notFoundErr if the user
+// doesn't exist, such as when it has been deleted, or is disabled.
+func findUserById(db *sql.DB, id string, notFoundErr error) (core.User, error) {
+	rows, err := db.Query(fmt.Sprintf(
+		"select %s from users where uuid = ? and not disabled",
+		strings.Join(userFields, ", ")), id)
+	if err != nil {
+		return nilUser, errors.Wrap(err, "failed to query for user")
+	}
+	defer rows.Close()
+	if rows.Next() {
+		return mapUser(rows)
+	}
+	return nilUser, notFoundErr
+}
@@ -0,0 +1,87 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package configdb
+
+import (
+	"github.com/jasonish/evebox/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApiTokens(t *testing.T) {
+	db, err := NewConfigDB(":memory:")
+	require.Nil(t, err)
+	userStore := NewUserStore(db.DB)
+	store := NewApiTokenStore(db.DB)
+
+	_, err = userStore.AddUser(core.User{Username: "alice"}, "password")
+	require.Nil(t, err)
+	alice, err := userStore.FindByUsername("alice")
+	require.Nil(t, err)
+
+	_, _, err = store.AddApiToken(alice, "", nil)
+	assert.NotNil(t, err)
+
+	token, apiToken, err := store.AddApiToken(alice, "script", nil)
+	require.Nil(t, err)
+	assert.True(t, strings.HasPrefix(token, API_TOKEN_PREFIX))
+	assert.Equal(t, "script", apiToken.Name)
+
+	expired := time.Now().Add(-time.Minute)
+	expiredToken, _, err := store.AddApiToken(alice, "expired", &expired)
+	require.Nil(t, err)
+
+	user, err := store.FindUserByApiToken(token)
+	require.Nil(t, err)
+	assert.Equal(t, "alice", user.Username)
+	assert.Equal(t, core.ROLE_ANALYST, user.Role)
+
+	_, err = store.FindUserByApiToken(expiredToken)
+	assert.Equal(t, core.ErrBadApiToken, err)
+	_, err = store.FindUserByApiToken(API_TOKEN_PREFIX + "bad")
+	assert.Equal(t, core.ErrBadApiToken, err)
+	_, err = store.FindUserByApiToken("")
+	assert.Equal(t, core.ErrBadApiToken, err)
+
+	tokens, err := store.FindApiTokens(alice)
+	require.Nil(t, err)
+	require.Len(t, tokens, 2)
+	assert.Equal(t, apiToken.Id, tokens[0].Id)
+	assert.NotNil(t, tokens[0].LastUsed)
+	assert.Nil(t, tokens[1].LastUsed)
+
+	// Another user can't delete the token.
+	bob := core.User{Id: "bob", Username: "bob"}
+	assert.Equal(t, core.ErrNoApiToken, store.DeleteApiToken(bob, apiToken.Id))
+
+	require.Nil(t, store.DeleteApiToken(alice, apiToken.Id))
+	_, err = store.FindUserByApiToken(token)
+	assert.Equal(t, core.ErrBadApiToken, err)
+}
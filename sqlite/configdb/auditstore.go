@@ -0,0 +1,90 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package configdb
+
+import (
+	"database/sql"
+	"github.com/jasonish/evebox/core"
+	"github.com/pkg/errors"
+	"time"
+)
+
+type AuditStore struct {
+	db *sql.DB
+}
+
+func NewAuditStore(db *sql.DB) *AuditStore {
+	return &AuditStore{
+		db: db,
+	}
+}
+
+func (s *AuditStore) AddAuditEntry(entry core.AuditEntry) error {
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+	_, err := s.db.Exec(`insert into audit_log
+	    (timestamp, username, remote_addr, action, target, details)
+	    values (?, ?, ?, ?, ?, ?)`,
+		entry.Timestamp.Unix(), toNullString(entry.Username),
+		toNullString(entry.RemoteAddr), entry.Action,
+		toNullString(entry.Target), toNullString(entry.Details))
+	if err != nil {
+		return errors.Wrap(err, "failed to insert audit entry")
+	}
+	return nil
+}
+
+func (s *AuditStore) FindAuditEntries(limit int) ([]core.AuditEntry, error) {
+	rows, err := s.db.Query(`select id, timestamp, username, remote_addr,
+	    action, target, details
+	    from audit_log order by id desc limit ?`, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query audit log")
+	}
+	defer rows.Close()
+	entries := []core.AuditEntry{}
+	for rows.Next() {
+		entry := core.AuditEntry{}
+		var timestamp int64
+		var username sql.NullString
+		var remoteAddr sql.NullString
+		var target sql.NullString
+		var details sql.NullString
+		if err := rows.Scan(&entry.Id, &timestamp, &username, &remoteAddr,
+			&entry.Action, &target, &details); err != nil {
+			return nil, errors.Wrap(err, "failed to read audit entry")
+		}
+		entry.Timestamp = time.Unix(timestamp, 0).UTC()
+		entry.Username = username.String
+		entry.RemoteAddr = remoteAddr.String
+		entry.Target = target.String
+		entry.Details = details.String
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
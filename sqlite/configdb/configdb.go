@@ -66,6 +66,13 @@ func NewConfigDB(directory string) (*ConfigDB, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	// Each connection to an in-memory database gets its own database, so
+	// limit the pool to a single connection.
+	if inMemory {
+		db.SetMaxOpenConns(1)
+	}
+
 	configDB := &ConfigDB{
 		DB:       db,
 		InMemory: inMemory,
@@ -0,0 +1,121 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package configdb
+
+import (
+	"database/sql"
+	"github.com/jasonish/evebox/core"
+	"github.com/pkg/errors"
+	"time"
+)
+
+type LoginFailureStore struct {
+	db *sql.DB
+}
+
+func NewLoginFailureStore(db *sql.DB) *LoginFailureStore {
+	return &LoginFailureStore{
+		db: db,
+	}
+}
+
+func (s *LoginFailureStore) GetLoginFailures(kind string, value string) (core.LoginFailures, error) {
+	failures := core.LoginFailures{
+		Kind:  kind,
+		Value: value,
+	}
+	var lastFailure int64
+	var lockedUntil sql.NullInt64
+	err := s.db.QueryRow(`select count, last_failure, locked_until
+	    from login_failures where kind = ? and value = ?`, kind, value).Scan(
+		&failures.Count, &lastFailure, &lockedUntil)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return failures, nil
+		}
+		return failures, errors.Wrap(err, "failed to query login failures")
+	}
+	failures.LastFailure = time.Unix(lastFailure, 0).UTC()
+	failures.LockedUntil = fromNullTime(lockedUntil)
+	return failures, nil
+}
+
+func (s *LoginFailureStore) SaveLoginFailures(failures core.LoginFailures) error {
+	_, err := s.db.Exec(`insert or replace into login_failures
+	    (kind, value, count, last_failure, locked_until)
+	    values (?, ?, ?, ?, ?)`,
+		failures.Kind, failures.Value, failures.Count,
+		failures.LastFailure.Unix(), toNullTime(failures.LockedUntil))
+	if err != nil {
+		return errors.Wrap(err, "failed to save login failures")
+	}
+	return nil
+}
+
+func (s *LoginFailureStore) DeleteLoginFailures(kind string, value string) error {
+	result, err := s.db.Exec(
+		"delete from login_failures where kind = ? and value = ?",
+		kind, value)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete login failures")
+	}
+	return checkRowsAffected(result, core.ErrNoLoginFailures)
+}
+
+func (s *LoginFailureStore) DeleteExpiredLoginFailures(before time.Time, now time.Time) (int64, error) {
+	result, err := s.db.Exec(`delete from login_failures
+	    where last_failure < ? and (locked_until is null or locked_until <= ?)`,
+		before.Unix(), now.Unix())
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to delete login failures")
+	}
+	return result.RowsAffected()
+}
+
+func (s *LoginFailureStore) FindLockouts() ([]core.LoginFailures, error) {
+	rows, err := s.db.Query(`select kind, value, count, last_failure,
+	    locked_until from login_failures where locked_until > ?
+	    order by kind, value`, time.Now().Unix())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query lockouts")
+	}
+	defer rows.Close()
+	lockouts := []core.LoginFailures{}
+	for rows.Next() {
+		failures := core.LoginFailures{}
+		var lastFailure int64
+		var lockedUntil sql.NullInt64
+		if err := rows.Scan(&failures.Kind, &failures.Value,
+			&failures.Count, &lastFailure, &lockedUntil); err != nil {
+			return nil, errors.Wrap(err, "failed to read lockout")
+		}
+		failures.LastFailure = time.Unix(lastFailure, 0).UTC()
+		failures.LockedUntil = fromNullTime(lockedUntil)
+		lockouts = append(lockouts, failures)
+	}
+	return lockouts, rows.Err()
+}
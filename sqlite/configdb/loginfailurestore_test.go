@@ -0,0 +1,147 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package configdb
+
+import (
+	"github.com/jasonish/evebox/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestLoginFailures(t *testing.T) {
+	db, err := NewConfigDB(":memory:")
+	require.Nil(t, err)
+	store := NewLoginFailureStore(db.DB)
+
+	failures, err := store.GetLoginFailures(core.LOCKOUT_USERNAME, "admin")
+	require.Nil(t, err)
+	assert.Equal(t, 0, failures.Count)
+	assert.Nil(t, failures.LockedUntil)
+
+	now := time.Now().Truncate(time.Second).UTC()
+	failures.Count = 3
+	failures.LastFailure = now
+	require.Nil(t, store.SaveLoginFailures(failures))
+
+	failures, err = store.GetLoginFailures(core.LOCKOUT_USERNAME, "admin")
+	require.Nil(t, err)
+	assert.Equal(t, 3, failures.Count)
+	assert.Equal(t, now, failures.LastFailure)
+
+	// Not locked out yet.
+	lockouts, err := store.FindLockouts()
+	require.Nil(t, err)
+	assert.Len(t, lockouts, 0)
+
+	until := now.Add(time.Minute)
+	failures.Count = 4
+	failures.LockedUntil = &until
+	require.Nil(t, store.SaveLoginFailures(failures))
+
+	lockouts, err = store.FindLockouts()
+	require.Nil(t, err)
+	require.Len(t, lockouts, 1)
+	assert.Equal(t, "admin", lockouts[0].Value)
+	assert.Equal(t, until, *lockouts[0].LockedUntil)
+
+	// The same value by address is counted separately.
+	failures, err = store.GetLoginFailures(core.LOCKOUT_ADDRESS, "admin")
+	require.Nil(t, err)
+	assert.Equal(t, 0, failures.Count)
+
+	require.Nil(t, store.DeleteLoginFailures(core.LOCKOUT_USERNAME, "admin"))
+	assert.Equal(t, core.ErrNoLoginFailures,
+		store.DeleteLoginFailures(core.LOCKOUT_USERNAME, "admin"))
+}
+
+func TestDeleteExpiredLoginFailures(t *testing.T) {
+	db, err := NewConfigDB(":memory:")
+	require.Nil(t, err)
+	store := NewLoginFailureStore(db.DB)
+
+	now := time.Now().Truncate(time.Second).UTC()
+	old := now.Add(-2 * time.Hour)
+	locked := now.Add(time.Minute)
+	expired := now.Add(-time.Minute)
+	for _, failures := range []core.LoginFailures{
+		{Kind: core.LOCKOUT_USERNAME, Value: "recent", Count: 1,
+			LastFailure: now},
+		{Kind: core.LOCKOUT_USERNAME, Value: "old", Count: 1,
+			LastFailure: old},
+		{Kind: core.LOCKOUT_USERNAME, Value: "locked", Count: 5,
+			LastFailure: old, LockedUntil: &locked},
+		{Kind: core.LOCKOUT_ADDRESS, Value: "10.1.1.1", Count: 5,
+			LastFailure: old, LockedUntil: &expired},
+	} {
+		require.Nil(t, store.SaveLoginFailures(failures))
+	}
+
+	count, err := store.DeleteExpiredLoginFailures(now.Add(-time.Hour), now)
+	require.Nil(t, err)
+	assert.Equal(t, int64(2), count)
+
+	for _, value := range []string{"recent", "locked"} {
+		failures, err := store.GetLoginFailures(core.LOCKOUT_USERNAME, value)
+		require.Nil(t, err)
+		assert.NotEqual(t, 0, failures.Count, value)
+	}
+	failures, err := store.GetLoginFailures(core.LOCKOUT_ADDRESS, "10.1.1.1")
+	require.Nil(t, err)
+	assert.Equal(t, 0, failures.Count)
+}
+
+func TestAuditLog(t *testing.T) {
+	db, err := NewConfigDB(":memory:")
+	require.Nil(t, err)
+	store := NewAuditStore(db.DB)
+
+	require.Nil(t, store.AddAuditEntry(core.AuditEntry{
+		Action: core.AUDIT_LOCKOUT,
+		Target: "username:admin",
+	}))
+	require.Nil(t, store.AddAuditEntry(core.AuditEntry{
+		Username:   "root",
+		RemoteAddr: "10.16.1.10",
+		Action:     core.AUDIT_UNLOCK,
+		Target:     "username:admin",
+	}))
+
+	entries, err := store.FindAuditEntries(10)
+	require.Nil(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, core.AUDIT_UNLOCK, entries[0].Action)
+	assert.Equal(t, "root", entries[0].Username)
+	assert.Equal(t, core.AUDIT_LOCKOUT, entries[1].Action)
+	assert.Equal(t, "", entries[1].Username)
+	assert.False(t, entries[1].Timestamp.IsZero())
+
+	entries, err = store.FindAuditEntries(1)
+	require.Nil(t, err)
+	assert.Len(t, entries, 1)
+}
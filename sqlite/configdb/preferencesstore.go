@@ -0,0 +1,82 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package configdb
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/jasonish/evebox/core"
+	"github.com/pkg/errors"
+	"time"
+)
+
+type PreferencesStore struct {
+	db *sql.DB
+}
+
+func NewPreferencesStore(db *sql.DB) *PreferencesStore {
+	return &PreferencesStore{
+		db: db,
+	}
+}
+
+func (s *PreferencesStore) GetPreferences(user core.User) (core.Preferences, error) {
+	preferences := core.Preferences{}
+	var buf string
+	err := s.db.QueryRow("select preferences from preferences where user_id = ?",
+		user.Id).Scan(&buf)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return preferences, nil
+		}
+		return preferences, errors.Wrap(err, "failed to query preferences")
+	}
+	if err := json.Unmarshal([]byte(buf), &preferences); err != nil {
+		return preferences, errors.Wrap(err, "failed to decode preferences")
+	}
+	return preferences, nil
+}
+
+func (s *PreferencesStore) SetPreferences(user core.User, preferences core.Preferences) error {
+	if user.Id == "" {
+		return errors.New("user has no id")
+	}
+	if err := preferences.Validate(); err != nil {
+		return err
+	}
+	buf, err := json.Marshal(preferences)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`insert or replace into preferences
+	    (user_id, preferences, updated) values (?, ?, ?)`,
+		user.Id, string(buf), time.Now().Unix())
+	if err != nil {
+		return errors.Wrap(err, "failed to save preferences")
+	}
+	return nil
+}
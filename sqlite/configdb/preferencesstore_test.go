@@ -0,0 +1,84 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package configdb
+
+import (
+	"github.com/jasonish/evebox/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestPreferences(t *testing.T) {
+	db, err := NewConfigDB(":memory:")
+	require.Nil(t, err)
+	store := NewPreferencesStore(db.DB)
+
+	alice := core.User{Id: "1234", Username: "alice"}
+	bob := core.NewAnonymousUser("bob")
+
+	// No preferences saved yet.
+	preferences, err := store.GetPreferences(alice)
+	require.Nil(t, err)
+	assert.Equal(t, core.Preferences{}, preferences)
+
+	assert.NotNil(t, store.SetPreferences(alice, core.Preferences{
+		TimeRange: "yesterday",
+	}))
+	assert.NotNil(t, store.SetPreferences(alice, core.Preferences{
+		Timezone: "Nowhere/Special",
+	}))
+
+	require.Nil(t, store.SetPreferences(alice, core.Preferences{
+		TimeRange: "24h",
+		Timezone:  "America/Regina",
+		PageSize:  100,
+		Extra: map[string]interface{}{
+			"theme": "dark",
+		},
+	}))
+	require.Nil(t, store.SetPreferences(bob, core.Preferences{
+		TimeRange: "all",
+	}))
+
+	preferences, err = store.GetPreferences(alice)
+	require.Nil(t, err)
+	assert.Equal(t, "24h", preferences.TimeRange)
+	assert.Equal(t, "America/Regina", preferences.Timezone)
+	assert.Equal(t, 100, preferences.PageSize)
+	assert.Equal(t, "dark", preferences.Extra["theme"])
+
+	// Replaced, not merged.
+	require.Nil(t, store.SetPreferences(alice, core.Preferences{PageSize: 50}))
+	preferences, err = store.GetPreferences(alice)
+	require.Nil(t, err)
+	assert.Equal(t, core.Preferences{PageSize: 50}, preferences)
+
+	preferences, err = store.GetPreferences(bob)
+	require.Nil(t, err)
+	assert.Equal(t, "all", preferences.TimeRange)
+}
@@ -0,0 +1,197 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package configdb
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+var savedSearchFields = []string{
+	"id",
+	"name",
+	"query_string",
+	"event_type",
+	"time_range",
+	"owner",
+	"shared",
+	"created",
+	"updated",
+}
+
+type SavedSearchStore struct {
+	db *sql.DB
+}
+
+func NewSavedSearchStore(db *sql.DB) *SavedSearchStore {
+	return &SavedSearchStore{
+		db: db,
+	}
+}
+
+func (s *SavedSearchStore) AddSavedSearch(search core.SavedSearch) (int64, error) {
+	if err := search.Validate(); err != nil {
+		return 0, err
+	}
+	if search.Owner == "" {
+		return 0, errors.New("owner is required")
+	}
+	now := time.Now().Unix()
+	result, err := s.db.Exec(`insert into saved_searches (
+	      name,
+	      query_string,
+	      event_type,
+	      time_range,
+	      owner,
+	      shared,
+	      created,
+	      updated
+	    ) values (?, ?, ?, ?, ?, ?, ?, ?)`,
+		search.Name,
+		toNullString(search.QueryString),
+		toNullString(search.EventType),
+		toNullString(search.TimeRange),
+		search.Owner,
+		search.Shared,
+		now,
+		now)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to insert saved search")
+	}
+	return result.LastInsertId()
+}
+
+// UpdateSavedSearch updates everything but the owner of a saved search.
+func (s *SavedSearchStore) UpdateSavedSearch(search core.SavedSearch) error {
+	if err := search.Validate(); err != nil {
+		return err
+	}
+	result, err := s.db.Exec(`update saved_searches set
+	      name = ?,
+	      query_string = ?,
+	      event_type = ?,
+	      time_range = ?,
+	      shared = ?,
+	      updated = ?
+	    where id = ?`,
+		search.Name,
+		toNullString(search.QueryString),
+		toNullString(search.EventType),
+		toNullString(search.TimeRange),
+		search.Shared,
+		time.Now().Unix(),
+		search.Id)
+	if err != nil {
+		return errors.Wrap(err, "failed to update saved search")
+	}
+	return checkRowsAffected(result, core.ErrNoSavedSearch)
+}
+
+func (s *SavedSearchStore) DeleteSavedSearch(id int64) error {
+	result, err := s.db.Exec("delete from saved_searches where id = ?", id)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete saved search")
+	}
+	return checkRowsAffected(result, core.ErrNoSavedSearch)
+}
+
+func (s *SavedSearchStore) FindSavedSearchById(id int64) (core.SavedSearch, error) {
+	searches, err := s.query("where id = ?", id)
+	if err != nil {
+		return core.SavedSearch{}, err
+	}
+	if len(searches) == 0 {
+		return core.SavedSearch{}, core.ErrNoSavedSearch
+	}
+	return searches[0], nil
+}
+
+func (s *SavedSearchStore) FindSavedSearches(username string) ([]core.SavedSearch, error) {
+	return s.query("where owner = ? or shared = 1 order by name, id", username)
+}
+
+func (s *SavedSearchStore) FindSavedSearchByName(username string, name string) (core.SavedSearch, error) {
+	// Order the user's own saved search first.
+	searches, err := s.query(`where name = ? and (owner = ? or shared = 1)
+	    order by owner = ? desc, id`, name, username, username)
+	if err != nil {
+		return core.SavedSearch{}, err
+	}
+	if len(searches) == 0 {
+		return core.SavedSearch{}, core.ErrNoSavedSearch
+	}
+	return searches[0], nil
+}
+
+func (s *SavedSearchStore) query(where string, args ...interface{}) ([]core.SavedSearch, error) {
+	rows, err := s.db.Query(fmt.Sprintf("select %s from saved_searches %s",
+		strings.Join(savedSearchFields, ", "), where), args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query saved searches")
+	}
+	defer rows.Close()
+	searches := []core.SavedSearch{}
+	for rows.Next() {
+		search, err := mapSavedSearch(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read saved search")
+		}
+		searches = append(searches, search)
+	}
+	return searches, rows.Err()
+}
+
+func mapSavedSearch(rows *sql.Rows) (core.SavedSearch, error) {
+	search := core.SavedSearch{}
+	var queryString sql.NullString
+	var eventType sql.NullString
+	var timeRange sql.NullString
+	var created int64
+	var updated int64
+	err := rows.Scan(&search.Id,
+		&search.Name,
+		&queryString,
+		&eventType,
+		&timeRange,
+		&search.Owner,
+		&search.Shared,
+		&created,
+		&updated)
+	if err != nil {
+		return search, err
+	}
+	search.QueryString = queryString.String
+	search.EventType = eventType.String
+	search.TimeRange = timeRange.String
+	search.Created = time.Unix(created, 0).UTC()
+	search.Updated = time.Unix(updated, 0).UTC()
+	return search, nil
+}
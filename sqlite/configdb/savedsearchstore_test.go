@@ -0,0 +1,122 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package configdb
+
+import (
+	"github.com/jasonish/evebox/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func setupSavedSearchStore(t *testing.T) *SavedSearchStore {
+	db, err := NewConfigDB(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewSavedSearchStore(db.DB)
+}
+
+func TestSavedSearchVisibility(t *testing.T) {
+	store := setupSavedSearchStore(t)
+
+	_, err := store.AddSavedSearch(core.SavedSearch{Owner: "alice"})
+	assert.NotNil(t, err)
+
+	_, err = store.AddSavedSearch(core.SavedSearch{Name: "bad",
+		Owner: "alice", TimeRange: "yesterday"})
+	assert.NotNil(t, err)
+
+	privateId, err := store.AddSavedSearch(core.SavedSearch{
+		Name:        "scans",
+		QueryString: "alert.category:scan",
+		Owner:       "alice",
+	})
+	require.Nil(t, err)
+
+	sharedId, err := store.AddSavedSearch(core.SavedSearch{
+		Name:        "scans",
+		QueryString: "alert.signature:ET SCAN",
+		EventType:   "alert",
+		TimeRange:   "24h",
+		Owner:       "bob",
+		Shared:      true,
+	})
+	require.Nil(t, err)
+
+	// The same owner can't have two searches with the same name.
+	_, err = store.AddSavedSearch(core.SavedSearch{Name: "scans", Owner: "bob"})
+	assert.NotNil(t, err)
+
+	searches, err := store.FindSavedSearches("alice")
+	require.Nil(t, err)
+	assert.Len(t, searches, 2)
+
+	searches, err = store.FindSavedSearches("bob")
+	require.Nil(t, err)
+	require.Len(t, searches, 1)
+	assert.Equal(t, sharedId, searches[0].Id)
+	assert.Equal(t, "24h", searches[0].TimeRange)
+	assert.True(t, searches[0].Shared)
+
+	// Own saved search is preferred over a shared one.
+	search, err := store.FindSavedSearchByName("alice", "scans")
+	require.Nil(t, err)
+	assert.Equal(t, privateId, search.Id)
+
+	search, err = store.FindSavedSearchByName("carol", "scans")
+	require.Nil(t, err)
+	assert.Equal(t, sharedId, search.Id)
+
+	_, err = store.FindSavedSearchByName("carol", "nope")
+	assert.Equal(t, core.ErrNoSavedSearch, err)
+}
+
+func TestSavedSearchUpdateDelete(t *testing.T) {
+	store := setupSavedSearchStore(t)
+
+	id, err := store.AddSavedSearch(core.SavedSearch{Name: "dns",
+		EventType: "dns", Owner: "alice"})
+	require.Nil(t, err)
+
+	search, err := store.FindSavedSearchById(id)
+	require.Nil(t, err)
+	search.Name = "dns queries"
+	search.Shared = true
+	require.Nil(t, store.UpdateSavedSearch(search))
+
+	search, err = store.FindSavedSearchById(id)
+	require.Nil(t, err)
+	assert.Equal(t, "dns queries", search.Name)
+	assert.Equal(t, "alice", search.Owner)
+	assert.True(t, search.Shared)
+
+	require.Nil(t, store.DeleteSavedSearch(id))
+	_, err = store.FindSavedSearchById(id)
+	assert.Equal(t, core.ErrNoSavedSearch, err)
+	assert.Equal(t, core.ErrNoSavedSearch, store.DeleteSavedSearch(id))
+}
@@ -0,0 +1,317 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package configdb
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/log"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+// Prefix for generated sensor tokens.
+const SENSOR_TOKEN_PREFIX = "evebox_sensor_"
+
+// Like API tokens, only update the last seen time of a sensor if it is
+// older than this, so every submission doesn't result in a write.
+const SENSOR_LAST_SEEN_RESOLUTION = API_TOKEN_LAST_USED_RESOLUTION
+
+type SensorStore struct {
+	db *sql.DB
+
+	// The maximum number of sensors registered by unauthenticated
+	// heartbeats, 0 for no limit.
+	MaxUnauthenticated int
+}
+
+func NewSensorStore(db *sql.DB) *SensorStore {
+	return &SensorStore{
+		db: db,
+	}
+}
+
+func (s *SensorStore) AddSensor(name string) (string, core.Sensor, error) {
+	sensor := core.Sensor{
+		Name:    name,
+		Created: time.Unix(time.Now().Unix(), 0).UTC(),
+	}
+	if name == "" {
+		return "", sensor, errors.New("name is required")
+	}
+	token, err := generateToken(SENSOR_TOKEN_PREFIX)
+	if err != nil {
+		return "", sensor, errors.Wrap(err, "failed to generate token")
+	}
+	result, err := s.db.Exec(`insert into sensors (name, token_hash, created,
+	    authentication) values (?, ?, ?, ?)`, name, hashToken(token),
+		sensor.Created.Unix(), core.SENSOR_AUTH_TOKEN)
+	if err != nil {
+		return "", sensor, errors.Wrap(err, "failed to insert sensor")
+	}
+	sensor.Id, err = result.LastInsertId()
+	if err != nil {
+		return "", sensor, err
+	}
+	sensor.Authentication = core.SENSOR_AUTH_TOKEN
+	return token, sensor, nil
+}
+
+const sensorColumns = `id, name, created, last_seen, last_address,
+    authentication, sensor_id, hostname, version, last_heartbeat, heartbeat_interval, status`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanSensor reads a row of sensorColumns, flagging the sensor as silent
+// if it has stopped sending heartbeats.
+func scanSensor(row scanner) (core.Sensor, error) {
+	sensor := core.Sensor{}
+	var created int64
+	var lastSeen, lastHeartbeat, interval sql.NullInt64
+	var lastAddress, authentication, sensorID, hostname, version,
+		status sql.NullString
+	if err := row.Scan(&sensor.Id, &sensor.Name, &created, &lastSeen,
+		&lastAddress, &authentication, &sensorID, &hostname, &version, &lastHeartbeat,
+		&interval, &status); err != nil {
+		return sensor, err
+	}
+	sensor.Created = time.Unix(created, 0).UTC()
+	sensor.LastSeen = fromNullTime(lastSeen)
+	sensor.LastAddress = lastAddress.String
+	sensor.Authentication = authentication.String
+	sensor.SensorID = sensorID.String
+	sensor.Hostname = hostname.String
+	sensor.Version = version.String
+	sensor.LastHeartbeat = fromNullTime(lastHeartbeat)
+	sensor.HeartbeatInterval = interval.Int64
+	if status.Valid {
+		sensor.Status = &core.SensorStatus{}
+		if err := json.Unmarshal([]byte(status.String), sensor.Status); err != nil {
+			return sensor, errors.Wrap(err, "bad sensor status")
+		}
+	}
+	sensor.Silent = sensor.IsSilent(time.Now())
+	return sensor, nil
+}
+
+func (s *SensorStore) FindSensors() ([]core.Sensor, error) {
+	rows, err := s.db.Query(`select ` + sensorColumns + `
+	    from sensors order by name`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query sensors")
+	}
+	defer rows.Close()
+	sensors := []core.Sensor{}
+	for rows.Next() {
+		sensor, err := scanSensor(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read sensor")
+		}
+		sensors = append(sensors, sensor)
+	}
+	return sensors, rows.Err()
+}
+
+func (s *SensorStore) findSensor(id int64) (core.Sensor, error) {
+	sensor, err := scanSensor(s.db.QueryRow(`select `+sensorColumns+`
+	    from sensors where id = ?`, id))
+	if err == sql.ErrNoRows {
+		return sensor, core.ErrNoSensor
+	}
+	return sensor, err
+}
+
+func (s *SensorStore) DeleteSensor(id int64) error {
+	result, err := s.db.Exec("delete from sensors where id = ?", id)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete sensor")
+	}
+	return checkRowsAffected(result, core.ErrNoSensor)
+}
+
+func (s *SensorStore) FindSensorByToken(token string, addr string) (core.Sensor, error) {
+	sensor := core.Sensor{}
+	if !strings.HasPrefix(token, SENSOR_TOKEN_PREFIX) {
+		return sensor, core.ErrBadSensorToken
+	}
+	var created int64
+	var lastSeen sql.NullInt64
+	var lastAddress sql.NullString
+	err := s.db.QueryRow(`select id, name, created, last_seen, last_address
+	    from sensors where token_hash = ?`, hashToken(token)).Scan(
+		&sensor.Id, &sensor.Name, &created, &lastSeen, &lastAddress)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return sensor, core.ErrBadSensorToken
+		}
+		return sensor, errors.Wrap(err, "failed to query sensor")
+	}
+	sensor.Created = time.Unix(created, 0).UTC()
+	sensor.LastSeen = fromNullTime(lastSeen)
+	sensor.LastAddress = lastAddress.String
+
+	now := time.Unix(time.Now().Unix(), 0).UTC()
+	result, err := s.db.Exec(`update sensors set last_seen = ?, last_address = ?
+	    where id = ? and (last_seen is null or last_seen < ?)`,
+		now.Unix(), toNullString(addr), sensor.Id,
+		now.Unix()-SENSOR_LAST_SEEN_RESOLUTION)
+	if err != nil {
+		return sensor, errors.Wrap(err, "failed to update sensor")
+	}
+	if updated, err := result.RowsAffected(); err == nil && updated > 0 {
+		sensor.LastSeen = &now
+		sensor.LastAddress = addr
+	}
+
+	return sensor, nil
+}
+
+func (s *SensorStore) RecordHeartbeat(name string, heartbeat core.Heartbeat, addr string) (core.Sensor, error) {
+	if heartbeat.SensorID == "" {
+		return core.Sensor{}, errors.New("sensor_id is required")
+	}
+	status, err := json.Marshal(heartbeat.SensorStatus)
+	if err != nil {
+		return core.Sensor{}, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return core.Sensor{}, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+
+	// Authenticated sensors are found by name, creating those
+	// authenticated by client certificate on their first heartbeat.
+	// Unauthenticated agents are found by their sensor ID, but can only
+	// update sensors that were registered unauthenticated.
+	var id int64
+	authentication := core.SENSOR_AUTH_CERTIFICATE
+	if name != "" {
+		err = tx.QueryRow("select id from sensors where name = ?",
+			name).Scan(&id)
+	} else {
+		authentication = core.SENSOR_AUTH_NONE
+		var existing sql.NullString
+		err = tx.QueryRow(`select id, authentication from sensors
+		    where sensor_id = ?`, heartbeat.SensorID).Scan(&id, &existing)
+		if err == nil && existing.String != core.SENSOR_AUTH_NONE {
+			return core.Sensor{}, core.ErrSensorIdInUse
+		}
+	}
+	if err == sql.ErrNoRows {
+		if name == "" {
+			if err := s.checkUnauthenticatedLimit(tx); err != nil {
+				return core.Sensor{}, err
+			}
+			name = registrationName(tx, heartbeat)
+		}
+		result, err := tx.Exec(`insert into sensors (name, created,
+		    authentication) values (?, ?, ?)`, name, now, authentication)
+		if err != nil {
+			return core.Sensor{}, errors.Wrap(err, "failed to insert sensor")
+		}
+		if id, err = result.LastInsertId(); err != nil {
+			return core.Sensor{}, err
+		}
+		log.Info("Registered sensor %s (%s)", name, heartbeat.SensorID)
+	} else if err != nil {
+		return core.Sensor{}, errors.Wrap(err, "failed to query sensor")
+	}
+
+	// The sensor ID moves to this sensor if the agent was registered
+	// under another name before.
+	_, err = tx.Exec(`update sensors set sensor_id = null
+	    where sensor_id = ? and id != ?`, heartbeat.SensorID, id)
+	if err != nil {
+		return core.Sensor{}, errors.Wrap(err, "failed to update sensor")
+	}
+
+	_, err = tx.Exec(`update sensors set sensor_id = ?, hostname = ?,
+	    version = ?, last_heartbeat = ?, heartbeat_interval = ?, status = ?,
+	    last_seen = ?, last_address = ?
+	    where id = ?`,
+		heartbeat.SensorID, toNullString(heartbeat.Hostname),
+		toNullString(heartbeat.Version), now,
+		toNullInt64(heartbeat.Interval), string(status), now,
+		toNullString(addr), id)
+	if err != nil {
+		return core.Sensor{}, errors.Wrap(err, "failed to update sensor")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return core.Sensor{}, err
+	}
+	return s.findSensor(id)
+}
+
+// checkUnauthenticatedLimit returns ErrSensorLimit if another sensor can't
+// be registered by an unauthenticated heartbeat.
+func (s *SensorStore) checkUnauthenticatedLimit(tx *sql.Tx) error {
+	if s.MaxUnauthenticated <= 0 {
+		return nil
+	}
+	var count int
+	if err := tx.QueryRow(`select count(*) from sensors
+	    where authentication = ?`, core.SENSOR_AUTH_NONE).Scan(&count); err != nil {
+		return errors.Wrap(err, "failed to count sensors")
+	}
+	if count >= s.MaxUnauthenticated {
+		return core.ErrSensorLimit
+	}
+	return nil
+}
+
+// registrationName returns the name to register an unauthenticated agent
+// as, the name it asked for or its hostname, made unique with its sensor ID
+// if already taken.
+func registrationName(tx *sql.Tx, heartbeat core.Heartbeat) string {
+	name := heartbeat.Name
+	if name == "" {
+		name = heartbeat.Hostname
+	}
+	if name == "" {
+		return heartbeat.SensorID
+	}
+	var count int
+	err := tx.QueryRow("select count(*) from sensors where name = ?",
+		name).Scan(&count)
+	if err == nil && count == 0 {
+		return name
+	}
+	suffix := heartbeat.SensorID
+	if len(suffix) > 8 {
+		suffix = suffix[:8]
+	}
+	return name + "-" + suffix
+}
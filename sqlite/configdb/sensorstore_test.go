@@ -0,0 +1,208 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package configdb
+
+import (
+	"github.com/jasonish/evebox/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSensors(t *testing.T) {
+	db, err := NewConfigDB(":memory:")
+	require.Nil(t, err)
+	store := NewSensorStore(db.DB)
+
+	_, _, err = store.AddSensor("")
+	assert.NotNil(t, err)
+
+	token, sensor, err := store.AddSensor("sensor-one")
+	require.Nil(t, err)
+	assert.True(t, strings.HasPrefix(token, SENSOR_TOKEN_PREFIX))
+
+	// Names are unique.
+	_, _, err = store.AddSensor("sensor-one")
+	assert.NotNil(t, err)
+
+	found, err := store.FindSensorByToken(token, "10.16.1.10:40000")
+	require.Nil(t, err)
+	assert.Equal(t, sensor.Id, found.Id)
+	assert.Equal(t, "sensor-one", found.Name)
+
+	_, err = store.FindSensorByToken(SENSOR_TOKEN_PREFIX+"bad", "")
+	assert.Equal(t, core.ErrBadSensorToken, err)
+
+	// An API token is not a sensor token.
+	_, err = store.FindSensorByToken(API_TOKEN_PREFIX+"bad", "")
+	assert.Equal(t, core.ErrBadSensorToken, err)
+
+	sensors, err := store.FindSensors()
+	require.Nil(t, err)
+	require.Len(t, sensors, 1)
+	assert.NotNil(t, sensors[0].LastSeen)
+	assert.Equal(t, "10.16.1.10:40000", sensors[0].LastAddress)
+
+	// The last seen time is only written once per resolution period.
+	recent := time.Now().Unix() - SENSOR_LAST_SEEN_RESOLUTION/2
+	_, err = db.DB.Exec("update sensors set last_seen = ?", recent)
+	require.Nil(t, err)
+	found, err = store.FindSensorByToken(token, "10.16.1.11:40000")
+	require.Nil(t, err)
+	assert.Equal(t, recent, found.LastSeen.Unix())
+	assert.Equal(t, "10.16.1.10:40000", found.LastAddress)
+
+	old := time.Now().Unix() - SENSOR_LAST_SEEN_RESOLUTION*2
+	_, err = db.DB.Exec("update sensors set last_seen = ?", old)
+	require.Nil(t, err)
+	found, err = store.FindSensorByToken(token, "10.16.1.11:40000")
+	require.Nil(t, err)
+	assert.True(t, found.LastSeen.Unix() > old)
+	assert.Equal(t, "10.16.1.11:40000", found.LastAddress)
+
+	require.Nil(t, store.DeleteSensor(sensor.Id))
+	assert.Equal(t, core.ErrNoSensor, store.DeleteSensor(sensor.Id))
+	_, err = store.FindSensorByToken(token, "")
+	assert.Equal(t, core.ErrBadSensorToken, err)
+}
+
+func TestSensorHeartbeats(t *testing.T) {
+	db, err := NewConfigDB(":memory:")
+	require.Nil(t, err)
+	store := NewSensorStore(db.DB)
+
+	heartbeat := core.Heartbeat{
+		SensorID: "0123456789abcdef",
+		Hostname: "sensor-host",
+		Version:  "1.0.0",
+		Interval: 60,
+		SensorStatus: core.SensorStatus{
+			EventsPerSecond: 10,
+			Inputs: []core.SensorInput{{
+				Input: "/var/log/suricata/eve.json",
+				Lag:   100,
+			}},
+		},
+	}
+
+	_, err = store.RecordHeartbeat("", core.Heartbeat{}, "")
+	assert.NotNil(t, err)
+
+	// Unauthenticated agents are registered by hostname and found again
+	// by sensor ID.
+	sensor, err := store.RecordHeartbeat("", heartbeat, "10.16.1.10:40000")
+	require.Nil(t, err)
+	assert.Equal(t, "sensor-host", sensor.Name)
+	assert.Equal(t, core.SENSOR_AUTH_NONE, sensor.Authentication)
+	assert.Equal(t, "1.0.0", sensor.Version)
+	assert.NotNil(t, sensor.LastHeartbeat)
+	assert.Equal(t, heartbeat.SensorStatus, *sensor.Status)
+	assert.False(t, sensor.Silent)
+
+	heartbeat.Version = "1.1.0"
+	again, err := store.RecordHeartbeat("", heartbeat, "")
+	require.Nil(t, err)
+	assert.Equal(t, sensor.Id, again.Id)
+	assert.Equal(t, "1.1.0", again.Version)
+
+	// Another agent with the same hostname gets a unique name.
+	other := heartbeat
+	other.SensorID = "fedcba9876543210"
+	sensor, err = store.RecordHeartbeat("", other, "")
+	require.Nil(t, err)
+	assert.Equal(t, "sensor-host-fedcba98", sensor.Name)
+
+	// A token sensor takes over the sensor ID of the agent.
+	_, tokenSensor, err := store.AddSensor("sensor-one")
+	require.Nil(t, err)
+	sensor, err = store.RecordHeartbeat("sensor-one", heartbeat, "")
+	require.Nil(t, err)
+	assert.Equal(t, tokenSensor.Id, sensor.Id)
+	assert.Equal(t, heartbeat.SensorID, sensor.SensorID)
+
+	// Unauthenticated agents can't claim the sensor ID of a token sensor.
+	_, err = store.RecordHeartbeat("", heartbeat, "")
+	assert.Equal(t, core.ErrSensorIdInUse, err)
+
+	// Sensors authenticated by certificate are registered by name.
+	sensor, err = store.RecordHeartbeat("sensor-two", other, "")
+	require.Nil(t, err)
+	assert.Equal(t, "sensor-two", sensor.Name)
+	assert.Equal(t, core.SENSOR_AUTH_CERTIFICATE, sensor.Authentication)
+
+	// And can't be updated by an unauthenticated heartbeat either.
+	_, err = store.RecordHeartbeat("", other, "")
+	assert.Equal(t, core.ErrSensorIdInUse, err)
+
+	sensors, err := store.FindSensors()
+	require.Nil(t, err)
+	ids := map[string]string{}
+	for _, sensor := range sensors {
+		ids[sensor.Name] = sensor.SensorID
+	}
+	assert.Equal(t, map[string]string{
+		"sensor-host":          "",
+		"sensor-host-fedcba98": "",
+		"sensor-one":           heartbeat.SensorID,
+		"sensor-two":           other.SensorID,
+	}, ids)
+
+	// A sensor is silent once it misses heartbeats.
+	_, err = db.DB.Exec("update sensors set last_heartbeat = ? where name = ?",
+		time.Now().Add(-4*time.Minute).Unix(), "sensor-one")
+	require.Nil(t, err)
+	sensors, err = store.FindSensors()
+	require.Nil(t, err)
+	for _, sensor := range sensors {
+		assert.Equal(t, sensor.Name == "sensor-one", sensor.Silent,
+			sensor.Name)
+	}
+}
+
+func TestSensorHeartbeatLimit(t *testing.T) {
+	db, err := NewConfigDB(":memory:")
+	require.Nil(t, err)
+	store := NewSensorStore(db.DB)
+	store.MaxUnauthenticated = 2
+
+	for _, id := range []string{"sensor-1", "sensor-2"} {
+		_, err := store.RecordHeartbeat("", core.Heartbeat{SensorID: id}, "")
+		require.Nil(t, err)
+	}
+
+	_, err = store.RecordHeartbeat("", core.Heartbeat{SensorID: "sensor-3"}, "")
+	assert.Equal(t, core.ErrSensorLimit, err)
+
+	// Registered sensors can still send heartbeats, as can authenticated
+	// ones.
+	_, err = store.RecordHeartbeat("", core.Heartbeat{SensorID: "sensor-1"}, "")
+	assert.Nil(t, err)
+	_, err = store.RecordHeartbeat("sensor-3", core.Heartbeat{SensorID: "sensor-3"}, "")
+	assert.Nil(t, err)
+}
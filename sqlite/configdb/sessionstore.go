@@ -0,0 +1,140 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package configdb
+
+import (
+	"database/sql"
+	"github.com/jasonish/evebox/core"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// SessionStore persists login sessions. The user is looked up on each
+// load so changes to the user, such as a new role, apply to existing
+// sessions and deleting a user ends their sessions.
+type SessionStore struct {
+	db *sql.DB
+}
+
+func NewSessionStore(db *sql.DB) *SessionStore {
+	return &SessionStore{
+		db: db,
+	}
+}
+
+func (s *SessionStore) SaveSession(session core.PersistentSession) error {
+	if session.IdHash == "" {
+		return errors.New("session has no id")
+	}
+	if session.User.Id == "" {
+		return errors.New("session user has no id")
+	}
+	_, err := s.db.Exec(`insert or replace into sessions
+	    (id_hash, user_id, remote_addr, created, expires)
+	    values (?, ?, ?, ?, ?)`,
+		session.IdHash, session.User.Id, toNullString(session.RemoteAddr),
+		session.Created.Unix(), session.Expires.Unix())
+	if err != nil {
+		return errors.Wrap(err, "failed to save session")
+	}
+	return nil
+}
+
+func (s *SessionStore) FindSession(idHash string) (core.PersistentSession, error) {
+	session := core.PersistentSession{
+		IdHash: idHash,
+	}
+	var userId string
+	var remoteAddr sql.NullString
+	var created int64
+	var expires int64
+	err := s.db.QueryRow(`select user_id, remote_addr, created, expires
+	    from sessions where id_hash = ? and expires > ?`,
+		idHash, time.Now().Unix()).Scan(&userId, &remoteAddr, &created,
+		&expires)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return session, core.ErrNoSession
+		}
+		return session, errors.Wrap(err, "failed to query session")
+	}
+	session.User, err = findUserById(s.db, userId, core.ErrNoSession)
+	if err != nil {
+		return session, err
+	}
+	session.RemoteAddr = remoteAddr.String
+	session.Created = time.Unix(created, 0).UTC()
+	session.Expires = time.Unix(expires, 0).UTC()
+	return session, nil
+}
+
+func (s *SessionStore) FindSessionsByUser(user core.User) ([]core.PersistentSession, error) {
+	rows, err := s.db.Query(`select id_hash, remote_addr, created, expires
+	    from sessions where user_id = ? and expires > ? order by created`,
+		user.Id, time.Now().Unix())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query sessions")
+	}
+	defer rows.Close()
+	sessions := []core.PersistentSession{}
+	for rows.Next() {
+		session := core.PersistentSession{
+			User: user,
+		}
+		var remoteAddr sql.NullString
+		var created int64
+		var expires int64
+		if err := rows.Scan(&session.IdHash, &remoteAddr, &created,
+			&expires); err != nil {
+			return nil, errors.Wrap(err, "failed to read session")
+		}
+		session.RemoteAddr = remoteAddr.String
+		session.Created = time.Unix(created, 0).UTC()
+		session.Expires = time.Unix(expires, 0).UTC()
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (s *SessionStore) DeleteSession(idHash string) error {
+	result, err := s.db.Exec("delete from sessions where id_hash = ?", idHash)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete session")
+	}
+	return checkRowsAffected(result, core.ErrNoSession)
+}
+
+// DeleteExpiredSessions deletes expired sessions, and the sessions of
+// users that no longer exist, returning the number deleted.
+func (s *SessionStore) DeleteExpiredSessions() (int64, error) {
+	result, err := s.db.Exec(`delete from sessions where expires <= ?
+	    or user_id not in (select uuid from users)`, time.Now().Unix())
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to delete expired sessions")
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,97 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package configdb
+
+import (
+	"github.com/jasonish/evebox/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestSessionStore(t *testing.T) {
+	db, err := NewConfigDB(":memory:")
+	require.Nil(t, err)
+	userStore := NewUserStore(db.DB)
+	store := NewSessionStore(db.DB)
+
+	_, err = userStore.AddUser(core.User{Username: "alice"}, "password")
+	require.Nil(t, err)
+	alice, err := userStore.FindByUsername("alice")
+	require.Nil(t, err)
+
+	now := time.Unix(time.Now().Unix(), 0).UTC()
+	session := core.PersistentSession{
+		IdHash:     "hash0",
+		User:       alice,
+		RemoteAddr: "127.0.0.1:40000",
+		Created:    now,
+		Expires:    now.Add(time.Hour),
+	}
+	require.Nil(t, store.SaveSession(session))
+
+	expired := session
+	expired.IdHash = "hash1"
+	expired.Expires = now.Add(-time.Minute)
+	require.Nil(t, store.SaveSession(expired))
+
+	found, err := store.FindSession("hash0")
+	require.Nil(t, err)
+	assert.Equal(t, session, found)
+
+	_, err = store.FindSession("hash1")
+	assert.Equal(t, core.ErrNoSession, err)
+
+	// Saving again updates the expiry.
+	session.Expires = now.Add(2 * time.Hour)
+	require.Nil(t, store.SaveSession(session))
+	sessions, err := store.FindSessionsByUser(alice)
+	require.Nil(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, session.Expires, sessions[0].Expires)
+
+	// Role changes apply to the existing session.
+	require.Nil(t, userStore.SetRole("alice", core.ROLE_ADMIN))
+	found, err = store.FindSession("hash0")
+	require.Nil(t, err)
+	assert.Equal(t, core.ROLE_ADMIN, found.User.Role)
+
+	count, err := store.DeleteExpiredSessions()
+	require.Nil(t, err)
+	assert.Equal(t, int64(1), count)
+
+	// Deleting the user deletes the session.
+	require.Nil(t, userStore.DeleteByUsername("alice"))
+	_, err = store.FindSession("hash0")
+	assert.Equal(t, core.ErrNoSession, err)
+	count, err = store.DeleteExpiredSessions()
+	require.Nil(t, err)
+	assert.Equal(t, int64(0), count)
+
+	assert.Equal(t, core.ErrNoSession, store.DeleteSession("hash0"))
+}
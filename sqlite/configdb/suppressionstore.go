@@ -0,0 +1,239 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package configdb
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+var suppressionFields = []string{
+	"id",
+	"signature_id",
+	"src_cidr",
+	"dest_cidr",
+	"sensor",
+	"action",
+	"reason",
+	"username",
+	"created",
+	"expires",
+	"hits",
+	"last_hit",
+}
+
+type SuppressionStore struct {
+	db *sql.DB
+}
+
+func NewSuppressionStore(db *sql.DB) *SuppressionStore {
+	return &SuppressionStore{
+		db: db,
+	}
+}
+
+func toNullTime(value *time.Time) sql.NullInt64 {
+	if value == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: value.Unix(), Valid: true}
+}
+
+func fromNullTime(value sql.NullInt64) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	t := time.Unix(value.Int64, 0).UTC()
+	return &t
+}
+
+func (s *SuppressionStore) AddSuppression(suppression core.Suppression) (int64, error) {
+	if err := suppression.Validate(); err != nil {
+		return 0, err
+	}
+	if suppression.Created.IsZero() {
+		suppression.Created = time.Now()
+	}
+	result, err := s.db.Exec(`insert into suppressions (
+	      signature_id,
+	      src_cidr,
+	      dest_cidr,
+	      sensor,
+	      action,
+	      reason,
+	      username,
+	      created,
+	      expires
+	    ) values (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		toNullInt64(int64(suppression.SignatureID)),
+		toNullString(suppression.SrcCIDR),
+		toNullString(suppression.DestCIDR),
+		toNullString(suppression.Sensor),
+		suppression.Action,
+		toNullString(suppression.Reason),
+		toNullString(suppression.Username),
+		suppression.Created.Unix(),
+		toNullTime(suppression.Expires))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to insert suppression")
+	}
+	return result.LastInsertId()
+}
+
+// UpdateSuppression updates the match criteria, action, reason and expiry
+// of a suppression. The creator and hit counters are left as is.
+func (s *SuppressionStore) UpdateSuppression(suppression core.Suppression) error {
+	if err := suppression.Validate(); err != nil {
+		return err
+	}
+	result, err := s.db.Exec(`update suppressions set
+	      signature_id = ?,
+	      src_cidr = ?,
+	      dest_cidr = ?,
+	      sensor = ?,
+	      action = ?,
+	      reason = ?,
+	      expires = ?
+	    where id = ?`,
+		toNullInt64(int64(suppression.SignatureID)),
+		toNullString(suppression.SrcCIDR),
+		toNullString(suppression.DestCIDR),
+		toNullString(suppression.Sensor),
+		suppression.Action,
+		toNullString(suppression.Reason),
+		toNullTime(suppression.Expires),
+		suppression.Id)
+	if err != nil {
+		return errors.Wrap(err, "failed to update suppression")
+	}
+	return checkRowsAffected(result, core.ErrNoSuppression)
+}
+
+func (s *SuppressionStore) FindAllSuppressions() ([]core.Suppression, error) {
+	rows, err := s.db.Query(fmt.Sprintf(
+		"select %s from suppressions order by id",
+		strings.Join(suppressionFields, ", ")))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query suppressions")
+	}
+	defer rows.Close()
+	suppressions := []core.Suppression{}
+	for rows.Next() {
+		suppression, err := mapSuppression(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read suppression")
+		}
+		suppressions = append(suppressions, suppression)
+	}
+	return suppressions, rows.Err()
+}
+
+func (s *SuppressionStore) FindSuppressionById(id int64) (core.Suppression, error) {
+	rows, err := s.db.Query(fmt.Sprintf(
+		"select %s from suppressions where id = ?",
+		strings.Join(suppressionFields, ", ")), id)
+	if err != nil {
+		return core.Suppression{}, errors.Wrap(err,
+			"failed to query suppression")
+	}
+	defer rows.Close()
+	if rows.Next() {
+		return mapSuppression(rows)
+	}
+	return core.Suppression{}, core.ErrNoSuppression
+}
+
+func (s *SuppressionStore) DeleteSuppression(id int64) error {
+	result, err := s.db.Exec("delete from suppressions where id = ?", id)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete suppression")
+	}
+	return checkRowsAffected(result, core.ErrNoSuppression)
+}
+
+func (s *SuppressionStore) AddSuppressionHits(id int64, count uint64, lastHit time.Time) error {
+	_, err := s.db.Exec(`update suppressions
+	    set hits = hits + ?, last_hit = ? where id = ?`,
+		count, lastHit.Unix(), id)
+	if err != nil {
+		return errors.Wrap(err, "failed to update suppression hits")
+	}
+	return nil
+}
+
+func checkRowsAffected(result sql.Result, notFound error) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound
+	}
+	return nil
+}
+
+func mapSuppression(rows *sql.Rows) (core.Suppression, error) {
+	suppression := core.Suppression{}
+	var signatureId sql.NullInt64
+	var srcCidr sql.NullString
+	var destCidr sql.NullString
+	var sensor sql.NullString
+	var reason sql.NullString
+	var username sql.NullString
+	var created int64
+	var expires sql.NullInt64
+	var lastHit sql.NullInt64
+	err := rows.Scan(&suppression.Id,
+		&signatureId,
+		&srcCidr,
+		&destCidr,
+		&sensor,
+		&suppression.Action,
+		&reason,
+		&username,
+		&created,
+		&expires,
+		&suppression.Hits,
+		&lastHit)
+	if err != nil {
+		return suppression, err
+	}
+	suppression.SignatureID = uint64(signatureId.Int64)
+	suppression.SrcCIDR = srcCidr.String
+	suppression.DestCIDR = destCidr.String
+	suppression.Sensor = sensor.String
+	suppression.Reason = reason.String
+	suppression.Username = username.String
+	suppression.Created = time.Unix(created, 0).UTC()
+	suppression.Expires = fromNullTime(expires)
+	suppression.LastHit = fromNullTime(lastHit)
+	return suppression, nil
+}
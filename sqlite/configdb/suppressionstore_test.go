@@ -0,0 +1,119 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package configdb
+
+import (
+	"github.com/jasonish/evebox/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func setupSuppressionStore(t *testing.T) *SuppressionStore {
+	db, err := NewConfigDB(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewSuppressionStore(db.DB)
+}
+
+func TestSuppressionAddFind(t *testing.T) {
+	store := setupSuppressionStore(t)
+
+	// Requires at least one criterion.
+	_, err := store.AddSuppression(core.Suppression{
+		Action: core.SUPPRESSION_ACTION_ARCHIVE,
+	})
+	assert.NotNil(t, err)
+
+	// Requires a valid CIDR.
+	_, err = store.AddSuppression(core.Suppression{
+		Action:  core.SUPPRESSION_ACTION_ARCHIVE,
+		SrcCIDR: "10.0.0.0/33",
+	})
+	assert.NotNil(t, err)
+
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	id, err := store.AddSuppression(core.Suppression{
+		SignatureID: 2013028,
+		SrcCIDR:     "10.16.1.0/24",
+		Action:      core.SUPPRESSION_ACTION_DROP,
+		Reason:      "noisy",
+		Username:    "admin",
+		Expires:     &expires,
+	})
+	require.Nil(t, err)
+
+	suppression, err := store.FindSuppressionById(id)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(2013028), suppression.SignatureID)
+	assert.Equal(t, "10.16.1.0/24", suppression.SrcCIDR)
+	assert.Equal(t, "", suppression.DestCIDR)
+	assert.Equal(t, core.SUPPRESSION_ACTION_DROP, suppression.Action)
+	assert.Equal(t, "admin", suppression.Username)
+	require.NotNil(t, suppression.Expires)
+	assert.True(t, expires.Equal(*suppression.Expires))
+	assert.Nil(t, suppression.LastHit)
+
+	_, err = store.FindSuppressionById(id + 1)
+	assert.Equal(t, core.ErrNoSuppression, err)
+}
+
+func TestSuppressionUpdateDelete(t *testing.T) {
+	store := setupSuppressionStore(t)
+
+	id, err := store.AddSuppression(core.Suppression{
+		Sensor: "sensor-one",
+		Action: core.SUPPRESSION_ACTION_ARCHIVE,
+	})
+	require.Nil(t, err)
+
+	assert.Nil(t, store.AddSuppressionHits(id, 3, time.Now()))
+	assert.Nil(t, store.AddSuppressionHits(id, 2, time.Now()))
+
+	assert.Nil(t, store.UpdateSuppression(core.Suppression{
+		Id:     id,
+		Sensor: "sensor-two",
+		Action: core.SUPPRESSION_ACTION_DROP,
+	}))
+
+	suppressions, err := store.FindAllSuppressions()
+	require.Nil(t, err)
+	require.Len(t, suppressions, 1)
+	assert.Equal(t, "sensor-two", suppressions[0].Sensor)
+	assert.Equal(t, core.SUPPRESSION_ACTION_DROP, suppressions[0].Action)
+	assert.Equal(t, uint64(5), suppressions[0].Hits)
+	assert.NotNil(t, suppressions[0].LastHit)
+
+	assert.Nil(t, store.DeleteSuppression(id))
+	assert.Equal(t, core.ErrNoSuppression, store.DeleteSuppression(id))
+
+	suppressions, err = store.FindAllSuppressions()
+	require.Nil(t, err)
+	assert.Len(t, suppressions, 0)
+}
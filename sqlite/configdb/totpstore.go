@@ -0,0 +1,259 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package configdb
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/totp"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+const TOTP_ISSUER = "EveBox"
+
+const RECOVERY_CODE_COUNT = 10
+
+// Characters used in recovery codes, without ones easily confused with
+// each other.
+const recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+type TotpStore struct {
+	db *sql.DB
+}
+
+func NewTotpStore(db *sql.DB) *TotpStore {
+	return &TotpStore{
+		db: db,
+	}
+}
+
+// generateRecoveryCode generates a code like "abcde-fghjk".
+func generateRecoveryCode() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := make([]byte, len(buf))
+	for i, b := range buf {
+		code[i] = recoveryCodeAlphabet[int(b)%len(recoveryCodeAlphabet)]
+	}
+	return string(code[:5]) + "-" + string(code[5:]), nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.Replace(strings.Replace(code, "-", "", -1), " ", "", -1)
+}
+
+func (s *TotpStore) EnrollTotp(user core.User) (core.TotpEnrollment, error) {
+	enrollment := core.TotpEnrollment{}
+	if user.Id == "" {
+		return enrollment, errors.New("user has no id")
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return enrollment, errors.Wrap(err, "failed to generate secret")
+	}
+	// An enabled secret can only be replaced by disabling it first.
+	result, err := s.db.Exec(`insert into user_totp (user_id, pending_secret)
+	    values (?, ?)
+	    on conflict (user_id) do update set pending_secret = excluded.pending_secret
+	    where user_totp.secret is null`,
+		user.Id, secret)
+	if err != nil {
+		return enrollment, errors.Wrap(err, "failed to save totp secret")
+	}
+	if err := checkRowsAffected(result, core.ErrTotpEnabled); err != nil {
+		return enrollment, err
+	}
+	enrollment.Secret = secret
+	enrollment.URI = totp.ProvisioningURI(TOTP_ISSUER, user.Username, secret)
+	return enrollment, nil
+}
+
+func (s *TotpStore) ConfirmTotp(user core.User, code string) ([]string, error) {
+	var pendingSecret sql.NullString
+	err := s.db.QueryRow(
+		"select pending_secret from user_totp where user_id = ?",
+		user.Id).Scan(&pendingSecret)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "failed to query totp")
+	}
+	if !pendingSecret.Valid {
+		return nil, errors.New("no pending two-factor enrollment")
+	}
+	counter, ok := totp.Validate(pendingSecret.String, code, time.Now())
+	if !ok {
+		return nil, core.ErrBadTotpCode
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec(`update user_totp set secret = pending_secret,
+	    pending_secret = null, last_counter = ?, enabled = ?
+	    where user_id = ? and secret is null`,
+		counter, time.Now().Unix(), user.Id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to enable totp")
+	}
+	if err := checkRowsAffected(result, core.ErrTotpEnabled); err != nil {
+		return nil, err
+	}
+	codes, err := replaceRecoveryCodes(tx, user)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, user core.User) ([]string, error) {
+	_, err := tx.Exec("delete from user_recovery_codes where user_id = ?",
+		user.Id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to delete recovery codes")
+	}
+	codes := []string{}
+	for i := 0; i < RECOVERY_CODE_COUNT; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to generate recovery code")
+		}
+		_, err = tx.Exec(`insert into user_recovery_codes (user_id, code_hash)
+		    values (?, ?)`, user.Id, hashToken(normalizeRecoveryCode(code)))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to insert recovery code")
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+func (s *TotpStore) GetTotpStatus(user core.User) (core.TotpStatus, error) {
+	status := core.TotpStatus{}
+	var secret sql.NullString
+	err := s.db.QueryRow("select secret from user_totp where user_id = ?",
+		user.Id).Scan(&secret)
+	if err != nil && err != sql.ErrNoRows {
+		return status, errors.Wrap(err, "failed to query totp")
+	}
+	status.Enabled = secret.Valid
+	if status.Enabled {
+		err = s.db.QueryRow(
+			"select count(*) from user_recovery_codes where user_id = ?",
+			user.Id).Scan(&status.RecoveryCodesRemaining)
+		if err != nil {
+			return status, errors.Wrap(err, "failed to count recovery codes")
+		}
+	}
+	return status, nil
+}
+
+func (s *TotpStore) VerifyTotp(user core.User, code string) error {
+	var secret sql.NullString
+	var lastCounter sql.NullInt64
+	err := s.db.QueryRow(`select secret, last_counter from user_totp
+	    where user_id = ?`, user.Id).Scan(&secret, &lastCounter)
+	if err != nil && err != sql.ErrNoRows {
+		return errors.Wrap(err, "failed to query totp")
+	}
+	if !secret.Valid {
+		return core.ErrNoTotp
+	}
+
+	code = strings.TrimSpace(code)
+	if len(strings.Replace(code, " ", "", -1)) == totp.Digits {
+		counter, ok := totp.Validate(secret.String, code, time.Now())
+		if !ok {
+			return core.ErrBadTotpCode
+		}
+
+		// Only accept codes newer than the last one used, so an
+		// observed code can't be replayed.
+		result, err := s.db.Exec(`update user_totp set last_counter = ?
+		    where user_id = ? and (last_counter is null or last_counter < ?)`,
+			counter, user.Id, counter)
+		if err != nil {
+			return errors.Wrap(err, "failed to update totp")
+		}
+		return checkRowsAffected(result, core.ErrBadTotpCode)
+	}
+
+	result, err := s.db.Exec(`delete from user_recovery_codes
+	    where user_id = ? and code_hash = ?`, user.Id,
+		hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return errors.Wrap(err, "failed to check recovery code")
+	}
+	return checkRowsAffected(result, core.ErrBadTotpCode)
+}
+
+func (s *TotpStore) RegenerateRecoveryCodes(user core.User) ([]string, error) {
+	status, err := s.GetTotpStatus(user)
+	if err != nil {
+		return nil, err
+	}
+	if !status.Enabled {
+		return nil, core.ErrNoTotp
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	codes, err := replaceRecoveryCodes(tx, user)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+func (s *TotpStore) DisableTotp(user core.User) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec("delete from user_totp where user_id = ?", user.Id)
+	if err != nil {
+		return errors.Wrap(err, "failed to disable totp")
+	}
+	if err := checkRowsAffected(result, core.ErrNoTotp); err != nil {
+		return err
+	}
+	_, err = tx.Exec("delete from user_recovery_codes where user_id = ?",
+		user.Id)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete recovery codes")
+	}
+	return tx.Commit()
+}
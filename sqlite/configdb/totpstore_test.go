@@ -0,0 +1,119 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package configdb
+
+import (
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTotpStore(t *testing.T) {
+	db, err := NewConfigDB(":memory:")
+	require.Nil(t, err)
+	userStore := NewUserStore(db.DB)
+	store := NewTotpStore(db.DB)
+
+	_, err = userStore.AddUser(core.User{Username: "alice"}, "password")
+	require.Nil(t, err)
+	alice, err := userStore.FindByUsername("alice")
+	require.Nil(t, err)
+
+	status, err := store.GetTotpStatus(alice)
+	require.Nil(t, err)
+	assert.False(t, status.Enabled)
+	assert.Equal(t, core.ErrNoTotp, store.VerifyTotp(alice, "123456"))
+
+	enrollment, err := store.EnrollTotp(alice)
+	require.Nil(t, err)
+	assert.True(t, strings.HasPrefix(enrollment.URI, "otpauth://totp/"))
+
+	// Not enabled until confirmed.
+	status, err = store.GetTotpStatus(alice)
+	require.Nil(t, err)
+	assert.False(t, status.Enabled)
+
+	_, err = store.ConfirmTotp(alice, "000000x")
+	assert.Equal(t, core.ErrBadTotpCode, err)
+
+	// Confirm with the code of the previous period, so the current one
+	// is still unused.
+	previous, err := totp.CodeForCounter(enrollment.Secret,
+		totp.Counter(time.Now())-1)
+	require.Nil(t, err)
+	codes, err := store.ConfirmTotp(alice, previous)
+	require.Nil(t, err)
+	assert.Len(t, codes, RECOVERY_CODE_COUNT)
+
+	status, err = store.GetTotpStatus(alice)
+	require.Nil(t, err)
+	assert.True(t, status.Enabled)
+	assert.Equal(t, RECOVERY_CODE_COUNT, status.RecoveryCodesRemaining)
+
+	// The code used to confirm can't be used again.
+	assert.Equal(t, core.ErrBadTotpCode, store.VerifyTotp(alice, previous))
+
+	current, err := totp.CodeForCounter(enrollment.Secret,
+		totp.Counter(time.Now()))
+	require.Nil(t, err)
+	assert.Nil(t, store.VerifyTotp(alice, current))
+	assert.Equal(t, core.ErrBadTotpCode, store.VerifyTotp(alice, current))
+
+	// Recovery codes work once, ignoring case and dashes.
+	recovery := strings.ToUpper(strings.Replace(codes[0], "-", "", -1))
+	assert.Nil(t, store.VerifyTotp(alice, recovery))
+	assert.Equal(t, core.ErrBadTotpCode, store.VerifyTotp(alice, codes[0]))
+	assert.Equal(t, core.ErrBadTotpCode, store.VerifyTotp(alice, "bad-code"))
+
+	status, err = store.GetTotpStatus(alice)
+	require.Nil(t, err)
+	assert.Equal(t, RECOVERY_CODE_COUNT-1, status.RecoveryCodesRemaining)
+
+	newCodes, err := store.RegenerateRecoveryCodes(alice)
+	require.Nil(t, err)
+	assert.Len(t, newCodes, RECOVERY_CODE_COUNT)
+	assert.Equal(t, core.ErrBadTotpCode, store.VerifyTotp(alice, codes[1]))
+	assert.Nil(t, store.VerifyTotp(alice, newCodes[0]))
+
+	// An enabled secret can't be replaced without disabling it first.
+	_, err = store.EnrollTotp(alice)
+	assert.Equal(t, core.ErrTotpEnabled, err)
+	status, err = store.GetTotpStatus(alice)
+	require.Nil(t, err)
+	assert.True(t, status.Enabled)
+
+	require.Nil(t, store.DisableTotp(alice))
+	status, err = store.GetTotpStatus(alice)
+	require.Nil(t, err)
+	assert.False(t, status.Enabled)
+	assert.Equal(t, core.ErrNoTotp, store.DisableTotp(alice))
+	assert.Equal(t, core.ErrNoTotp, store.VerifyTotp(alice, newCodes[1]))
+}
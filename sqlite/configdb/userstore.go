@@ -43,24 +43,36 @@ var userFields = []string{
 	"email",
 	"github_id",
 	"github_username",
+	"role",
+	"disabled",
+	"password_change_required",
+	"provider",
+	"external_id",
 }
 
-var ErrNoUsername = errors.New("username does not exist")
+var ErrNoUsername = core.ErrNoUsername
 var ErrNoPassword = errors.New("user has no password")
 var ErrBadPassword = errors.New("bad password")
 
 var nilUser = core.User{}
 
 type UserStore struct {
-	db *sql.DB
+	db     *sql.DB
+	policy core.PasswordPolicy
 }
 
 func NewUserStore(db *sql.DB) *UserStore {
 	return &UserStore{
-		db: db,
+		db:     db,
+		policy: core.DefaultPasswordPolicy(),
 	}
 }
 
+// SetPasswordPolicy sets the policy new passwords are checked against.
+func (s *UserStore) SetPasswordPolicy(policy core.PasswordPolicy) {
+	s.policy = policy
+}
+
 func toNullString(value string) sql.NullString {
 	if value != "" {
 		return sql.NullString{value, true}
@@ -97,9 +109,21 @@ func (s *UserStore) AddUser(user core.User, password string) (string, error) {
 	email := toNullString(user.Email)
 	githubId := toNullInt64(user.GitHubID)
 	githubUsername := toNullString(user.GitHubUsername)
+	provider := toNullString(user.Provider)
+	externalId := toNullString(user.ExternalId)
+
+	role := user.Role
+	if role == "" {
+		role = core.ROLE_ANALYST
+	} else if !core.IsValidRole(role) {
+		return noid, errors.Errorf("invalid role: %s", role)
+	}
 
 	var sqlPassword sql.NullString
 	if password != "" {
+		if err := s.policy.Check(username, password); err != nil {
+			return noid, err
+		}
 		hash, err := encryptPassword(password)
 		if err != nil {
 			return noid, errors.Wrap(err,
@@ -126,8 +150,11 @@ func (s *UserStore) AddUser(user core.User, password string) (string, error) {
 	      email,
 	      password,
 	      github_id,
-	      github_username
-	    ) values (?, ?, ?, ?, ?, ?, ?)`)
+	      github_username,
+	      role,
+	      provider,
+	      external_id
+	    ) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
 	if err != nil {
 		return noid, errors.Wrap(err,
 			"failed to prepare user insert statement")
@@ -138,7 +165,10 @@ func (s *UserStore) AddUser(user core.User, password string) (string, error) {
 		email,
 		sqlPassword,
 		githubId,
-		githubUsername)
+		githubUsername,
+		role,
+		provider,
+		externalId)
 	if err != nil {
 		return noid, errors.Wrap(err, "failed to insert user")
 	}
@@ -150,10 +180,11 @@ func (s *UserStore) AddUser(user core.User, password string) (string, error) {
 // with the given password.
 func (s *UserStore) FindByUsernamePassword(username string, password string) (core.User, error) {
 	row := s.db.QueryRow(
-		"select password from users where username = ?",
+		"select password, disabled from users where username = ?",
 		username)
-	var hash string
-	err := row.Scan(&hash)
+	var hash sql.NullString
+	var disabled bool
+	err := row.Scan(&hash, &disabled)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nilUser, ErrNoUsername
@@ -161,15 +192,20 @@ func (s *UserStore) FindByUsernamePassword(username string, password string) (co
 		return nilUser, errors.Wrap(err, "failed to query for user")
 	}
 
-	if password == "" {
+	if password == "" || !hash.Valid {
 		return nilUser, ErrNoPassword
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	err = bcrypt.CompareHashAndPassword([]byte(hash.String), []byte(password))
 	if err != nil {
 		return nilUser, ErrBadPassword
 	}
 
+	// Only reveal that the user is disabled once the password is known.
+	if disabled {
+		return nilUser, core.ErrUserDisabled
+	}
+
 	return s.FindByUsername(username)
 }
 
@@ -189,7 +225,50 @@ func (s *UserStore) FindByGitHubUsername(username string) (user core.User, err e
 		}
 		return user, nil
 	}
-	return user, errors.New("username does not exist")
+	return user, ErrNoUsername
+}
+
+func (s *UserStore) FindByExternalId(provider string, externalId string) (core.User, error) {
+	rows, err := s.db.Query(fmt.Sprintf(
+		"select %s from users where provider = ? and external_id = ?",
+		strings.Join(userFields, ", ")),
+		provider, externalId)
+	if err != nil {
+		return nilUser, errors.Wrap(err, "failed to query for user")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		user, err := mapUser(rows)
+		if err != nil {
+			return user, errors.Wrap(err, "failed to read user")
+		}
+		return user, nil
+	}
+	return nilUser, ErrNoUsername
+}
+
+// LinkExternalId sets the external identity of a user that can't log in
+// any other way.
+func (s *UserStore) LinkExternalId(username string, provider string, externalId string) error {
+	result, err := s.db.Exec(`update users set provider = ?, external_id = ?
+	    where username = ? and external_id is null and password is null
+	      and github_username is null and github_id is null
+	      and (provider is null or provider = ?)`,
+		provider, externalId, username, provider)
+	if err != nil {
+		return errors.Wrap(err, "failed to link user")
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		if _, err := s.FindByUsername(username); err != nil {
+			return err
+		}
+		return core.ErrUserNotLinkable
+	}
+	return nil
 }
 
 func (s *UserStore) FindByUsername(username string) (core.User, error) {
@@ -210,23 +289,86 @@ func (s *UserStore) FindByUsername(username string) (core.User, error) {
 		}
 		return user, nil
 	}
-	return user, errors.New("username does not exist")
+	return user, ErrNoUsername
 }
 
-func (s *UserStore) DeleteByUsername(username string) error {
-	r, err := s.db.Exec("delete from users where username = ?",
-		username)
+// SetRole changes the role of a user.
+func (s *UserStore) SetRole(username string, role string) error {
+	if !core.IsValidRole(role) {
+		return errors.Errorf("invalid role: %s", role)
+	}
+	r, err := s.db.Exec("update users set role = ? where username = ?",
+		role, username)
 	if err != nil {
 		return err
 	}
-	n, err := r.RowsAffected()
+	return checkRowsAffected(r, ErrNoUsername)
+}
+
+// UpdateUser updates the full name, email, GitHub username and role of
+// the user with the same username.
+func (s *UserStore) UpdateUser(user core.User) error {
+	if !core.IsValidRole(user.Role) {
+		return errors.Errorf("invalid role: %s", user.Role)
+	}
+	r, err := s.db.Exec(`update users set fullname = ?, email = ?,
+	    github_username = ?, role = ? where username = ?`,
+		toNullString(user.FullName), toNullString(user.Email),
+		toNullString(user.GitHubUsername), user.Role, user.Username)
+	if err != nil {
+		return errors.Wrap(err, "failed to update user")
+	}
+	return checkRowsAffected(r, ErrNoUsername)
+}
+
+func (s *UserStore) SetDisabled(username string, disabled bool) error {
+	r, err := s.db.Exec("update users set disabled = ? where username = ?",
+		disabled, username)
 	if err != nil {
 		return err
 	}
-	if n == 0 {
+	return checkRowsAffected(r, ErrNoUsername)
+}
+
+// The tables with rows owned by a user, by user ID.
+var userTables = []string{
+	"api_tokens",
+	"user_totp",
+	"user_recovery_codes",
+	"preferences",
+	"sessions",
+}
+
+func (s *UserStore) DeleteByUsername(username string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id string
+	err = tx.QueryRow("select uuid from users where username = ?",
+		username).Scan(&id)
+	if err == sql.ErrNoRows {
 		return ErrNoUsername
+	} else if err != nil {
+		return err
 	}
-	return nil
+
+	for _, table := range userTables {
+		if _, err := tx.Exec(fmt.Sprintf(
+			"delete from %s where user_id = ?", table), id); err != nil {
+			return errors.Wrapf(err, "failed to delete from %s", table)
+		}
+	}
+	if _, err := tx.Exec("delete from saved_searches where owner = ?",
+		username); err != nil {
+		return errors.Wrap(err, "failed to delete saved searches")
+	}
+	if _, err := tx.Exec("delete from users where uuid = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func mapUser(rows *sql.Rows) (core.User, error) {
@@ -238,6 +380,11 @@ func mapUser(rows *sql.Rows) (core.User, error) {
 	var email sql.NullString
 	var githubId sql.NullInt64
 	var githubUsername sql.NullString
+	var role string
+	var disabled bool
+	var passwordChangeRequired bool
+	var provider sql.NullString
+	var externalId sql.NullString
 
 	err := rows.Scan(
 		&id,
@@ -246,12 +393,22 @@ func mapUser(rows *sql.Rows) (core.User, error) {
 		&email,
 		&githubId,
 		&githubUsername,
+		&role,
+		&disabled,
+		&passwordChangeRequired,
+		&provider,
+		&externalId,
 	)
 	if err != nil {
 		return user, err
 	}
 
 	user.Id = id
+	user.Role = role
+	user.Disabled = disabled
+	user.PasswordChangeRequired = passwordChangeRequired
+	user.Provider = provider.String
+	user.ExternalId = externalId.String
 
 	if sqlUsername.Valid {
 		user.Username = sqlUsername.String
@@ -293,7 +450,25 @@ func (s *UserStore) FindAll() ([]core.User, error) {
 	return users, nil
 }
 
+// UpdatePassword sets a new password chosen by the user, clearing any
+// required password change.
 func (s *UserStore) UpdatePassword(username string, password string) error {
+	return s.setPassword(username, password, false)
+}
+
+// ResetPassword sets a password chosen by an admin. The user must change
+// it on their next login.
+func (s *UserStore) ResetPassword(username string, password string) error {
+	return s.setPassword(username, password, true)
+}
+
+func (s *UserStore) setPassword(username string, password string, changeRequired bool) error {
+	if password == "" {
+		return ErrNoPassword
+	}
+	if err := s.policy.Check(username, password); err != nil {
+		return err
+	}
 	hash, err := encryptPassword(password)
 	if err != nil {
 		return err
@@ -305,8 +480,9 @@ func (s *UserStore) UpdatePassword(username string, password string) error {
 	}
 	defer tx.Commit()
 
-	r, err := tx.Exec(`update users set password = ? where username = ?`,
-		hash, username)
+	r, err := tx.Exec(`update users set password = ?,
+	    password_change_required = ? where username = ?`,
+		hash, changeRequired, username)
 	if err != nil {
 		return err
 	}
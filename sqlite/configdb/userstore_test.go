@@ -1,9 +1,14 @@
 package configdb
 
 import (
+	"fmt"
 	"github.com/jasonish/evebox/core"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
 	"testing"
+	"time"
 )
 
 func Setup(t *testing.T) *UserStore {
@@ -12,7 +17,7 @@ func Setup(t *testing.T) *UserStore {
 		t.Fatal(err)
 	}
 
-	userstore := &UserStore{db.DB}
+	userstore := NewUserStore(db.DB)
 	return userstore
 }
 
@@ -68,3 +73,271 @@ func TestCheckPassword(t *testing.T) {
 	assert.Equal(t, ErrBadPassword, err)
 	assert.Equal(t, "", user.Username)
 }
+
+func TestUserRole(t *testing.T) {
+	userstore := Setup(t)
+
+	_, err := userstore.AddUser(core.User{Username: "bad", Role: "root"}, "")
+	assert.NotNil(t, err)
+
+	// Users are analysts by default.
+	_, err = userstore.AddUser(core.User{Username: "analyst"}, "password")
+	assert.Nil(t, err)
+	user, err := userstore.FindByUsername("analyst")
+	assert.Nil(t, err)
+	assert.Equal(t, core.ROLE_ANALYST, user.Role)
+	assert.True(t, user.HasRole(core.ROLE_VIEWER))
+	assert.False(t, user.HasRole(core.ROLE_ADMIN))
+
+	assert.Nil(t, userstore.SetRole("analyst", core.ROLE_VIEWER))
+	user, err = userstore.FindByUsernamePassword("analyst", "password")
+	assert.Nil(t, err)
+	assert.Equal(t, core.ROLE_VIEWER, user.Role)
+	assert.False(t, user.HasRole(core.ROLE_ANALYST))
+
+	assert.NotNil(t, userstore.SetRole("analyst", "root"))
+	assert.Equal(t, ErrNoUsername, userstore.SetRole("nobody", core.ROLE_ADMIN))
+}
+
+func TestUpdateUser(t *testing.T) {
+	userstore := Setup(t)
+
+	_, err := userstore.AddUser(core.User{
+		Username: "jason",
+		FullName: "Jason",
+		Email:    "jason@example.com",
+	}, "password")
+	require.Nil(t, err)
+
+	user, err := userstore.FindByUsername("jason")
+	require.Nil(t, err)
+	user.FullName = "Jason Ish"
+	user.Email = ""
+	user.GitHubUsername = "jasonish"
+	user.Role = core.ROLE_ADMIN
+	require.Nil(t, userstore.UpdateUser(user))
+
+	user, err = userstore.FindByGitHubUsername("jasonish")
+	require.Nil(t, err)
+	assert.Equal(t, "jason", user.Username)
+	assert.Equal(t, "Jason Ish", user.FullName)
+	assert.Equal(t, "", user.Email)
+	assert.Equal(t, core.ROLE_ADMIN, user.Role)
+
+	user.Role = "root"
+	assert.NotNil(t, userstore.UpdateUser(user))
+	assert.Equal(t, ErrNoUsername,
+		userstore.UpdateUser(core.User{Username: "nobody", Role: core.ROLE_VIEWER}))
+}
+
+func TestExternalId(t *testing.T) {
+	userstore := Setup(t)
+
+	_, err := userstore.AddUser(core.User{
+		Username:   "alice",
+		Provider:   core.PROVIDER_OIDC,
+		ExternalId: "https://idp.example.com alice-sub",
+	}, "")
+	require.Nil(t, err)
+	user, err := userstore.FindByExternalId(core.PROVIDER_OIDC,
+		"https://idp.example.com alice-sub")
+	require.Nil(t, err)
+	assert.Equal(t, "alice", user.Username)
+	assert.Equal(t, core.PROVIDER_OIDC, user.Provider)
+
+	_, err = userstore.FindByExternalId(core.PROVIDER_LDAP,
+		"https://idp.example.com alice-sub")
+	assert.Equal(t, ErrNoUsername, err)
+
+	// Only users that can't log in any other way can be linked.
+	_, err = userstore.AddUser(core.User{Username: "local"}, "password")
+	require.Nil(t, err)
+	_, err = userstore.AddUser(core.User{Username: "github",
+		GitHubUsername: "github"}, "")
+	require.Nil(t, err)
+	_, err = userstore.AddUser(core.User{Username: "ldap",
+		Provider: core.PROVIDER_LDAP}, "")
+	require.Nil(t, err)
+	_, err = userstore.AddUser(core.User{Username: "bob"}, "")
+	require.Nil(t, err)
+
+	for _, username := range []string{"alice", "local", "github", "ldap"} {
+		assert.Equal(t, core.ErrUserNotLinkable,
+			userstore.LinkExternalId(username, core.PROVIDER_OIDC, "sub"),
+			username)
+	}
+	assert.Equal(t, ErrNoUsername,
+		userstore.LinkExternalId("nobody", core.PROVIDER_OIDC, "sub"))
+
+	require.Nil(t, userstore.LinkExternalId("bob", core.PROVIDER_OIDC, "bob-sub"))
+	user, err = userstore.FindByExternalId(core.PROVIDER_OIDC, "bob-sub")
+	require.Nil(t, err)
+	assert.Equal(t, "bob", user.Username)
+
+	// An identity belongs to one user.
+	_, err = userstore.AddUser(core.User{Username: "bob2",
+		Provider: core.PROVIDER_OIDC, ExternalId: "bob-sub"}, "")
+	assert.NotNil(t, err)
+}
+
+func TestDisableUser(t *testing.T) {
+	db, err := NewConfigDB(":memory:")
+	require.Nil(t, err)
+	userstore := NewUserStore(db.DB)
+	tokenStore := NewApiTokenStore(db.DB)
+
+	_, err = userstore.AddUser(core.User{Username: "jason"}, "password")
+	require.Nil(t, err)
+	user, err := userstore.FindByUsername("jason")
+	require.Nil(t, err)
+	token, _, err := tokenStore.AddApiToken(user, "script", nil)
+	require.Nil(t, err)
+
+	require.Nil(t, userstore.SetDisabled("jason", true))
+
+	user, err = userstore.FindByUsername("jason")
+	require.Nil(t, err)
+	assert.True(t, user.Disabled)
+
+	_, err = userstore.FindByUsernamePassword("jason", "password")
+	assert.Equal(t, core.ErrUserDisabled, err)
+
+	// The password is checked first.
+	_, err = userstore.FindByUsernamePassword("jason", "bad")
+	assert.Equal(t, ErrBadPassword, err)
+
+	// API tokens of disabled users don't work.
+	_, err = tokenStore.FindUserByApiToken(token)
+	assert.Equal(t, core.ErrBadApiToken, err)
+
+	require.Nil(t, userstore.SetDisabled("jason", false))
+	_, err = userstore.FindByUsernamePassword("jason", "password")
+	assert.Nil(t, err)
+	_, err = tokenStore.FindUserByApiToken(token)
+	assert.Nil(t, err)
+
+	assert.Equal(t, ErrNoUsername, userstore.SetDisabled("nobody", true))
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	userstore := Setup(t)
+
+	// The default policy only has a minimum length.
+	_, err := userstore.AddUser(core.User{Username: "joe"}, "short")
+	assert.IsType(t, &core.PasswordPolicyError{}, err)
+	_, err = userstore.AddUser(core.User{Username: "joe"}, "password")
+	require.Nil(t, err)
+
+	breached, err := ioutil.TempFile("", "evebox-breached")
+	require.Nil(t, err)
+	defer os.Remove(breached.Name())
+	// A plain password, and the SHA-1 of "Password1!" in the format of
+	// the Have I Been Pwned downloads.
+	fmt.Fprintf(breached, "# Breached passwords.\nletmein123\n%s:42\n",
+		"32ca9fc1a0f5b6330e3f4c8c1bbecde9bedb9573")
+	breached.Close()
+
+	policy, err := core.NewPasswordPolicy(10, 3, breached.Name())
+	require.Nil(t, err)
+	assert.Equal(t, 2, policy.BreachedCount())
+	userstore.SetPasswordPolicy(policy)
+
+	for _, password := range []string{
+		// Too short.
+		"Sh0rt!",
+		// Not enough character classes.
+		"alllowercase",
+		"lowerUPPERcase",
+		// Breached.
+		"letmein123",
+		"Password1!",
+	} {
+		err := userstore.UpdatePassword("joe", password)
+		assert.IsType(t, &core.PasswordPolicyError{}, err, password)
+	}
+	assert.Nil(t, userstore.UpdatePassword("joe", "Correct-Horse-1"))
+
+	// The password can't be the username.
+	userstore.SetPasswordPolicy(core.DefaultPasswordPolicy())
+	_, err = userstore.AddUser(core.User{Username: "joe-admin"}, "JOE-ADMIN")
+	assert.IsType(t, &core.PasswordPolicyError{}, err)
+
+	_, err = core.NewPasswordPolicy(8, 5, "")
+	assert.NotNil(t, err)
+	_, err = core.NewPasswordPolicy(8, 0, "/does/not/exist")
+	assert.NotNil(t, err)
+}
+
+func TestResetPassword(t *testing.T) {
+	userstore := Setup(t)
+
+	_, err := userstore.AddUser(core.User{Username: "joe"}, "password")
+	require.Nil(t, err)
+
+	require.Nil(t, userstore.ResetPassword("joe", "temporary"))
+	user, err := userstore.FindByUsernamePassword("joe", "temporary")
+	require.Nil(t, err)
+	assert.True(t, user.PasswordChangeRequired)
+
+	require.Nil(t, userstore.UpdatePassword("joe", "new-password"))
+	user, err = userstore.FindByUsernamePassword("joe", "new-password")
+	require.Nil(t, err)
+	assert.False(t, user.PasswordChangeRequired)
+
+	assert.Equal(t, ErrNoUsername, userstore.ResetPassword("nobody", "temporary"))
+}
+
+func TestDeleteByUsername(t *testing.T) {
+	userstore := Setup(t)
+	db := userstore.db
+
+	// Give each user a row in every table that references users.
+	addRows := func(username string) core.User {
+		_, err := userstore.AddUser(core.User{Username: username}, "password")
+		require.Nil(t, err)
+		user, err := userstore.FindByUsername(username)
+		require.Nil(t, err)
+
+		_, _, err = NewApiTokenStore(db).AddApiToken(user, "token", nil)
+		require.Nil(t, err)
+		_, err = NewTotpStore(db).EnrollTotp(user)
+		require.Nil(t, err)
+		_, err = db.Exec(`insert into user_recovery_codes (user_id, code_hash)
+		    values (?, 'hash')`, user.Id)
+		require.Nil(t, err)
+		require.Nil(t, NewPreferencesStore(db).SetPreferences(user, core.Preferences{}))
+		_, err = NewSavedSearchStore(db).AddSavedSearch(core.SavedSearch{
+			Name:  "search",
+			Owner: username,
+		})
+		require.Nil(t, err)
+		require.Nil(t, NewSessionStore(db).SaveSession(core.PersistentSession{
+			IdHash:  username,
+			User:    user,
+			Created: time.Now(),
+			Expires: time.Now().Add(time.Hour),
+		}))
+		return user
+	}
+	count := func(table string, column string, value string) int {
+		var n int
+		require.Nil(t, db.QueryRow(fmt.Sprintf(
+			"select count(*) from %s where %s = ?", table, column),
+			value).Scan(&n))
+		return n
+	}
+
+	joe := addRows("joe")
+	bob := addRows("bob")
+
+	require.Nil(t, userstore.DeleteByUsername("joe"))
+	for _, table := range userTables {
+		assert.Equal(t, 0, count(table, "user_id", joe.Id), table)
+		assert.Equal(t, 1, count(table, "user_id", bob.Id), table)
+	}
+	assert.Equal(t, 0, count("saved_searches", "owner", "joe"))
+	assert.Equal(t, 1, count("saved_searches", "owner", "bob"))
+	assert.Equal(t, 0, count("users", "username", "joe"))
+
+	assert.Equal(t, ErrNoUsername, userstore.DeleteByUsername("joe"))
+}
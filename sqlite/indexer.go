@@ -92,9 +92,15 @@ func (i *SqliteIndexer) Submit(event eve.EveEvent) error {
 		return err
 	}
 
+	// Alerts may already be archived by a suppression.
+	archived := 0
+	if event.HasTag("archived") {
+		archived = 1
+	}
+
 	i.queue = append(i.queue, op{
-		query: "insert into events (timestamp, source) values ($1, $2)",
-		args:  []interface{}{event.Timestamp().UnixNano(), encoded},
+		query: "insert into events (timestamp, archived, source) values ($1, $2, $3)",
+		args:  []interface{}{event.Timestamp().UnixNano(), archived, encoded},
 	})
 
 	// Add to full text search...
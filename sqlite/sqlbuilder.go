@@ -38,6 +38,23 @@ type SqlBuilder struct {
 	limit  int
 }
 
+// Clone returns a copy of the builder that can be modified without
+// modifying the original.
+func (b *SqlBuilder) Clone() SqlBuilder {
+	clone := SqlBuilder{
+		where: append([]string{}, b.where...),
+		args:  append([]interface{}{}, b.args...),
+		limit: b.limit,
+	}
+	for field := range b.fields {
+		clone.Select(field)
+	}
+	for table := range b.from {
+		clone.From(table)
+	}
+	return clone
+}
+
 func (b *SqlBuilder) Select(what string) (builder *SqlBuilder) {
 	if b.fields == nil {
 		b.fields = make(map[string]bool)
@@ -57,6 +74,12 @@ func (b *SqlBuilder) Where(where string) {
 	b.where = append(b.where, where)
 }
 
+// WhereArgs adds a where clause that contains its own placeholders.
+func (b *SqlBuilder) WhereArgs(where string, args ...interface{}) {
+	b.where = append(b.where, where)
+	b.args = append(b.args, args...)
+}
+
 func (b *SqlBuilder) WhereEquals(field string, value interface{}) {
 	b.where = append(b.where, fmt.Sprintf("%s = ?", field))
 	b.args = append(b.args, value)
@@ -0,0 +1,240 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Package suppression applies suppression rules to alerts as they are
+// received, archiving or dropping alerts that match.
+package suppression
+
+import (
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/eve"
+	"github.com/jasonish/evebox/log"
+	"net"
+	"sync"
+	"time"
+)
+
+// How often hit counters are written out and the rules reloaded.
+const FLUSH_INTERVAL = 60 * time.Second
+
+// Username recorded in the history of alerts archived by a suppression.
+const USERNAME = "suppression"
+
+type rule struct {
+	core.Suppression
+	srcNet  *net.IPNet
+	destNet *net.IPNet
+}
+
+func (r *rule) matches(event eve.EveEvent) bool {
+	if r.SignatureID != 0 {
+		signatureId, ok := event.GetAlertSignatureId()
+		if !ok || signatureId != r.SignatureID {
+			return false
+		}
+	}
+	if r.srcNet != nil && !containsIp(r.srcNet, event.SrcIp()) {
+		return false
+	}
+	if r.destNet != nil && !containsIp(r.destNet, event.DestIp()) {
+		return false
+	}
+	if r.Sensor != "" && event.GetString("host") != r.Sensor {
+		return false
+	}
+	return true
+}
+
+func containsIp(network *net.IPNet, addr string) bool {
+	ip := net.ParseIP(addr)
+	return ip != nil && network.Contains(ip)
+}
+
+type hitCounter struct {
+	count   uint64
+	lastHit time.Time
+}
+
+// Filter is an eve.EveFilter that applies the suppressions in a
+// SuppressionStore to alerts.
+type Filter struct {
+	store core.SuppressionStore
+
+	lock  sync.Mutex
+	rules []*rule
+	hits  map[int64]*hitCounter
+
+	stop chan bool
+}
+
+func NewFilter(store core.SuppressionStore) (*Filter, error) {
+	filter := &Filter{
+		store: store,
+		hits:  map[int64]*hitCounter{},
+	}
+	if err := filter.Reload(); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// Reload reloads the suppressions from the store. Pending hit counts are
+// flushed first.
+func (f *Filter) Reload() error {
+	f.Flush()
+	suppressions, err := f.store.FindAllSuppressions()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	rules := []*rule{}
+	for _, suppression := range suppressions {
+		if suppression.IsExpired(now) {
+			continue
+		}
+		r := &rule{Suppression: suppression}
+		if r.srcNet, err = core.ParseCIDR(suppression.SrcCIDR); err != nil {
+			log.Warning("Ignoring suppression %d with bad src_cidr: %v",
+				suppression.Id, err)
+			continue
+		}
+		if r.destNet, err = core.ParseCIDR(suppression.DestCIDR); err != nil {
+			log.Warning("Ignoring suppression %d with bad dest_cidr: %v",
+				suppression.Id, err)
+			continue
+		}
+		rules = append(rules, r)
+	}
+	f.lock.Lock()
+	f.rules = rules
+	f.lock.Unlock()
+	log.Debug("Loaded %d active suppressions", len(rules))
+	return nil
+}
+
+// Flush writes the pending hit counts out to the store.
+func (f *Filter) Flush() {
+	f.lock.Lock()
+	hits := f.hits
+	f.hits = map[int64]*hitCounter{}
+	f.lock.Unlock()
+
+	for id, hit := range hits {
+		if err := f.store.AddSuppressionHits(id, hit.count, hit.lastHit); err != nil {
+			log.Error("Failed to update hits for suppression %d: %v", id, err)
+		}
+	}
+}
+
+// Start periodically flushes the hit counts and reloads the suppressions
+// so expired suppressions stop being applied.
+func (f *Filter) Start() {
+	f.stop = make(chan bool)
+	go func() {
+		ticker := time.NewTicker(FLUSH_INTERVAL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := f.Reload(); err != nil {
+					log.Error("Failed to reload suppressions: %v", err)
+				}
+			case <-f.stop:
+				f.Flush()
+				return
+			}
+		}
+	}()
+}
+
+func (f *Filter) Stop() {
+	if f.stop != nil {
+		close(f.stop)
+	}
+}
+
+func (f *Filter) match(event eve.EveEvent) *rule {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	now := time.Now()
+	for _, r := range f.rules {
+		if r.IsExpired(now) || !r.matches(event) {
+			continue
+		}
+		hit := f.hits[r.Id]
+		if hit == nil {
+			hit = &hitCounter{}
+			f.hits[r.Id] = hit
+		}
+		hit.count++
+		hit.lastHit = now
+		return r
+	}
+	return nil
+}
+
+func (f *Filter) Filter(event eve.EveEvent) {
+	if event.EventType() != "alert" {
+		return
+	}
+	r := f.match(event)
+	if r == nil {
+		return
+	}
+	switch r.Action {
+	case core.SUPPRESSION_ACTION_DROP:
+		event.Drop()
+	case core.SUPPRESSION_ACTION_ARCHIVE:
+		archive(event, r.Reason)
+	}
+}
+
+// archive tags the event as archived and adds a history entry, the same as
+// an analyst archiving the alert.
+func archive(event eve.EveEvent, reason string) {
+	if event.HasTag("archived") {
+		return
+	}
+	event.AddTag("archived")
+	event.AddTag("evebox.archived")
+
+	entry := map[string]interface{}{
+		"timestamp": eve.FormatTimestampUTC(time.Now()),
+		"username":  USERNAME,
+		"action":    "archived",
+	}
+	if reason != "" {
+		entry["comment"] = reason
+	}
+
+	evebox, ok := event["evebox"].(map[string]interface{})
+	if !ok {
+		evebox = map[string]interface{}{}
+		event["evebox"] = evebox
+	}
+	history, _ := evebox["history"].([]interface{})
+	evebox["history"] = append(history, entry)
+}
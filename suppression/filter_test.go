@@ -0,0 +1,112 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package suppression
+
+import (
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/eve"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type testStore struct {
+	core.SuppressionStore
+	suppressions []core.Suppression
+	hits         map[int64]uint64
+}
+
+func (s *testStore) FindAllSuppressions() ([]core.Suppression, error) {
+	return s.suppressions, nil
+}
+
+func (s *testStore) AddSuppressionHits(id int64, count uint64, lastHit time.Time) error {
+	s.hits[id] += count
+	return nil
+}
+
+const testAlert = `{"timestamp":"2016-02-11T08:07:42.815726-0600","event_type":"alert","src_ip":"72.20.52.30","dest_ip":"10.16.1.236","alert":{"signature_id":2021701},"host":"home-firewall"}`
+
+func newTestEvent(t *testing.T) eve.EveEvent {
+	event, err := eve.NewEveEventFromString(testAlert)
+	require.Nil(t, err)
+	return event
+}
+
+func TestFilter(t *testing.T) {
+	r := require.New(t)
+
+	expired := time.Now().Add(-time.Hour)
+	store := &testStore{
+		suppressions: []core.Suppression{
+			{Id: 1, SignatureID: 2021701, Action: core.SUPPRESSION_ACTION_DROP,
+				Expires: &expired},
+			{Id: 2, SignatureID: 2021701, DestCIDR: "10.16.2.0/24",
+				Action: core.SUPPRESSION_ACTION_DROP},
+			{Id: 3, SrcCIDR: "72.20.52.0/24", Sensor: "home-firewall",
+				Action: core.SUPPRESSION_ACTION_ARCHIVE, Reason: "known"},
+		},
+		hits: map[int64]uint64{},
+	}
+	filter, err := NewFilter(store)
+	r.Nil(err)
+
+	event := newTestEvent(t)
+	filter.Filter(event)
+	r.False(event.IsDropped())
+	r.True(event.HasTag("archived"))
+	r.True(event.HasTag("evebox.archived"))
+
+	// Matching the drop rule instead.
+	store.suppressions[1].DestCIDR = "10.16.1.236"
+	r.Nil(filter.Reload())
+	event = newTestEvent(t)
+	filter.Filter(event)
+	r.True(event.IsDropped())
+	r.False(event.HasTag("archived"))
+
+	filter.Flush()
+	r.Equal(uint64(0), store.hits[1])
+	r.Equal(uint64(1), store.hits[2])
+	r.Equal(uint64(1), store.hits[3])
+}
+
+func TestFilterIgnoresNonAlerts(t *testing.T) {
+	store := &testStore{
+		suppressions: []core.Suppression{
+			{Id: 1, Sensor: "home-firewall", Action: core.SUPPRESSION_ACTION_DROP},
+		},
+		hits: map[int64]uint64{},
+	}
+	filter, err := NewFilter(store)
+	require.Nil(t, err)
+
+	event := newTestEvent(t)
+	event["event_type"] = "flow"
+	filter.Filter(event)
+	require.False(t, event.IsDropped())
+}
@@ -0,0 +1,155 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package suppression
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/rules"
+	"io"
+	"strings"
+	"time"
+)
+
+// ThresholdOptions control how suppressions are converted to Suricata
+// threshold.config entries.
+type ThresholdOptions struct {
+	// Only export suppressions for this sensor, plus those that apply to
+	// all sensors. If empty, sensor specific suppressions are skipped.
+	Sensor string
+
+	// If LimitCount is set, suppressions on a signature alone are exported
+	// as a limit threshold of LimitCount alerts per LimitSeconds instead of
+	// suppressing the signature completely.
+	LimitCount   int
+	LimitSeconds int
+}
+
+// WriteThresholdConfig writes the active suppressions as Suricata
+// threshold.config suppress and threshold entries. If ruleMap is not nil
+// it is used to add the signature name as a comment to each entry.
+//
+// Suppressions that can't be expressed in threshold.config, such as those
+// with different source and destination networks, are written out as
+// comments.
+func WriteThresholdConfig(w io.Writer, suppressions []core.Suppression,
+	ruleMap *rules.RuleMap, options ThresholdOptions) error {
+	out := bufio.NewWriter(w)
+
+	now := time.Now()
+	fmt.Fprintf(out, "# Generated by EveBox at %s.\n",
+		now.UTC().Format(time.RFC3339))
+	if options.Sensor != "" {
+		fmt.Fprintf(out, "# Sensor: %s\n", options.Sensor)
+	}
+
+	for _, suppression := range suppressions {
+		if suppression.IsExpired(now) {
+			continue
+		}
+		if suppression.Sensor != "" && suppression.Sensor != options.Sensor {
+			continue
+		}
+		fmt.Fprintf(out, "\n")
+		writeThresholdComments(out, suppression, ruleMap)
+		line, err := thresholdLine(suppression, ruleMap, options)
+		if err != nil {
+			fmt.Fprintf(out, "# Skipped: %v.\n", err)
+			continue
+		}
+		fmt.Fprintf(out, "%s\n", line)
+	}
+
+	return out.Flush()
+}
+
+func writeThresholdComments(w io.Writer, suppression core.Suppression, ruleMap *rules.RuleMap) {
+	fmt.Fprintf(w, "# EveBox suppression %d", suppression.Id)
+	if suppression.Username != "" {
+		fmt.Fprintf(w, " by %s", suppression.Username)
+	}
+	fmt.Fprintf(w, ".\n")
+	if rule := ruleMap.FindById(suppression.SignatureID); rule != nil {
+		fmt.Fprintf(w, "# %s\n", rule.Msg)
+	}
+	if suppression.Reason != "" {
+		fmt.Fprintf(w, "# Reason: %s\n", oneLine(suppression.Reason))
+	}
+	if suppression.Expires != nil {
+		fmt.Fprintf(w, "# Expires: %s\n",
+			suppression.Expires.UTC().Format(time.RFC3339))
+	}
+}
+
+func thresholdLine(suppression core.Suppression, ruleMap *rules.RuleMap,
+	options ThresholdOptions) (string, error) {
+
+	// A generator and signature ID of 0 applies to all signatures.
+	gid := uint64(0)
+	sid := suppression.SignatureID
+	if sid != 0 {
+		gid = 1
+		if rule := ruleMap.FindById(sid); rule != nil && rule.Gid != 0 {
+			gid = rule.Gid
+		}
+	}
+
+	var track string
+	var ip string
+	switch {
+	case suppression.SrcCIDR != "" && suppression.DestCIDR != "":
+		if suppression.SrcCIDR != suppression.DestCIDR {
+			return "", fmt.Errorf("can't match on both src_cidr %s and dest_cidr %s",
+				suppression.SrcCIDR, suppression.DestCIDR)
+		}
+		track, ip = "by_either", suppression.SrcCIDR
+	case suppression.SrcCIDR != "":
+		track, ip = "by_src", suppression.SrcCIDR
+	case suppression.DestCIDR != "":
+		track, ip = "by_dst", suppression.DestCIDR
+	}
+
+	if ip == "" {
+		if sid == 0 {
+			return "", fmt.Errorf("no signature or address to match on")
+		}
+		if options.LimitCount > 0 {
+			return fmt.Sprintf(
+				"threshold gen_id %d, sig_id %d, type limit, track by_src, count %d, seconds %d",
+				gid, sid, options.LimitCount, options.LimitSeconds), nil
+		}
+		return fmt.Sprintf("suppress gen_id %d, sig_id %d", gid, sid), nil
+	}
+
+	return fmt.Sprintf("suppress gen_id %d, sig_id %d, track %s, ip %s",
+		gid, sid, track, ip), nil
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
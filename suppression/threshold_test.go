@@ -0,0 +1,86 @@
+/* Copyright (c) 2018 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package suppression
+
+import (
+	"bytes"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/rules"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRule = `alert tcp any any -> any any (msg:"ET POLICY Test Rule"; sid:2013028; rev:1;)`
+
+func TestWriteThresholdConfig(t *testing.T) {
+	r := require.New(t)
+
+	dir, err := ioutil.TempDir("", "evebox-threshold")
+	r.Nil(err)
+	defer os.RemoveAll(dir)
+	ruleFilename := filepath.Join(dir, "test.rules")
+	r.Nil(ioutil.WriteFile(ruleFilename, []byte(testRule+"\n"), 0644))
+	ruleMap := rules.NewRuleMap([]string{ruleFilename})
+
+	expired := time.Now().Add(-time.Hour)
+	suppressions := []core.Suppression{
+		{Id: 1, SignatureID: 2013028, SrcCIDR: "10.16.1.0/24", Reason: "updates"},
+		{Id: 2, SignatureID: 2013029},
+		{Id: 3, DestCIDR: "10.16.1.1"},
+		{Id: 4, SignatureID: 2013030, SrcCIDR: "10.0.0.0/8", DestCIDR: "10.1.1.1"},
+		{Id: 5, SignatureID: 2013031, Expires: &expired},
+		{Id: 6, SignatureID: 2013032, Sensor: "sensor-two"},
+	}
+
+	buf := &bytes.Buffer{}
+	r.Nil(WriteThresholdConfig(buf, suppressions, ruleMap, ThresholdOptions{}))
+	config := buf.String()
+
+	r.Contains(config, "# ET POLICY Test Rule\n# Reason: updates\n"+
+		"suppress gen_id 1, sig_id 2013028, track by_src, ip 10.16.1.0/24\n")
+	r.Contains(config, "suppress gen_id 1, sig_id 2013029\n")
+	r.Contains(config, "suppress gen_id 0, sig_id 0, track by_dst, ip 10.16.1.1\n")
+	r.Contains(config, "# Skipped: can't match on both")
+	r.False(strings.Contains(config, "2013031"))
+	r.False(strings.Contains(config, "2013032"))
+
+	buf.Reset()
+	r.Nil(WriteThresholdConfig(buf, suppressions, nil, ThresholdOptions{
+		Sensor:       "sensor-two",
+		LimitCount:   1,
+		LimitSeconds: 3600,
+	}))
+	config = buf.String()
+	r.False(strings.Contains(config, "ET POLICY Test Rule"))
+	r.Contains(config,
+		"threshold gen_id 1, sig_id 2013032, type limit, track by_src, count 1, seconds 3600\n")
+}